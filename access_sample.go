@@ -0,0 +1,60 @@
+package memcacheha
+
+import (
+	"time"
+)
+
+// AccessRecord is a sampled client operation, delivered to Client.AccessHook
+type AccessRecord struct {
+	// ClientID is the ClientID of the client performing the operation
+	ClientID string
+	// Time is the start time of the operation
+	Time time.Time
+	// Op is the operation, one of the OP_ constants
+	Op string
+	// Key is the caller's key, hashed or redacted under the client's KeyLogMode
+	Key string
+	// Hit is true if a Get found the item, or a write succeeded
+	Hit bool
+	// Size is the size of the value read or written, in bytes
+	Size int
+	// Latency is the duration of the operation
+	Latency time.Duration
+	// Error is the error returned by the operation, if any
+	Error error
+}
+
+// AccessChannelHook returns an AccessHook which sends records to the given channel. Records are dropped rather
+// than blocking if the channel is full.
+func AccessChannelHook(records chan<- AccessRecord) func(AccessRecord) {
+	return func(record AccessRecord) {
+		select {
+		case records <- record:
+		default:
+		}
+	}
+}
+
+// sampleAccess delivers a record of the operation to AccessHook, for AccessSampleRate of operations
+func (client *Client) sampleAccess(clientID string, op string, key string, start time.Time, item *Item, err error) {
+	if client.AccessHook == nil || client.AccessSampleRate <= 0 {
+		return
+	}
+	if client.AccessSampleRate < 1 && randFloat64() >= client.AccessSampleRate {
+		return
+	}
+
+	record := AccessRecord{
+		ClientID: clientID,
+		Time:     start,
+		Op:       op,
+		Key:      client.LogKey(key),
+		Hit:      err == nil,
+		Latency:  client.Clock.Now().Sub(start),
+		Error:    err,
+	}
+	if item != nil {
+		record.Size = len(item.Value)
+	}
+	client.AccessHook(record)
+}
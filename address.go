@@ -0,0 +1,103 @@
+package memcacheha
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+// MEMCACHE_DEFAULT_PORT is the port used for endpoints without one
+const MEMCACHE_DEFAULT_PORT = "11211"
+
+// ErrInvalidEndpoint is an error meaning a node endpoint is not a valid host:port
+var ErrInvalidEndpoint = errors.New("memcacheha: invalid endpoint")
+
+// NormalizeEndpoint returns the canonical form of a node endpoint: a lower case hostname without a trailing dot, or
+// an IP address in its shortest form with IPv6 addresses in brackets, followed by the port, MEMCACHE_DEFAULT_PORT if
+// none is given. For example "Cache.Example.:11211", "[::0001]" and "::1" normalize to "cache.example:11211",
+// "[::1]:11211" and "[::1]:11211".
+func NormalizeEndpoint(endpoint string) (string, error) {
+	endpoint = strings.TrimSpace(endpoint)
+
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		// No port: a hostname, IPv4 address, or bare or bracketed IPv6 address
+		host, port = strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]"), MEMCACHE_DEFAULT_PORT
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return "", ErrInvalidEndpoint
+		}
+	}
+	if host == "" || port == "" {
+		return "", ErrInvalidEndpoint
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// normalizeEndpoints returns the normalized, distinct endpoints, skipping invalid endpoints and endpoints resolving
+// to the same addresses as another. Endpoints of existing nodes are preferred, so nodes are not replaced.
+func (client *Client) normalizeEndpoints(endpoints []string, existing map[string]*Node) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, endpoint := range endpoints {
+		n, err := NormalizeEndpoint(endpoint)
+		if err != nil {
+			client.levelLog.Warn("GetNodes: Invalid endpoint %q", endpoint)
+			continue
+		}
+		if !seen[n] {
+			seen[n] = true
+			normalized = append(normalized, n)
+		}
+	}
+
+	// Consider endpoints of existing nodes first
+	sort.SliceStable(normalized, func(i, j int) bool {
+		_, iExists := existing[normalized[i]]
+		_, jExists := existing[normalized[j]]
+		return iExists && !jExists
+	})
+
+	var out []string
+	addresses := map[string]string{}
+	for _, endpoint := range normalized {
+		address := resolveEndpoint(endpoint)
+		if other, found := addresses[address]; found {
+			client.levelLog.Debug("GetNodes: Endpoint %s resolves to the same address as %s", endpoint, other)
+			continue
+		}
+		addresses[address] = endpoint
+		out = append(out, endpoint)
+	}
+	return out
+}
+
+// resolveEndpoint returns the resolved addresses and port of a normalized endpoint, or the endpoint itself if it
+// cannot be resolved
+func resolveEndpoint(endpoint string) string {
+	host, port, _ := net.SplitHostPort(endpoint)
+	if net.ParseIP(host) != nil {
+		return host + " port " + port
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RESOLVE_TIMEOUT)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return endpoint
+	}
+	for i, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			addrs[i] = ip.String()
+		}
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",") + " port " + port
+}
@@ -0,0 +1,329 @@
+package memcacheha
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// ErrUnknownNode is an error meaning no node of the client has the given endpoint
+	ErrUnknownNode = errors.New("memcacheha: unknown node")
+
+	// ErrFlushUnsupported is an error meaning the NodeClient of a node cannot flush, see FlushAll
+	ErrFlushUnsupported = errors.New("memcacheha: flush not supported by node client")
+)
+
+// AdminNodes is the status of each node and the drained endpoints, see ListNodes
+type AdminNodes struct {
+	Nodes []NodeStatus `json:"nodes"`
+	// Drained are the endpoints drained with DrainNode
+	Drained []string `json:"drained"`
+	// Disabled is true if the client bypasses the cache, see Client.Disable
+	Disabled bool `json:"disabled"`
+}
+
+// FlushAll removes all items from all nodes. Errors of nodes that failed are returned as for a write, see
+// ErrAllNodesFailed.
+func (client *Client) FlushAll() error {
+	return client.FlushAllContext(context.Background())
+}
+
+// FlushAllContext removes all items from all nodes as FlushAll does, recording the identity of ctx in its
+// AuditRecord, see WithAuditIdentity
+func (client *Client) FlushAllContext(ctx context.Context) (err error) {
+	start := client.Clock.Now()
+	defer func() { client.Audit(ctx, AUDIT_FLUSH_ALL, "", start, err) }()
+
+	nodes := client.Nodes.GetNodes()
+	if len(nodes) == 0 {
+		return ErrNoHealthyNodes
+	}
+
+	acked := 0
+	errs := map[string]error{}
+	for endpoint, node := range nodes {
+		if err := node.doFlushAll(); err != nil {
+			errs[endpoint] = err
+			continue
+		}
+		acked++
+	}
+	client.levelLog.Warn("FlushAll: Flushed %d of %d nodes", acked, len(nodes))
+	return writeError(acked, len(nodes), errs, nil)
+}
+
+func (node *Node) doFlushAll() error {
+	flusher, ok := node.getClient().(NodeFlusher)
+	if !ok {
+		return ErrFlushUnsupported
+	}
+	response := node.getNodeResponse("", nil, flusher.FlushAll())
+	err := response.Error
+	releaseNodeResponse(response)
+	return err
+}
+
+// DrainNode takes the node with the given endpoint out of service for maintenance: it is no longer read from, or
+// chosen for pinned keys, though it is still written to. With WithCopyHotKeys, the hottest keys it holds are then
+// added to the other nodes, so pinned keys and nodes missing them stay warm. The node is then marked removable, see
+// Node.IsRemovable, and kept until its source no longer returns it. Discovery does not add the endpoint again until
+// UndrainNode is called. The endpoint need not be known to the client.
+func (client *Client) DrainNode(endpoint string, opts ...DrainOption) error {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	options := DrainOptions{}
+	for _, opt := range opts {
+		opt.applyDrain(&options)
+	}
+	client.statusMutex.Lock()
+	client.drained[normalized] = true
+	client.statusMutex.Unlock()
+
+	node, found := client.Nodes.GetNodes()[normalized]
+	if !found {
+		client.levelLog.Info("DrainNode: Node Drained %s", normalized)
+		return nil
+	}
+	atomic.StoreInt32(&node.drainState, nodeDraining)
+	if options.CopyHotKeys > 0 {
+		copied := client.copyHotKeys(node, options.CopyHotKeys)
+		client.levelLog.Info("DrainNode: Copied %d hot keys from Node %s", copied, normalized)
+	}
+	// UndrainNode may have been called while keys were copied
+	if atomic.CompareAndSwapInt32(&node.drainState, nodeDraining, nodeRemovable) {
+		client.levelLog.Info("DrainNode: Node Drained %s", normalized)
+	}
+	return nil
+}
+
+// UndrainNode returns a node drained with DrainNode to service, returning false if it was not drained. A node that
+// was removed is added on the next discovery, if a source returns it.
+func (client *Client) UndrainNode(endpoint string) bool {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return false
+	}
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	if !client.drained[normalized] {
+		return false
+	}
+	delete(client.drained, normalized)
+	if node, found := client.Nodes.GetNodes()[normalized]; found {
+		atomic.StoreInt32(&node.drainState, nodeNotDrained)
+	}
+	client.levelLog.Info("UndrainNode: Node Undrained %s", normalized)
+	return true
+}
+
+// DrainedNodes returns the endpoints drained with DrainNode, sorted
+func (client *Client) DrainedNodes() []string {
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	out := make([]string, 0, len(client.drained))
+	for endpoint := range client.drained {
+		out = append(out, endpoint)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ListNodes returns the status of each node and the drained endpoints
+func (client *Client) ListNodes() *AdminNodes {
+	return &AdminNodes{
+		Nodes:    append([]NodeStatus{}, client.nodeStatuses()...),
+		Drained:  client.DrainedNodes(),
+		Disabled: client.Disabled(),
+	}
+}
+
+// isDrained returns true if the given normalized endpoint was drained with DrainNode
+func (client *Client) isDrained(endpoint string) bool {
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	return client.drained[endpoint]
+}
+
+// GetNode returns the node with the given endpoint, or ErrUnknownNode
+func (client *Client) GetNode(endpoint string) (*Node, error) {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	node, found := client.Nodes.GetNodes()[normalized]
+	if !found {
+		return nil, ErrUnknownNode
+	}
+	return node, nil
+}
+
+// AdminHandler returns an http.Handler for managing the nodes of this client during incidents. Requests must carry
+// the header "Authorization: Bearer <AdminToken>"; all requests are refused if AdminToken is empty. Paths are
+// relative to the handler, use http.StripPrefix to mount it under a prefix. Actions are recorded in AuditHook, with
+// the identity of the request context or its remote address.
+//
+//	GET  /nodes                          list nodes and drained endpoints
+//	POST /nodes/healthy?endpoint=...     force a node healthy, see Node.ForceHealth
+//	POST /nodes/unhealthy?endpoint=...   force a node unhealthy
+//	POST /nodes/clear?endpoint=...       clear a forced health
+//	POST /nodes/drain?endpoint=...       drain a node, see DrainNode; copy_hot_keys=n copies its hot keys
+//	POST /nodes/undrain?endpoint=...     undrain a node
+//	POST /discover                       discover nodes from the sources now
+//	POST /flush                          remove all items from all nodes, see FlushAll
+//	POST /disable                        bypass the cache, see Disable
+//	POST /enable                         stop bypassing the cache, see Enable
+func (client *Client) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			adminError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		client.adminNodes(w)
+	})
+	client.adminAction(mux, "/nodes/healthy", AUDIT_FORCE_HEALTHY, func(r *http.Request) error {
+		return client.forceNodeHealth(r.URL.Query().Get("endpoint"), true)
+	})
+	client.adminAction(mux, "/nodes/unhealthy", AUDIT_FORCE_UNHEALTHY, func(r *http.Request) error {
+		return client.forceNodeHealth(r.URL.Query().Get("endpoint"), false)
+	})
+	client.adminAction(mux, "/nodes/clear", AUDIT_CLEAR_FORCED_HEALTH, func(r *http.Request) error {
+		node, err := client.GetNode(r.URL.Query().Get("endpoint"))
+		if err != nil {
+			return err
+		}
+		node.ClearForcedHealth()
+		return nil
+	})
+	client.adminAction(mux, "/nodes/drain", AUDIT_DRAIN_NODE, func(r *http.Request) error {
+		var opts []DrainOption
+		if n, err := strconv.Atoi(r.URL.Query().Get("copy_hot_keys")); err == nil {
+			opts = append(opts, WithCopyHotKeys(n))
+		}
+		return client.DrainNode(r.URL.Query().Get("endpoint"), opts...)
+	})
+	client.adminAction(mux, "/nodes/undrain", AUDIT_UNDRAIN_NODE, func(r *http.Request) error {
+		if !client.UndrainNode(r.URL.Query().Get("endpoint")) {
+			return ErrUnknownNode
+		}
+		return nil
+	})
+	client.adminAction(mux, "/discover", AUDIT_DISCOVER, func(r *http.Request) error {
+		client.GetNodes()
+		return nil
+	})
+	// FlushAllContext records its own AuditRecord
+	client.adminAction(mux, "/flush", "", func(r *http.Request) error {
+		return client.FlushAllContext(adminContext(r))
+	})
+	client.adminAction(mux, "/disable", AUDIT_DISABLE, func(r *http.Request) error {
+		client.Disable()
+		return nil
+	})
+	client.adminAction(mux, "/enable", AUDIT_ENABLE, func(r *http.Request) error {
+		client.Enable()
+		return nil
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !client.adminAuthorized(r) {
+			adminError(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAction registers a POST handler on mux performing action, recorded as audit unless empty, then responding with
+// the nodes
+func (client *Client) adminAction(mux *http.ServeMux, path string, audit string, action func(r *http.Request) error) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			adminError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		client.levelLog.Info("AdminHandler: %s %s from %s", r.Method, r.URL.RequestURI(), r.RemoteAddr)
+		start := client.Clock.Now()
+		err := action(r)
+		if audit != "" {
+			client.Audit(adminContext(r), audit, r.URL.Query().Get("endpoint"), start, err)
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrUnknownNode) || errors.Is(err, ErrInvalidEndpoint) {
+				status = http.StatusBadRequest
+			}
+			adminError(w, status, err)
+			return
+		}
+		client.adminNodes(w)
+	})
+}
+
+// adminContext returns the context of an admin request, with the remote address as its audit identity if the request
+// has none, e.g. set by authentication middleware with WithAuditIdentity
+func adminContext(r *http.Request) context.Context {
+	if AuditIdentity(r.Context()) != "" {
+		return r.Context()
+	}
+	return WithAuditIdentity(r.Context(), r.RemoteAddr)
+}
+
+func (client *Client) forceNodeHealth(endpoint string, healthy bool) error {
+	node, err := client.GetNode(endpoint)
+	if err != nil {
+		return err
+	}
+	node.ForceHealth(healthy)
+	return nil
+}
+
+// CheckAdminToken returns true if token is the AdminToken. It is always false if AdminToken is empty.
+func (client *Client) CheckAdminToken(token string) bool {
+	client.configMutex.RLock()
+	expected := client.AdminToken
+	client.configMutex.RUnlock()
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// adminAuthorized returns true if the request carries the AdminToken as a Bearer token
+func (client *Client) adminAuthorized(r *http.Request) bool {
+	return client.CheckAdminAuthorization(r.Header.Get("Authorization"))
+}
+
+// CheckAdminAuthorization returns true if authorization, the value of an Authorization header, is "Bearer " followed
+// by the AdminToken. Values without the "Bearer " prefix are refused.
+func (client *Client) CheckAdminAuthorization(authorization string) bool {
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	return client.CheckAdminToken(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+func (client *Client) adminNodes(w http.ResponseWriter) {
+	adminRespond(w, http.StatusOK, client.ListNodes())
+}
+
+func adminError(w http.ResponseWriter, status int, err error) {
+	adminRespond(w, status, map[string]string{"error": err.Error()})
+}
+
+func adminRespond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,230 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// auditLog collects the records delivered to an AuditHook
+type auditLog struct {
+	records []memcacheha.AuditRecord
+	mutex   sync.Mutex
+}
+
+func (log *auditLog) hook(record memcacheha.AuditRecord) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.records = append(log.records, record)
+}
+
+// take returns the records delivered since the last take
+func (log *auditLog) take() []memcacheha.AuditRecord {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	records := log.records
+	log.records = nil
+	return records
+}
+
+// adminRequest sends a request to handler with the given Authorization header, if not empty
+func adminRequest(handler http.Handler, method string, path string, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminHandlerAuthorization(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	audit := &auditLog{}
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.AdminToken = "secret"
+		client.AuditHook = audit.hook
+	})
+	handler := client.AdminHandler()
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")})
+
+	refused := map[string]string{
+		"missing token":     "",
+		"wrong token":       "Bearer wrong",
+		"no bearer prefix":  "secret",
+		"other scheme":      "Basic secret",
+		"empty bearer":      "Bearer ",
+		"lower case bearer": "bearer secret",
+	}
+	for name, authorization := range refused {
+		t.Run(name, func(t *testing.T) {
+			if w := adminRequest(handler, http.MethodPost, "/flush", authorization); w.Code != http.StatusUnauthorized {
+				t.Fatalf("Request returned %d, expected 401", w.Code)
+			}
+		})
+	}
+	cluster.AssertValue(t, "key", []byte("value"))
+	if records := audit.take(); len(records) != 0 {
+		t.Fatalf("Refused requests were audited: %+v", records)
+	}
+
+	if w := adminRequest(handler, http.MethodGet, "/nodes", "Bearer secret"); w.Code != http.StatusOK {
+		t.Fatalf("Request with the token returned %d, expected 200", w.Code)
+	}
+
+	// Without an AdminToken, the API is disabled
+	client.SetAdminToken("")
+	for _, authorization := range []string{"", "Bearer ", "Bearer secret"} {
+		if w := adminRequest(handler, http.MethodGet, "/nodes", authorization); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Request with %q and no AdminToken returned %d, expected 401", authorization, w.Code)
+		}
+	}
+}
+
+func TestAdminHandlerActions(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	audit := &auditLog{}
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.AdminToken = "secret"
+		client.ClientID = "admin-test"
+		client.AuditHook = audit.hook
+	})
+	handler := client.AdminHandler()
+
+	// post performs an action, returning the nodes it responded with and the single record it audited
+	post := func(t *testing.T, path string, status int) (*memcacheha.AdminNodes, memcacheha.AuditRecord) {
+		t.Helper()
+		w := adminRequest(handler, http.MethodPost, path, "Bearer secret")
+		if w.Code != status {
+			t.Fatalf("POST %s returned %d %s, expected %d", path, w.Code, w.Body.String(), status)
+		}
+		nodes := &memcacheha.AdminNodes{}
+		if status == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), nodes); err != nil {
+				t.Fatalf("Decoding the response of POST %s failed: %s", path, err)
+			}
+		}
+		records := audit.take()
+		if len(records) != 1 {
+			t.Fatalf("POST %s audited %+v, expected one record", path, records)
+		}
+		if records[0].ClientID != "admin-test" || records[0].Identity != "192.0.2.1:1234" {
+			t.Fatalf("POST %s audited %+v, expected the client ID and remote address", path, records[0])
+		}
+		return nodes, records[0]
+	}
+	forced := func(nodes *memcacheha.AdminNodes, endpoint string) string {
+		for _, node := range nodes.Nodes {
+			if node.Endpoint == endpoint {
+				return node.Forced
+			}
+		}
+		t.Fatalf("%s not listed in %+v", endpoint, nodes)
+		return ""
+	}
+
+	w := adminRequest(handler, http.MethodGet, "/nodes", "Bearer secret")
+	nodes := &memcacheha.AdminNodes{}
+	if err := json.Unmarshal(w.Body.Bytes(), nodes); err != nil || len(nodes.Nodes) != 3 {
+		t.Fatalf("GET /nodes returned %s, %v", w.Body.String(), err)
+	}
+	if records := audit.take(); len(records) != 0 {
+		t.Fatalf("GET /nodes was audited: %+v", records)
+	}
+	for method, path := range map[string]string{http.MethodPost: "/nodes", http.MethodGet: "/flush"} {
+		if w := adminRequest(handler, method, path, "Bearer secret"); w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s %s returned %d, expected 405", method, path, w.Code)
+		}
+	}
+
+	actions := []struct {
+		path   string
+		action string
+		target string
+		check  func(t *testing.T, nodes *memcacheha.AdminNodes)
+	}{
+		{"/nodes/unhealthy?endpoint=node1:11211", memcacheha.AUDIT_FORCE_UNHEALTHY, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "unhealthy" {
+				t.Fatalf("node1 not forced unhealthy: %+v", nodes)
+			}
+		}},
+		{"/nodes/healthy?endpoint=node1:11211", memcacheha.AUDIT_FORCE_HEALTHY, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "healthy" {
+				t.Fatalf("node1 not forced healthy: %+v", nodes)
+			}
+		}},
+		{"/nodes/clear?endpoint=node1:11211", memcacheha.AUDIT_CLEAR_FORCED_HEALTH, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "" {
+				t.Fatalf("node1 still forced: %+v", nodes)
+			}
+		}},
+		{"/nodes/drain?endpoint=node2:11211", memcacheha.AUDIT_DRAIN_NODE, "node2:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Drained) != 1 || nodes.Drained[0] != "node2:11211" {
+				t.Fatalf("node2 not drained: %+v", nodes)
+			}
+		}},
+		{"/nodes/undrain?endpoint=node2:11211", memcacheha.AUDIT_UNDRAIN_NODE, "node2:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Drained) != 0 {
+				t.Fatalf("node2 still drained: %+v", nodes)
+			}
+		}},
+		{"/disable", memcacheha.AUDIT_DISABLE, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if !nodes.Disabled || !client.Disabled() {
+				t.Fatalf("Client not disabled: %+v", nodes)
+			}
+		}},
+		{"/enable", memcacheha.AUDIT_ENABLE, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if nodes.Disabled || client.Disabled() {
+				t.Fatalf("Client still disabled: %+v", nodes)
+			}
+		}},
+		{"/discover", memcacheha.AUDIT_DISCOVER, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Nodes) != 4 {
+				t.Fatalf("Added node not discovered: %+v", nodes)
+			}
+		}},
+	}
+	cluster.AddNode("node4:11211")
+	for _, action := range actions {
+		t.Run(action.action, func(t *testing.T) {
+			nodes, record := post(t, action.path, http.StatusOK)
+			if record.Action != action.action || record.Target != action.target || record.Error != "" {
+				t.Fatalf("Audited %+v, expected %s of %q without error", record, action.action, action.target)
+			}
+			action.check(t, nodes)
+		})
+	}
+
+	// Failed actions are refused, and audited with their error
+	for path, action := range map[string]string{
+		"/nodes/healthy?endpoint=unknown:11211": memcacheha.AUDIT_FORCE_HEALTHY,
+		"/nodes/undrain?endpoint=node2:11211":   memcacheha.AUDIT_UNDRAIN_NODE,
+		"/nodes/drain?endpoint=a:b:c":           memcacheha.AUDIT_DRAIN_NODE,
+	} {
+		if _, record := post(t, path, http.StatusBadRequest); record.Action != action || record.Error == "" {
+			t.Fatalf("POST %s audited %+v, expected %s with its error", path, record, action)
+		}
+	}
+
+	// Flush removes all items, recording the remote address as the identity
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if _, record := post(t, "/flush", http.StatusOK); record.Action != memcacheha.AUDIT_FLUSH_ALL || record.Target != "" || record.Error != "" {
+		t.Fatalf("POST /flush audited %+v", record)
+	}
+	cluster.AssertValue(t, "key", nil)
+
+	// An identity set by authentication middleware is recorded in place of the remote address
+	r := httptest.NewRequest(http.MethodPost, "/disable", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(memcacheha.WithAuditIdentity(r.Context(), "alice")))
+	if records := audit.take(); len(records) != 1 || records[0].Identity != "alice" {
+		t.Fatalf("Request with an identity audited %+v", records)
+	}
+}
@@ -0,0 +1,42 @@
+package memcacheha
+
+// GetResult is the result of a GetAsync
+type GetResult struct {
+	Item  *Item
+	Error error
+}
+
+// SetAsync writes item as Set does, without waiting. The error of the write is sent on the returned channel, which
+// is buffered so the result may be ignored. item must not be modified until the write completes.
+func (client *Client) SetAsync(item *Item, opts ...WriteOption) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- client.Set(item, opts...) }()
+	return result
+}
+
+// AddAsync writes item as Add does, without waiting. The error of the write is sent on the returned channel, which
+// is buffered so the result may be ignored. item must not be modified until the write completes.
+func (client *Client) AddAsync(item *Item, opts ...WriteOption) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- client.Add(item, opts...) }()
+	return result
+}
+
+// DeleteAsync deletes key as Delete does, without waiting. The error of the delete is sent on the returned channel,
+// which is buffered so the result may be ignored.
+func (client *Client) DeleteAsync(key string, opts ...WriteOption) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- client.Delete(key, opts...) }()
+	return result
+}
+
+// GetAsync reads key as Get does, without waiting. The item or error is sent on the returned channel, which is
+// buffered so the result may be ignored.
+func (client *Client) GetAsync(key string, opts ...ReadOption) <-chan GetResult {
+	result := make(chan GetResult, 1)
+	go func() {
+		item, err := client.Get(key, opts...)
+		result <- GetResult{Item: item, Error: err}
+	}()
+	return result
+}
@@ -0,0 +1,94 @@
+package memcacheha
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Audited actions, see AuditRecord
+const (
+	AUDIT_DELETE              = "delete"
+	AUDIT_FLUSH_ALL           = "flush_all"
+	AUDIT_FORCE_HEALTHY       = "force_healthy"
+	AUDIT_FORCE_UNHEALTHY     = "force_unhealthy"
+	AUDIT_CLEAR_FORCED_HEALTH = "clear_forced_health"
+	AUDIT_DRAIN_NODE          = "drain_node"
+	AUDIT_UNDRAIN_NODE        = "undrain_node"
+	AUDIT_DISCOVER            = "discover"
+	AUDIT_DISABLE             = "disable"
+	AUDIT_ENABLE              = "enable"
+)
+
+// AuditRecord is a destructive or administrative operation, delivered to Client.AuditHook
+type AuditRecord struct {
+	// Time is the start time of the operation
+	Time time.Time `json:"time"`
+	// ClientID is the ClientID of the client performing the operation
+	ClientID string `json:"client_id,omitempty"`
+	// Identity is the caller, set on the context of the operation with WithAuditIdentity
+	Identity string `json:"identity,omitempty"`
+	// Action is the operation, one of the AUDIT_ constants
+	Action string `json:"action"`
+	// Target is the key of a delete, hashed or redacted under the client's KeyLogMode, or the endpoint of a node
+	Target string `json:"target,omitempty"`
+	// Error is the error returned by the operation, if any
+	Error string `json:"error,omitempty"`
+}
+
+type auditIdentityKey struct{}
+
+// WithAuditIdentity returns a copy of ctx carrying the identity of the caller, recorded in AuditRecords of
+// operations performed with it, see WithContext and FlushAllContext
+func WithAuditIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, auditIdentityKey{}, identity)
+}
+
+// AuditIdentity returns the identity set on ctx with WithAuditIdentity, or ""
+func AuditIdentity(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	identity, _ := ctx.Value(auditIdentityKey{}).(string)
+	return identity
+}
+
+// AuditWriterHook returns an AuditHook which writes each record to w as a line of JSON. Writes are serialised, and
+// errors are ignored.
+func AuditWriterHook(w io.Writer) func(AuditRecord) {
+	var mutex sync.Mutex
+	return func(record AuditRecord) {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write(append(data, '\n'))
+	}
+}
+
+// Audit delivers a record of an operation to AuditHook, with the identity of ctx. Operations of the client are
+// audited by the client; Audit is for administrative actions performed through other means.
+func (client *Client) Audit(ctx context.Context, action string, target string, start time.Time, err error) {
+	client.configMutex.RLock()
+	hook, clientID := client.AuditHook, client.ClientID
+	client.configMutex.RUnlock()
+	if hook == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:     start,
+		ClientID: clientID,
+		Identity: AuditIdentity(ctx),
+		Action:   action,
+		Target:   target,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	hook(record)
+}
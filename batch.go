@@ -0,0 +1,232 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// NodeBatchOpType is the command of a NodeBatchOp
+type NodeBatchOpType int
+
+const (
+	// NODE_BATCH_SET writes Item, as NodeClient.Set does
+	NODE_BATCH_SET NodeBatchOpType = iota
+	// NODE_BATCH_DELETE deletes Key, as NodeClient.Delete does
+	NODE_BATCH_DELETE
+	// NODE_BATCH_TOUCH sets the expiry of Key to Seconds, as NodeClient.Touch does
+	NODE_BATCH_TOUCH
+)
+
+// NodeBatchOp is a write sent by NodeBatcher.Batch
+type NodeBatchOp struct {
+	Type    NodeBatchOpType
+	Item    *memcache.Item
+	Key     string
+	Seconds int32
+	// Err is the result of the operation, set by Batch to the error the corresponding NodeClient method would return
+	Err error
+}
+
+// NodeBatcher is implemented by NodeClients able to send several writes to their server in one round trip, used by
+// Pipeline, SetMulti, DeleteMulti and TouchMulti. Batch performs ops in order, storing the result of each in its Err.
+// If Batch returns an error, the results of ops are unknown and the error applies to all of them.
+type NodeBatcher interface {
+	Batch(ops []*NodeBatchOp) error
+}
+
+// errUnexpectedResponse is returned by Batch when the server answers a command with an unknown response
+var errUnexpectedResponse = errors.New("memcache: unexpected response")
+
+// Batch implements NodeBatcher, writing every command on one connection before reading their responses. Each response
+// must arrive within the Timeout of the client after the previous one.
+func (client *memcacheNodeClient) Batch(ops []*NodeBatchOp) error {
+	conn, reused, err := client.takeBatchConn()
+	if err != nil {
+		return err
+	}
+	read, err := client.batch(conn, ops)
+	if err != nil && reused && read == 0 {
+		// The idle connection may have been closed by the server, so retry once on a new one
+		conn.Close()
+		if conn, _, err = client.takeBatchConn(); err != nil {
+			return err
+		}
+		_, err = client.batch(conn, ops)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	client.putBatchConn(conn)
+	return nil
+}
+
+// batch sends ops on conn and reads their results, returning the number of responses read
+func (client *memcacheNodeClient) batch(conn net.Conn, ops []*NodeBatchOp) (int, error) {
+	conn.SetDeadline(time.Now().Add(client.Timeout))
+	writer := bufio.NewWriter(conn)
+	sent := make([]*NodeBatchOp, 0, len(ops))
+	for _, op := range ops {
+		op.Err = nil
+		key := op.Key
+		if op.Type == NODE_BATCH_SET {
+			key = op.Item.Key
+		}
+		if ValidateKey(key) != nil {
+			op.Err = memcache.ErrMalformedKey
+			continue
+		}
+		switch op.Type {
+		case NODE_BATCH_SET:
+			fmt.Fprintf(writer, "set %s %d %d %d\r\n", key, op.Item.Flags, op.Item.Expiration, len(op.Item.Value))
+			writer.Write(op.Item.Value)
+			writer.WriteString("\r\n")
+		case NODE_BATCH_DELETE:
+			fmt.Fprintf(writer, "delete %s\r\n", key)
+		case NODE_BATCH_TOUCH:
+			fmt.Fprintf(writer, "touch %s %d\r\n", key, op.Seconds)
+		}
+		sent = append(sent, op)
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(conn)
+	for read, op := range sent {
+		conn.SetReadDeadline(time.Now().Add(client.Timeout))
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			return read, err
+		}
+		result, ok := batchResult(op.Type, strings.TrimSuffix(string(line), "\r\n"))
+		if !ok {
+			return read, fmt.Errorf("%w: %q", errUnexpectedResponse, line)
+		}
+		op.Err = result
+	}
+	return len(sent), nil
+}
+
+// batchResult returns the result of a command of the given type from its response line, and false if the line is not
+// a response to it
+func batchResult(opType NodeBatchOpType, line string) (error, bool) {
+	switch line {
+	case "NOT_FOUND":
+		return memcache.ErrCacheMiss, true
+	case "STORED":
+		return nil, opType == NODE_BATCH_SET
+	case "NOT_STORED":
+		return memcache.ErrNotStored, opType == NODE_BATCH_SET
+	case "DELETED":
+		return nil, opType == NODE_BATCH_DELETE
+	case "TOUCHED":
+		return nil, opType == NODE_BATCH_TOUCH
+	}
+	switch {
+	case strings.HasPrefix(line, "CLIENT_ERROR "):
+		return errors.New("memcache: client error: " + strings.TrimPrefix(line, "CLIENT_ERROR ")), true
+	case strings.HasPrefix(line, "SERVER_ERROR"):
+		return memcache.ErrServerError, true
+	}
+	return nil, false
+}
+
+// takeBatchConn returns the idle connection used by the last Batch, and true, or a new connection
+func (client *memcacheNodeClient) takeBatchConn() (net.Conn, bool, error) {
+	client.batchMutex.Lock()
+	conn := client.batchConn
+	client.batchConn = nil
+	client.batchMutex.Unlock()
+	if conn != nil {
+		return conn, true, nil
+	}
+
+	dial := client.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", client.endpoint)
+	return conn, false, err
+}
+
+// putBatchConn keeps conn for the next Batch, closing it if another connection is already kept
+func (client *memcacheNodeClient) putBatchConn(conn net.Conn) {
+	client.batchMutex.Lock()
+	defer client.batchMutex.Unlock()
+	if client.batchConn != nil {
+		conn.Close()
+		return
+	}
+	client.batchConn = conn
+}
+
+// batch sends ops to the node as one NodeBatcher.Batch, storing the error of each in errs, and returns true, or
+// returns false if the node client is not a NodeBatcher
+func (node *Node) batch(opID string, ops []*pipelineOp, errs []error) bool {
+	batcher, ok := node.getClient().(NodeBatcher)
+	if !ok {
+		return false
+	}
+	now := node.clock.Now()
+	batch := make([]*NodeBatchOp, 0, len(ops))
+	indexes := make([]int, 0, len(ops))
+	for i, op := range ops {
+		if errs[i] != nil || op.Error != nil {
+			continue
+		}
+		batchOp := &NodeBatchOp{Key: op.Item.Key, Seconds: op.Seconds}
+		switch op.Type {
+		case pipelineSet:
+			// Items already expired are not written, as doSet does
+			if op.Item.Expiration != nil && !op.Item.Expiration.After(now) {
+				continue
+			}
+			node.debug(opID, "SET %s", node.keyForLog(op.Item.Key))
+			mcItem, err := node.encode(op.Item, now)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			batchOp.Type, batchOp.Item = NODE_BATCH_SET, mcItem
+		case pipelineDelete:
+			node.debug(opID, "DELETE %s", node.keyForLog(op.Item.Key))
+			batchOp.Type = NODE_BATCH_DELETE
+		case pipelineTouch:
+			node.debug(opID, "TOUCH %s", node.keyForLog(op.Item.Key))
+			batchOp.Type = NODE_BATCH_TOUCH
+		}
+		batch = append(batch, batchOp)
+		indexes = append(indexes, i)
+	}
+	if len(batch) == 0 {
+		return true
+	}
+
+	node.limiter.acquire()
+	err := batcher.Batch(batch)
+	node.limiter.release()
+	if err != nil {
+		response := node.getNodeResponse(opID, nil, err)
+		for _, i := range indexes {
+			errs[i] = response.Error
+		}
+		releaseNodeResponse(response)
+		return true
+	}
+	for j, i := range indexes {
+		response := node.getNodeResponse(opID, nil, batch[j].Err)
+		errs[i] = response.Error
+		releaseNodeResponse(response)
+	}
+	return true
+}
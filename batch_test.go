@@ -0,0 +1,208 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// textServer is a memcached text protocol server for set, delete and touch, counting its connections
+type textServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	items    map[string]bool
+	conns    []net.Conn
+}
+
+func newTextServer(t *testing.T) *textServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &textServer{listener: listener, items: map[string]bool{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, conn)
+			server.mutex.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *textServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		server.mutex.Lock()
+		response := "ERROR"
+		switch {
+		case fields[0] == "set" && len(fields) == 5:
+			size, _ := strconv.Atoi(fields[4])
+			if _, err := io.ReadFull(reader, make([]byte, size+2)); err != nil {
+				server.mutex.Unlock()
+				return
+			}
+			server.items[fields[1]] = true
+			response = "STORED"
+		case fields[0] == "delete" || fields[0] == "touch":
+			response = "NOT_FOUND"
+			if server.items[fields[1]] {
+				response = map[string]string{"delete": "DELETED", "touch": "TOUCHED"}[fields[0]]
+			}
+			if fields[0] == "delete" {
+				delete(server.items, fields[1])
+			}
+		}
+		server.mutex.Unlock()
+		conn.Write([]byte(response + "\r\n"))
+	}
+}
+
+// closeConns closes the server side of every connection
+func (server *textServer) closeConns() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+}
+
+func (server *textServer) connCount() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.conns)
+}
+
+func TestMemcacheNodeClientBatch(t *testing.T) {
+	server := newTextServer(t)
+	batcher := memcacheha.NewMemcacheNodeClient(server.listener.Addr().String(), time.Second).(memcacheha.NodeBatcher)
+
+	batch := func() []*memcacheha.NodeBatchOp {
+		ops := []*memcacheha.NodeBatchOp{
+			{Type: memcacheha.NODE_BATCH_SET, Item: &memcache.Item{Key: "a", Value: []byte("1")}},
+			{Type: memcacheha.NODE_BATCH_TOUCH, Key: "a", Seconds: 10},
+			{Type: memcacheha.NODE_BATCH_DELETE, Key: "a"},
+			{Type: memcacheha.NODE_BATCH_DELETE, Key: "a"},
+			{Type: memcacheha.NODE_BATCH_DELETE, Key: "bad key"},
+		}
+		if err := batcher.Batch(ops); err != nil {
+			t.Fatalf("Batch failed: %s", err)
+		}
+		return ops
+	}
+	expected := []error{nil, nil, nil, memcache.ErrCacheMiss, memcache.ErrMalformedKey}
+	for i, op := range batch() {
+		if op.Err != expected[i] {
+			t.Fatalf("Operation %d returned %v, expected %v", i, op.Err, expected[i])
+		}
+	}
+
+	// The connection is reused, and replaced if the server closed it
+	batch()
+	if count := server.connCount(); count != 1 {
+		t.Fatalf("Batches used %d connections, expected 1", count)
+	}
+	server.closeConns()
+	batch()
+	if count := server.connCount(); count != 2 {
+		t.Fatalf("Batches used %d connections, expected 2", count)
+	}
+}
+
+// countingBatcher counts the batches sent to a NodeClient
+type countingBatcher struct {
+	memcacheha.NodeClient
+	mutex   sync.Mutex
+	batches int
+}
+
+func (node *countingBatcher) Batch(ops []*memcacheha.NodeBatchOp) error {
+	node.mutex.Lock()
+	node.batches++
+	node.mutex.Unlock()
+	return node.NodeClient.(memcacheha.NodeBatcher).Batch(ops)
+}
+
+func TestPipelineBatchesPerNode(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	cluster.Put(&memcacheha.Item{Key: "c", Value: []byte("old")})
+	nodes := map[string]*countingBatcher{}
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.NewNodeClient = func(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+			nodes[endpoint] = &countingBatcher{NodeClient: cluster.NewNodeClient(endpoint, timeout)}
+			return nodes[endpoint]
+		}
+	})
+
+	pipeline := client.Pipeline()
+	pipeline.Set(&memcacheha.Item{Key: "a", Value: []byte("1")})
+	pipeline.Set(&memcacheha.Item{Key: "b", Value: []byte("2")})
+	pipeline.Delete("c")
+	pipeline.Touch("missing", 10)
+	results, err := pipeline.Flush()
+	if err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	expected := map[string]error{"a": nil, "b": nil, "c": nil, "missing": memcache.ErrCacheMiss}
+	for key, err := range expected {
+		if results[key] != err {
+			t.Fatalf("Flush returned %v for %s, expected %v", results[key], key, err)
+		}
+	}
+	cluster.AssertValue(t, "a", []byte("1"))
+	cluster.AssertValue(t, "b", []byte("2"))
+	cluster.AssertValue(t, "c", nil)
+	for endpoint, node := range nodes {
+		if node.batches != 1 {
+			t.Fatalf("%s received %d batches, expected 1", endpoint, node.batches)
+		}
+	}
+}
+
+func TestPipelineResultsWithoutNodes(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	results, err := client.Pipeline().Flush()
+	if err != nil || len(results) != 0 {
+		t.Fatalf("Flush of an empty pipeline returned %v, %v", results, err)
+	}
+
+	// Invalid keys are rejected before any node is needed
+	results, err = client.DeleteMulti([]string{"bad key"})
+	if err != nil || results["bad key"] != memcacheha.ErrKeyInvalidCharacter {
+		t.Fatalf("DeleteMulti returned %v, %v, expected ErrKeyInvalidCharacter for the key", results, err)
+	}
+
+	for _, node := range client.Nodes.GetNodes() {
+		node.ForceHealth(false)
+	}
+	results, err = client.SetMulti([]*memcacheha.Item{{Key: "bad key"}, {Key: "key", Value: []byte("value")}})
+	if err != memcacheha.ErrNoHealthyNodes {
+		t.Fatalf("SetMulti returned %v, expected ErrNoHealthyNodes", err)
+	}
+	if results["bad key"] != memcacheha.ErrKeyInvalidCharacter || results["key"] != memcacheha.ErrNoHealthyNodes {
+		t.Fatalf("SetMulti returned %v, expected the key error and ErrNoHealthyNodes", results)
+	}
+}
@@ -0,0 +1,275 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// BLUEGREEN_SHADOW_RATE is the fraction of hits on the active node set of a BlueGreenClient also read from the
+	// staged set, to measure its hit rate
+	BLUEGREEN_SHADOW_RATE = 0.01
+)
+
+// BlueGreenStats are the shadow reads and mirrored writes of a BlueGreenClient since its set was staged, see Stats
+type BlueGreenStats struct {
+	// ShadowReads are the hits on the active set also read from the staged set, of which ShadowHits were found there
+	ShadowReads uint64 `json:"shadow_reads"`
+	ShadowHits  uint64 `json:"shadow_hits"`
+	// StagedErrors are the writes that succeeded on the active set but failed on the staged set
+	StagedErrors uint64 `json:"staged_errors"`
+}
+
+// BlueGreenClient replaces a node set without a cold cache. A replacement set ("green") is staged alongside the active
+// one ("blue") with Stage, and every write is mirrored to it, while reads are served by the active set only. Switch
+// makes the staged set active for all reads at once, keeping the previous set staged, and mirrored to, so the switch
+// can be rolled back until Unstage. The result of an operation is that of the active set; failures on the staged set
+// are logged and counted in Stats.
+//
+// A sample of hits on the active set (BLUEGREEN_SHADOW_RATE) are also read from the staged set, in the background, to
+// measure the fraction of hot items it holds, see HitRate. SetAutoSwitch switches once it reaches a threshold.
+//
+// The Clients are started and stopped by the caller, so a set can be staged on a running client.
+type BlueGreenClient struct {
+	Log logger.Logger
+
+	mutex      sync.RWMutex
+	active     *Client
+	staged     *Client
+	autoSwitch float64
+	minReads   uint64
+
+	shadowReads  uint64
+	shadowHits   uint64
+	stagedErrors uint64
+}
+
+// NewBlueGreenClient returns a new BlueGreenClient reading from and writing to active, with no staged set
+func NewBlueGreenClient(log logger.Logger, active *Client) *BlueGreenClient {
+	return &BlueGreenClient{
+		Log:    log,
+		active: active,
+	}
+}
+
+// Active returns the Client of the active node set
+func (blueGreen *BlueGreenClient) Active() *Client {
+	blueGreen.mutex.RLock()
+	defer blueGreen.mutex.RUnlock()
+	return blueGreen.active
+}
+
+// Staged returns the Client of the staged node set, or nil
+func (blueGreen *BlueGreenClient) Staged() *Client {
+	blueGreen.mutex.RLock()
+	defer blueGreen.mutex.RUnlock()
+	return blueGreen.staged
+}
+
+// Stage mirrors writes to the given client from now on, replacing any staged client, and resets Stats
+func (blueGreen *BlueGreenClient) Stage(staged *Client) {
+	blueGreen.mutex.Lock()
+	defer blueGreen.mutex.Unlock()
+	blueGreen.staged = staged
+	blueGreen.resetStats()
+	blueGreen.Log.Info("BlueGreenClient: Staged node set")
+}
+
+// Unstage stops mirroring writes to the staged client, returning it, or nil if none was staged, e.g. to stop it once
+// the active set needs no rollback
+func (blueGreen *BlueGreenClient) Unstage() *Client {
+	blueGreen.mutex.Lock()
+	defer blueGreen.mutex.Unlock()
+	staged := blueGreen.staged
+	blueGreen.staged = nil
+	blueGreen.autoSwitch = 0
+	if staged != nil {
+		blueGreen.Log.Info("BlueGreenClient: Unstaged node set")
+	}
+	return staged
+}
+
+// Switch makes the staged node set active and the active set staged, returning ErrNoStagedNodes if no set is
+// staged. Stats are reset and SetAutoSwitch is disabled, so calling Switch again rolls back.
+func (blueGreen *BlueGreenClient) Switch() error {
+	blueGreen.mutex.Lock()
+	defer blueGreen.mutex.Unlock()
+	return blueGreen.doSwitch()
+}
+
+func (blueGreen *BlueGreenClient) doSwitch() error {
+	if blueGreen.staged == nil {
+		return ErrNoStagedNodes
+	}
+	blueGreen.active, blueGreen.staged = blueGreen.staged, blueGreen.active
+	blueGreen.autoSwitch = 0
+	blueGreen.resetStats()
+	blueGreen.Log.Warn("BlueGreenClient: Switched node sets")
+	return nil
+}
+
+// SetAutoSwitch switches to the staged node set once its HitRate reaches hitRate (0 to 1), after at least minReads
+// shadow reads. Zero disables it.
+func (blueGreen *BlueGreenClient) SetAutoSwitch(hitRate float64, minReads uint64) {
+	blueGreen.mutex.Lock()
+	defer blueGreen.mutex.Unlock()
+	blueGreen.autoSwitch = hitRate
+	blueGreen.minReads = minReads
+}
+
+// HitRate returns the fraction of shadow reads found on the staged node set, or zero if there were none
+func (blueGreen *BlueGreenClient) HitRate() float64 {
+	reads := atomic.LoadUint64(&blueGreen.shadowReads)
+	if reads == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&blueGreen.shadowHits)) / float64(reads)
+}
+
+// Stats returns the shadow reads and staged write failures since the node set was staged or switched
+func (blueGreen *BlueGreenClient) Stats() BlueGreenStats {
+	return BlueGreenStats{
+		ShadowReads:  atomic.LoadUint64(&blueGreen.shadowReads),
+		ShadowHits:   atomic.LoadUint64(&blueGreen.shadowHits),
+		StagedErrors: atomic.LoadUint64(&blueGreen.stagedErrors),
+	}
+}
+
+func (blueGreen *BlueGreenClient) resetStats() {
+	atomic.StoreUint64(&blueGreen.shadowReads, 0)
+	atomic.StoreUint64(&blueGreen.shadowHits, 0)
+	atomic.StoreUint64(&blueGreen.stagedErrors, 0)
+}
+
+// clients returns the active Client and the staged Client, or nil
+func (blueGreen *BlueGreenClient) clients() (*Client, *Client) {
+	blueGreen.mutex.RLock()
+	defer blueGreen.mutex.RUnlock()
+	return blueGreen.active, blueGreen.staged
+}
+
+// stagedError logs and counts a failure of a write mirrored to the staged set
+func (blueGreen *BlueGreenClient) stagedError(name string, active *Client, key string, err error) {
+	atomic.AddUint64(&blueGreen.stagedErrors, 1)
+	blueGreen.Log.Warn("BlueGreenClient: %s of %s failed on the staged node set: %s", name, active.LogKey(key), err)
+}
+
+// write performs op on the active and staged Client concurrently, returning the error of the active one. A failure of
+// the staged one is logged and counted, unless it is a miss, see isMiss.
+func (blueGreen *BlueGreenClient) write(name string, key string, op func(client *Client) error) error {
+	active, staged := blueGreen.clients()
+	if staged == nil {
+		return op(active)
+	}
+	stagedErr := make(chan error, 1)
+	go func() {
+		stagedErr <- op(staged)
+	}()
+	err := op(active)
+	if serr := <-stagedErr; serr != nil && !isMiss(serr) {
+		blueGreen.stagedError(name, active, key, serr)
+	}
+	return err
+}
+
+// shadowRead reads key from the staged Client, counting whether it was found, then switches if the hit rate reached
+// the SetAutoSwitch threshold
+func (blueGreen *BlueGreenClient) shadowRead(staged *Client, key string, opts []ReadOption) {
+	_, err := staged.Get(key, opts...)
+	if err != nil && err != memcache.ErrCacheMiss {
+		return
+	}
+	reads := atomic.AddUint64(&blueGreen.shadowReads, 1)
+	if err == nil {
+		atomic.AddUint64(&blueGreen.shadowHits, 1)
+	}
+
+	blueGreen.mutex.Lock()
+	defer blueGreen.mutex.Unlock()
+	// The sets may have been switched or unstaged since the read
+	if blueGreen.autoSwitch <= 0 || blueGreen.staged != staged || reads < blueGreen.minReads {
+		return
+	}
+	if hitRate := blueGreen.HitRate(); hitRate >= blueGreen.autoSwitch {
+		blueGreen.Log.Info("BlueGreenClient: Staged hit rate %.3f reached %.3f", hitRate, blueGreen.autoSwitch)
+		blueGreen.doSwitch()
+	}
+}
+
+// Set performs Client.Set on both node sets
+func (blueGreen *BlueGreenClient) Set(item *Item, opts ...WriteOption) error {
+	return blueGreen.write("Set", item.Key, func(client *Client) error {
+		return client.Set(item, opts...)
+	})
+}
+
+// Add performs Client.Add on the active node set and, if it is stored, Client.Set on the staged set, so both hold
+// the same value
+func (blueGreen *BlueGreenClient) Add(item *Item, opts ...WriteOption) error {
+	active, staged := blueGreen.clients()
+	if err := active.Add(item, opts...); err != nil {
+		return err
+	}
+	if staged != nil {
+		if err := staged.Set(item, opts...); err != nil {
+			blueGreen.stagedError("Add", active, item.Key, err)
+		}
+	}
+	return nil
+}
+
+// Get performs Client.Get on the active node set. A sample of hits are read from the staged set in the background,
+// see HitRate.
+func (blueGreen *BlueGreenClient) Get(key string, opts ...ReadOption) (*Item, error) {
+	active, staged := blueGreen.clients()
+	item, err := active.Get(key, opts...)
+	if err == nil && staged != nil && randFloat64() < BLUEGREEN_SHADOW_RATE {
+		go blueGreen.shadowRead(staged, key, opts)
+	}
+	return item, err
+}
+
+// Delete performs Client.Delete on both node sets, returning the result of the active set
+func (blueGreen *BlueGreenClient) Delete(key string, opts ...WriteOption) error {
+	return blueGreen.write("Delete", key, func(client *Client) error {
+		return client.Delete(key, opts...)
+	})
+}
+
+// Touch performs Client.Touch on both node sets, returning the result of the active set
+func (blueGreen *BlueGreenClient) Touch(key string, seconds int32, opts ...WriteOption) error {
+	return blueGreen.write("Touch", key, func(client *Client) error {
+		return client.Touch(key, seconds, opts...)
+	})
+}
+
+// Increment performs Client.Increment on the active node set, deleting the key from the staged set so a stale value
+// is never read from it after a switch
+func (blueGreen *BlueGreenClient) Increment(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return blueGreen.incrDecr("Increment", key, func(client *Client) (uint64, error) {
+		return client.Increment(key, delta, opts...)
+	})
+}
+
+// Decrement performs Client.Decrement on the active node set, deleting the key from the staged set so a stale value
+// is never read from it after a switch
+func (blueGreen *BlueGreenClient) Decrement(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return blueGreen.incrDecr("Decrement", key, func(client *Client) (uint64, error) {
+		return client.Decrement(key, delta, opts...)
+	})
+}
+
+func (blueGreen *BlueGreenClient) incrDecr(name string, key string, op func(client *Client) (uint64, error)) (uint64, error) {
+	active, staged := blueGreen.clients()
+	value, err := op(active)
+	if err != nil || staged == nil {
+		return value, err
+	}
+	if err := staged.Delete(key); err != nil && err != memcache.ErrCacheMiss {
+		blueGreen.stagedError(name, active, key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,178 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBlueGreenMirroredWrites(t *testing.T) {
+	blue, green := memcachehatest.NewCluster(3), memcachehatest.NewCluster(3)
+	blueGreen := memcacheha.NewBlueGreenClient(logger.NewConsoleLogger("error"), blue.NewClient(t))
+	greenClient := green.NewClient(t)
+
+	// Without a staged set, writes go to the active set only
+	if err := blueGreen.Switch(); err != memcacheha.ErrNoStagedNodes {
+		t.Fatalf("Switch without a staged set returned %v, expected ErrNoStagedNodes", err)
+	}
+	if err := blueGreen.Set(&memcacheha.Item{Key: "unstaged", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	green.AssertValue(t, "unstaged", nil)
+
+	// Once staged, writes are mirrored to it, while reads are served by the active set
+	blueGreen.Stage(greenClient)
+	if err := blueGreen.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := blueGreen.Add(&memcacheha.Item{Key: "added", Value: []byte("added")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := blueGreen.Add(&memcacheha.Item{Key: "added", Value: []byte("other")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+	for _, cluster := range []*memcachehatest.Cluster{blue, green} {
+		cluster.AssertValue(t, "key", []byte("value"))
+		cluster.AssertValue(t, "added", []byte("added"))
+	}
+	green.Put(&memcacheha.Item{Key: "green", Value: []byte("value")})
+	if _, err := blueGreen.Get("green"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a key on the staged set only returned %v, expected ErrCacheMiss", err)
+	}
+
+	// Counters are deleted from the staged set, and misses there are not failures
+	for _, cluster := range []*memcachehatest.Cluster{blue, green} {
+		cluster.Put(&memcacheha.Item{Key: "counter", Value: []byte("5")})
+	}
+	if value, err := blueGreen.Increment("counter", 1); err != nil || value != 6 {
+		t.Fatalf("Increment returned %d, %v, expected 6", value, err)
+	}
+	blue.AssertValue(t, "counter", []byte("6"))
+	green.AssertValue(t, "counter", nil)
+	green.Remove("key")
+	if err := blueGreen.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	blue.AssertValue(t, "key", nil)
+	if stats := blueGreen.Stats(); stats != (memcacheha.BlueGreenStats{}) {
+		t.Fatalf("Stats are %+v, expected no staged errors", stats)
+	}
+
+	// Failures of the staged set are counted, not returned
+	green.Fail(errors.New("node failure"))
+	if err := blueGreen.Set(&memcacheha.Item{Key: "key", Value: []byte("active only")}); err != nil {
+		t.Fatalf("Set with a failed staged set returned %s", err)
+	}
+	if err := blueGreen.Add(&memcacheha.Item{Key: "key2", Value: []byte("active only")}); err != nil {
+		t.Fatalf("Add with a failed staged set returned %s", err)
+	}
+	if stats := blueGreen.Stats(); stats.StagedErrors != 2 {
+		t.Fatalf("Stats are %+v, expected 2 staged errors", stats)
+	}
+}
+
+func TestBlueGreenSwitch(t *testing.T) {
+	blue, green := memcachehatest.NewCluster(3), memcachehatest.NewCluster(3)
+	blueClient, greenClient := blue.NewClient(t), green.NewClient(t)
+	blueGreen := memcacheha.NewBlueGreenClient(logger.NewConsoleLogger("error"), blueClient)
+	blueGreen.Stage(greenClient)
+	green.Fail(errors.New("node failure"))
+	blueGreen.Set(&memcacheha.Item{Key: "failed", Value: []byte("value")})
+	green.Recover()
+	eventually(t, func() bool {
+		greenClient.HealthCheck()
+		return greenClient.Nodes.GetHealthyNodeCount() == 3
+	})
+	if stats := blueGreen.Stats(); stats.StagedErrors != 1 {
+		t.Fatalf("Stats are %+v, expected 1 staged error", stats)
+	}
+
+	// Switching serves reads from the staged set, resets Stats and keeps mirroring to the previous set
+	if err := blueGreen.Switch(); err != nil {
+		t.Fatalf("Switch failed: %s", err)
+	}
+	if blueGreen.Active() != greenClient || blueGreen.Staged() != blueClient {
+		t.Fatal("Switch did not swap the active and staged sets")
+	}
+	if stats := blueGreen.Stats(); stats != (memcacheha.BlueGreenStats{}) {
+		t.Fatalf("Stats are %+v after Switch, expected none", stats)
+	}
+	green.Put(&memcacheha.Item{Key: "key", Value: []byte("green")})
+	blue.Put(&memcacheha.Item{Key: "key", Value: []byte("blue")})
+	if item, err := blueGreen.Get("key"); err != nil || string(item.Value) != "green" {
+		t.Fatalf("Get after Switch returned %v, %v, expected the green value", item, err)
+	}
+	if err := blueGreen.Set(&memcacheha.Item{Key: "switched", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	blue.AssertValue(t, "switched", []byte("value"))
+
+	// Switching again rolls back
+	if err := blueGreen.Switch(); err != nil {
+		t.Fatalf("Switch failed: %s", err)
+	}
+	if item, err := blueGreen.Get("key"); err != nil || string(item.Value) != "blue" {
+		t.Fatalf("Get after rolling back returned %v, %v, expected the blue value", item, err)
+	}
+
+	// Unstaging stops mirroring
+	if staged := blueGreen.Unstage(); staged != greenClient {
+		t.Fatal("Unstage did not return the staged client")
+	}
+	if staged := blueGreen.Unstage(); staged != nil {
+		t.Fatal("Unstage without a staged set returned a client")
+	}
+	if err := blueGreen.Set(&memcacheha.Item{Key: "unstaged", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	green.AssertValue(t, "unstaged", nil)
+}
+
+func TestBlueGreenAutoSwitch(t *testing.T) {
+	defer func(rate float64) { memcacheha.BLUEGREEN_SHADOW_RATE = rate }(memcacheha.BLUEGREEN_SHADOW_RATE)
+	memcacheha.BLUEGREEN_SHADOW_RATE = 1
+	blue, green := memcachehatest.NewCluster(3), memcachehatest.NewCluster(3)
+	blueClient, greenClient := blue.NewClient(t), green.NewClient(t)
+	blueGreen := memcacheha.NewBlueGreenClient(logger.NewConsoleLogger("error"), blueClient)
+	blueGreen.Stage(greenClient)
+
+	// Hits on the active set are read from the staged set to measure its hit rate
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		blue.Put(&memcacheha.Item{Key: key, Value: []byte("value")})
+		if i%2 == 0 {
+			green.Put(&memcacheha.Item{Key: key, Value: []byte("value")})
+		}
+		if _, err := blueGreen.Get(key); err != nil {
+			t.Fatalf("Get failed: %s", err)
+		}
+	}
+	if _, err := blueGreen.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	eventually(t, func() bool { return blueGreen.Stats().ShadowReads == 10 })
+	if stats, hitRate := blueGreen.Stats(), blueGreen.HitRate(); stats.ShadowHits != 5 || hitRate != 0.5 {
+		t.Fatalf("Stats are %+v with a hit rate of %.2f, expected 5 hits of 10", stats, hitRate)
+	}
+
+	// The staged set becomes active once the hit rate is reached over enough reads
+	blueGreen.Stage(greenClient)
+	blueGreen.SetAutoSwitch(0.9, 10)
+	for i := 0; i < 9; i++ {
+		blueGreen.Get("key0")
+	}
+	eventually(t, func() bool { return blueGreen.Stats().ShadowReads == 9 })
+	if blueGreen.Active() != blueClient {
+		t.Fatal("Switched before the minimum number of shadow reads")
+	}
+	blueGreen.Get("key0")
+	eventually(t, func() bool { return blueGreen.Active() == greenClient })
+	if blueGreen.Staged() != blueClient {
+		t.Fatal("The previous active set is not staged after switching")
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+const (
+	// NoExpiration stores an item without expiry
+	NoExpiration time.Duration = -1
+	// DefaultExpiration stores an item with the DefaultExpiration of the Cache
+	DefaultExpiration time.Duration = 0
+)
+
+// Cache provides a go-cache like API over a memcacheha Client. Values are encoded with encoding/gob, so custom
+// types must be registered with gob.Register.
+type Cache struct {
+	Client            *memcacheha.Client
+	DefaultExpiration time.Duration
+}
+
+// cacheValue wraps values so that gob records their concrete type
+type cacheValue struct {
+	Value interface{}
+}
+
+// New returns a new Cache using the given client, storing items with the given default expiration
+func New(client *memcacheha.Client, defaultExpiration time.Duration) *Cache {
+	return &Cache{
+		Client:            client,
+		DefaultExpiration: defaultExpiration,
+	}
+}
+
+// Get returns the value for the given key, and whether it was found
+func (cache *Cache) Get(k string) (interface{}, bool) {
+	item, err := cache.Client.Get(k)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			cache.Client.Log.Warn("Cache: Get %s failed: %s", cache.Client.LogKey(k), err)
+		}
+		return nil, false
+	}
+	value := &cacheValue{}
+	if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(value); err != nil {
+		cache.Client.Log.Warn("Cache: Decoding %s failed: %s", cache.Client.LogKey(k), err)
+		return nil, false
+	}
+	return value.Value, true
+}
+
+// Set stores the value x for the given key, expiring after d
+func (cache *Cache) Set(k string, x interface{}, d time.Duration) {
+	item, err := cache.newItem(k, x, d)
+	if err == nil {
+		err = cache.Client.Set(item)
+	}
+	if err != nil {
+		cache.Client.Log.Warn("Cache: Set %s failed: %s", cache.Client.LogKey(k), err)
+	}
+}
+
+// Add stores the value x for the given key only if it does not exist, returning memcache.ErrNotStored otherwise
+func (cache *Cache) Add(k string, x interface{}, d time.Duration) error {
+	item, err := cache.newItem(k, x, d)
+	if err != nil {
+		return err
+	}
+	return cache.Client.Add(item)
+}
+
+// Delete deletes the given key
+func (cache *Cache) Delete(k string) {
+	err := cache.Client.Delete(k)
+	if err != nil && err != memcache.ErrCacheMiss {
+		cache.Client.Log.Warn("Cache: Delete %s failed: %s", cache.Client.LogKey(k), err)
+	}
+}
+
+func (cache *Cache) newItem(k string, x interface{}, d time.Duration) (*memcacheha.Item, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&cacheValue{Value: x}); err != nil {
+		return nil, err
+	}
+	item := &memcacheha.Item{Key: k, Value: buf.Bytes()}
+	if d == DefaultExpiration {
+		d = cache.DefaultExpiration
+	}
+	if d > 0 {
+		expiration := cache.Client.Clock.Now().Add(d)
+		item.Expiration = &expiration
+	}
+	return item, nil
+}
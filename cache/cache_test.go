@@ -0,0 +1,61 @@
+package cache_test
+
+import (
+	"github.com/apitalent/memcacheha/cache"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y int
+}
+
+func init() {
+	gob.Register(point{})
+}
+
+func TestCache(t *testing.T) {
+	// A clock well ahead of the system clock, so expiry on the system clock is already past
+	clk := memcachehatest.NewClock(time.Now().Add(24 * time.Hour))
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	c := cache.New(cluster.NewClient(t), time.Minute)
+
+	c.Set("default", point{1, 2}, cache.DefaultExpiration)
+	c.Set("short", "value", 10*time.Second)
+	c.Set("forever", 42, cache.NoExpiration)
+	if value, found := c.Get("default"); !found || value != (point{1, 2}) {
+		t.Fatalf("Get returned %v, %t", value, found)
+	}
+	if err := c.Add("short", "other", cache.DefaultExpiration); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+
+	// Expirations are on the client's clock
+	clk.Advance(11 * time.Second)
+	if value, found := c.Get("short"); found {
+		t.Fatalf("Get after the expiration returned %v", value)
+	}
+	if value, found := c.Get("default"); !found || value != (point{1, 2}) {
+		t.Fatalf("Get before the default expiration returned %v, %t", value, found)
+	}
+	clk.Advance(time.Minute)
+	if value, found := c.Get("default"); found {
+		t.Fatalf("Get after the default expiration returned %v", value)
+	}
+	if value, found := c.Get("forever"); !found || value != 42 {
+		t.Fatalf("Get of an item without expiry returned %v, %t", value, found)
+	}
+
+	if err := c.Add("short", "added", cache.DefaultExpiration); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	c.Delete("short")
+	if _, found := c.Get("short"); found {
+		t.Fatal("Get of a deleted key found it")
+	}
+}
@@ -0,0 +1,236 @@
+// Package cache adapts a memcacheha Client to popular cache interfaces: the eko/gocache Store and a go-cache like
+// Get/Set API.
+package cache
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/eko/gocache/lib/v4/store"
+
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	// GOCACHE_TYPE is the store type returned by GocacheStore.GetType
+	GOCACHE_TYPE = "memcacheha"
+	// GOCACHE_TAG_PREFIX is the prefix of the keys listing the keys with each tag
+	GOCACHE_TAG_PREFIX = "gocache_tag_"
+	// GOCACHE_TAG_LOCK_SUFFIX is appended to the key of a tag's key list to form the key of its lock
+	GOCACHE_TAG_LOCK_SUFFIX = "_lock"
+)
+
+var (
+	// GOCACHE_TAG_TTL is the expiry of the key lists of tags
+	GOCACHE_TAG_TTL = 720 * time.Hour
+	// GOCACHE_TAG_LOCK_TTL is the TTL of the lock held while the key list of a tag is modified
+	GOCACHE_TAG_LOCK_TTL = time.Second
+	// GOCACHE_TAG_RETRIES is the number of attempts to acquire the lock of a tag held by other writers
+	GOCACHE_TAG_RETRIES = 50
+	// GOCACHE_TAG_RETRY_DELAY is the longest random delay between attempts to acquire the lock of a tag
+	GOCACHE_TAG_RETRY_DELAY = 10 * time.Millisecond
+)
+
+var (
+	// ErrUnsupportedValue is an error meaning a value other than []byte or string was given to GocacheStore.Set
+	ErrUnsupportedValue = errors.New("memcacheha: value must be []byte or string")
+
+	// ErrClearNotSupported is an error meaning GocacheStore.Clear was called, as memcacheha does not flush nodes
+	ErrClearNotSupported = errors.New("memcacheha: clear is not supported")
+
+	// ErrTagLocked is an error meaning the lock of a tag was held by other writers in each of GOCACHE_TAG_RETRIES
+	// attempts to acquire it
+	ErrTagLocked = errors.New("memcacheha: tag locked by other writers")
+)
+
+// GocacheStore implements the eko/gocache store.StoreInterface. Values are stored and returned as []byte.
+type GocacheStore struct {
+	Client  *memcacheha.Client
+	Options *store.Options
+}
+
+// NewGocacheStore returns a new GocacheStore using the given client. The given options are the defaults for Set.
+func NewGocacheStore(client *memcacheha.Client, options ...store.Option) *GocacheStore {
+	return &GocacheStore{
+		Client:  client,
+		Options: store.ApplyOptions(options...),
+	}
+}
+
+// Get returns the value for the given key
+func (gocacheStore *GocacheStore) Get(ctx context.Context, key any) (any, error) {
+	item, err := gocacheStore.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// GetWithTTL returns the value for the given key and its remaining time to live on the client's Clock, or 0 if it
+// does not expire
+func (gocacheStore *GocacheStore) GetWithTTL(ctx context.Context, key any) (any, time.Duration, error) {
+	item, err := gocacheStore.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ttl time.Duration
+	if item.Expiration != nil {
+		ttl = item.Expiration.Sub(gocacheStore.Client.Clock.Now())
+	}
+	return item.Value, ttl, nil
+}
+
+// Set writes the given []byte or string value, with the expiration and tags in options
+func (gocacheStore *GocacheStore) Set(ctx context.Context, key any, value any, options ...store.Option) error {
+	opts := *gocacheStore.Options
+	for _, option := range options {
+		option(&opts)
+	}
+
+	item := &memcacheha.Item{Key: keyString(key)}
+	switch v := value.(type) {
+	case []byte:
+		item.Value = v
+	case string:
+		item.Value = []byte(v)
+	default:
+		return ErrUnsupportedValue
+	}
+	if opts.Expiration > 0 {
+		expiration := gocacheStore.Client.Clock.Now().Add(opts.Expiration)
+		item.Expiration = &expiration
+	}
+	if err := gocacheStore.Client.Set(item); err != nil {
+		return err
+	}
+	for _, tag := range opts.Tags {
+		if err := gocacheStore.addTag(tag, item.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the given key. Deleting a missing key is not an error.
+func (gocacheStore *GocacheStore) Delete(ctx context.Context, key any) error {
+	err := gocacheStore.Client.Delete(keyString(key))
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// Invalidate deletes all keys with the tags in options, holding the lock of each tag so keys added concurrently are
+// either deleted or listed again
+func (gocacheStore *GocacheStore) Invalidate(ctx context.Context, options ...store.InvalidateOption) error {
+	opts := store.ApplyInvalidateOptions(options...)
+	for _, tag := range opts.Tags {
+		if err := gocacheStore.invalidateTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear returns ErrClearNotSupported
+func (gocacheStore *GocacheStore) Clear(ctx context.Context) error {
+	return ErrClearNotSupported
+}
+
+// GetType returns GOCACHE_TYPE
+func (gocacheStore *GocacheStore) GetType() string {
+	return GOCACHE_TYPE
+}
+
+func (gocacheStore *GocacheStore) get(key any) (*memcacheha.Item, error) {
+	item, err := gocacheStore.Client.Get(keyString(key))
+	if err == memcache.ErrCacheMiss {
+		return nil, store.NotFoundWithCause(err)
+	}
+	return item, err
+}
+
+// invalidateTag deletes the keys with the given tag, and its key list
+func (gocacheStore *GocacheStore) invalidateTag(ctx context.Context, tag string) error {
+	unlock, err := gocacheStore.lockTag(tag)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := gocacheStore.tagKeys(tag)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := gocacheStore.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return gocacheStore.Delete(ctx, GOCACHE_TAG_PREFIX+tag)
+}
+
+// tagKeys returns the keys with the given tag, read from all nodes
+func (gocacheStore *GocacheStore) tagKeys(tag string) ([]string, error) {
+	item, err := gocacheStore.Client.Get(GOCACHE_TAG_PREFIX+tag, memcacheha.WithReadAll())
+	if err == memcache.ErrCacheMiss {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(item.Value), ","), nil
+}
+
+// addTag adds key to the key list of the given tag, holding the lock of the tag so keys added concurrently to the
+// same tag are not lost
+func (gocacheStore *GocacheStore) addTag(tag string, key string) error {
+	unlock, err := gocacheStore.lockTag(tag)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := gocacheStore.tagKeys(tag)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k == key {
+			return nil
+		}
+	}
+	expiration := gocacheStore.Client.Clock.Now().Add(GOCACHE_TAG_TTL)
+	return gocacheStore.Client.Set(&memcacheha.Item{
+		Key:        GOCACHE_TAG_PREFIX + tag,
+		Value:      []byte(strings.Join(append(keys, key), ",")),
+		Expiration: &expiration,
+	})
+}
+
+// lockTag acquires the lock of the given tag, see memcacheha.Client.Lock. While it is held by other writers, it is
+// retried after a random delay of up to GOCACHE_TAG_RETRY_DELAY, on the system clock as the writers holding it are
+// not driven by the client's Clock, up to GOCACHE_TAG_RETRIES times before ErrTagLocked is returned.
+func (gocacheStore *GocacheStore) lockTag(tag string) (func(), error) {
+	for attempt := 1; ; attempt++ {
+		unlock, err := gocacheStore.Client.Lock(GOCACHE_TAG_PREFIX+tag+GOCACHE_TAG_LOCK_SUFFIX, GOCACHE_TAG_LOCK_TTL)
+		if err != memcacheha.ErrLockNotAcquired {
+			return unlock, err
+		}
+		if attempt >= GOCACHE_TAG_RETRIES {
+			return nil, ErrTagLocked
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(GOCACHE_TAG_RETRY_DELAY) + 1)))
+	}
+}
+
+func keyString(key any) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
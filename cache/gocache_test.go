@@ -0,0 +1,124 @@
+package cache_test
+
+import (
+	"github.com/apitalent/memcacheha/cache"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/eko/gocache/lib/v4/store"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ store.StoreInterface = &cache.GocacheStore{}
+
+func TestGocacheStore(t *testing.T) {
+	// A clock well ahead of the system clock, so expiry on the system clock is already past
+	clk := memcachehatest.NewClock(time.Now().Truncate(time.Second).Add(24 * time.Hour))
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	gocacheStore := cache.NewGocacheStore(cluster.NewClient(t), store.WithExpiration(time.Minute))
+	ctx := context.Background()
+
+	if err := gocacheStore.Set(ctx, "bytes", []byte("value")); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := gocacheStore.Set(ctx, 42, "string", store.WithExpiration(10*time.Second)); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := gocacheStore.Set(ctx, "other", 42); err != cache.ErrUnsupportedValue {
+		t.Fatalf("Set of an int returned %v, expected ErrUnsupportedValue", err)
+	}
+
+	// Expirations and TTLs are on the client's clock
+	if value, ttl, err := gocacheStore.GetWithTTL(ctx, "bytes"); err != nil || string(value.([]byte)) != "value" || ttl != time.Minute {
+		t.Fatalf("GetWithTTL returned %v, %s, %v, expected the value with a TTL of 1m", value, ttl, err)
+	}
+	clk.Advance(5 * time.Second)
+	if value, ttl, err := gocacheStore.GetWithTTL(ctx, 42); err != nil || string(value.([]byte)) != "string" || ttl != 5*time.Second {
+		t.Fatalf("GetWithTTL returned %v, %s, %v, expected the value with a TTL of 5s", value, ttl, err)
+	}
+	clk.Advance(6 * time.Second)
+	if _, err := gocacheStore.Get(ctx, 42); !errors.Is(err, store.NotFound{}) {
+		t.Fatalf("Get of an expired key returned %v, expected NotFound", err)
+	}
+
+	if err := gocacheStore.Delete(ctx, "bytes"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if err := gocacheStore.Delete(ctx, "bytes"); err != nil {
+		t.Fatalf("Delete of a missing key failed: %s", err)
+	}
+	if _, err := gocacheStore.Get(ctx, "bytes"); !errors.Is(err, store.NotFound{}) {
+		t.Fatalf("Get of a deleted key returned %v, expected NotFound", err)
+	}
+	if err := gocacheStore.Clear(ctx); err != cache.ErrClearNotSupported {
+		t.Fatalf("Clear returned %v, expected ErrClearNotSupported", err)
+	}
+}
+
+func TestGocacheStoreInvalidate(t *testing.T) {
+	gocacheStore := cache.NewGocacheStore(memcachehatest.NewCluster(3).NewClient(t))
+	ctx := context.Background()
+
+	for key, tags := range map[string][]string{"a": {"red"}, "b": {"red", "blue"}, "c": {"blue"}, "d": nil} {
+		if err := gocacheStore.Set(ctx, key, key, store.WithTags(tags)); err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+	}
+	// Writing a key again does not list it twice
+	if err := gocacheStore.Set(ctx, "a", "a", store.WithTags([]string{"red"})); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := gocacheStore.Invalidate(ctx, store.WithInvalidateTags([]string{"red"})); err != nil {
+		t.Fatalf("Invalidate failed: %s", err)
+	}
+	for key, found := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+		if _, err := gocacheStore.Get(ctx, key); (err == nil) != found {
+			t.Fatalf("Get of %s after invalidating red returned %v", key, err)
+		}
+	}
+	if err := gocacheStore.Invalidate(ctx, store.WithInvalidateTags([]string{"blue", "unknown"})); err != nil {
+		t.Fatalf("Invalidate failed: %s", err)
+	}
+	if _, err := gocacheStore.Get(ctx, "c"); !errors.Is(err, store.NotFound{}) {
+		t.Fatalf("Get of c after invalidating blue returned %v, expected NotFound", err)
+	}
+}
+
+func TestGocacheStoreConcurrentTags(t *testing.T) {
+	gocacheStore := cache.NewGocacheStore(memcachehatest.NewCluster(3).NewClient(t))
+	ctx := context.Background()
+
+	// Keys written concurrently with the same tag are all listed
+	writers := 8
+	errs := make(chan error, writers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- gocacheStore.Set(ctx, fmt.Sprintf("key%d", i), "value", store.WithTags([]string{"shared"}))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+	}
+
+	if err := gocacheStore.Invalidate(ctx, store.WithInvalidateTags([]string{"shared"})); err != nil {
+		t.Fatalf("Invalidate failed: %s", err)
+	}
+	for i := 0; i < writers; i++ {
+		if _, err := gocacheStore.Get(ctx, fmt.Sprintf("key%d", i)); !errors.Is(err, store.NotFound{}) {
+			t.Fatalf("Get of key%d after Invalidate returned %v, expected NotFound", i, err)
+		}
+	}
+}
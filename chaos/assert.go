@@ -0,0 +1,53 @@
+package chaos
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"testing"
+	"time"
+)
+
+// AssertAvailable fails the test if a Set followed by a Get of item through client does not succeed
+func AssertAvailable(t testing.TB, client *memcacheha.Client, item *memcacheha.Item) {
+	t.Helper()
+	if err := client.Set(item); err != nil {
+		t.Fatalf("chaos: Set %s failed: %s", item.Key, err)
+	}
+	got, err := client.Get(item.Key)
+	if err != nil {
+		t.Fatalf("chaos: Get %s failed: %s", item.Key, err)
+	}
+	if !bytes.Equal(got.Value, item.Value) {
+		t.Fatalf("chaos: Get %s returned %q, expected %q", item.Key, got.Value, item.Value)
+	}
+}
+
+// AssertConsistent fails the test if the nodes of controller don't all hold the same value for key, bypassing any
+// injected faults. Values are decoded by client, see memcachehatest.AssertConsistent.
+func AssertConsistent(t testing.TB, controller *Controller, client *memcacheha.Client, key string) {
+	t.Helper()
+	items := map[string]*memcache.Item{}
+	for endpoint, node := range controller.Nodes() {
+		item, err := node.Client.Get(key)
+		if err != nil {
+			item = nil
+		}
+		items[endpoint] = item
+	}
+	memcachehatest.AssertConsistent(t, client, key, items)
+}
+
+// AssertEventually fails the test if condition does not return true within timeout
+func AssertEventually(t testing.TB, timeout time.Duration, condition func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("chaos: timed out after %s waiting for %s", timeout, msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,242 @@
+// Package chaos wraps memcacheha NodeClients to inject timeouts, dropped responses, partitions and slow nodes,
+// so memcacheha's HA behaviour can be validated in tests.
+package chaos
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// timeoutError is a net.Error timeout, as returned by a real connection
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "chaos: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Fault is a type of failure injected into a NodeClient
+type Fault int
+
+const (
+	// FAULT_NONE performs operations normally
+	FAULT_NONE Fault = iota
+	// FAULT_TIMEOUT waits for the timeout, then fails without performing the operation
+	FAULT_TIMEOUT
+	// FAULT_DROP_RESPONSE performs the operation, then fails as if the response was lost
+	FAULT_DROP_RESPONSE
+	// FAULT_SLOW waits for the configured latency, then performs the operation
+	FAULT_SLOW
+)
+
+var (
+	// ErrTimeout is returned by operations failed with FAULT_TIMEOUT. It is a net.Error reporting a timeout.
+	ErrTimeout error = timeoutError{}
+
+	// ErrDroppedResponse is returned by operations failed with FAULT_DROP_RESPONSE
+	ErrDroppedResponse = errors.New("chaos: response dropped")
+)
+
+// NodeClient wraps a memcacheha.NodeClient, injecting faults into its operations
+type NodeClient struct {
+	Client memcacheha.NodeClient
+
+	mutex       sync.Mutex
+	fault       Fault
+	probability float64
+	timeout     time.Duration
+	latency     time.Duration
+	rand        *rand.Rand
+}
+
+// NewNodeClient returns a new NodeClient wrapping client. timeout is the duration FAULT_TIMEOUT waits before failing.
+func NewNodeClient(client memcacheha.NodeClient, timeout time.Duration) *NodeClient {
+	return &NodeClient{
+		Client:  client,
+		fault:   FAULT_NONE,
+		timeout: timeout,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Inject applies the given fault to a fraction of subsequent operations given by probability (0 to 1)
+func (nodeClient *NodeClient) Inject(fault Fault, probability float64) {
+	nodeClient.mutex.Lock()
+	defer nodeClient.mutex.Unlock()
+	nodeClient.fault = fault
+	nodeClient.probability = probability
+}
+
+// SetLatency sets the latency added by FAULT_SLOW
+func (nodeClient *NodeClient) SetLatency(latency time.Duration) {
+	nodeClient.mutex.Lock()
+	defer nodeClient.mutex.Unlock()
+	nodeClient.latency = latency
+}
+
+// Clear removes any injected fault
+func (nodeClient *NodeClient) Clear() {
+	nodeClient.Inject(FAULT_NONE, 0)
+}
+
+// Get implements memcacheha.NodeClient
+func (nodeClient *NodeClient) Get(key string) (*memcache.Item, error) {
+	var item *memcache.Item
+	err := nodeClient.do(func() error {
+		var err error
+		item, err = nodeClient.Client.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Set implements memcacheha.NodeClient
+func (nodeClient *NodeClient) Set(item *memcache.Item) error {
+	return nodeClient.do(func() error { return nodeClient.Client.Set(item) })
+}
+
+// Add implements memcacheha.NodeClient
+func (nodeClient *NodeClient) Add(item *memcache.Item) error {
+	return nodeClient.do(func() error { return nodeClient.Client.Add(item) })
+}
+
+// Delete implements memcacheha.NodeClient
+func (nodeClient *NodeClient) Delete(key string) error {
+	return nodeClient.do(func() error { return nodeClient.Client.Delete(key) })
+}
+
+// Touch implements memcacheha.NodeClient
+func (nodeClient *NodeClient) Touch(key string, seconds int32) error {
+	return nodeClient.do(func() error { return nodeClient.Client.Touch(key, seconds) })
+}
+
+// CompareAndSwap implements memcacheha.NodeClient
+func (nodeClient *NodeClient) CompareAndSwap(item *memcache.Item) error {
+	return nodeClient.do(func() error { return nodeClient.Client.CompareAndSwap(item) })
+}
+
+func (nodeClient *NodeClient) do(op func() error) error {
+	nodeClient.mutex.Lock()
+	fault := nodeClient.fault
+	if fault != FAULT_NONE && nodeClient.rand.Float64() >= nodeClient.probability {
+		fault = FAULT_NONE
+	}
+	timeout := nodeClient.timeout
+	latency := nodeClient.latency
+	nodeClient.mutex.Unlock()
+
+	switch fault {
+	case FAULT_TIMEOUT:
+		time.Sleep(timeout)
+		return ErrTimeout
+	case FAULT_DROP_RESPONSE:
+		op()
+		return ErrDroppedResponse
+	case FAULT_SLOW:
+		time.Sleep(latency)
+	}
+	return op()
+}
+
+// Event is a fault applied to a set of nodes at an offset from the start of a Schedule
+type Event struct {
+	// At is the offset from the start of the schedule
+	At time.Duration
+	// Endpoints are the nodes to apply the fault to
+	Endpoints []string
+	// Fault is the fault to apply, FAULT_NONE to clear faults
+	Fault Fault
+	// Probability is the fraction of operations affected (0 to 1)
+	Probability float64
+}
+
+// Schedule is a list of Events ordered by At
+type Schedule []Event
+
+// Controller creates and tracks chaos NodeClients for a memcacheha.Client
+type Controller struct {
+	mutex   sync.Mutex
+	nodes   map[string]*NodeClient
+	factory memcacheha.NodeClientFactory
+}
+
+// NewController returns a new Controller creating underlying NodeClients with the given factory
+func NewController(factory memcacheha.NodeClientFactory) *Controller {
+	return &Controller{
+		nodes:   map[string]*NodeClient{},
+		factory: factory,
+	}
+}
+
+// NewNodeClient implements memcacheha.NodeClientFactory. Assign it to memcacheha.Client.NewNodeClient.
+func (controller *Controller) NewNodeClient(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	node, found := controller.nodes[endpoint]
+	if !found {
+		node = NewNodeClient(controller.factory(endpoint, timeout), timeout)
+		controller.nodes[endpoint] = node
+	}
+	return node
+}
+
+// Node returns the NodeClient for the given endpoint, or nil if it hasn't been created
+func (controller *Controller) Node(endpoint string) *NodeClient {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	return controller.nodes[endpoint]
+}
+
+// Nodes returns all NodeClients, keyed by endpoint
+func (controller *Controller) Nodes() map[string]*NodeClient {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	out := map[string]*NodeClient{}
+	for endpoint, node := range controller.nodes {
+		out[endpoint] = node
+	}
+	return out
+}
+
+// Inject applies the given fault to the given endpoints
+func (controller *Controller) Inject(fault Fault, probability float64, endpoints ...string) {
+	for _, endpoint := range endpoints {
+		if node := controller.Node(endpoint); node != nil {
+			node.Inject(fault, probability)
+		}
+	}
+}
+
+// Partition makes the given endpoints unreachable, timing out all operations
+func (controller *Controller) Partition(endpoints ...string) {
+	controller.Inject(FAULT_TIMEOUT, 1, endpoints...)
+}
+
+// Heal clears faults from all nodes
+func (controller *Controller) Heal() {
+	for _, node := range controller.Nodes() {
+		node.Clear()
+	}
+}
+
+// Run applies the events in schedule at their offsets, returning when all events have been applied or ctx is done
+func (controller *Controller) Run(ctx context.Context, schedule Schedule) error {
+	start := time.Now()
+	for _, event := range schedule {
+		select {
+		case <-time.After(time.Until(start.Add(event.At))):
+			controller.Inject(event.Fault, event.Probability, event.Endpoints...)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
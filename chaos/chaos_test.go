@@ -0,0 +1,65 @@
+package chaos
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, endpoints ...string) (*memcacheha.Client, *Controller) {
+	controller := NewController(func(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+		return memcacheha.NewMemoryNodeClient()
+	})
+	client := memcacheha.New(logger.NewConsoleLogger("error"), memcacheha.NewStaticNodeSource(endpoints...))
+	client.NewNodeClient = controller.NewNodeClient
+	client.GetNodes()
+	if client.Nodes.GetHealthyNodeCount() != len(endpoints) {
+		t.Fatalf("expected %d healthy nodes, got %d", len(endpoints), client.Nodes.GetHealthyNodeCount())
+	}
+	return client, controller
+}
+
+func TestWriteSurvivesPartitionedNode(t *testing.T) {
+	client, controller := newTestClient(t, "node1:11211", "node2:11211", "node3:11211")
+
+	controller.Partition("node1:11211")
+	AssertAvailable(t, client, &memcacheha.Item{Key: "partition", Value: []byte("value")})
+	if client.Nodes.GetHealthyNodeCount() != 2 {
+		t.Fatalf("expected partitioned node to be unhealthy, %d healthy nodes", client.Nodes.GetHealthyNodeCount())
+	}
+}
+
+func TestReadRepairAfterHeal(t *testing.T) {
+	client, controller := newTestClient(t, "node1:11211", "node2:11211", "node3:11211")
+
+	controller.Partition("node1:11211")
+	AssertAvailable(t, client, &memcacheha.Item{Key: "repair", Value: []byte("value")})
+
+	controller.Heal()
+	client.HealthCheck()
+
+	if _, err := client.Get("repair", memcacheha.WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	AssertEventually(t, time.Second, func() bool {
+		item, err := controller.Node("node1:11211").Client.Get("repair")
+		return err == nil && string(item.Value[8:]) == "value"
+	}, "read repair of node1")
+	AssertConsistent(t, controller, client, "repair")
+}
+
+func TestDroppedResponsesMarkNodeUnhealthy(t *testing.T) {
+	client, controller := newTestClient(t, "node1:11211", "node2:11211")
+
+	controller.Inject(FAULT_DROP_RESPONSE, 1, "node2:11211")
+	if err := client.Set(&memcacheha.Item{Key: "dropped", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if client.Nodes.GetHealthyNodeCount() != 1 {
+		t.Fatalf("expected 1 healthy node, got %d", client.Nodes.GetHealthyNodeCount())
+	}
+	controller.Heal()
+	AssertConsistent(t, controller, client, "dropped")
+}
@@ -2,8 +2,16 @@
 package memcacheha
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha/clock"
 	"github.com/bradfitz/gomemcache/memcache"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -23,111 +31,395 @@ type Client struct {
 	Sources []NodeSource
 	Log     logger.Logger
 
+	// SourceMerge decides how the endpoints of multiple Sources are combined. Defaults to SOURCE_MERGE_UNION.
+	SourceMerge SourceMergePolicy
+
+	// ClientID identifies this client, e.g. the name of the service using it, in OperationStats and AccessRecords, to
+	// attribute load on a cluster shared by several services
+	ClientID string
+
+	// LogLevel is the minimum level of messages written to Log. Defaults to LOG_DEBUG.
+	LogLevel LogLevel
+	// KeyLogMode decides whether keys are logged as they are, hashed or redacted. Defaults to KEY_LOG_PLAIN.
+	KeyLogMode KeyLogMode
+
 	Timeout time.Duration
 
-	shutdownChan chan (int)
-	running      bool
+	// GetNodesPeriod is the period between checking all sources for new or deprecated nodes. Defaults to GET_NODES_PERIOD.
+	GetNodesPeriod time.Duration
+	// HealthCheckPeriod is the period between healthchecks on nodes. Defaults to HEALTHCHECK_PERIOD.
+	HealthCheckPeriod time.Duration
+	// HealthCheckProbe is the request sent by healthchecks. Defaults to HEALTHCHECK_PROBE_GET.
+	HealthCheckProbe HealthCheckProbe
+	// HealthCheckKeyPrefix prefixes the random keys read by HEALTHCHECK_PROBE_GET, so the probes of each client can
+	// be told apart. Defaults to HEALTHCHECK_KEY_PREFIX.
+	HealthCheckKeyPrefix string
+	// ResolvePeriod is the period between re-resolving node hostnames, see ResolveNodes. Zero disables
+	// re-resolving. Defaults to RESOLVE_PERIOD.
+	ResolvePeriod time.Duration
+	// MaintenanceJitter is the fraction by which the periods above are randomly varied, e.g. 0.1 for up to 10% either
+	// way, so that many clients don't discover and healthcheck in step. Defaults to MAINTENANCE_JITTER.
+	MaintenanceJitter float64
+
+	// DefaultReadOptions are applied to every read operation before any per-call options
+	DefaultReadOptions ReadOptions
+	// DefaultWriteOptions are applied to every write operation before any per-call options
+	DefaultWriteOptions WriteOptions
+	// KeyPolicies replace DefaultReadOptions and DefaultWriteOptions for matching keys. The first match is used.
+	KeyPolicies []KeyPolicy
+
+	// NewNodeClient returns the NodeClient for newly discovered nodes. Defaults to NewMemcacheNodeClient.
+	NewNodeClient NodeClientFactory
+	// NewTLSNodeClient returns the NodeClient for nodes whose NodeSpec requires TLS. Such nodes are not added if it is
+	// nil, as by default.
+	NewTLSNodeClient NodeClientFactory
+	// PrewarmConnections opens and validates the idle connections of a newly added node once its initial healthcheck
+	// passes, so the first requests to it don't wait for connections to be established
+	PrewarmConnections bool
+	// IdleCheckPeriod is the period between validating the idle connections of healthy nodes, so that connections
+	// left half-open by a crashed server are discarded before live traffic waits on them. Zero disables the check.
+	// Defaults to IDLE_CHECK_PERIOD.
+	IdleCheckPeriod time.Duration
+
+	// MaxValueSize is the maximum size in bytes of a stored value, including its header, above which writes return a
+	// ValueTooLargeError without being sent. Zero means no limit. Defaults to MAX_VALUE_SIZE.
+	MaxValueSize int
+
+	// Encryption, if set, encrypts item values before they are sent to the nodes and decrypts them when read, for
+	// sensitive data on shared memcached servers. Keys, flags and expiries are not encrypted.
+	Encryption *Encryption
+	// Integrity, if set, signs item values before they are sent to the nodes and verifies them when read, so that
+	// corrupted or modified values are read as missing
+	Integrity *Integrity
+
+	// HashLongKeys replaces keys longer than MAX_KEY_LENGTH with a hash (see HashKey) rather than returning ErrKeyTooLong
+	HashLongKeys bool
+
+	// PinnedKeys are patterns (see path.Match) of keys stored on a single node rather than all nodes, see PinnedNode
+	PinnedKeys []string
+
+	// TrackHotKeys enables tracking of the most frequently accessed keys, see HotKeys
+	TrackHotKeys bool
+
+	// ReadYourWrites is the period after this client writes a key with Set, Add or CompareAndSwap during which its
+	// Gets of the key read all nodes and return the value written, if any node has it. Zero disables it.
+	ReadYourWrites time.Duration
+
+	// DegradedLatency is the p99 node latency above which a node is degraded: not read from, but still written to.
+	// Nodes are restored when their latency recovers. Zero disables degradation.
+	DegradedLatency time.Duration
+	// NodeRampPeriod is the period over which a node added to a client that already has nodes ramps up from none to
+	// its full share of reads, so a cold node doesn't drag down the hit rate and latency. It is written to from the
+	// start. Zero reads from new nodes straight away, as by default.
+	NodeRampPeriod time.Duration
+
+	// MaxRepairsPerSecond limits the rate of writes synchronising nodes with missing data, dropping the excess (see
+	// RepairStats). Zero means no limit.
+	MaxRepairsPerSecond float64
+	// MaxConcurrentRepairs limits the number of writes synchronising nodes in progress, dropping the excess. Zero
+	// means no limit.
+	MaxConcurrentRepairs int
+
+	// DeleteRetryTTL is the period during which a Delete missed by a node, because it failed or was unhealthy, is
+	// retried when the node is healthy, so the key is not resurrected. Zero disables retries. Defaults to
+	// DELETE_RETRY_TTL.
+	DeleteRetryTTL time.Duration
+	// DeleteJournal is the path of a file journaling the deletes queued for retry, so they are retried after a
+	// restart. It is opened by Start and closed by Stop. Empty disables the journal.
+	DeleteJournal string
+
+	// SuppressRepairsOnPartition disables synchronisation of nodes with missing data while a majority of nodes are
+	// unreachable (see PartitionStatus), as the reachable nodes may hold stale data
+	SuppressRepairsOnPartition bool
+	// SingleNodeErrorRate is the fraction of node requests failing between healthchecks, e.g. 0.2, above which the
+	// client sends every request to the healthy node with the lowest p99 latency instead of all nodes, trading
+	// consistency for survival during a brownout. See SingleNode. Zero disables single-node mode.
+	SingleNodeErrorRate float64
+
+	// MaxConcurrentRequests limits the number of in-flight node requests, queueing further requests until one
+	// completes. Zero means no limit. Defaults to MAX_CONCURRENT_REQUESTS.
+	MaxConcurrentRequests int
+
+	// CoalesceGets shares the result of a Get between concurrent callers of the same key with the same options,
+	// sending one read to the cluster rather than one per caller
+	CoalesceGets bool
+
+	// FillLeases makes GetOrFill take a lease before filling a missing key, so concurrent callers across all clients
+	// wait for one fill rather than all filling it. FlushAndWarm enables it while the cache is cold.
+	FillLeases bool
+
+	// AccessSampleRate is the fraction (0 to 1) of operations recorded and delivered to AccessHook
+	AccessSampleRate float64
+	// AccessHook receives sampled AccessRecords. It is called synchronously on completion of an operation and must
+	// not block, see AccessChannelHook.
+	AccessHook func(AccessRecord)
+	// AuditHook receives an AuditRecord of every Delete and FlushAll, and of the actions of the AdminHandler, e.g. to
+	// keep an audit log of a shared cluster, see AuditWriterHook. It is called synchronously and must not block.
+	AuditHook func(AuditRecord)
+	// SizePrefixes are key prefixes whose value sizes are accounted separately in Metrics, e.g. one per team sharing
+	// the cluster. Keys are accounted under the longest prefix they start with.
+	SizePrefixes []string
+	// TTLSampleRate is the fraction (0 to 1) of successful writes whose TTL is recorded in TTLStats
+	TTLSampleRate float64
+	// TenantQuotas limit the operations of each tenant sharing the client, so one caller cannot starve the others.
+	// Operations are tagged with a tenant by WithContext and WithTenant, and fail with ErrQuotaExceeded over quota.
+	// Tenants without a quota have DefaultTenantQuota; operations without a tenant are not limited.
+	TenantQuotas       map[string]TenantQuota
+	DefaultTenantQuota TenantQuota
+
+	// SLOHealthCheckLatency, SLONodeLatency and SLOClusterLatency are latency objectives checked at every
+	// healthcheck: the latency of the healthcheck of each node, the p99 latency of requests to each node, and the p99
+	// latency of requests to all healthy nodes. Zero disables an objective.
+	SLOHealthCheckLatency time.Duration
+	SLONodeLatency        time.Duration
+	SLOClusterLatency     time.Duration
+	// SLOViolationHook receives a NodeSLOReport when a latency objective is breached, and when it is met again, e.g.
+	// to trip a feature flag. It is called from the healthcheck and must not block.
+	SLOViolationHook func(NodeSLOReport)
+
+	// ErrorMapper, if set, returns the category of each error from a node, deciding whether it is retried, marks the
+	// node unhealthy, fails the request only, or is a miss, e.g. for the error strings of a proxy between the client
+	// and memcached. Errors it returns ERROR_CATEGORY_DEFAULT for are categorised by ErrorPatterns. It must not block.
+	ErrorMapper func(err error) ErrorCategory
+	// ErrorPatterns map substrings of the messages of node errors to their category. The longest substring found in
+	// a message decides its category; errors matching none have the built-in handling.
+	ErrorPatterns map[string]ErrorCategory
+
+	// LogHandler, if set, receives a structured record of each operation and each failed request to a node, in
+	// addition to the messages written to Log
+	LogHandler slog.Handler
+
+	// Clock is the time of healthchecks, periodic tasks and expiries, see memcachehatest.Clock. It must be set
+	// before the client is used. Defaults to clock.Real.
+	Clock clock.Clock
+
+	// AdminToken is the bearer token required by AdminHandler. The admin API is disabled if empty.
+	AdminToken string
+
+	singleNode  singleNodeMode
+	disabled    int32
+	hotKeys     *hotKeyTracker
+	opCounters  map[string]*opCounter
+	ttls        *ttlSampler
+	metrics     *clientMetrics
+	recent      *recentWrites
+	gets        *getGroup
+	limiter     *requestLimiter
+	repairs     repairThrottle
+	tenants     *tenantThrottle
+	slos        sloState
+	deletes     *deleteRetryQueue
+	levelLog    *levelLogger
+	configMutex sync.RWMutex
+	sources     []SourceStatus
+	drained     map[string]bool
+	partitioned bool
+	statusMutex sync.Mutex
+	run         runState
+	workers     map[string]*WorkerStatus
+	sourceTTL   time.Duration
+	watches     *sourceWatches
+	// discoveryMutex serialises discoveries by the discovery worker and by updates from WatchableSources
+	discoveryMutex sync.Mutex
+	wake           *wakeSignal
 }
 
 // New returns a new Client with the specified logger and NodeSources
 func New(logger logger.Logger, sources ...NodeSource) *Client {
 	i := &Client{
-		Nodes:        NewNodeList(),
-		Sources:      sources,
-		Log:          logger,
-		Timeout:      100 * time.Millisecond,
-		shutdownChan: make(chan (int)),
-		running:      false,
+		Nodes:                 NewNodeList(),
+		Sources:               sources,
+		Log:                   logger,
+		Timeout:               100 * time.Millisecond,
+		GetNodesPeriod:        GET_NODES_PERIOD,
+		HealthCheckPeriod:     HEALTHCHECK_PERIOD,
+		HealthCheckKeyPrefix:  HEALTHCHECK_KEY_PREFIX,
+		ResolvePeriod:         RESOLVE_PERIOD,
+		MaintenanceJitter:     MAINTENANCE_JITTER,
+		IdleCheckPeriod:       IDLE_CHECK_PERIOD,
+		NewNodeClient:         NewMemcacheNodeClient,
+		Clock:                 clock.Real,
+		MaxValueSize:          MAX_VALUE_SIZE,
+		HashLongKeys:          false,
+		PinnedKeys:            nil,
+		TrackHotKeys:          false,
+		CoalesceGets:          false,
+		DeleteRetryTTL:        DELETE_RETRY_TTL,
+		MaxConcurrentRequests: MAX_CONCURRENT_REQUESTS,
+		hotKeys:               newHotKeyTracker(HOTKEY_SKETCH_WIDTH, HOTKEY_SKETCH_DEPTH, HOTKEY_TOP_K),
+		gets:                  newGetGroup(),
+		opCounters:            newOpCounters(),
+		ttls:                  newTTLSampler(),
+		metrics:               newClientMetrics(),
+		tenants:               newTenantThrottle(),
+		recent:                newRecentWrites(),
+		deletes:               newDeleteRetryQueue(),
+		drained:               map[string]bool{},
+		workers:               map[string]*WorkerStatus{},
+		watches:               newSourceWatches(),
+		wake:                  newWakeSignal(),
+	}
+	i.levelLog = &levelLogger{client: i}
+	i.deletes.onJournalError = func(err error) {
+		i.levelLog.Error("Delete journal failed, missed deletes will not survive a restart: %s", err)
 	}
+	i.limiter = newRequestLimiter(func() int {
+		i.configMutex.RLock()
+		defer i.configMutex.RUnlock()
+		return i.MaxConcurrentRequests
+	})
 	return i
 }
 
 // Add writes the given item, if no value already exists for its key. ErrNotStored is returned if that condition is not met.
-func (client *Client) Add(item *Item) error {
-	// Get all nodes that are marked healthy
-	nodes := client.Nodes.GetHealthyNodes()
+func (client *Client) Add(item *Item, opts ...WriteOption) (err error) {
+	start := client.Clock.Now()
+	defer func() { client.observe(OP_ADD, item.Key, start, item, err) }()
+
+	_, err = client.add(newOperationID(), item, client.newWriteOptions(item.Key, opts), false)
+	return err
+}
+
+// AddOrGet writes the given item, if no value already exists for its key. If a value exists, it is returned with
+// ErrNotStored, or nil if it could not be read. On success, nil is returned.
+func (client *Client) AddOrGet(item *Item, opts ...WriteOption) (existing *Item, err error) {
+	start := client.Clock.Now()
+	defer func() { client.observe(OP_ADD, item.Key, start, item, err) }()
+
+	return client.add(newOperationID(), item, client.newWriteOptions(item.Key, opts), true)
+}
+
+// add performs Add, returning the existing value on ErrNotStored if fetchExisting is true or it was read for
+// synchronisation
+func (client *Client) add(opID string, original *Item, options *WriteOptions, fetchExisting bool) (*Item, error) {
+	if client.Disabled() {
+		return nil, nil
+	}
+	if err := client.checkQuota(options.ctx, len(original.Value)); err != nil {
+		return nil, err
+	}
+	item, err := client.mapItem(options.applyTTL(original, client.Clock.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all healthy nodes for the key
+	nodes := client.getHealthyNodes(original.Key)
 	nodeCount := len(nodes)
 
 	// Bug out early if no nodes
 	if nodeCount == 0 {
-		return ErrNoHealthyNodes
+		return nil, ErrNoHealthyNodes
 	}
 
-	finishChan := make(chan (error))
 	statusChan := make(chan (*NodeResponse), nodeCount)
 
 	// Concurrently write to all healthy nodes
 	for _, node := range nodes {
-		node.Add(item, statusChan)
+		node.add(opID, item, statusChan)
 	}
 
-	// True if any node returns ErrNotStored
-	doSync := false
-	// These are the nodes that don't contain the value
+	// These are the nodes that already had a value
+	var conflictNodes []*Node
+	// These are the nodes that stored the new item
 	var nodesToSync []*Node
+	// These are the errors of nodes that failed, and the time each node took
+	errs := map[string]error{}
+	timings := make(nodeTimings, 0, nodeCount)
+
+	// Get response from all nodes
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		switch response.Error {
+		case memcache.ErrNotStored:
+			conflictNodes = append(conflictNodes, response.Node)
+		case nil:
+			nodesToSync = append(nodesToSync, response.Node)
+		default:
+			errs[response.Node.Endpoint] = response.Error
+		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
 
-	// Handle responses
-	go func() {
-		defer func() {
-			r := recover()
-			if r != nil {
-				finishChan <- ErrUnknown
-			}
-		}()
+	// Was the write acknowledged by enough nodes?
+	if err := writeError(len(conflictNodes)+len(nodesToSync), options.Quorum, errs, timings); err != nil {
+		return nil, err
+	}
 
-		// Get response from all nodes
-		for ; nodeCount > 0; nodeCount-- {
-			response := <-statusChan
-			if response.Error == memcache.ErrNotStored {
-				doSync = true
-			}
-			if response.Error == nil {
-				nodesToSync = append(nodesToSync, response.Node)
-			}
-			// We ignore other errors
-		}
+	// Where there any ErrNotStored?
+	if len(conflictNodes) == 0 {
+		return nil, nil
+	}
+	existing, err := client.resolveAddConflict(opID, item, conflictNodes, nodesToSync, options, fetchExisting)
 
-		// Where there any ErrNotStored?
-		if doSync {
-			if len(nodesToSync) > 0 {
-				client.Log.Info("Add: Synchronising %d nodes", len(nodesToSync))
-				// Re-read the original
-				item, err := client.Get(item.Key)
-				if err != nil {
-					// Write to all sync nodes unconditionally
-					if item.Expiration != nil {
-						client.Log.Info("Add: Synchronising %d nodes with %s expiry", len(nodesToSync), *item.Expiration)
-					} else {
-						client.Log.Info("Add: Synchronising %d nodes", len(nodesToSync))
-					}
-					for _, node := range nodesToSync {
-						node.Set(item, nil)
-					}
-				}
-			}
+	// Return the existing item under the caller's key, without modifying the item being synchronised
+	if existing != nil {
+		result := *existing
+		result.Key = original.Key
+		return &result, err
+	}
+	return nil, err
+}
 
-			finishChan <- memcache.ErrNotStored
-			return
+// resolveAddConflict applies the AddConflict policy where conflictNodes already had a value for the key of item and
+// nodesToSync stored item. It returns the result of the Add, and the existing value if it was read.
+func (client *Client) resolveAddConflict(opID string, item *Item, conflictNodes []*Node, nodesToSync []*Node, options *WriteOptions, fetchExisting bool) (*Item, error) {
+	if options.AddConflict == ADD_CONFLICT_OVERWRITE {
+		client.levelLog.Info("[%s] Add: Overwriting %d nodes", opID, len(conflictNodes))
+		for _, node := range conflictNodes {
+			node.set(opID, item, nil)
 		}
+		return nil, nil
+	}
 
-		// If this happened, writes to all nodes failed
-		if client.Nodes.GetHealthyNodeCount() == 0 {
-			finishChan <- ErrNoHealthyNodes
-			return
-		}
+	doSync := options.AddConflict == ADD_CONFLICT_PROPAGATE && len(nodesToSync) > 0 && !options.NoRepair
+	if !doSync && !fetchExisting {
+		return nil, memcache.ErrNotStored
+	}
 
-		// All good
-		finishChan <- nil
-	}()
+	// Re-read the existing value from a node that had it
+	response := conflictNodes[0].doGet(opID, item.Key)
+	defer releaseNodeResponse(response)
+	if response.Error != nil {
+		client.levelLog.Warn("[%s] Add: Reading existing value of %s failed: %s", opID, client.LogKey(item.Key), response.Error)
+		return nil, memcache.ErrNotStored
+	}
+	existing := response.Item
+	if !doSync {
+		return existing, memcache.ErrNotStored
+	}
 
-	// Return result
-	return <-finishChan
+	// Write to all sync nodes unconditionally
+	if existing.Expiration != nil {
+		client.levelLog.Info("[%s] Add: Synchronising %d nodes with %s expiry", opID, len(nodesToSync), *existing.Expiration)
+	} else {
+		client.levelLog.Info("[%s] Add: Synchronising %d nodes", opID, len(nodesToSync))
+	}
+	client.repairNodes(opID, nodesToSync, existing)
+	return existing, memcache.ErrNotStored
 }
 
 // Set writes the given item, unconditionally.
-func (client *Client) Set(item *Item) error {
-	// Get all nodes that are marked healthy
-	nodes := client.Nodes.GetHealthyNodes()
+func (client *Client) Set(item *Item, opts ...WriteOption) (err error) {
+	start, original := client.Clock.Now(), item
+	defer func() { client.observe(OP_SET, original.Key, start, original, err) }()
+
+	if client.Disabled() {
+		return nil
+	}
+	opID := newOperationID()
+	options := client.newWriteOptions(item.Key, opts)
+	if err := client.checkQuota(options.ctx, len(item.Value)); err != nil {
+		return err
+	}
+	item, err = client.mapItem(options.applyTTL(item, client.Clock.Now()))
+	if err != nil {
+		return err
+	}
+
+	// Get all healthy nodes for the key
+	nodes := client.getHealthyNodes(original.Key)
 	nodeCount := len(nodes)
 
 	// Bug out early if no nodes
@@ -135,138 +427,248 @@ func (client *Client) Set(item *Item) error {
 		return ErrNoHealthyNodes
 	}
 
-	finishChan := make(chan (error))
 	statusChan := make(chan (*NodeResponse), nodeCount)
 
 	// Concurrently write to all nodes
 	for _, node := range nodes {
-		node.Set(item, statusChan)
+		node.set(opID, item, statusChan)
 	}
 
-	// Handle responses
-	go func() {
-		// Panic handler
-		defer func() {
-			r := recover()
-			if r != nil {
-				finishChan <- ErrUnknown
-			}
-		}()
-
-		for ; nodeCount > 0; nodeCount-- {
-			// We actually don't care about errors, Node handles them.
-			<-statusChan
+	// Count of nodes that acknowledged the write
+	acked := 0
+	// These are the errors of nodes that failed, and the time each node took
+	errs := map[string]error{}
+	timings := make(nodeTimings, 0, nodeCount)
+
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		if response.Error == nil {
+			acked++
+			client.deletes.cancel(response.Node.Endpoint, item.Key)
+		} else {
+			errs[response.Node.Endpoint] = response.Error
 		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
 
-		// If this happened, writes to all nodes failed
-		if client.Nodes.GetHealthyNodeCount() == 0 {
-			finishChan <- ErrNoHealthyNodes
-			return
-		}
-
-		finishChan <- nil
-	}()
-
-	// Wait for final response and return
-	return <-finishChan
+	return writeError(acked, options.Quorum, errs, timings)
 }
 
 // Get gets the item for the given key. ErrCacheMiss is returned for a memcache cache miss.
 // The key must be at most 250 bytes in length.
-func (client *Client) Get(key string) (*Item, error) {
-	// Get all nodes that are marked healthy
-	nodes := client.Nodes.GetHealthyNodes()
-	nodeCount := len(nodes)
+func (client *Client) Get(key string, opts ...ReadOption) (result *Item, err error) {
+	start, originalKey := client.Clock.Now(), key
+	defer func() { client.observe(OP_GET, originalKey, start, result, err) }()
+
+	opID := newOperationID()
+	options := client.newReadOptions(key, opts)
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return nil, err
+	}
+	key, err = client.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
 
-	// Bug out early if no nodes
-	if nodeCount == 0 {
-		return nil, ErrNoHealthyNodes
+	client.configMutex.RLock()
+	coalesceGets := client.CoalesceGets
+	client.configMutex.RUnlock()
+	if coalesceGets {
+		return client.getCoalesced(opID, originalKey, key, options)
+	}
+	return client.get(opID, originalKey, key, options, nil)
+}
+
+// Stale returns true if the SoftExpiration of item has passed by the client's Clock
+func (client *Client) Stale(item *Item) bool {
+	return item.StaleAt(client.Clock.Now())
+}
+
+// GetInto copies the value of the item for the given key into buf, returning its length. If buf is too short, the
+// length of the value is returned with io.ErrShortBuffer. Reusing buf avoids allocating a result for each read on hot
+// paths. Reads without options skip decoding an Item where getIntoFast applies, and allocate nothing with nodes that
+// read into buf without allocating, e.g. MemoryNodeClient, and debug logging off; gets are not coalesced. Errors are
+// returned as for Get.
+func (client *Client) GetInto(key string, buf []byte, opts ...ReadOption) (n int, err error) {
+	start := client.Clock.Now()
+	if len(opts) == 0 {
+		if n, ok, err := client.getIntoFast(key, buf); ok {
+			client.observe(OP_GET, key, start, nil, err)
+			return n, err
+		}
+	}
+	var item *Item
+	defer func() { client.observe(OP_GET, key, start, item, err) }()
+
+	opID := newOperationID()
+	options := client.newReadOptions(key, opts)
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return 0, err
 	}
+	mappedKey, err := client.mapKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	item, err = client.get(opID, key, mappedKey, options, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < len(item.Value) {
+		return len(item.Value), io.ErrShortBuffer
+	}
+	return copy(buf, item.Value), nil
+}
+
+// readNodes returns the healthy nodes a read of the given key is sent to, skipping draining and degraded nodes and, at
+// random, nodes still ramping up
+func (client *Client) readNodes(originalKey string, options *ReadOptions) []*Node {
+	client.configMutex.RLock()
+	rampPeriod := client.NodeRampPeriod
+	client.configMutex.RUnlock()
+	nodes := rampNodes(getReadableNodes(withoutDraining(client.getHealthyNodes(originalKey))), client.Clock.Now(), rampPeriod)
+	return selectReadNodes(originalKey, nodes, readCount(len(nodes), options), options.Selection)
+}
 
-	// If there are more than 2 nodes
+// readCount returns the number of nodes of nodeCount a read is sent to
+func readCount(nodeCount int, options *ReadOptions) int {
+	if options.ReadAll {
+		return nodeCount
+	}
+	if options.ReadCount > 0 {
+		return options.ReadCount
+	}
 	if nodeCount > 2 {
 		// Reduce to Ceil(n/2) nodes
-		nodesToRead := nodeCount / 2
-		if nodesToRead*2 < nodeCount {
-			nodesToRead += 1
-		}
-		for k := range nodes {
-			if len(nodes) <= nodesToRead {
-				break
-			}
-			delete(nodes, k)
-		}
-		nodeCount = len(nodes)
+		return (nodeCount + 1) / 2
 	}
+	return nodeCount
+}
 
-	finishChan := make(chan (*NodeResponse))
-	statusChan := make(chan (*NodeResponse), nodeCount)
+// get reads the item with the mapped key from healthy nodes, returning it under originalKey. If info is not nil,
+// it is filled with the metadata of the item.
+func (client *Client) get(opID string, originalKey string, key string, options *ReadOptions, info *ItemInfo) (*Item, error) {
+	if client.Disabled() {
+		return nil, memcache.ErrCacheMiss
+	}
+	// Read all nodes for keys recently written by this client, see ReadYourWrites
+	_, recent := client.recent.lookup(originalKey, client.Clock.Now())
+	if recent && !options.ReadAll {
+		readAll := *options
+		readAll.ReadAll = true
+		options = &readAll
+	}
+
+	selected := client.readNodes(originalKey, options)
+	nodeCount := len(selected)
+
+	// Bug out early if no nodes
+	if nodeCount == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	statusChan := getResponseChan(nodeCount)
 
 	// Concurrently read from nodes
-	for _, node := range nodes {
-		node.Get(key, statusChan)
+	for _, node := range selected {
+		node.get(opID, key, statusChan)
 	}
 
 	// These are the nodes to sync to if we get some ErrCacheMiss from requests
 	var nodesToSync []*Node
-
-	// Handle responses
-	go func() {
-		// Panic handler
-		defer func() {
-			r := recover()
-			if r != nil {
-				finishChan <- NewNodeResponse(nil, nil, ErrUnknown)
-			}
-		}()
-
-		// Placeholder for result
-		var item *Item
-
-		// Get response from all nodes
-		for ; nodeCount > 0; nodeCount-- {
-			response := <-statusChan
-			if response.Error == memcache.ErrCacheMiss {
-				nodesToSync = append(nodesToSync, response.Node)
-			}
-			if response.Error == nil && response.Item != nil {
-				item = response.Item
+	// These are the errors of nodes that failed, allocated on the first failure, and the time each node took
+	var errs map[string]error
+	timings := make(nodeTimings, 0, nodeCount)
+	// These are the items found, and the endpoint and CAS ID of each
+	var items []*Item
+	var itemEndpoints []string
+	var itemCasIDs []uint64
+
+	// Get response from all nodes
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		switch {
+		case response.Error == memcache.ErrCacheMiss:
+			nodesToSync = append(nodesToSync, response.Node)
+		case response.Error == nil && response.Item != nil:
+			items = append(items, response.Item)
+			itemEndpoints = append(itemEndpoints, response.Node.Endpoint)
+			itemCasIDs = append(itemCasIDs, response.CasID)
+		default:
+			if errs == nil {
+				errs = map[string]error{}
 			}
+			errs[response.Node.Endpoint] = response.Error
 		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
+	releaseResponseChan(statusChan)
 
-		// Did we find an item from any node?
-		if item != nil {
-			if len(nodesToSync) > 0 {
-				if item.Expiration != nil {
-					client.Log.Info("Get: Synchronising %d nodes with %s expiry", len(nodesToSync), *item.Expiration)
-				} else {
-					client.Log.Info("Get: Synchronising %d nodes", len(nodesToSync))
-				}
-				// Resync by writing to missing nodes
-				for _, node := range nodesToSync {
-					node.Set(item, nil)
-				}
+	// Did we find an item from any node?
+	if len(items) == 0 {
+		if len(nodesToSync) == 0 {
+			return nil, timeoutError(&ErrAllNodesFailed{Errors: errs}, errs, timings)
+		}
+		return nil, memcache.ErrCacheMiss
+	}
+	item, agreed := agreedItem(items)
+	if recent {
+		item, agreed = client.recentItem(originalKey, items)
+	}
+	if info != nil {
+		info.Agreed = agreed
+		info.CasIDs = map[string]uint64{}
+		for i, other := range items {
+			if other.Flags == item.Flags && bytes.Equal(other.Value, item.Value) {
+				info.CasIDs[itemEndpoints[i]] = itemCasIDs[i]
 			}
-
-			// Return Item
-			finishChan <- NewNodeResponse(nil, item, nil)
-			return
 		}
+	}
 
-		// Not found
-		finishChan <- NewNodeResponse(nil, nil, memcache.ErrCacheMiss)
-	}()
-
-	// Wait for aggregate response
-	res := <-finishChan
+	if len(nodesToSync) > 0 && options.RepairQuorum > 0 && agreed < options.RepairQuorum {
+		client.levelLog.Info("[%s] Get: Not synchronising %d nodes, %d of %d nodes agree", opID, len(nodesToSync), agreed, options.RepairQuorum)
+	} else if len(nodesToSync) > 0 && !options.NoRepair {
+		if item.Expiration != nil {
+			client.levelLog.Info("[%s] Get: Synchronising %d nodes with %s expiry", opID, len(nodesToSync), *item.Expiration)
+		} else {
+			client.levelLog.Info("[%s] Get: Synchronising %d nodes", opID, len(nodesToSync))
+		}
+		// Resync by writing to missing nodes
+		client.repairNodes(opID, nodesToSync, item)
+	}
 
-	return res.Item, res.Error
+	// Return the item under the caller's key, without modifying the item being synchronised
+	if item.Key != originalKey {
+		result := *item
+		result.Key = originalKey
+		return &result, nil
+	}
+	return item, nil
 }
 
 // Delete deletes the item with the provided key. The error ErrCacheMiss is returned if the item didn't already exist in the cache.
-func (client *Client) Delete(key string) error {
-	// Get all nodes that are marked healthy
-	nodes := client.Nodes.GetHealthyNodes()
+func (client *Client) Delete(key string, opts ...WriteOption) (err error) {
+	start, originalKey := client.Clock.Now(), key
+	defer func() { client.observe(OP_DELETE, originalKey, start, nil, err) }()
+
+	if client.Disabled() {
+		return nil
+	}
+	opID := newOperationID()
+	options := client.newWriteOptions(key, opts)
+	defer func() { client.Audit(options.ctx, AUDIT_DELETE, client.LogKey(originalKey), start, err) }()
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return err
+	}
+	key, err = client.mapKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Get all healthy nodes for the key
+	nodes := client.getHealthyNodes(originalKey)
 	nodeCount := len(nodes)
 
 	// Bug out early if no nodes
@@ -274,52 +676,43 @@ func (client *Client) Delete(key string) error {
 		return ErrNoHealthyNodes
 	}
 
-	finishChan := make(chan (error))
 	statusChan := make(chan (*NodeResponse), nodeCount)
 
 	// Concurrently delete from all nodes
 	for _, node := range nodes {
-		node.Delete(key, statusChan)
+		node.delete(opID, key, statusChan)
 	}
 
-	// If any node returns ErrCacheMiss return this instead.
-	var errToReturn error
-
-	// Handle responses
-	go func() {
-		// Panic handler
-		defer func() {
-			r := recover()
-			if r != nil {
-				finishChan <- ErrUnknown
-			}
-		}()
-
-		for ; nodeCount > 0; nodeCount-- {
-			response := <-statusChan
-			if response.Error == memcache.ErrCacheMiss {
-				errToReturn = memcache.ErrCacheMiss
-			}
-		}
-
-		// If this happened, writes to all nodes failed
-		if client.Nodes.GetHealthyNodeCount() == 0 {
-			finishChan <- ErrNoHealthyNodes
-			return
-		}
-
-		finishChan <- errToReturn
-	}()
-
-	return <-finishChan
+	_, errs, err := collectMissableWrite(statusChan, nodeCount, options)
+	client.queueMissedDeletes(originalKey, key, nodes, errs)
+	return err
 }
 
 // Touch updates the expiry for the given key. The seconds parameter is either a Unix timestamp or,
 // if seconds is less than 1 month, the number of seconds into the future at which time the item will expire.
 // ErrCacheMiss is returned if the key is not in the cache. The key must be at most 250 bytes in length.
-func (client *Client) Touch(key string, seconds int32) error {
-	// Get all nodes that are marked healthy
-	nodes := client.Nodes.GetHealthyNodes()
+// With WithTouchRepair, nodes missing the key are synchronised from a node that has it, with the new expiry, and nil
+// is returned.
+func (client *Client) Touch(key string, seconds int32, opts ...WriteOption) (err error) {
+	start, originalKey := client.Clock.Now(), key
+	defer func() { client.observe(OP_TOUCH, originalKey, start, nil, err) }()
+
+	if client.Disabled() {
+		return nil
+	}
+	opID := newOperationID()
+	options := client.newWriteOptions(key, opts)
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return err
+	}
+	key, err = client.mapKey(key)
+	if err != nil {
+		return err
+	}
+	seconds = options.clampSeconds(seconds, client.Clock.Now())
+
+	// Get all healthy nodes for the key
+	nodes := client.getHealthyNodes(originalKey)
 	nodeCount := len(nodes)
 
 	// Bug out early if no nodes
@@ -327,165 +720,464 @@ func (client *Client) Touch(key string, seconds int32) error {
 		return ErrNoHealthyNodes
 	}
 
-	finishChan := make(chan (error))
 	statusChan := make(chan (*NodeResponse), nodeCount)
 
-	// Concurrently delete from all nodes
+	// Concurrently touch all nodes
+	for _, node := range nodes {
+		node.touch(opID, key, seconds, statusChan)
+	}
+
+	missed, _, err := collectMissableWrite(statusChan, nodeCount, options)
+	if err == memcache.ErrCacheMiss && options.TouchRepair && !options.NoRepair && len(missed) < nodeCount {
+		return client.repairTouch(opID, key, seconds, nodes, missed)
+	}
+	return err
+}
+
+// repairTouch synchronises the missed nodes with the item read from another of nodes, with the expiry of a Touch of
+// seconds. ErrCacheMiss is returned if the item could not be read.
+func (client *Client) repairTouch(opID string, key string, seconds int32, nodes map[string]*Node, missed []*Node) error {
+	isMissed := map[*Node]bool{}
+	for _, node := range missed {
+		isMissed[node] = true
+	}
+
 	for _, node := range nodes {
-		node.Touch(key, seconds, statusChan)
+		if isMissed[node] {
+			continue
+		}
+		response := node.doGet(opID, key)
+		item, err := response.Item, response.Error
+		releaseNodeResponse(response)
+		if err != nil || item == nil {
+			continue
+		}
+
+		item.Expiration = secondsToExpiration(seconds, client.Clock.Now())
+		client.levelLog.Info("[%s] Touch: Synchronising %d nodes", opID, len(missed))
+		client.repairNodes(opID, missed, item)
+		return nil
 	}
+	return memcache.ErrCacheMiss
+}
 
+// collectMissableWrite collects the responses of nodeCount nodes to a Delete or Touch, returning the nodes that did
+// not have the key and the errors of nodes that failed. ErrCacheMiss is returned if any node did not have the key.
+func collectMissableWrite(statusChan chan (*NodeResponse), nodeCount int, options *WriteOptions) ([]*Node, map[string]error, error) {
 	// If any node returns ErrCacheMiss return this instead.
 	var errToReturn error
+	// These are the nodes that did not have the key
+	var missed []*Node
+	// Count of nodes that acknowledged the write
+	acked := 0
+	// These are the errors of nodes that failed, and the time each node took
+	errs := map[string]error{}
+	timings := make(nodeTimings, 0, nodeCount)
+
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		switch response.Error {
+		case memcache.ErrCacheMiss:
+			errToReturn = memcache.ErrCacheMiss
+			missed = append(missed, response.Node)
+			acked++
+		case nil:
+			acked++
+		default:
+			errs[response.Node.Endpoint] = response.Error
+		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
 
-	// Handle responses
-	go func() {
-		// Panic handler
-		defer func() {
-			r := recover()
-			if r != nil {
-				finishChan <- ErrUnknown
-			}
-		}()
+	if err := writeError(acked, options.Quorum, errs, timings); err != nil {
+		return missed, errs, err
+	}
+	return missed, errs, errToReturn
+}
+
+// Increment increments the decimal value of the given key by delta on all nodes and returns the new value. Each node is
+// incremented with its own CAS loop, so the increment is atomic per node but not across nodes. ErrCacheMiss is returned
+// if the key is not in the cache. If nodes disagree, the highest value is returned and nodes missing the key or behind
+// it are synchronised with it.
+func (client *Client) Increment(key string, delta uint64, opts ...WriteOption) (value uint64, err error) {
+	start := client.Clock.Now()
+	defer func() { client.observe(OP_INCREMENT, key, start, nil, err) }()
+
+	return client.incrDecr(newOperationID(), key, delta, true, client.newWriteOptions(key, opts))
+}
+
+// Decrement decrements the decimal value of the given key by delta on all nodes and returns the new value, with a CAS
+// loop per node as Increment does. ErrCacheMiss is returned if the key is not in the cache. The value will not go below
+// zero. If nodes disagree, the lowest value is returned and nodes missing the key or above it are synchronised with it.
+func (client *Client) Decrement(key string, delta uint64, opts ...WriteOption) (value uint64, err error) {
+	start := client.Clock.Now()
+	defer func() { client.observe(OP_DECREMENT, key, start, nil, err) }()
+
+	return client.incrDecr(newOperationID(), key, delta, false, client.newWriteOptions(key, opts))
+}
+
+func (client *Client) incrDecr(opID string, key string, delta uint64, incr bool, options *WriteOptions) (uint64, error) {
+	if client.Disabled() {
+		return 0, memcache.ErrCacheMiss
+	}
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return 0, err
+	}
+	originalKey := key
+	key, err := client.mapKey(key)
+	if err != nil {
+		return 0, err
+	}
 
-		for ; nodeCount > 0; nodeCount-- {
-			response := <-statusChan
-			if response.Error == memcache.ErrCacheMiss {
-				errToReturn = memcache.ErrCacheMiss
+	// Get all healthy nodes for the key
+	nodes := client.getHealthyNodes(originalKey)
+	nodeCount := len(nodes)
+
+	// Bug out early if no nodes
+	if nodeCount == 0 {
+		return 0, ErrNoHealthyNodes
+	}
+
+	statusChan := make(chan (*NodeResponse), nodeCount)
+
+	// Concurrently modify all nodes
+	for _, node := range nodes {
+		node.incrementOrDecrement(opID, key, delta, incr, statusChan)
+	}
+
+	// These are the nodes to sync to if we get some ErrCacheMiss from requests
+	var nodesToSync []*Node
+	// These are the values of the nodes that changed, to align those behind the returned value
+	counts := map[*Node]uint64{}
+	// These are the errors of nodes that failed, and the time each node took
+	errs := map[string]error{}
+	timings := make(nodeTimings, 0, nodeCount)
+	// The item with the highest value on increment, or the lowest on decrement
+	var item *Item
+	var value uint64
+	// Count of nodes that acknowledged the write
+	acked := 0
+	notNumeric := false
+
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		switch {
+		case response.Error == memcache.ErrCacheMiss:
+			nodesToSync = append(nodesToSync, response.Node)
+			acked++
+		case response.Error == nil && response.Item != nil:
+			x, err := strconv.ParseUint(string(response.Item.Value), 10, 64)
+			if err != nil {
+				notNumeric = true
+				errs[response.Node.Endpoint] = ErrNotNumeric
+				break
+			}
+			if item == nil || (incr && x > value) || (!incr && x < value) {
+				item = response.Item
+				value = x
 			}
+			counts[response.Node] = x
+			acked++
+		default:
+			if response.Error == ErrNotNumeric {
+				notNumeric = true
+			}
+			errs[response.Node.Endpoint] = response.Error
 		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
 
-		// If this happened, writes to all nodes failed
-		if client.Nodes.GetHealthyNodeCount() == 0 {
-			finishChan <- ErrNoHealthyNodes
-			return
-		}
+	if item == nil && notNumeric {
+		return 0, ErrNotNumeric
+	}
 
-		finishChan <- errToReturn
-	}()
+	// Was the write acknowledged by enough nodes?
+	if err := writeError(acked, options.Quorum, errs, timings); err != nil {
+		return 0, err
+	}
+
+	if item == nil {
+		return 0, memcache.ErrCacheMiss
+	}
 
-	return <-finishChan
+	if options.NoRepair {
+		return value, nil
+	}
+	if len(nodesToSync) > 0 {
+		client.levelLog.Info("[%s] Increment: Synchronising %d nodes", opID, len(nodesToSync))
+		client.repairNodes(opID, nodesToSync, item)
+	}
+	// Nodes that missed earlier increments hold a lower count, and those that missed earlier decrements a higher one
+	var nodesBehind []*Node
+	for node, x := range counts {
+		if (incr && x < value) || (!incr && x > value) {
+			nodesBehind = append(nodesBehind, node)
+		}
+	}
+	if len(nodesBehind) > 0 {
+		client.levelLog.Info("[%s] Increment: Aligning %d nodes to %d", opID, len(nodesBehind), value)
+		client.alignCounters(opID, nodesBehind, key, value, incr)
+	}
+	return value, nil
 }
 
 // Start the Client client. This should be called before any operations are called.
 func (client *Client) Start() error {
-	if client.running != false {
-		return ErrAlreadyRunning
-	}
-	go client.runloop()
+	return client.run.start(client.openJournal, client.runloop)
+}
 
+// openJournal opens the DeleteJournal, if any, replaying the deletes missed before the last Stop
+func (client *Client) openJournal() error {
+	client.configMutex.RLock()
+	journal := client.DeleteJournal
+	client.configMutex.RUnlock()
+	if journal == "" {
+		return nil
+	}
+	if err := client.deletes.openJournal(journal, client.Clock.Now()); err != nil {
+		return err
+	}
+	client.levelLog.Info("Start: Replayed %d missed deletes from %s", client.deletes.len(), journal)
 	return nil
 }
 
+// StartAndWait discovers and healthchecks the nodes before starting the client as Start does, then waits for a healthy
+// node with WaitForNodesContext, so the first operations do not race discovery and fail with ErrNoHealthyNodes.
+// Discovery is not interrupted by ctx. The client is left running if no node becomes healthy.
+func (client *Client) StartAndWait(ctx context.Context) error {
+	if client.run.running() {
+		return ErrAlreadyRunning
+	}
+	client.GetNodes()
+	if err := client.Start(); err != nil {
+		return err
+	}
+	return client.WaitForNodesContext(ctx, 1)
+}
+
 // WaitForNodes waits for at least one available node, timing out on the deadline with ErrNoHealthyNodes
 func (client *Client) WaitForNodes(deadline time.Time) error {
-	startedChan := make(chan (error))
-	go func() {
-		for !time.Now().After(deadline) {
-			if client.Nodes.GetHealthyNodeCount() > 0 {
-				startedChan <- nil
-				return
-			}
-			time.Sleep(time.Second / 10)
-		}
-		startedChan <- ErrNoHealthyNodes
-	}()
-
-	return <-startedChan
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := client.WaitForNodesContext(ctx, 1); err != nil {
+		return ErrNoHealthyNodes
+	}
+	return nil
 }
 
-func (client *Client) runloop() {
-	client.Log.Info("Running")
-	timerChannel := time.After(time.Duration(time.Second))
-	lastGetNodes := time.Time{}
-	lastHealthCheck := time.Time{}
-	client.running = true
-
+// WaitForNodesContext waits for at least minNodes healthy nodes, waking on each change of the nodes' health. If ctx
+// is done first, an error matching both ErrNoHealthyNodes and the error of ctx is returned.
+func (client *Client) WaitForNodesContext(ctx context.Context, minNodes int) error {
+	if minNodes < 1 {
+		minNodes = 1
+	}
 	for {
+		changed := client.Nodes.Changed()
+		if client.Nodes.GetHealthyNodeCount() >= minNodes {
+			return nil
+		}
 		select {
-		case <-timerChannel:
-			now := time.Now()
-
-			if lastGetNodes.Add(GET_NODES_PERIOD).Before(now) {
-				client.GetNodes()
-				lastGetNodes = time.Now()
-			}
-
-			if lastHealthCheck.Add(HEALTHCHECK_PERIOD).Before(now) {
-				err := client.HealthCheck()
-				if err != nil {
-					client.Log.Warn("HealthCheck returned an error: %s", err)
-				}
-				lastHealthCheck = time.Now()
-			}
-
-			timerChannel = time.After(time.Duration(time.Second / 10))
-
-		case <-client.shutdownChan:
-			client.running = false
-			client.Log.Info("Stopped")
-			client.shutdownChan <- 2
-			return
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrNoHealthyNodes, ctx.Err())
 		}
 	}
+}
 
+// runloop runs the maintenance workers of this client until stop is closed
+func (client *Client) runloop(stop <-chan struct{}) {
+	client.levelLog.Info("Running")
+	clients := func() []*Client { return []*Client{client} }
+
+	var wg sync.WaitGroup
+	for _, worker := range maintenanceWorkers {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			superviseWorker(worker, clients, client.waitWake, stop)
+		}(worker)
+	}
+	wg.Wait()
+	client.levelLog.Info("Stopped")
 }
 
 // GetNodes updates the list of nodes in the client from the configured sources.
 func (client *Client) GetNodes() {
+	client.GetNodesContext(context.Background())
+}
+
+// GetNodesContext updates the list of nodes in the client from the configured sources, passing ctx to the
+// NodeSpecSources, see GetNodeSpecs. A source that fails is recorded in its SourceStatus and left out of the merge, so
+// with SOURCE_MERGE_PRIORITY the next source is used. If every source fails, the nodes are left unchanged.
+func (client *Client) GetNodesContext(ctx context.Context) {
+	client.discoveryMutex.Lock()
+	defer client.discoveryMutex.Unlock()
+
 	incomingNodes := map[string]bool{}
+	var sourceEndpoints [][]string
+	specs := map[string]NodeSpec{}
+	var ttl time.Duration
+
+	client.configMutex.RLock()
+	sources := client.Sources
+	sourceMerge, prewarm := client.SourceMerge, client.PrewarmConnections
+	client.configMutex.RUnlock()
+
+	statuses := make([]SourceStatus, 0, len(sources))
+	defer func() {
+		client.statusMutex.Lock()
+		client.sources = statuses
+		client.statusMutex.Unlock()
+	}()
 
-	for _, source := range client.Sources {
-		nodes, err := source.GetNodes()
+	for _, source := range sources {
+		// WatchableSources are polled only while not subscribed to
+		sourceSpecs, watched := client.watches.specs(source)
+		var err error
+		if !watched {
+			sourceSpecs, err = GetNodeSpecs(ctx, source)
+		}
+		nodes := specAddresses(sourceSpecs)
+		status := SourceStatus{Source: fmt.Sprintf("%T", source), LastRun: client.Clock.Now(), Nodes: nodes, Watched: watched}
+		if _, ok := source.(NodeSpecSource); ok {
+			status.Specs = sourceSpecs
+		}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
 		if err != nil {
-			client.Log.Error("GetNodes: Source Error: %s", err)
-			return
+			client.levelLog.Error("GetNodes: Source Error: %s", err)
+			continue
 		}
+		sourceEndpoints = append(sourceEndpoints, nodes)
 
-		// Added Nodes
-		for _, nodeAddr := range nodes {
-			incomingNodes[nodeAddr] = true
-			if !client.Nodes.Exists(nodeAddr) {
-				client.Log.Info("GetNodes: Node Added %s", nodeAddr)
-				node := NewNode(client.Log, nodeAddr, client.Timeout)
-				client.Nodes.Add(node)
-				ok, err := node.HealthCheck()
+		// The first source describing a node decides its spec
+		for _, spec := range sourceSpecs {
+			normalized, err := NormalizeEndpoint(spec.Address)
+			if err != nil {
+				continue
+			}
+			if _, found := specs[normalized]; !found {
+				specs[normalized] = spec
+			}
+			if spec.TTL > 0 && (ttl == 0 || spec.TTL < ttl) {
+				ttl = spec.TTL
+			}
+		}
+	}
+	if len(sources) > 0 && len(sourceEndpoints) == 0 {
+		return
+	}
+	endpoints := mergeSources(sourceMerge, sourceEndpoints)
+	client.setSourceTTL(ttl)
+
+	// Added Nodes ramp up unless they are the first
+	known := client.Nodes.GetNodes()
+	for _, nodeAddr := range client.normalizeEndpoints(endpoints, known) {
+		// Drained nodes are kept until their source drops them, but not added again
+		if client.isDrained(nodeAddr) {
+			if client.Nodes.Exists(nodeAddr) {
+				incomingNodes[nodeAddr] = true
+			}
+			continue
+		}
+		incomingNodes[nodeAddr] = true
+		if !client.Nodes.Exists(nodeAddr) {
+			spec := specs[nodeAddr]
+			nodeClient, err := client.newNodeClientFor(nodeAddr, spec)
+			if err != nil {
+				client.levelLog.Error("GetNodes: Node %s not added: %s", nodeAddr, err)
+				delete(incomingNodes, nodeAddr)
+				continue
+			}
+			client.levelLog.Info("GetNodes: Node Added %s", nodeAddr)
+			node := NewNodeWithClient(client.levelLog, nodeAddr, nodeClient)
+			node.Spec = spec
+			if len(known) > 0 {
+				node.rampStart = client.Clock.Now()
+			}
+			node.limiter = client.limiter
+			node.onError = client.logNodeError
+			node.logKey = client.LogKey
+			node.encryption = client.encryption
+			node.integrity = client.integrity
+			node.probeConfig = client.healthCheckProbe
+			node.errorCategory = client.errorCategory
+			node.clock = client.Clock
+			client.Nodes.Add(node)
+			ok, err := node.HealthCheck()
+			if err != nil {
+				client.levelLog.Warn("GetNodes: Initial HealthCheck for Node %s returned an error: %s", nodeAddr, err)
+			}
+			if !ok {
+				client.levelLog.Warn("GetNodes: Initial HealthCheck failed for Node %s", nodeAddr)
+			}
+			if ok && prewarm {
+				opened, err := node.prewarm()
 				if err != nil {
-					client.Log.Warn("GetNodes: Initial HealthCheck for Node %s returned an error: %s", nodeAddr, err)
-				}
-				if !ok {
-					client.Log.Warn("GetNodes: Initial HealthCheck failed for Node %s", nodeAddr)
+					client.levelLog.Warn("GetNodes: Prewarming Node %s opened %d connections: %s", nodeAddr, opened, err)
+				} else if opened > 0 {
+					client.levelLog.Debug("GetNodes: Prewarmed %d connections to Node %s", opened, nodeAddr)
 				}
 			}
 		}
 	}
 
 	// Removed nodes
-	for nodeAddr := range client.Nodes.Nodes {
+	for nodeAddr := range client.Nodes.GetNodes() {
 		if _, found := incomingNodes[nodeAddr]; !found {
-			client.Log.Info("GetNodes: Node Removed %s", nodeAddr)
-			delete(client.Nodes.Nodes, nodeAddr)
+			client.levelLog.Info("GetNodes: Node Removed %s", nodeAddr)
+			client.Nodes.Remove(nodeAddr)
 		}
 	}
 }
 
-// HealthCheck performs a healthcheck on all nodes.
+// newNodeClientFor returns a new NodeClient for the node at endpoint described by spec, from NewTLSNodeClient if spec
+// requires TLS or from NewNodeClient otherwise. ErrTLSRequired is returned if NewTLSNodeClient is needed but not set,
+// and ErrNoNodeClient if NewNodeClient is.
+func (client *Client) newNodeClientFor(endpoint string, spec NodeSpec) (NodeClient, error) {
+	client.configMutex.RLock()
+	newNodeClient, timeout := client.NewNodeClient, client.Timeout
+	if spec.TLS {
+		newNodeClient = client.NewTLSNodeClient
+	}
+	client.configMutex.RUnlock()
+	switch {
+	case newNodeClient == nil && spec.TLS:
+		return nil, ErrTLSRequired
+	case newNodeClient == nil:
+		return nil, ErrNoNodeClient
+	}
+	return newNodeClient(endpoint, timeout), nil
+}
+
+// setSourceTTL records the shortest TTL of the NodeSpecs found by the last discovery, rescheduling discovery if it
+// has changed
+func (client *Client) setSourceTTL(ttl time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	if ttl != client.sourceTTL {
+		client.sourceTTL = ttl
+		client.wakeRunloop()
+	}
+}
+
+// HealthCheck performs a healthcheck on all nodes, returning the first error encountered.
 func (client *Client) HealthCheck() error {
-	for _, node := range client.Nodes.Nodes {
+	var firstErr error
+	for _, node := range client.Nodes.GetNodes() {
 		_, err := node.HealthCheck()
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
-	return nil
+	return firstErr
 }
 
-// Stop the Client client.
+// Stop the Client client, waiting for its runloop to return. ErrNotRunning is returned if it is not running.
 func (client *Client) Stop() error {
-	if client.running != true {
-		return ErrAlreadyRunning
-	}
-	client.shutdownChan <- 1
-	<-client.shutdownChan
-	return nil
+	return client.run.stopAndWait(client.deletes.closeJournal)
 }
@@ -0,0 +1,161 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+
+	"fmt"
+	"testing"
+	"time"
+)
+
+var benchNodeCounts = []int{1, 2, 3, 5}
+
+func newBenchClient(b *testing.B, nodeCount int) *Client {
+	var endpoints []string
+	for i := 0; i < nodeCount; i++ {
+		endpoints = append(endpoints, fmt.Sprintf("node%d:11211", i))
+	}
+	client := New(logger.NewConsoleLogger("error"), NewStaticNodeSource(endpoints...))
+	client.NewNodeClient = func(endpoint string, timeout time.Duration) NodeClient {
+		return NewMemoryNodeClient()
+	}
+	client.GetNodes()
+	if client.Nodes.GetHealthyNodeCount() != nodeCount {
+		b.Fatalf("Expected %d healthy nodes, got %d", nodeCount, client.Nodes.GetHealthyNodeCount())
+	}
+	return client
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			item := &Item{Key: "bench", Value: make([]byte, 1024)}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := client.Set(item); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			if err := client.Set(&Item{Key: "bench", Value: make([]byte, 1024)}); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Get("bench"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			if err := client.Set(&Item{Key: "bench", Value: make([]byte, 1024)}); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Get("bench"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetCoalesced measures the overhead of CoalesceGets for a single caller, compare with BenchmarkGet
+func BenchmarkGetCoalesced(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			client.CoalesceGets = true
+			if err := client.Set(&Item{Key: "bench", Value: make([]byte, 1024)}); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Get("bench"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetCoalescedParallel(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			client.CoalesceGets = true
+			if err := client.Set(&Item{Key: "bench", Value: make([]byte, 1024)}); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Get("bench"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			item := &Item{Key: "bench", Value: make([]byte, 1024)}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := client.Set(item); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetInto measures GetInto reusing a buffer, compare with BenchmarkGet
+func BenchmarkGetInto(b *testing.B) {
+	for _, nodeCount := range benchNodeCounts {
+		b.Run(fmt.Sprintf("nodes=%d", nodeCount), func(b *testing.B) {
+			client := newBenchClient(b, nodeCount)
+			if err := client.Set(&Item{Key: "bench", Value: make([]byte, 1024)}); err != nil {
+				b.Fatal(err)
+			}
+			// Room for the stored value with its header
+			buf := make([]byte, 1024+12)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.GetInto("bench", buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
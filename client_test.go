@@ -0,0 +1,590 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventually fails the test if condition doesn't return true within a second, for background repairs
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met within 1s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// consistent returns true if all nodes of cluster hold value for key
+func consistent(cluster *memcachehatest.Cluster, key string, value []byte) bool {
+	for _, endpoint := range cluster.Endpoints() {
+		if !bytes.Equal(cluster.Value(endpoint, key), value) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIncrementRaisesLaggingNodes(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	if err := client.Add(&memcacheha.Item{Key: "counter", Value: []byte("0")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+
+	// Keep the node healthy so it is written to once it recovers
+	client.Nodes.GetNodes()["node1:11211"].ForceHealth(true)
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	for i := 0; i < 3; i++ {
+		if _, err := client.Increment("counter", 1); err != nil {
+			t.Fatalf("Increment failed: %s", err)
+		}
+	}
+	if value := cluster.Value("node1:11211", "counter"); string(value) != "0" {
+		t.Fatalf("Failed node holds %q, expected 0", value)
+	}
+
+	cluster.Recover("node1:11211")
+	value, err := client.Increment("counter", 1)
+	if err != nil {
+		t.Fatalf("Increment failed: %s", err)
+	}
+	if value != 4 {
+		t.Fatalf("Increment returned %d, expected 4", value)
+	}
+	eventually(t, func() bool { return consistent(cluster, "counter", []byte("4")) })
+}
+
+func TestDecrementLowersLaggingNodes(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	if err := client.Add(&memcacheha.Item{Key: "counter", Value: []byte("10")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+
+	// Keep the node healthy so it is written to once it recovers
+	client.Nodes.GetNodes()["node1:11211"].ForceHealth(true)
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	for i := 0; i < 5; i++ {
+		if _, err := client.Decrement("counter", 1); err != nil {
+			t.Fatalf("Decrement failed: %s", err)
+		}
+	}
+
+	cluster.Recover("node1:11211")
+	value, err := client.Decrement("counter", 1)
+	if err != nil {
+		t.Fatalf("Decrement failed: %s", err)
+	}
+	if value != 4 {
+		t.Fatalf("Decrement returned %d, expected 4", value)
+	}
+	eventually(t, func() bool { return consistent(cluster, "counter", []byte("4")) })
+}
+
+func TestAddConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy   memcacheha.AddConflictPolicy
+		err      error
+		expected map[string]string
+	}{
+		{memcacheha.ADD_CONFLICT_PROPAGATE, memcache.ErrNotStored, map[string]string{"node1:11211": "old", "node2:11211": "old", "node3:11211": "old"}},
+		{memcacheha.ADD_CONFLICT_ABORT, memcache.ErrNotStored, map[string]string{"node1:11211": "old", "node2:11211": "new", "node3:11211": "new"}},
+		{memcacheha.ADD_CONFLICT_OVERWRITE, nil, map[string]string{"node1:11211": "new", "node2:11211": "new", "node3:11211": "new"}},
+	}
+	for _, test := range tests {
+		name, _ := test.policy.MarshalText()
+		t.Run(string(name), func(t *testing.T) {
+			cluster := memcachehatest.NewCluster(3)
+			client := cluster.NewClient(t)
+			cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("old")}, "node1:11211")
+
+			err := client.Add(&memcacheha.Item{Key: "key", Value: []byte("new")}, memcacheha.WithAddConflictPolicy(test.policy))
+			if err != test.err {
+				t.Fatalf("Add returned %v, expected %v", err, test.err)
+			}
+			eventually(t, func() bool {
+				for endpoint, value := range test.expected {
+					if string(cluster.Value(endpoint, "key")) != value {
+						return false
+					}
+				}
+				return true
+			})
+		})
+	}
+}
+
+func TestWriteQuorum(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211", "node2:11211")
+
+	err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}, memcacheha.WithWriteQuorum(2))
+	var quorumErr *memcacheha.QuorumError
+	if !errors.As(err, &quorumErr) || !errors.Is(err, memcacheha.ErrQuorumNotReached) {
+		t.Fatalf("Set returned %v, expected a QuorumError", err)
+	}
+	if quorumErr.Acked != 1 || quorumErr.Quorum != 2 || len(quorumErr.Errors) != 2 {
+		t.Fatalf("QuorumError has %d of %d acked with %d errors, expected 1 of 2 with 2 errors", quorumErr.Acked, quorumErr.Quorum, len(quorumErr.Errors))
+	}
+}
+
+func TestAllNodesFailed(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	cluster.Fail(memcacheha.ErrNodeNetwork)
+
+	err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")})
+	var allFailed *memcacheha.ErrAllNodesFailed
+	if !errors.As(err, &allFailed) || !errors.Is(err, memcacheha.ErrNoHealthyNodes) {
+		t.Fatalf("Set returned %v, expected ErrAllNodesFailed", err)
+	}
+	if len(allFailed.Errors) != 3 {
+		t.Fatalf("ErrAllNodesFailed has %d errors, expected 3", len(allFailed.Errors))
+	}
+}
+
+func TestGetRepairsMissingNodes(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")}, "node1:11211", "node2:11211")
+
+	item, err := client.Get("key", memcacheha.WithReadAll())
+	if err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	eventually(t, func() bool { return consistent(cluster, "key", []byte("value")) })
+}
+
+func TestGetRepairQuorum(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("a")}, "node1:11211")
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("b")}, "node2:11211")
+
+	if _, err := client.Get("key", memcacheha.WithReadAll(), memcacheha.WithRepairQuorum(2)); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if value := cluster.Value("node3:11211", "key"); value != nil {
+		t.Fatalf("node3 was repaired with %q without a repair quorum", value)
+	}
+}
+
+func TestDeleteRetryJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "deletes")
+	configure := func(client *memcacheha.Client) {
+		client.DeleteRetryTTL = time.Minute
+		client.DeleteJournal = journal
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, configure)
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")})
+
+	// Keep the node healthy so the delete is sent to it and fails
+	client.Nodes.GetNodes()["node1:11211"].ForceHealth(true)
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	if err := client.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if pending := client.PendingDeletes(); pending != 1 {
+		t.Fatalf("%d pending deletes, expected 1", pending)
+	}
+	if err := client.Stop(); err != nil {
+		t.Fatalf("Stop failed: %s", err)
+	}
+
+	// The missed delete is replayed by the next client, and cancelled by a Set that reaches the node
+	cluster.Recover("node1:11211")
+	client = cluster.NewClient(t, configure)
+	if pending := client.PendingDeletes(); pending != 1 {
+		t.Fatalf("%d pending deletes after restart, expected 1", pending)
+	}
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("new")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if pending := client.PendingDeletes(); pending != 0 {
+		t.Fatalf("%d pending deletes after Set, expected 0", pending)
+	}
+}
+
+func TestCoalesceGets(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.CoalesceGets = true })
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")})
+	cluster.SetLatency(50 * time.Millisecond)
+
+	requests := func() uint64 {
+		var count uint64
+		for _, node := range client.Nodes.GetNodes() {
+			count += node.RequestCount()
+		}
+		return count
+	}
+	before := requests()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := client.Get("key")
+			if err != nil || string(item.Value) != "value" {
+				t.Errorf("Get returned %v, %v", item, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sent := requests() - before; sent >= 10 {
+		t.Fatalf("%d requests sent for 10 coalesced Gets", sent)
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	encryption, err := memcacheha.NewEncryption(1, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Encryption = encryption })
+
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("secret")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if value := cluster.Value("node1:11211", "key"); bytes.Contains(value, []byte("secret")) {
+		t.Fatalf("node1 holds the plaintext value %q", value)
+	}
+	cluster.AssertConsistent(t, client, "key")
+	item, err := client.Get("key")
+	if err != nil || string(item.Value) != "secret" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
+
+func TestIntegrityTamperIsMiss(t *testing.T) {
+	integrity, err := memcacheha.NewIntegrity(1, bytes.Repeat([]byte("k"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Integrity = integrity })
+
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	// An unsigned value is read as a miss from that node and repaired from the others
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("tampered")}, "node1:11211")
+	item, err := client.Get("key", memcacheha.WithReadAll())
+	if err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	eventually(t, func() bool { return string(cluster.Value("node1:11211", "key")) != "tampered" })
+
+	// A value tampered with on every node is a miss
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("tampered")})
+	if _, err := client.Get("key"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get returned %v, expected ErrCacheMiss", err)
+	}
+}
+
+func TestTimeoutErrorAttribution(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+	cluster.Fail(memcacheha.ErrNodeTimeout, "node1:11211", "node2:11211")
+
+	err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}, memcacheha.WithWriteQuorum(2))
+	var timeoutErr *memcacheha.TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, memcacheha.ErrOperationTimeout) || !errors.Is(err, memcacheha.ErrQuorumNotReached) {
+		t.Fatalf("Set returned %v, expected a TimeoutError of a QuorumError", err)
+	}
+	for _, endpoint := range []string{"node1:11211", "node2:11211"} {
+		if _, found := timeoutErr.TimedOut[endpoint]; !found {
+			t.Errorf("%s not reported as timed out: %s", endpoint, err)
+		}
+	}
+	if _, found := timeoutErr.Responded["node3:11211"]; !found || len(timeoutErr.Responded) != 1 {
+		t.Errorf("Only node3:11211 should have responded: %s", err)
+	}
+}
+
+func TestGetIntoAllocations(t *testing.T) {
+	// Debug logging allocates an operation ID per read, unless there is no Log
+	tests := []struct {
+		name      string
+		configure func(*memcacheha.Client)
+	}{
+		{"info", func(client *memcacheha.Client) { client.LogLevel = memcacheha.LOG_INFO }},
+		{"nolog", func(client *memcacheha.Client) { client.Log = nil }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := memcachehatest.NewCluster(3)
+			client := cluster.NewClient(t, test.configure)
+			if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+				t.Fatalf("Set failed: %s", err)
+			}
+
+			buf := make([]byte, 64)
+			n, err := client.GetInto("key", buf)
+			if err != nil || string(buf[:n]) != "value" {
+				t.Fatalf("GetInto returned %q, %v", buf[:n], err)
+			}
+			allocs := testing.AllocsPerRun(1000, func() {
+				if _, err := client.GetInto("key", buf); err != nil {
+					t.Fatalf("GetInto failed: %s", err)
+				}
+			})
+			if allocs != 0 {
+				t.Fatalf("GetInto made %v allocations per read, expected 0", allocs)
+			}
+			if _, err := client.GetInto("missing", buf); err != memcache.ErrCacheMiss {
+				t.Fatalf("GetInto of a missing key returned %v, expected ErrCacheMiss", err)
+			}
+		})
+	}
+}
+
+func TestSetMultiObserved(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.ReadYourWrites = time.Minute })
+
+	results, err := client.SetMulti([]*memcacheha.Item{{Key: "a", Value: []byte("new")}, {Key: "b", Value: []byte("new")}})
+	if err != nil || results["a"] != nil || results["b"] != nil {
+		t.Fatalf("SetMulti returned %v, %v", results, err)
+	}
+	if calls := client.OperationStats().Ops[memcacheha.OP_SET].Calls; calls != 2 {
+		t.Fatalf("SetMulti counted %d sets, expected 2", calls)
+	}
+
+	// The write is read back over a majority of nodes holding an older value
+	cluster.Put(&memcacheha.Item{Key: "a", Value: []byte("old")}, "node1:11211", "node2:11211")
+	item, err := client.Get("a", memcacheha.WithReadAll())
+	if err != nil || string(item.Value) != "new" {
+		t.Fatalf("Get returned %v, %v, expected new", item, err)
+	}
+}
+
+func TestRequestLimitDoesNotStallOtherClients(t *testing.T) {
+	slow := memcachehatest.NewCluster(1)
+	limited := slow.NewClient(t, func(client *memcacheha.Client) { client.MaxConcurrentRequests = 1 })
+	slow.SetLatency(5 * time.Millisecond)
+
+	// Queue more requests than the worker pool has workers behind the limit
+	var wg sync.WaitGroup
+	for i := 0; i < memcacheha.WORKER_POOL_SIZE+64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limited.Set(&memcacheha.Item{Key: "key", Value: []byte("value")})
+		}()
+	}
+	defer wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+
+	other := memcachehatest.NewCluster(1).NewClient(t)
+	start := time.Now()
+	if err := other.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Set of another client took %s", elapsed)
+	}
+}
+
+func TestPrioritySourceFallsBackOnError(t *testing.T) {
+	cluster := memcachehatest.NewCluster(2)
+	failing := memcacheha.NodeSpecSourceFunc(func(ctx context.Context) ([]memcacheha.NodeSpec, error) {
+		return nil, errors.New("discovery down")
+	})
+	client := memcacheha.New(nil, failing, memcacheha.NewStaticNodeSource("node1:11211", "node2:11211"))
+	client.NewNodeClient = cluster.NewNodeClient
+	client.SourceMerge = memcacheha.SOURCE_MERGE_PRIORITY
+	clk := memcachehatest.NewClock(time.Unix(1700000000, 0))
+	client.Clock = clk
+
+	client.GetNodes()
+	if count := client.Nodes.GetHealthyNodeCount(); count != 2 {
+		t.Fatalf("Expected the 2 static nodes, got %d", count)
+	}
+	sources := client.DebugState().Sources
+	if len(sources) != 2 || sources[0].Error != "discovery down" {
+		t.Fatalf("Expected the error of the first source in its status, got %+v", sources)
+	}
+	if !sources[0].LastRun.Equal(clk.Now()) {
+		t.Fatalf("Source last run at %s, expected the client clock's %s", sources[0].LastRun, clk.Now())
+	}
+}
+
+func TestStreamLongKey(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+
+	key := string(bytes.Repeat([]byte("k"), memcacheha.MAX_KEY_LENGTH))
+	if err := client.SetReader(key, bytes.NewReader([]byte("value")), 5, 0); err != nil {
+		t.Fatalf("SetReader failed: %s", err)
+	}
+	r, err := client.GetReader(key)
+	if err != nil {
+		t.Fatalf("GetReader failed: %s", err)
+	}
+	defer r.Close()
+	if value, err := io.ReadAll(r); err != nil || string(value) != "value" {
+		t.Fatalf("Read returned %q, %v", value, err)
+	}
+
+	if err := client.SetReader(key+"k", bytes.NewReader([]byte("value")), 5, 0); err != memcacheha.ErrKeyTooLong {
+		t.Fatalf("SetReader returned %v, expected %v", err, memcacheha.ErrKeyTooLong)
+	}
+}
+
+func TestApplicationFlagsSurviveSync(t *testing.T) {
+	const flags uint32 = 0x00c0ffee
+	assertFlags := func(t *testing.T, cluster *memcachehatest.Cluster, key string) {
+		t.Helper()
+		eventually(t, func() bool {
+			for _, endpoint := range cluster.Endpoints() {
+				if item := cluster.Node(endpoint).Peek(key); item == nil || item.Flags != flags {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	item := &memcacheha.Item{Key: "set", Value: []byte("value")}
+	if err := item.SetApplicationFlags(flags); err != nil {
+		t.Fatalf("SetApplicationFlags failed: %s", err)
+	}
+	if err := client.Set(item); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	assertFlags(t, cluster, "set")
+	if read, err := client.Get("set"); err != nil || read.ApplicationFlags() != flags {
+		t.Fatalf("Get returned %v, %v, expected flags %#x", read, err, flags)
+	}
+
+	// An Add conflicting with one node synchronises the others with its item and flags
+	cluster.Put(&memcacheha.Item{Key: "add", Value: []byte("old"), Flags: flags}, "node1:11211")
+	if err := client.Add(&memcacheha.Item{Key: "add", Value: []byte("new")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add returned %v, expected ErrNotStored", err)
+	}
+	assertFlags(t, cluster, "add")
+
+	// Read repair copies the flags to the node missing the item
+	cluster.Put(&memcacheha.Item{Key: "repair", Value: []byte("value"), Flags: flags}, "node1:11211", "node2:11211")
+	if _, err := client.Get("repair", memcacheha.WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	assertFlags(t, cluster, "repair")
+
+	if err := item.SetApplicationFlags(memcacheha.FLAG_COMPRESSED); err != memcacheha.ErrReservedFlags {
+		t.Fatalf("SetApplicationFlags returned %v, expected ErrReservedFlags", err)
+	}
+}
+
+func TestCodecFlagsRoundTrip(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	// Items built with the library's flag helpers are written and read back as any others
+	item := &memcacheha.Item{Key: "key", Value: []byte("value")}
+	if err := item.SetCodec(7); err != nil {
+		t.Fatalf("SetCodec failed: %s", err)
+	}
+	item.SetFlag(memcacheha.FLAG_COMPRESSED, true)
+	if err := item.SetApplicationFlags(0x1234); err != nil {
+		t.Fatalf("SetApplicationFlags failed: %s", err)
+	}
+	for _, write := range []func(*memcacheha.Item, ...memcacheha.WriteOption) error{client.Set, client.Add} {
+		if err := write(item); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		read, err := client.Get("key")
+		if err != nil {
+			t.Fatalf("Get failed: %s", err)
+		}
+		if read.Codec() != 7 || !read.HasFlag(memcacheha.FLAG_COMPRESSED) || read.ApplicationFlags() != 0x1234 {
+			t.Fatalf("Get returned flags %#x, expected codec 7, compressed and application flags 0x1234", read.Flags)
+		}
+		if err := client.Delete("key"); err != nil {
+			t.Fatalf("Delete failed: %s", err)
+		}
+	}
+	if err := item.SetCodec(0x40); err != memcacheha.ErrReservedFlags {
+		t.Fatalf("SetCodec of an id beyond FLAG_CODEC_MASK returned %v, expected ErrReservedFlags", err)
+	}
+}
+
+func TestGetMultiNoKeys(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+	if err := client.GetMultiFunc(nil, func(*memcacheha.Item) { t.Fatal("fn called without keys") }); err != nil {
+		t.Fatalf("GetMultiFunc returned %v", err)
+	}
+	result, err := client.GetMultiContext(context.Background(), []string{})
+	if err != nil || len(result.Items) != 0 || result.Partial {
+		t.Fatalf("GetMultiContext returned %+v, %v", result, err)
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"node1:11211":          "node1:11211",
+		" NODE1.:11211 ":       "node1:11211",
+		"node1":                "node1:11211",
+		"10.0.0.1":             "10.0.0.1:11211",
+		"::1":                  "[::1]:11211",
+		"[::0001]":             "[::1]:11211",
+		"[0:0:0:0:0:0:0:1]:99": "[::1]:99",
+	}
+	for endpoint, expected := range tests {
+		if normalized, err := memcacheha.NormalizeEndpoint(endpoint); err != nil || normalized != expected {
+			t.Fatalf("NormalizeEndpoint(%q) returned %q, %v, expected %q", endpoint, normalized, err, expected)
+		}
+	}
+	for _, endpoint := range []string{"", ":11211", "1:2:3:x"} {
+		if _, err := memcacheha.NormalizeEndpoint(endpoint); err != memcacheha.ErrInvalidEndpoint {
+			t.Fatalf("NormalizeEndpoint(%q) returned %v, expected ErrInvalidEndpoint", endpoint, err)
+		}
+	}
+}
+
+func TestNormalizedEndpointsShareNodes(t *testing.T) {
+	cluster := memcachehatest.NewCluster(2)
+	client := memcacheha.New(nil,
+		memcacheha.NewStaticNodeSource("NODE1:11211", "node2"),
+		memcacheha.NewStaticNodeSource("node1.:11211", " node2:11211"))
+	client.NewNodeClient = cluster.NewNodeClient
+
+	// Every form of an endpoint is the same node
+	client.GetNodes()
+	nodes := client.Nodes.GetNodes()
+	if len(nodes) != 2 || nodes["node1:11211"] == nil || nodes["node2:11211"] == nil {
+		t.Fatalf("Expected nodes node1:11211 and node2:11211, got %v", nodes)
+	}
+
+	// And so writes and reads through any form reach the one stored item
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	cluster.AssertValue(t, "key", []byte("value"))
+	if item, err := client.Get("key"); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	if err := client.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	cluster.AssertValue(t, "key", nil)
+}
@@ -0,0 +1,28 @@
+// Package clock abstracts the time used by memcacheha, so tests can drive its timers and expiries deterministically,
+// see memcachehatest.Clock
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and schedules timers
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After returns a channel receiving the current time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
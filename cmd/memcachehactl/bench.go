@@ -0,0 +1,368 @@
+package main
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// benchFlags are the flags of the bench command
+type benchFlags struct {
+	clusterFlags
+	QPS          int
+	Duration     time.Duration
+	Concurrency  int
+	Keys         int
+	KeyPrefix    string
+	Distribution string
+	ZipfS        float64
+	ValueSize    int
+	ReadRatio    float64
+	TTL          time.Duration
+}
+
+func runBench(args []string) error {
+	benchFlags := &benchFlags{}
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	benchFlags.Register(flags)
+	flags.IntVar(&benchFlags.QPS, "qps", 1000, "target operations per second, 0 for unlimited")
+	flags.DurationVar(&benchFlags.Duration, "duration", 30*time.Second, "duration of the benchmark")
+	flags.IntVar(&benchFlags.Concurrency, "concurrency", 32, "number of concurrent workers")
+	flags.IntVar(&benchFlags.Keys, "keys", 100000, "number of distinct keys")
+	flags.StringVar(&benchFlags.KeyPrefix, "key-prefix", "memcachehactl:bench:", "key prefix")
+	flags.StringVar(&benchFlags.Distribution, "distribution", "uniform", "key distribution, uniform or zipfian")
+	flags.Float64Var(&benchFlags.ZipfS, "zipf-s", 1.1, "zipfian distribution skew (s > 1)")
+	flags.IntVar(&benchFlags.ValueSize, "value-size", 1024, "value size in bytes")
+	flags.Float64Var(&benchFlags.ReadRatio, "read-ratio", 0.9, "fraction of operations that are reads")
+	flags.DurationVar(&benchFlags.TTL, "ttl", 10*time.Minute, "item TTL")
+	flags.Parse(args)
+
+	if err := benchFlags.validate(); err != nil {
+		return err
+	}
+
+	nodeStats := newNodeStats()
+	client, err := benchFlags.NewClient(nodeStats.NewNodeClient)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	fmt.Printf("Benchmarking %d nodes for %s at %d qps (%s keys, %d%% reads)\n",
+		client.Nodes.GetHealthyNodeCount(), benchFlags.Duration, benchFlags.QPS, benchFlags.Distribution, int(benchFlags.ReadRatio*100))
+
+	results := newBenchResults()
+	var tokens chan struct{}
+	if benchFlags.QPS > 0 {
+		tokens = make(chan struct{}, benchFlags.Concurrency)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < benchFlags.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			benchFlags.worker(client, results, tokens, done, seed)
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	// Pace the workers
+	start := time.Now()
+	deadline := time.After(benchFlags.Duration)
+	if tokens != nil {
+		ticker := time.NewTicker(time.Second / time.Duration(benchFlags.QPS))
+	pace:
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				default:
+					results.Dropped()
+				}
+			case <-deadline:
+				break pace
+			}
+		}
+		ticker.Stop()
+	} else {
+		<-deadline
+	}
+	close(done)
+	wg.Wait()
+
+	results.Print(time.Since(start))
+	nodeStats.Print()
+	return nil
+}
+
+// validate returns an error for flag values the benchmark can't run with
+func (benchFlags *benchFlags) validate() error {
+	switch {
+	case benchFlags.Distribution != "uniform" && benchFlags.Distribution != "zipfian":
+		return fmt.Errorf("unknown distribution %s", benchFlags.Distribution)
+	case benchFlags.Distribution == "zipfian" && benchFlags.ZipfS <= 1:
+		return fmt.Errorf("-zipf-s must be greater than 1, got %g", benchFlags.ZipfS)
+	case benchFlags.Keys < 1:
+		return fmt.Errorf("-keys must be at least 1, got %d", benchFlags.Keys)
+	case benchFlags.QPS < 0 || benchFlags.QPS > int(time.Second):
+		return fmt.Errorf("-qps must be between 0 and %d, got %d", int(time.Second), benchFlags.QPS)
+	case benchFlags.Concurrency < 1:
+		return fmt.Errorf("-concurrency must be at least 1, got %d", benchFlags.Concurrency)
+	case benchFlags.Duration <= 0:
+		return fmt.Errorf("-duration must be positive, got %s", benchFlags.Duration)
+	case benchFlags.ValueSize < 0:
+		return fmt.Errorf("-value-size must not be negative, got %d", benchFlags.ValueSize)
+	case benchFlags.ReadRatio < 0 || benchFlags.ReadRatio > 1:
+		return fmt.Errorf("-read-ratio must be between 0 and 1, got %g", benchFlags.ReadRatio)
+	}
+	return nil
+}
+
+func (benchFlags *benchFlags) worker(client *memcacheha.Client, results *benchResults, tokens chan struct{}, done chan struct{}, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	var zipf *rand.Zipf
+	if benchFlags.Distribution == "zipfian" {
+		zipf = rand.NewZipf(r, benchFlags.ZipfS, 1, uint64(benchFlags.Keys-1))
+	}
+	value := make([]byte, benchFlags.ValueSize)
+	r.Read(value)
+
+	for {
+		if tokens != nil {
+			select {
+			case <-tokens:
+			case <-done:
+				return
+			}
+		} else {
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+
+		var n uint64
+		if zipf != nil {
+			n = zipf.Uint64()
+		} else {
+			n = uint64(r.Intn(benchFlags.Keys))
+		}
+		key := benchFlags.KeyPrefix + strconv.FormatUint(n, 10)
+
+		start := time.Now()
+		if r.Float64() < benchFlags.ReadRatio {
+			_, err := client.Get(key)
+			results.Record("get", time.Since(start), err)
+		} else {
+			expiry := time.Now().Add(benchFlags.TTL)
+			err := client.Set(&memcacheha.Item{Key: key, Value: value, Expiration: &expiry})
+			results.Record("set", time.Since(start), err)
+		}
+	}
+}
+
+// benchResults collects latencies and errors per operation
+type benchResults struct {
+	mutex     sync.Mutex
+	latencies map[string][]time.Duration
+	misses    map[string]int
+	errors    map[string]int
+	dropped   int
+}
+
+func newBenchResults() *benchResults {
+	return &benchResults{
+		latencies: map[string][]time.Duration{},
+		misses:    map[string]int{},
+		errors:    map[string]int{},
+	}
+}
+
+func (benchResults *benchResults) Record(op string, latency time.Duration, err error) {
+	benchResults.mutex.Lock()
+	defer benchResults.mutex.Unlock()
+	benchResults.latencies[op] = append(benchResults.latencies[op], latency)
+	if err == memcache.ErrCacheMiss {
+		benchResults.misses[op]++
+	} else if err != nil {
+		benchResults.errors[op]++
+	}
+}
+
+func (benchResults *benchResults) Dropped() {
+	benchResults.mutex.Lock()
+	defer benchResults.mutex.Unlock()
+	benchResults.dropped++
+}
+
+func (benchResults *benchResults) Print(elapsed time.Duration) {
+	benchResults.mutex.Lock()
+	defer benchResults.mutex.Unlock()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "op\tcount\tqps\tmiss\terrors\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, op := range []string{"get", "set"} {
+		latencies := benchResults.latencies[op]
+		if len(latencies) == 0 {
+			continue
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n",
+			op, len(latencies), float64(len(latencies))/elapsed.Seconds(), benchResults.misses[op], benchResults.errors[op],
+			percentile(latencies, 0.5), percentile(latencies, 0.9), percentile(latencies, 0.99), percentile(latencies, 0.999),
+			latencies[len(latencies)-1])
+	}
+	w.Flush()
+	if benchResults.dropped > 0 {
+		fmt.Printf("%d operations were not issued because all workers were busy, increase -concurrency\n", benchResults.dropped)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+// nodeStats wraps node clients to count operations and errors per node
+type nodeStats struct {
+	mutex sync.Mutex
+	nodes map[string]*countingNodeClient
+}
+
+func newNodeStats() *nodeStats {
+	return &nodeStats{
+		nodes: map[string]*countingNodeClient{},
+	}
+}
+
+// NewNodeClient implements memcacheha.NodeClientFactory
+func (nodeStats *nodeStats) NewNodeClient(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+	nodeStats.mutex.Lock()
+	defer nodeStats.mutex.Unlock()
+	node := &countingNodeClient{NodeClient: memcacheha.NewMemcacheNodeClient(endpoint, timeout)}
+	nodeStats.nodes[endpoint] = node
+	return node
+}
+
+func (nodeStats *nodeStats) Print() {
+	nodeStats.mutex.Lock()
+	defer nodeStats.mutex.Unlock()
+
+	var endpoints []string
+	for endpoint := range nodeStats.nodes {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "node\tops\terrors\terror rate\t")
+	for _, endpoint := range endpoints {
+		node := nodeStats.nodes[endpoint]
+		ops := atomic.LoadUint64(&node.ops)
+		errs := atomic.LoadUint64(&node.errors)
+		rate := 0.0
+		if ops > 0 {
+			rate = float64(errs) / float64(ops) * 100
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f%%\t\n", endpoint, ops, errs, rate)
+	}
+	w.Flush()
+}
+
+// errUnsupported is returned by the optional methods of a countingNodeClient whose NodeClient lacks them
+var errUnsupported = errors.New("not supported by the node client")
+
+// countingNodeClient counts operations and errors of a NodeClient. Cache misses and failed conditions are not errors.
+// It forwards NodePinger, NodeStatter and NodeMultiGetter to the NodeClient, so healthchecks and batch reads behave as
+// they do without it.
+type countingNodeClient struct {
+	memcacheha.NodeClient
+	ops    uint64
+	errors uint64
+}
+
+func (node *countingNodeClient) count(err error) {
+	atomic.AddUint64(&node.ops, 1)
+	if err != nil && err != memcache.ErrCacheMiss && err != memcache.ErrNotStored && err != memcache.ErrCASConflict {
+		atomic.AddUint64(&node.errors, 1)
+	}
+}
+
+func (node *countingNodeClient) Get(key string) (*memcache.Item, error) {
+	item, err := node.NodeClient.Get(key)
+	node.count(err)
+	return item, err
+}
+
+func (node *countingNodeClient) Set(item *memcache.Item) error {
+	err := node.NodeClient.Set(item)
+	node.count(err)
+	return err
+}
+
+func (node *countingNodeClient) Add(item *memcache.Item) error {
+	err := node.NodeClient.Add(item)
+	node.count(err)
+	return err
+}
+
+func (node *countingNodeClient) Delete(key string) error {
+	err := node.NodeClient.Delete(key)
+	node.count(err)
+	return err
+}
+
+func (node *countingNodeClient) Touch(key string, seconds int32) error {
+	err := node.NodeClient.Touch(key, seconds)
+	node.count(err)
+	return err
+}
+
+func (node *countingNodeClient) CompareAndSwap(item *memcache.Item) error {
+	err := node.NodeClient.CompareAndSwap(item)
+	node.count(err)
+	return err
+}
+
+// Ping implements memcacheha.NodePinger
+func (node *countingNodeClient) Ping() error {
+	pinger, ok := node.NodeClient.(memcacheha.NodePinger)
+	if !ok {
+		return errUnsupported
+	}
+	return pinger.Ping()
+}
+
+// Stats implements memcacheha.NodeStatter
+func (node *countingNodeClient) Stats() (map[string]string, error) {
+	statter, ok := node.NodeClient.(memcacheha.NodeStatter)
+	if !ok {
+		return nil, memcacheha.ErrStatsUnsupported
+	}
+	return statter.Stats()
+}
+
+// GetMulti implements memcacheha.NodeMultiGetter
+func (node *countingNodeClient) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	getter, ok := node.NodeClient.(memcacheha.NodeMultiGetter)
+	if !ok {
+		return nil, errUnsupported
+	}
+	items, err := getter.GetMulti(keys)
+	node.count(err)
+	return items, err
+}
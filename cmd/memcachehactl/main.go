@@ -0,0 +1,109 @@
+// Command memcachehactl is a command line tool for memcacheha clusters.
+//
+// Usage:
+//
+//	memcachehactl <command> [flags]
+//
+// Commands:
+//
+//	bench    drive load against a cluster and report latency percentiles and per-node error rates
+//	migrate  copy all keys from a set of memcache servers into a cluster
+package main
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a memcachehactl subcommand
+type command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = []*command{
+	{Name: "bench", Usage: "drive load against a cluster and report latency percentiles and per-node error rates", Run: runBench},
+	{Name: "migrate", Usage: "copy all keys from a set of memcache servers into a cluster", Run: runMigrate},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.Name == os.Args[1] {
+			if err := cmd.Run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "memcachehactl %s: %s\n", cmd.Name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: memcachehactl <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.Name, cmd.Usage)
+	}
+}
+
+// clusterFlags are the flags common to all commands that connect to a cluster
+type clusterFlags struct {
+	Nodes       string
+	AWSRegion   string
+	ClusterId   string
+	Timeout     time.Duration
+	LogLevel    string
+	WaitTimeout time.Duration
+}
+
+func (clusterFlags *clusterFlags) Register(flags *flag.FlagSet) {
+	flags.StringVar(&clusterFlags.Nodes, "nodes", "", "comma separated list of memcache endpoints (host:port)")
+	flags.StringVar(&clusterFlags.AWSRegion, "aws-region", "", "AWS region of the ElastiCache cluster")
+	flags.StringVar(&clusterFlags.ClusterId, "elasticache-cluster", "", "ElastiCache cluster ID to discover nodes from")
+	flags.DurationVar(&clusterFlags.Timeout, "timeout", 100*time.Millisecond, "node operation timeout")
+	flags.StringVar(&clusterFlags.LogLevel, "log-level", "warn", "log level")
+	flags.DurationVar(&clusterFlags.WaitTimeout, "wait", 5*time.Second, "time to wait for healthy nodes")
+}
+
+// NewClient returns a started client for the configured cluster, with healthy nodes
+func (clusterFlags *clusterFlags) NewClient(factory memcacheha.NodeClientFactory) (*memcacheha.Client, error) {
+	log := logger.NewConsoleLogger(clusterFlags.LogLevel)
+
+	var sources []memcacheha.NodeSource
+	if clusterFlags.Nodes != "" {
+		sources = append(sources, memcacheha.NewStaticNodeSource(strings.Split(clusterFlags.Nodes, ",")...))
+	}
+	if clusterFlags.ClusterId != "" {
+		sources = append(sources, memcacheha.NewElastiCacheNodeSource(log, clusterFlags.AWSRegion, clusterFlags.ClusterId))
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("one of -nodes or -elasticache-cluster is required")
+	}
+
+	client := memcacheha.New(log, sources...)
+	client.Timeout = clusterFlags.Timeout
+	if factory != nil {
+		client.NewNodeClient = factory
+	}
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+	if err := client.WaitForNodes(time.Now().Add(clusterFlags.WaitTimeout)); err != nil {
+		client.Stop()
+		return nil, err
+	}
+	return client, nil
+}
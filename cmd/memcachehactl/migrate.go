@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/apitalent/memcacheha"
+
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func runMigrate(args []string) error {
+	clusterFlags := &clusterFlags{}
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	clusterFlags.Register(flags)
+	from := flags.String("from", "", "comma separated list of source memcache endpoints (host:port)")
+	concurrency := flags.Int("concurrency", 32, "number of concurrent reads and writes")
+	flags.Parse(args)
+
+	if *from == "" {
+		return errors.New("-from is required")
+	}
+
+	client, err := clusterFlags.NewClient(nil)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	endpoints := strings.Split(*from, ",")
+	fmt.Printf("Migrating %d source nodes to %d nodes\n", len(endpoints), client.Nodes.GetHealthyNodeCount())
+	stats, err := memcacheha.Migrate(ctx, endpoints, client, clusterFlags.Timeout, *concurrency, printWarmStats)
+	if stats != nil {
+		fmt.Printf("Done: ")
+		printWarmStats(*stats)
+	}
+	return err
+}
+
+func printWarmStats(stats memcacheha.WarmStats) {
+	fmt.Printf("%d items copied, %d failed in %s", stats.Items, stats.Errors, stats.Elapsed.Truncate(1e6))
+	for endpoint, count := range stats.NodeErrors {
+		fmt.Printf(", %s: %d errors", endpoint, count)
+	}
+	fmt.Println()
+}
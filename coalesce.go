@@ -0,0 +1,88 @@
+package memcacheha
+
+import (
+	"sync"
+)
+
+// getCallKey identifies Gets that can share a result: the same key read with the same options
+type getCallKey struct {
+	key     string
+	options ReadOptions
+}
+
+// getCall is a Get in progress, whose result is shared by concurrent callers of the same key
+type getCall struct {
+	done    chan (struct{})
+	waiters int
+	items   []*Item
+	err     error
+}
+
+// getGroup tracks Gets in progress, see CoalesceGets
+type getGroup struct {
+	mutex sync.Mutex
+	calls map[getCallKey]*getCall
+}
+
+func newGetGroup() *getGroup {
+	return &getGroup{
+		calls: map[getCallKey]*getCall{},
+	}
+}
+
+// getCoalesced performs get, unless a Get of the same key with the same options is in progress, in which case its
+// result is returned. Callers sharing a result each receive their own copy of the item, made before the result is
+// returned to any of them.
+func (client *Client) getCoalesced(opID string, originalKey string, key string, options *ReadOptions) (*Item, error) {
+	// Callers share a result whatever their contexts
+	callKey := getCallKey{key: originalKey, options: *options}
+	callKey.options.ctx = nil
+
+	client.gets.mutex.Lock()
+	if call, found := client.gets.calls[callKey]; found {
+		waiter := call.waiters
+		call.waiters++
+		client.gets.mutex.Unlock()
+		<-call.done
+		if call.items == nil {
+			return nil, call.err
+		}
+		return call.items[waiter], call.err
+	}
+	call := &getCall{done: make(chan (struct{}))}
+	client.gets.calls[callKey] = call
+	client.gets.mutex.Unlock()
+
+	item, err := client.get(opID, originalKey, key, options, nil)
+
+	// No more callers can wait on this call once it is removed
+	client.gets.mutex.Lock()
+	delete(client.gets.calls, callKey)
+	client.gets.mutex.Unlock()
+
+	call.err = err
+	if item != nil && call.waiters > 0 {
+		call.items = make([]*Item, call.waiters)
+		for i := range call.items {
+			call.items[i] = item.copy()
+		}
+	}
+	close(call.done)
+
+	return item, err
+}
+
+// copy returns a copy of this item that does not share its value or expiries
+func (item *Item) copy() *Item {
+	out := *item
+	out.Value = append([]byte(nil), item.Value...)
+	if item.Expiration != nil {
+		expiration := *item.Expiration
+		out.Expiration = &expiration
+	}
+	if item.SoftExpiration != nil {
+		softExpiration := *item.SoftExpiration
+		out.SoftExpiration = &softExpiration
+	}
+	return &out
+}
@@ -0,0 +1,636 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+
+	"gopkg.in/yaml.v3"
+
+	"crypto/tls"
+	"crypto/x509"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CONFIG_ENV_PREFIX is the prefix of environment variables overriding Config fields
+const CONFIG_ENV_PREFIX = "MEMCACHEHA_"
+
+var (
+	// ErrConfigNoSources is an error meaning a Config has no node sources
+	ErrConfigNoSources = errors.New("memcacheha: config has no nodes or elasticache cluster")
+
+	// ErrConfigFormat is an error meaning a config file does not have a .json, .yaml or .yml extension
+	ErrConfigFormat = errors.New("memcacheha: unknown config file format")
+)
+
+// Config is the declarative configuration of a Client, loadable from JSON or YAML with LoadConfig.
+// Each field can be overridden by the environment variable CONFIG_ENV_PREFIX followed by its env tag.
+type Config struct {
+	// Nodes is a static list of memcache endpoints (host:port)
+	Nodes []string `json:"nodes,omitempty" yaml:"nodes,omitempty" env:"NODES"`
+	// ElastiCache configures discovery of nodes from an AWS ElastiCache cluster
+	ElastiCache *ElastiCacheConfig `json:"elasticache,omitempty" yaml:"elasticache,omitempty" env:"ELASTICACHE_"`
+	// SourceMerge combines the nodes of multiple sources: union, priority or intersection
+	SourceMerge SourceMergePolicy `json:"source_merge,omitempty" yaml:"source_merge,omitempty" env:"SOURCE_MERGE"`
+
+	// Timeout is the node operation timeout
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" env:"TIMEOUT"`
+	// GetNodesPeriod is the period between checking all sources for new or deprecated nodes
+	GetNodesPeriod Duration `json:"get_nodes_period,omitempty" yaml:"get_nodes_period,omitempty" env:"GET_NODES_PERIOD"`
+	// HealthCheckPeriod is the period between healthchecks on nodes
+	HealthCheckPeriod Duration `json:"healthcheck_period,omitempty" yaml:"healthcheck_period,omitempty" env:"HEALTHCHECK_PERIOD"`
+	// HealthCheckProbe is the request sent by healthchecks: get, or version for read-only credentials
+	HealthCheckProbe HealthCheckProbe `json:"healthcheck_probe,omitempty" yaml:"healthcheck_probe,omitempty" env:"HEALTHCHECK_PROBE"`
+	// HealthCheckKeyPrefix prefixes the keys read by get healthchecks
+	HealthCheckKeyPrefix string `json:"healthcheck_key_prefix,omitempty" yaml:"healthcheck_key_prefix,omitempty" env:"HEALTHCHECK_KEY_PREFIX"`
+	// ResolvePeriod is the period between re-resolving node hostnames, negative to disable
+	ResolvePeriod Duration `json:"resolve_period,omitempty" yaml:"resolve_period,omitempty" env:"RESOLVE_PERIOD"`
+	// PrewarmConnections opens the idle connections of new nodes before their first request
+	PrewarmConnections bool `json:"prewarm_connections,omitempty" yaml:"prewarm_connections,omitempty" env:"PREWARM_CONNECTIONS"`
+	// IdleCheckPeriod is the period between validating the idle connections of healthy nodes, negative to disable
+	IdleCheckPeriod Duration `json:"idle_check_period,omitempty" yaml:"idle_check_period,omitempty" env:"IDLE_CHECK_PERIOD"`
+	// KeepAlive is the period of TCP keep-alive probes on node connections, negative to disable
+	KeepAlive Duration `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty" env:"KEEP_ALIVE"`
+	// MaxIdleConns is the number of idle connections kept per node, zero for the default of 2
+	MaxIdleConns int `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty" env:"MAX_IDLE_CONNS"`
+	// MaintenanceJitter is the fraction by which the periods above are randomly varied, e.g. 0.1
+	MaintenanceJitter float64 `json:"maintenance_jitter,omitempty" yaml:"maintenance_jitter,omitempty" env:"MAINTENANCE_JITTER"`
+
+	// ReadAll reads from all healthy nodes by default
+	ReadAll bool `json:"read_all,omitempty" yaml:"read_all,omitempty" env:"READ_ALL"`
+	// ReadCount is the default number of nodes to read from, zero for Ceil(n/2)
+	ReadCount int `json:"read_count,omitempty" yaml:"read_count,omitempty" env:"READ_COUNT"`
+	// ReadSelection chooses the nodes read from when reading fewer than all nodes: key, random or latency
+	ReadSelection ReadSelection `json:"read_selection,omitempty" yaml:"read_selection,omitempty" env:"READ_SELECTION"`
+	// RepairQuorum is the default number of nodes that must agree on a value before nodes are synchronised
+	RepairQuorum int `json:"repair_quorum,omitempty" yaml:"repair_quorum,omitempty" env:"REPAIR_QUORUM"`
+	// WriteQuorum is the default number of nodes that must acknowledge a write, -1 (WRITE_QUORUM_ALL) for all
+	WriteQuorum int `json:"write_quorum,omitempty" yaml:"write_quorum,omitempty" env:"WRITE_QUORUM"`
+	// NoRepair disables synchronisation of nodes with missing data by default
+	NoRepair bool `json:"no_repair,omitempty" yaml:"no_repair,omitempty" env:"NO_REPAIR"`
+	// AddConflict is the default Add conflict policy: propagate, abort or overwrite
+	AddConflict AddConflictPolicy `json:"add_conflict,omitempty" yaml:"add_conflict,omitempty" env:"ADD_CONFLICT"`
+	// TouchRepair synchronises nodes missing the key of a Touch by default
+	TouchRepair bool `json:"touch_repair,omitempty" yaml:"touch_repair,omitempty" env:"TOUCH_REPAIR"`
+	// DefaultTTL is the expiry of items written without one, zero for none
+	DefaultTTL Duration `json:"default_ttl,omitempty" yaml:"default_ttl,omitempty" env:"DEFAULT_TTL"`
+	// MaxTTL limits the expiry of items written, zero for no limit
+	MaxTTL Duration `json:"max_ttl,omitempty" yaml:"max_ttl,omitempty" env:"MAX_TTL"`
+	// KeyPolicies replace the default read and write options for keys matching a pattern or regexp
+	KeyPolicies []KeyPolicyConfig `json:"key_policies,omitempty" yaml:"key_policies,omitempty"`
+
+	// MaxValueSize is the maximum size in bytes of a stored value, negative for no limit
+	MaxValueSize int `json:"max_value_size,omitempty" yaml:"max_value_size,omitempty" env:"MAX_VALUE_SIZE"`
+	// HashLongKeys hashes keys longer than MAX_KEY_LENGTH
+	HashLongKeys bool `json:"hash_long_keys,omitempty" yaml:"hash_long_keys,omitempty" env:"HASH_LONG_KEYS"`
+	// PinnedKeys are patterns of keys stored on a single node
+	PinnedKeys []string `json:"pinned_keys,omitempty" yaml:"pinned_keys,omitempty" env:"PINNED_KEYS"`
+	// SizePrefixes are key prefixes whose value sizes are accounted separately in metrics
+	SizePrefixes []string `json:"size_prefixes,omitempty" yaml:"size_prefixes,omitempty" env:"SIZE_PREFIXES"`
+	// TenantQuotas limit the operations of each tenant, see Client.TenantQuotas
+	TenantQuotas map[string]TenantQuota `json:"tenant_quotas,omitempty" yaml:"tenant_quotas,omitempty"`
+	// DefaultTenantQuota limits the operations of tenants without a quota in TenantQuotas
+	DefaultTenantQuota *TenantQuota `json:"default_tenant_quota,omitempty" yaml:"default_tenant_quota,omitempty" env:"DEFAULT_TENANT_QUOTA_"`
+	// ErrorPatterns map substrings of node error messages to their category: default, unhealthy, retryable, fatal or
+	// miss, see Client.ErrorPatterns
+	ErrorPatterns map[string]ErrorCategory `json:"error_patterns,omitempty" yaml:"error_patterns,omitempty"`
+	// TTLSampleRate is the fraction of writes whose TTL is sampled, see Client.TTLStats
+	TTLSampleRate float64 `json:"ttl_sample_rate,omitempty" yaml:"ttl_sample_rate,omitempty" env:"TTL_SAMPLE_RATE"`
+	// TrackHotKeys enables hot key tracking
+	TrackHotKeys bool `json:"track_hot_keys,omitempty" yaml:"track_hot_keys,omitempty" env:"TRACK_HOT_KEYS"`
+	// ReadYourWrites is the period after writing a key during which reads of it read all nodes, zero to disable
+	ReadYourWrites Duration `json:"read_your_writes,omitempty" yaml:"read_your_writes,omitempty" env:"READ_YOUR_WRITES"`
+	// DegradedLatency is the p99 node latency above which a node is not read from, zero to disable
+	DegradedLatency Duration `json:"degraded_latency,omitempty" yaml:"degraded_latency,omitempty" env:"DEGRADED_LATENCY"`
+	// SLOHealthCheckLatency, SLONodeLatency and SLOClusterLatency are latency objectives reported to
+	// Client.SLOViolationHook, zero to disable
+	SLOHealthCheckLatency Duration `json:"slo_healthcheck_latency,omitempty" yaml:"slo_healthcheck_latency,omitempty" env:"SLO_HEALTHCHECK_LATENCY"`
+	SLONodeLatency        Duration `json:"slo_node_latency,omitempty" yaml:"slo_node_latency,omitempty" env:"SLO_NODE_LATENCY"`
+	SLOClusterLatency     Duration `json:"slo_cluster_latency,omitempty" yaml:"slo_cluster_latency,omitempty" env:"SLO_CLUSTER_LATENCY"`
+	// NodeRampPeriod is the period over which new nodes ramp up to their full share of reads, zero to disable
+	NodeRampPeriod Duration `json:"node_ramp_period,omitempty" yaml:"node_ramp_period,omitempty" env:"NODE_RAMP_PERIOD"`
+	// MaxRepairsPerSecond limits the rate of writes synchronising nodes, zero for no limit
+	MaxRepairsPerSecond float64 `json:"max_repairs_per_second,omitempty" yaml:"max_repairs_per_second,omitempty" env:"MAX_REPAIRS_PER_SECOND"`
+	// MaxConcurrentRepairs limits the number of writes synchronising nodes in progress, zero for no limit
+	MaxConcurrentRepairs int `json:"max_concurrent_repairs,omitempty" yaml:"max_concurrent_repairs,omitempty" env:"MAX_CONCURRENT_REPAIRS"`
+	// DeleteRetryTTL is the period during which deletes missed by a node are retried, negative to disable
+	DeleteRetryTTL Duration `json:"delete_retry_ttl,omitempty" yaml:"delete_retry_ttl,omitempty" env:"DELETE_RETRY_TTL"`
+	// DeleteJournal is the path of a file journaling the deletes queued for retry, empty to disable
+	DeleteJournal string `json:"delete_journal,omitempty" yaml:"delete_journal,omitempty" env:"DELETE_JOURNAL"`
+	// SuppressRepairsOnPartition disables synchronisation of nodes while a majority of nodes are unreachable
+	SuppressRepairsOnPartition bool `json:"suppress_repairs_on_partition,omitempty" yaml:"suppress_repairs_on_partition,omitempty" env:"SUPPRESS_REPAIRS_ON_PARTITION"`
+	// SingleNodeErrorRate is the fraction of failing node requests above which all requests go to one node, zero to disable
+	SingleNodeErrorRate float64 `json:"single_node_error_rate,omitempty" yaml:"single_node_error_rate,omitempty" env:"SINGLE_NODE_ERROR_RATE"`
+	// MaxConcurrentRequests limits the number of in-flight node requests, zero for no limit
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty" yaml:"max_concurrent_requests,omitempty" env:"MAX_CONCURRENT_REQUESTS"`
+	// CoalesceGets shares the result of concurrent Gets of the same key
+	CoalesceGets bool `json:"coalesce_gets,omitempty" yaml:"coalesce_gets,omitempty" env:"COALESCE_GETS"`
+	// FillLeases makes GetOrFill take a lease before filling a missing key
+	FillLeases bool `json:"fill_leases,omitempty" yaml:"fill_leases,omitempty" env:"FILL_LEASES"`
+	// ClientID identifies the client in statistics, e.g. the name of the service
+	ClientID string `json:"client_id,omitempty" yaml:"client_id,omitempty" env:"CLIENT_ID"`
+	// LogLevel is the minimum level of log messages: debug, info, warn, error or none
+	LogLevel LogLevel `json:"log_level,omitempty" yaml:"log_level,omitempty" env:"LOG_LEVEL"`
+	// KeyLogMode decides how keys appear in logs: plain, hash or redact
+	KeyLogMode KeyLogMode `json:"key_log_mode,omitempty" yaml:"key_log_mode,omitempty" env:"KEY_LOG_MODE"`
+	// AdminToken is the bearer token of the admin API, which is disabled if empty
+	AdminToken string `json:"admin_token,omitempty" yaml:"admin_token,omitempty" env:"ADMIN_TOKEN"`
+
+	// TLS enables TLS connections to nodes
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" env:"TLS_"`
+	// Encryption encrypts item values, see Client.Encryption. The Encryption of a client is kept if not set.
+	Encryption *EncryptionConfig `json:"encryption,omitempty" yaml:"encryption,omitempty" env:"ENCRYPTION_"`
+	// Integrity signs item values, see Client.Integrity. The Integrity of a client is kept if not set.
+	Integrity *IntegrityConfig `json:"integrity,omitempty" yaml:"integrity,omitempty" env:"INTEGRITY_"`
+
+	// Shards configures a Client for each named shard of a ShardedClient, see NewShardedFromConfig. Environment
+	// variables of a shard are prefixed with SHARD_ and the upper case shard name, e.g. MEMCACHEHA_SHARD_A_NODES.
+	Shards map[string]*Config `json:"shards,omitempty" yaml:"shards,omitempty"`
+
+	// Pools configures a Client for each named pool of a Router, see NewRouterFromConfig. Environment variables of a
+	// pool are prefixed with POOL_ and the upper case pool name, e.g. MEMCACHEHA_POOL_SESSIONS_NODES.
+	Pools map[string]*Config `json:"pools,omitempty" yaml:"pools,omitempty"`
+	// Routes direct keys to Pools, the first route matching a key is used
+	Routes []RouteConfig `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// ElastiCacheConfig configures an ElastiCacheNodeSource
+type ElastiCacheConfig struct {
+	Region         string `json:"region" yaml:"region" env:"REGION"`
+	CacheClusterId string `json:"cache_cluster_id" yaml:"cache_cluster_id" env:"CACHE_CLUSTER_ID"`
+}
+
+// TLSConfig configures TLS connections to nodes
+type TLSConfig struct {
+	// CAFile is a PEM file of CAs to verify nodes with, the system roots are used if empty
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty" env:"CA_FILE"`
+	// CertFile and KeyFile are a PEM client certificate and key, if required by the nodes
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty" env:"CERT_FILE"`
+	KeyFile  string `json:"key_file,omitempty" yaml:"key_file,omitempty" env:"KEY_FILE"`
+	// ServerName overrides the name used to verify node certificates
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty" env:"SERVER_NAME"`
+	// InsecureSkipVerify disables verification of node certificates
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty" env:"INSECURE_SKIP_VERIFY"`
+}
+
+// EncryptionConfig configures the encryption of item values, see Encryption
+type EncryptionConfig struct {
+	// Keys are the AES keys, each as its ID, a colon and the base64 encoded key, e.g. "2:q1w2e3..."
+	Keys []string `json:"keys" yaml:"keys" env:"KEYS"`
+	// CurrentKey is the ID of the key new values are encrypted with. Zero means the first of Keys.
+	CurrentKey int `json:"current_key,omitempty" yaml:"current_key,omitempty" env:"CURRENT_KEY"`
+	// AllowPlaintext reads values that are not encrypted, e.g. while enabling encryption on a populated cluster
+	AllowPlaintext bool `json:"allow_plaintext,omitempty" yaml:"allow_plaintext,omitempty" env:"ALLOW_PLAINTEXT"`
+}
+
+// IntegrityConfig configures the signing of item values, see Integrity
+type IntegrityConfig struct {
+	// Keys are the HMAC keys, each as its ID, a colon and the base64 encoded key, e.g. "2:q1w2e3..."
+	Keys []string `json:"keys" yaml:"keys" env:"KEYS"`
+	// CurrentKey is the ID of the key new values are signed with. Zero means the first of Keys.
+	CurrentKey int `json:"current_key,omitempty" yaml:"current_key,omitempty" env:"CURRENT_KEY"`
+	// AllowUnsigned reads values that are not signed, e.g. while enabling signing on a populated cluster
+	AllowUnsigned bool `json:"allow_unsigned,omitempty" yaml:"allow_unsigned,omitempty" env:"ALLOW_UNSIGNED"`
+}
+
+// Duration is a time.Duration which is read from and written to config as a string, e.g. "100ms"
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (duration *Duration) UnmarshalText(text []byte) error {
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*duration = Duration(d)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (duration Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(duration).String()), nil
+}
+
+// DefaultConfig returns a Config with the default timeout and periods
+func DefaultConfig() *Config {
+	return &Config{
+		Timeout:           Duration(100 * time.Millisecond),
+		GetNodesPeriod:    Duration(GET_NODES_PERIOD),
+		HealthCheckPeriod: Duration(HEALTHCHECK_PERIOD),
+	}
+}
+
+// LoadConfig reads a Config from the JSON (.json) or YAML (.yaml, .yml) file at path over the defaults, then
+// applies environment variable overrides. If path is empty, only the defaults and environment are used.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		if err := decodeConfigFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(CONFIG_ENV_PREFIX); err != nil {
+		return nil, err
+	}
+	if err := applyNamedEnv(cfg.Shards, CONFIG_ENV_PREFIX+"SHARD_"); err != nil {
+		return nil, err
+	}
+	if err := applyNamedEnv(cfg.Pools, CONFIG_ENV_PREFIX+"POOL_"); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyNamedEnv applies environment variable overrides to each of the named configs, prefixed with prefix and the
+// upper case name. Missing configs are replaced with the defaults.
+func applyNamedEnv(configs map[string]*Config, prefix string) error {
+	for name, cfg := range configs {
+		if cfg == nil {
+			cfg = DefaultConfig()
+			configs[name] = cfg
+		}
+		if err := cfg.ApplyEnv(prefix + strings.ToUpper(name) + "_"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeConfigFile unmarshals the JSON or YAML file at path into v, by file extension
+func decodeConfigFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	default:
+		err = ErrConfigFormat
+	}
+	if err != nil {
+		return fmt.Errorf("memcacheha: %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides fields of this Config from environment variables named prefix followed by the field's env tag.
+// Lists are comma separated.
+func (cfg *Config) ApplyEnv(prefix string) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), prefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		field := v.Field(i)
+		name := prefix + tag
+
+		// Nested config, allocated only if one of its variables is set
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			nested := reflect.New(field.Type().Elem())
+			if !field.IsNil() {
+				nested.Elem().Set(field.Elem())
+			}
+			if err := applyEnv(nested.Elem(), name); err != nil {
+				return err
+			}
+			if !field.IsNil() || !reflect.DeepEqual(nested.Elem().Interface(), reflect.Zero(field.Type().Elem()).Interface()) {
+				field.Set(nested)
+			}
+			continue
+		}
+
+		value, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("memcacheha: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Sources returns the NodeSources configured by this Config. Discovery comes before the static nodes, so that with
+// SOURCE_MERGE_PRIORITY the static nodes are a fallback.
+func (cfg *Config) Sources(log logger.Logger) []NodeSource {
+	var sources []NodeSource
+	if cfg.ElastiCache != nil && cfg.ElastiCache.CacheClusterId != "" {
+		sources = append(sources, NewElastiCacheNodeSource(log, cfg.ElastiCache.Region, cfg.ElastiCache.CacheClusterId))
+	}
+	if len(cfg.Nodes) > 0 {
+		sources = append(sources, NewStaticNodeSource(cfg.Nodes...))
+	}
+	return sources
+}
+
+// Apply sets the options of the given client from this Config, excluding sources. Existing nodes are not updated,
+// see Client.UpdateConfig.
+func (cfg *Config) Apply(client *Client) error {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		tlsConfig, err = cfg.TLS.TLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	var encryption *Encryption
+	if cfg.Encryption != nil {
+		var err error
+		encryption, err = cfg.Encryption.Encryption()
+		if err != nil {
+			return err
+		}
+	}
+
+	var integrity *Integrity
+	if cfg.Integrity != nil {
+		var err error
+		integrity, err = cfg.Integrity.Integrity()
+		if err != nil {
+			return err
+		}
+	}
+
+	var keyPolicies []KeyPolicy
+	for i := range cfg.KeyPolicies {
+		policy, err := cfg.KeyPolicies[i].KeyPolicy()
+		if err != nil {
+			return err
+		}
+		keyPolicies = append(keyPolicies, policy)
+	}
+
+	// Wake requests waiting on the previous MaxConcurrentRequests, once the config is unlocked
+	defer client.limiter.wake()
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	if cfg.Timeout > 0 {
+		client.Timeout = time.Duration(cfg.Timeout)
+	}
+	if cfg.GetNodesPeriod > 0 {
+		client.GetNodesPeriod = time.Duration(cfg.GetNodesPeriod)
+	}
+	client.HealthCheckProbe = cfg.HealthCheckProbe
+	if cfg.HealthCheckKeyPrefix != "" {
+		client.HealthCheckKeyPrefix = cfg.HealthCheckKeyPrefix
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		client.HealthCheckPeriod = time.Duration(cfg.HealthCheckPeriod)
+	}
+	if cfg.ResolvePeriod != 0 {
+		client.ResolvePeriod = time.Duration(cfg.ResolvePeriod)
+	}
+	client.MaintenanceJitter = cfg.MaintenanceJitter
+	client.PrewarmConnections = cfg.PrewarmConnections
+	if cfg.IdleCheckPeriod != 0 {
+		client.IdleCheckPeriod = time.Duration(cfg.IdleCheckPeriod)
+	}
+	if cfg.DeleteRetryTTL != 0 {
+		client.DeleteRetryTTL = time.Duration(cfg.DeleteRetryTTL)
+	}
+	if cfg.MaxValueSize != 0 {
+		client.MaxValueSize = cfg.MaxValueSize
+	}
+	client.DefaultReadOptions = ReadOptions{
+		ReadAll:      cfg.ReadAll,
+		ReadCount:    cfg.ReadCount,
+		NoRepair:     cfg.NoRepair,
+		RepairQuorum: cfg.RepairQuorum,
+		Selection:    cfg.ReadSelection,
+	}
+	client.DefaultWriteOptions = WriteOptions{
+		Quorum:      cfg.WriteQuorum,
+		NoRepair:    cfg.NoRepair,
+		AddConflict: cfg.AddConflict,
+		TouchRepair: cfg.TouchRepair,
+		DefaultTTL:  time.Duration(cfg.DefaultTTL),
+		MaxTTL:      time.Duration(cfg.MaxTTL),
+	}
+	client.KeyPolicies = keyPolicies
+	client.HashLongKeys = cfg.HashLongKeys
+	client.SourceMerge = cfg.SourceMerge
+	client.PinnedKeys = cfg.PinnedKeys
+	client.TrackHotKeys = cfg.TrackHotKeys
+	client.TTLSampleRate = cfg.TTLSampleRate
+	client.SizePrefixes = cfg.SizePrefixes
+	client.TenantQuotas = cfg.TenantQuotas
+	client.DefaultTenantQuota = TenantQuota{}
+	if cfg.DefaultTenantQuota != nil {
+		client.DefaultTenantQuota = *cfg.DefaultTenantQuota
+	}
+	client.ErrorPatterns = cfg.ErrorPatterns
+	client.CoalesceGets = cfg.CoalesceGets
+	client.FillLeases = cfg.FillLeases
+	client.DeleteJournal = cfg.DeleteJournal
+	client.MaxConcurrentRequests = cfg.MaxConcurrentRequests
+	client.ReadYourWrites = time.Duration(cfg.ReadYourWrites)
+	client.DegradedLatency = time.Duration(cfg.DegradedLatency)
+	client.SLOHealthCheckLatency = time.Duration(cfg.SLOHealthCheckLatency)
+	client.SLONodeLatency = time.Duration(cfg.SLONodeLatency)
+	client.SLOClusterLatency = time.Duration(cfg.SLOClusterLatency)
+	client.NodeRampPeriod = time.Duration(cfg.NodeRampPeriod)
+	client.SuppressRepairsOnPartition = cfg.SuppressRepairsOnPartition
+	client.SingleNodeErrorRate = cfg.SingleNodeErrorRate
+	client.MaxRepairsPerSecond = cfg.MaxRepairsPerSecond
+	client.MaxConcurrentRepairs = cfg.MaxConcurrentRepairs
+	client.LogLevel = cfg.LogLevel
+	client.KeyLogMode = cfg.KeyLogMode
+	client.ClientID = cfg.ClientID
+	client.AdminToken = cfg.AdminToken
+	if encryption != nil {
+		client.Encryption = encryption
+	}
+	if integrity != nil {
+		client.Integrity = integrity
+	}
+
+	if tlsConfig != nil || cfg.KeepAlive != 0 || cfg.MaxIdleConns != 0 {
+		client.NewNodeClient = NewNodeClientFactory(NodeClientOptions{
+			TLS:          tlsConfig,
+			KeepAlive:    time.Duration(cfg.KeepAlive),
+			MaxIdleConns: cfg.MaxIdleConns,
+		})
+		if tlsConfig != nil {
+			client.NewTLSNodeClient = client.NewNodeClient
+		}
+	}
+	client.wakeRunloop()
+	return nil
+}
+
+// TLSConfig returns the *tls.Config described by this TLSConfig
+func (cfg *TLSConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("memcacheha: no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Encryption returns the Encryption described by this EncryptionConfig
+func (cfg *EncryptionConfig) Encryption() (*Encryption, error) {
+	var encryption *Encryption
+	for _, entry := range cfg.Keys {
+		keyID, key, err := parseConfigKey("encryption", entry)
+		if err != nil {
+			return nil, err
+		}
+		if encryption == nil {
+			encryption, err = NewEncryption(keyID, key)
+		} else {
+			err = encryption.AddKey(keyID, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("memcacheha: encryption key %d: %s", keyID, err)
+		}
+	}
+	if encryption == nil {
+		return nil, errors.New("memcacheha: no encryption keys")
+	}
+	if cfg.CurrentKey != 0 {
+		if err := encryption.SetCurrentKey(uint32(cfg.CurrentKey)); err != nil {
+			return nil, err
+		}
+	}
+	encryption.SetAllowPlaintext(cfg.AllowPlaintext)
+	return encryption, nil
+}
+
+// Integrity returns the Integrity described by this IntegrityConfig
+func (cfg *IntegrityConfig) Integrity() (*Integrity, error) {
+	var integrity *Integrity
+	for _, entry := range cfg.Keys {
+		keyID, key, err := parseConfigKey("integrity", entry)
+		if err != nil {
+			return nil, err
+		}
+		if integrity == nil {
+			integrity, err = NewIntegrity(keyID, key)
+		} else {
+			err = integrity.AddKey(keyID, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("memcacheha: integrity key %d: %s", keyID, err)
+		}
+	}
+	if integrity == nil {
+		return nil, errors.New("memcacheha: no integrity keys")
+	}
+	if cfg.CurrentKey != 0 {
+		if err := integrity.SetCurrentKey(uint32(cfg.CurrentKey)); err != nil {
+			return nil, err
+		}
+	}
+	integrity.SetAllowUnsigned(cfg.AllowUnsigned)
+	return integrity, nil
+}
+
+// parseConfigKey parses a key of the given kind given in a Config as its ID, a colon and the base64 encoded key. The
+// key is never part of an error.
+func parseConfigKey(kind string, entry string) (uint32, []byte, error) {
+	fields := strings.SplitN(entry, ":", 2)
+	if len(fields) != 2 {
+		return 0, nil, fmt.Errorf("memcacheha: %s keys must be ID:base64", kind)
+	}
+	keyID, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("memcacheha: invalid %s key ID %q", kind, fields[0])
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("memcacheha: %s key %d is not base64", kind, keyID)
+	}
+	return uint32(keyID), key, nil
+}
+
+// NewFromConfig returns a new Client with the specified logger, configured by cfg
+func NewFromConfig(log logger.Logger, cfg *Config) (*Client, error) {
+	sources := cfg.Sources(log)
+	if len(sources) == 0 {
+		return nil, ErrConfigNoSources
+	}
+	client := New(log, sources...)
+	if err := cfg.Apply(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
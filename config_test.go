@@ -0,0 +1,247 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes data to a file with the given name in a temporary directory, returning its path
+func writeConfig(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	json := `{"nodes": ["node1:11211", "node2:11211"], "timeout": "250ms", "resolve_period": "-1s",
+		"delete_retry_ttl": "-1s", "max_value_size": -1, "add_conflict": "abort"}`
+	yaml := "nodes: [node1:11211, node2:11211]\ntimeout: 250ms\nresolve_period: -1s\ndelete_retry_ttl: -1s\n" +
+		"max_value_size: -1\nadd_conflict: abort\n"
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  string
+	}{
+		{"json", "config.json", json, ""},
+		{"yaml", "config.yaml", yaml, ""},
+		{"yml", "config.yml", yaml, ""},
+		{"upper case extension", "config.JSON", json, ""},
+		{"unknown extension", "config.toml", json, memcacheha.ErrConfigFormat.Error()},
+		{"invalid json duration", "config.json", `{"timeout": "soon"}`, `invalid duration "soon"`},
+		{"invalid yaml duration", "config.yaml", "timeout: 10 parsecs\n", `unknown unit " parsecs"`},
+		{"invalid json", "config.json", `{"nodes": `, "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := memcacheha.LoadConfig(writeConfig(t, test.file, test.data))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("LoadConfig returned %v, expected an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %s", err)
+			}
+			if !reflect.DeepEqual(cfg.Nodes, []string{"node1:11211", "node2:11211"}) {
+				t.Errorf("Nodes are %v", cfg.Nodes)
+			}
+			if time.Duration(cfg.Timeout) != 250*time.Millisecond {
+				t.Errorf("Timeout is %s, expected 250ms", time.Duration(cfg.Timeout))
+			}
+			if time.Duration(cfg.HealthCheckPeriod) != memcacheha.HEALTHCHECK_PERIOD {
+				t.Errorf("HealthCheckPeriod is %s, expected the default", time.Duration(cfg.HealthCheckPeriod))
+			}
+			if cfg.AddConflict != memcacheha.ADD_CONFLICT_ABORT {
+				t.Errorf("AddConflict is %v, expected abort", cfg.AddConflict)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	_, err := memcacheha.LoadConfig(writeConfig(t, "config.ini", "nodes=node1:11211"))
+	if !errors.Is(err, memcacheha.ErrConfigFormat) {
+		t.Fatalf("LoadConfig returned %v, expected ErrConfigFormat", err)
+	}
+	if _, err := memcacheha.LoadConfig(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadConfig of a missing file returned %v", err)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+nodes: [file:11211]
+timeout: 250ms
+read_all: true
+tls:
+  server_name: file
+shards:
+  a:
+    nodes: [shard-file:11211]
+pools:
+  sessions:
+`)
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, cfg *memcacheha.Config)
+		err   string
+	}{
+		{"file", nil, func(t *testing.T, cfg *memcacheha.Config) {
+			if len(cfg.Nodes) != 1 || cfg.Nodes[0] != "file:11211" || !cfg.ReadAll || cfg.TLS.ServerName != "file" {
+				t.Errorf("Config is %+v", cfg)
+			}
+		}, ""},
+		{"override", map[string]string{
+			"MEMCACHEHA_NODES":           "env1:11211, env2:11211,",
+			"MEMCACHEHA_TIMEOUT":         "1s",
+			"MEMCACHEHA_READ_ALL":        "false",
+			"MEMCACHEHA_TLS_SERVER_NAME": "env",
+		}, func(t *testing.T, cfg *memcacheha.Config) {
+			if !reflect.DeepEqual(cfg.Nodes, []string{"env1:11211", "env2:11211"}) {
+				t.Errorf("Nodes are %v", cfg.Nodes)
+			}
+			if time.Duration(cfg.Timeout) != time.Second || cfg.ReadAll || cfg.TLS.ServerName != "env" {
+				t.Errorf("Config is %+v", cfg)
+			}
+		}, ""},
+		{"disabled", map[string]string{
+			"MEMCACHEHA_RESOLVE_PERIOD":   "-1s",
+			"MEMCACHEHA_DELETE_RETRY_TTL": "-1s",
+			"MEMCACHEHA_MAX_VALUE_SIZE":   "-1",
+		}, func(t *testing.T, cfg *memcacheha.Config) {
+			if cfg.ResolvePeriod >= 0 || cfg.DeleteRetryTTL >= 0 || cfg.MaxValueSize >= 0 {
+				t.Errorf("Config is %+v", cfg)
+			}
+		}, ""},
+		{"nested", map[string]string{
+			"MEMCACHEHA_ENCRYPTION_KEYS":                     "1:a2V5",
+			"MEMCACHEHA_DEFAULT_TENANT_QUOTA_OPS_PER_SECOND": "10",
+			"MEMCACHEHA_ELASTICACHE_REGION":                  "",
+			"MEMCACHEHA_INTEGRITY_ALLOW_UNSIGNED":            "false",
+			"MEMCACHEHA_ENCRYPTION_ALLOW_PLAINTEXT":          "true",
+		}, func(t *testing.T, cfg *memcacheha.Config) {
+			if cfg.Encryption == nil || !reflect.DeepEqual(cfg.Encryption.Keys, []string{"1:a2V5"}) || !cfg.Encryption.AllowPlaintext {
+				t.Errorf("Encryption is %+v", cfg.Encryption)
+			}
+			if cfg.DefaultTenantQuota == nil || cfg.DefaultTenantQuota.OpsPerSecond != 10 {
+				t.Errorf("DefaultTenantQuota is %+v", cfg.DefaultTenantQuota)
+			}
+			// Nested configs whose variables are all zero stay unset
+			if cfg.ElastiCache != nil || cfg.Integrity != nil {
+				t.Errorf("ElastiCache is %+v and Integrity is %+v, expected nil", cfg.ElastiCache, cfg.Integrity)
+			}
+		}, ""},
+		{"named", map[string]string{
+			"MEMCACHEHA_SHARD_A_NODES":         "shard-env:11211",
+			"MEMCACHEHA_POOL_SESSIONS_NODES":   "pool-env:11211",
+			"MEMCACHEHA_POOL_SESSIONS_TIMEOUT": "2s",
+		}, func(t *testing.T, cfg *memcacheha.Config) {
+			if nodes := cfg.Shards["a"].Nodes; len(nodes) != 1 || nodes[0] != "shard-env:11211" {
+				t.Errorf("Shard a nodes are %v", nodes)
+			}
+			// A pool given without a config gets the defaults
+			pool := cfg.Pools["sessions"]
+			if pool == nil || len(pool.Nodes) != 1 || pool.Nodes[0] != "pool-env:11211" || time.Duration(pool.Timeout) != 2*time.Second {
+				t.Fatalf("Pool sessions is %+v", pool)
+			}
+			if time.Duration(pool.HealthCheckPeriod) != memcacheha.HEALTHCHECK_PERIOD {
+				t.Errorf("Pool sessions HealthCheckPeriod is %s, expected the default", time.Duration(pool.HealthCheckPeriod))
+			}
+		}, ""},
+		{"invalid duration", map[string]string{"MEMCACHEHA_TIMEOUT": "soon"}, nil, "MEMCACHEHA_TIMEOUT"},
+		{"invalid bool", map[string]string{"MEMCACHEHA_READ_ALL": "maybe"}, nil, "MEMCACHEHA_READ_ALL"},
+		{"invalid int", map[string]string{"MEMCACHEHA_MAX_VALUE_SIZE": "1MB"}, nil, "MEMCACHEHA_MAX_VALUE_SIZE"},
+		{"invalid shard", map[string]string{"MEMCACHEHA_SHARD_A_TIMEOUT": "soon"}, nil, "MEMCACHEHA_SHARD_A_TIMEOUT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := memcacheha.LoadConfig(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("LoadConfig returned %v, expected an error naming %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %s", err)
+			}
+			test.check(t, cfg)
+		})
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   memcacheha.Config
+		check func(t *testing.T, client *memcacheha.Client)
+	}{
+		{"unset", memcacheha.Config{}, func(t *testing.T, client *memcacheha.Client) {
+			if client.ResolvePeriod <= 0 || client.MaxValueSize != memcacheha.MAX_VALUE_SIZE {
+				t.Errorf("ResolvePeriod is %s and MaxValueSize %d, expected the defaults", client.ResolvePeriod, client.MaxValueSize)
+			}
+		}},
+		{"disabled", memcacheha.Config{
+			ResolvePeriod:  memcacheha.Duration(-1),
+			DeleteRetryTTL: memcacheha.Duration(-1),
+			MaxValueSize:   -1,
+		}, func(t *testing.T, client *memcacheha.Client) {
+			if client.ResolvePeriod >= 0 || client.DeleteRetryTTL >= 0 || client.MaxValueSize >= 0 {
+				t.Errorf("ResolvePeriod is %s, DeleteRetryTTL %s and MaxValueSize %d, expected negative", client.ResolvePeriod, client.DeleteRetryTTL, client.MaxValueSize)
+			}
+		}},
+		{"set", memcacheha.Config{
+			Timeout:        memcacheha.Duration(time.Second),
+			ResolvePeriod:  memcacheha.Duration(time.Minute),
+			DeleteRetryTTL: memcacheha.Duration(time.Hour),
+			MaxValueSize:   1024,
+			WriteQuorum:    2,
+			DefaultTTL:     memcacheha.Duration(time.Minute),
+		}, func(t *testing.T, client *memcacheha.Client) {
+			if client.Timeout != time.Second || client.ResolvePeriod != time.Minute || client.DeleteRetryTTL != time.Hour || client.MaxValueSize != 1024 {
+				t.Errorf("Client has Timeout %s, ResolvePeriod %s, DeleteRetryTTL %s and MaxValueSize %d", client.Timeout, client.ResolvePeriod, client.DeleteRetryTTL, client.MaxValueSize)
+			}
+			if client.DefaultWriteOptions.Quorum != 2 || client.DefaultWriteOptions.DefaultTTL != time.Minute {
+				t.Errorf("DefaultWriteOptions are %+v", client.DefaultWriteOptions)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := memcacheha.New(nil, memcacheha.NewStaticNodeSource("node1:11211"))
+			if err := test.cfg.Apply(client); err != nil {
+				t.Fatalf("Apply failed: %s", err)
+			}
+			test.check(t, client)
+		})
+	}
+}
+
+func TestDurationText(t *testing.T) {
+	var duration memcacheha.Duration
+	if err := duration.UnmarshalText([]byte("1m30s")); err != nil || time.Duration(duration) != 90*time.Second {
+		t.Fatalf("UnmarshalText returned %s, %v", time.Duration(duration), err)
+	}
+	if text, err := duration.MarshalText(); err != nil || string(text) != "1m30s" {
+		t.Fatalf("MarshalText returned %q, %v", text, err)
+	}
+	if err := duration.UnmarshalText([]byte("90")); err == nil {
+		t.Fatal("UnmarshalText of a duration without a unit succeeded")
+	}
+}
@@ -0,0 +1,224 @@
+package memcacheha
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DebugState is a snapshot of the live state of a Client, see DebugHandler
+type DebugState struct {
+	// Partition is the error of PartitionStatus, if any
+	Partition string `json:"partition,omitempty"`
+	// TTLs are the sampled TTLs of writes, if TTLSampleRate is set
+	TTLs *TTLStats `json:"ttls,omitempty"`
+	// Disabled is true if the client bypasses the cache, see Client.Disable
+	Disabled bool `json:"disabled,omitempty"`
+	// Encryption are the key IDs of the Encryption of item values, if set
+	Encryption *EncryptionStatus `json:"encryption,omitempty"`
+	// Integrity are the key IDs of the Integrity of item values, if set
+	Integrity *IntegrityStatus `json:"integrity,omitempty"`
+	// SingleNode is the node all requests are sent to in single-node mode, see Client.SingleNode
+	SingleNode string           `json:"single_node,omitempty"`
+	Nodes      []NodeStatus     `json:"nodes"`
+	Sources    []SourceStatus   `json:"sources"`
+	WorkerPool WorkerPoolStatus `json:"worker_pool"`
+	Repairs    RepairStats      `json:"repairs"`
+	Operations OperationStats   `json:"operations"`
+	// PendingDeletes is the number of deletes missed by nodes that will be retried
+	PendingDeletes int `json:"pending_deletes"`
+	// Workers are the maintenance workers that have panicked, see WorkerStatuses
+	Workers []WorkerStatus `json:"workers"`
+	Config  *Config        `json:"config"`
+}
+
+// NodeStatus is the health of a node
+type NodeStatus struct {
+	Endpoint         string    `json:"endpoint"`
+	Healthy          bool      `json:"healthy"`
+	LastHealthCheck  time.Time `json:"last_healthcheck"`
+	HealthCheckError string    `json:"healthcheck_error,omitempty"`
+	Degraded         bool      `json:"degraded"`
+	// Forced is "healthy" or "unhealthy" if the node's health is overridden, see Node.ForceHealth
+	Forced string `json:"forced,omitempty"`
+	// Drain is "draining" while a node drained with DrainNode has its hot keys copied, then "removable"
+	Drain      string        `json:"drain,omitempty"`
+	P99Latency time.Duration `json:"p99_latency"`
+	// History is the node's recent healthcheck results, oldest first
+	History []HealthCheckResult `json:"history"`
+}
+
+// SourceStatus is the result of the last discovery from a NodeSource
+type SourceStatus struct {
+	Source  string    `json:"source"`
+	LastRun time.Time `json:"last_run"`
+	Nodes   []string  `json:"nodes,omitempty"`
+	// Specs are the nodes found by a NodeSpecSource
+	Specs []NodeSpec `json:"specs,omitempty"`
+	// Watched is true if the nodes were pushed by a WatchableSource rather than polled
+	Watched bool   `json:"watched,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WorkerPoolStatus is the size and queue depth of the worker pool running node operations
+type WorkerPoolStatus struct {
+	Workers       int `json:"workers"`
+	Queued        int `json:"queued"`
+	QueueCapacity int `json:"queue_capacity"`
+	// InFlight is the number of node requests of this client in progress, see MaxConcurrentRequests
+	InFlight int `json:"in_flight"`
+}
+
+// DebugState returns a snapshot of the nodes, sources, worker pool and configuration of this client
+func (client *Client) DebugState() *DebugState {
+	state := &DebugState{
+		Nodes:  append([]NodeStatus{}, client.nodeStatuses()...),
+		Config: client.currentConfig(),
+	}
+
+	if err := client.PartitionStatus(); err != nil {
+		state.Partition = err.Error()
+	}
+	state.SingleNode = client.SingleNode()
+	state.Disabled = client.Disabled()
+	if encryption := client.encryption(); encryption != nil {
+		status := encryption.Status()
+		state.Encryption = &status
+	}
+	if integrity := client.integrity(); integrity != nil {
+		status := integrity.Status()
+		state.Integrity = &status
+	}
+	if state.Config.TTLSampleRate > 0 {
+		ttls := client.TTLStats()
+		state.TTLs = &ttls
+	}
+
+	client.statusMutex.Lock()
+	state.Sources = append([]SourceStatus{}, client.sources...)
+	client.statusMutex.Unlock()
+
+	state.Repairs = client.RepairStats()
+	state.Operations = client.OperationStats()
+	state.PendingDeletes = client.PendingDeletes()
+	state.Workers = client.WorkerStatuses()
+
+	pool := getWorkerPool()
+	state.WorkerPool = WorkerPoolStatus{
+		Workers:       pool.workers,
+		Queued:        len(pool.jobs),
+		QueueCapacity: cap(pool.jobs),
+		InFlight:      client.limiter.current(),
+	}
+	return state
+}
+
+// nodeStatuses returns the status of each node, sorted by endpoint
+func (client *Client) nodeStatuses() []NodeStatus {
+	var out []NodeStatus
+	for _, node := range client.Nodes.GetNodes() {
+		status := NodeStatus{
+			Endpoint:        node.Endpoint,
+			Healthy:         node.IsHealthy(),
+			LastHealthCheck: node.LastHealthCheck(),
+			Degraded:        node.IsDegraded(),
+			P99Latency:      node.P99Latency(),
+			History:         node.History(),
+		}
+		if len(status.History) > 0 {
+			status.HealthCheckError = status.History[len(status.History)-1].Error
+		}
+		if node.IsRemovable() {
+			status.Drain = "removable"
+		} else if node.IsDraining() {
+			status.Drain = "draining"
+		}
+		if healthy, forced := node.ForcedHealth(); forced && healthy {
+			status.Forced = "healthy"
+		} else if forced {
+			status.Forced = "unhealthy"
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+// DebugHandler returns an http.Handler serving the DebugState of this client as JSON, e.g. to mount under /debug
+func (client *Client) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(client.DebugState()); err != nil {
+			client.levelLog.Warn("DebugHandler: %s", err)
+		}
+	})
+}
+
+// currentConfig returns the options of this client as a Config, excluding sources, TLS, encryption, integrity and the
+// options of NewNodeClient
+func (client *Client) currentConfig() *Config {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	var keyPolicies []KeyPolicyConfig
+	for _, policy := range client.KeyPolicies {
+		keyPolicies = append(keyPolicies, keyPolicyConfig(policy))
+	}
+	var defaultTenantQuota *TenantQuota
+	if client.DefaultTenantQuota != (TenantQuota{}) {
+		quota := client.DefaultTenantQuota
+		defaultTenantQuota = &quota
+	}
+	return &Config{
+		SourceMerge:                client.SourceMerge,
+		Timeout:                    Duration(client.Timeout),
+		GetNodesPeriod:             Duration(client.GetNodesPeriod),
+		HealthCheckPeriod:          Duration(client.HealthCheckPeriod),
+		HealthCheckProbe:           client.HealthCheckProbe,
+		HealthCheckKeyPrefix:       client.HealthCheckKeyPrefix,
+		ResolvePeriod:              Duration(client.ResolvePeriod),
+		MaintenanceJitter:          client.MaintenanceJitter,
+		PrewarmConnections:         client.PrewarmConnections,
+		IdleCheckPeriod:            Duration(client.IdleCheckPeriod),
+		ReadAll:                    client.DefaultReadOptions.ReadAll,
+		ReadCount:                  client.DefaultReadOptions.ReadCount,
+		ReadSelection:              client.DefaultReadOptions.Selection,
+		RepairQuorum:               client.DefaultReadOptions.RepairQuorum,
+		WriteQuorum:                client.DefaultWriteOptions.Quorum,
+		NoRepair:                   client.DefaultReadOptions.NoRepair || client.DefaultWriteOptions.NoRepair,
+		AddConflict:                client.DefaultWriteOptions.AddConflict,
+		TouchRepair:                client.DefaultWriteOptions.TouchRepair,
+		DefaultTTL:                 Duration(client.DefaultWriteOptions.DefaultTTL),
+		MaxTTL:                     Duration(client.DefaultWriteOptions.MaxTTL),
+		KeyPolicies:                keyPolicies,
+		MaxValueSize:               client.MaxValueSize,
+		HashLongKeys:               client.HashLongKeys,
+		PinnedKeys:                 client.PinnedKeys,
+		TrackHotKeys:               client.TrackHotKeys,
+		TTLSampleRate:              client.TTLSampleRate,
+		SizePrefixes:               client.SizePrefixes,
+		TenantQuotas:               client.TenantQuotas,
+		DefaultTenantQuota:         defaultTenantQuota,
+		ErrorPatterns:              client.ErrorPatterns,
+		CoalesceGets:               client.CoalesceGets,
+		FillLeases:                 client.FillLeases,
+		MaxConcurrentRequests:      client.MaxConcurrentRequests,
+		ReadYourWrites:             Duration(client.ReadYourWrites),
+		DegradedLatency:            Duration(client.DegradedLatency),
+		SLOHealthCheckLatency:      Duration(client.SLOHealthCheckLatency),
+		SLONodeLatency:             Duration(client.SLONodeLatency),
+		SLOClusterLatency:          Duration(client.SLOClusterLatency),
+		NodeRampPeriod:             Duration(client.NodeRampPeriod),
+		SuppressRepairsOnPartition: client.SuppressRepairsOnPartition,
+		SingleNodeErrorRate:        client.SingleNodeErrorRate,
+		DeleteRetryTTL:             Duration(client.DeleteRetryTTL),
+		DeleteJournal:              client.DeleteJournal,
+		MaxRepairsPerSecond:        client.MaxRepairsPerSecond,
+		MaxConcurrentRepairs:       client.MaxConcurrentRepairs,
+		LogLevel:                   client.LogLevel,
+		KeyLogMode:                 client.KeyLogMode,
+		ClientID:                   client.ClientID,
+	}
+}
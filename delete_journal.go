@@ -0,0 +1,149 @@
+package memcacheha
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DELETE_JOURNAL_COMPACT_LINES is the number of lines written to a delete journal, beyond twice the number of queued
+// deletes, after which it is rewritten with only the queued deletes
+var DELETE_JOURNAL_COMPACT_LINES = 10000
+
+// deleteJournal is an append-only file of the deletes queued and completed in a deleteRetryQueue, so missed deletes
+// survive a restart. Each line is "add <endpoint> <expiry unix nanoseconds> <key>" or "done <endpoint> <key>".
+type deleteJournal struct {
+	path  string
+	file  *os.File
+	lines int
+}
+
+// openJournal replays the journal at path into this queue, dropping deletes expired at now, then rewrites it and
+// appends further changes to it
+func (queue *deleteRetryQueue) openJournal(path string, now time.Time) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		// Malformed lines are skipped, however long, and an unterminated last line is a write torn by a crash
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return err
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 4 && fields[0] == "add":
+				nanos, err := strconv.ParseInt(fields[2], 10, 64)
+				if expires := time.Unix(0, nanos); err == nil && expires.After(now) {
+					queue.addLocked(fields[1], fields[3], expires)
+				}
+			case len(fields) == 3 && fields[0] == "done":
+				queue.removeLocked(fields[1], fields[2])
+			}
+		}
+		file.Close()
+	}
+
+	queue.journal = &deleteJournal{path: path}
+	return queue.compactLocked()
+}
+
+// closeJournal stops journaling changes to this queue
+func (queue *deleteRetryQueue) closeJournal() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.journal == nil {
+		return nil
+	}
+	err := queue.journal.file.Close()
+	queue.journal = nil
+	return err
+}
+
+// compactJournal rewrites the journal with only the queued deletes, if enough lines have been written since it was
+// last rewritten. It must not be called while deletes taken from the queue are being retried.
+func (queue *deleteRetryQueue) compactJournal() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.journal == nil || queue.journal.lines < 2*queue.lenLocked()+DELETE_JOURNAL_COMPACT_LINES {
+		return nil
+	}
+	return queue.compactLocked()
+}
+
+// compactLocked writes the queued deletes to a new journal file, replacing the current one
+func (queue *deleteRetryQueue) compactLocked() error {
+	journal := queue.journal
+	tmp := journal.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	lines := 0
+	for endpoint, keys := range queue.pending {
+		for key, expires := range keys {
+			writer.WriteString(journalAddLine(endpoint, key, expires))
+			lines++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, journal.path); err != nil {
+		return err
+	}
+
+	file, err = os.OpenFile(journal.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if journal.file != nil {
+		journal.file.Close()
+	}
+	journal.file, journal.lines = file, lines
+	return nil
+}
+
+// writeJournal appends line to the journal, if any. On failure, journaling stops and the error is returned.
+func (queue *deleteRetryQueue) writeJournal(line string) error {
+	if queue.journal == nil {
+		return nil
+	}
+	if _, err := queue.journal.file.WriteString(line); err != nil {
+		queue.journal.file.Close()
+		queue.journal = nil
+		return err
+	}
+	queue.journal.lines++
+	return nil
+}
+
+func journalAddLine(endpoint string, key string, expires time.Time) string {
+	return "add " + endpoint + " " + strconv.FormatInt(expires.UnixNano(), 10) + " " + key + "\n"
+}
+
+func journalDoneLine(endpoint string, key string) string {
+	return "done " + endpoint + " " + key + "\n"
+}
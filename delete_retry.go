@@ -0,0 +1,183 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync"
+	"time"
+)
+
+var (
+	// DELETE_RETRY_TTL is the default period during which a Delete missed by a node is retried, see DeleteRetryTTL
+	DELETE_RETRY_TTL time.Duration = time.Duration(10 * time.Minute)
+	// DELETE_RETRY_MAX_KEYS is the maximum number of missed deletes queued per node. Further deletes are not retried.
+	DELETE_RETRY_MAX_KEYS = 10000
+)
+
+// deleteRetryQueue holds, for each node endpoint, the keys of deletes the node missed and when retrying them expires
+type deleteRetryQueue struct {
+	pending map[string]map[string]time.Time
+	journal *deleteJournal
+	// onJournalError is called when journaling fails and stops, if not nil
+	onJournalError func(error)
+	mutex          sync.Mutex
+}
+
+func newDeleteRetryQueue() *deleteRetryQueue {
+	return &deleteRetryQueue{
+		pending: map[string]map[string]time.Time{},
+	}
+}
+
+// add queues a delete of key on the node with the given endpoint until expires
+func (queue *deleteRetryQueue) add(endpoint string, key string, expires time.Time) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.addLocked(endpoint, key, expires) {
+		queue.journalLocked(journalAddLine(endpoint, key, expires))
+	}
+}
+
+// addLocked queues a delete, returning false if the node's queue is full
+func (queue *deleteRetryQueue) addLocked(endpoint string, key string, expires time.Time) bool {
+	keys, found := queue.pending[endpoint]
+	if !found {
+		keys = map[string]time.Time{}
+		queue.pending[endpoint] = keys
+	}
+	if len(keys) >= DELETE_RETRY_MAX_KEYS {
+		return false
+	}
+	keys[key] = expires
+	return true
+}
+
+// cancel removes a queued delete of key on the node with the given endpoint, as the key has since been written
+func (queue *deleteRetryQueue) cancel(endpoint string, key string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if len(queue.pending) == 0 {
+		return
+	}
+	if queue.removeLocked(endpoint, key) {
+		queue.journalLocked(journalDoneLine(endpoint, key))
+	}
+}
+
+// done records that a delete taken from the queue has been completed
+func (queue *deleteRetryQueue) done(endpoint string, key string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.journalLocked(journalDoneLine(endpoint, key))
+}
+
+// removeLocked removes a queued delete, returning false if it was not queued
+func (queue *deleteRetryQueue) removeLocked(endpoint string, key string) bool {
+	keys, found := queue.pending[endpoint]
+	if !found {
+		return false
+	}
+	if _, found := keys[key]; !found {
+		return false
+	}
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(queue.pending, endpoint)
+	}
+	return true
+}
+
+// journalLocked appends line to the journal, if any, reporting a failure to onJournalError
+func (queue *deleteRetryQueue) journalLocked(line string) {
+	if err := queue.writeJournal(line); err != nil && queue.onJournalError != nil {
+		queue.onJournalError(err)
+	}
+}
+
+// take removes and returns the unexpired queued deletes of the node with the given endpoint. They remain in the
+// journal until done, or add if they are queued again.
+func (queue *deleteRetryQueue) take(endpoint string, now time.Time) map[string]time.Time {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	keys := queue.pending[endpoint]
+	delete(queue.pending, endpoint)
+	for key, expires := range keys {
+		if !expires.After(now) {
+			delete(keys, key)
+		}
+	}
+	return keys
+}
+
+// len returns the number of queued deletes
+func (queue *deleteRetryQueue) len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.lenLocked()
+}
+
+func (queue *deleteRetryQueue) lenLocked() int {
+	count := 0
+	for _, keys := range queue.pending {
+		count += len(keys)
+	}
+	return count
+}
+
+// PendingDeletes returns the number of deletes missed by nodes that will be retried when the nodes are healthy
+func (client *Client) PendingDeletes() int {
+	return client.deletes.len()
+}
+
+// queueMissedDeletes queues a retry of the delete of key on nodes that failed it, and, for keys stored on all
+// nodes, on known nodes that were unhealthy when it was sent
+func (client *Client) queueMissedDeletes(originalKey string, key string, sent map[string]*Node, errs map[string]error) {
+	client.configMutex.RLock()
+	ttl := client.DeleteRetryTTL
+	client.configMutex.RUnlock()
+	if ttl <= 0 {
+		return
+	}
+
+	expires := client.Clock.Now().Add(ttl)
+	for endpoint := range errs {
+		client.deletes.add(endpoint, key, expires)
+	}
+	if client.isPinned(originalKey) {
+		return
+	}
+	for endpoint := range client.Nodes.GetNodes() {
+		if _, found := sent[endpoint]; !found {
+			client.deletes.add(endpoint, key, expires)
+		}
+	}
+}
+
+// retryDeletes re-issues the queued deletes of healthy nodes. Deletes that fail are queued again.
+func (client *Client) retryDeletes() {
+	now := client.Clock.Now()
+	for endpoint, node := range client.Nodes.GetHealthyNodes() {
+		keys := client.deletes.take(endpoint, now)
+		if len(keys) == 0 {
+			continue
+		}
+
+		client.levelLog.Info("RetryDeletes: Deleting %d keys from %s", len(keys), endpoint)
+		for key, expires := range keys {
+			if !node.IsHealthy() {
+				client.deletes.add(endpoint, key, expires)
+				continue
+			}
+			response := node.doDelete("", key)
+			if response.Error != nil && response.Error != memcache.ErrCacheMiss {
+				client.deletes.add(endpoint, key, expires)
+			} else {
+				client.deletes.done(endpoint, key)
+			}
+			releaseNodeResponse(response)
+		}
+	}
+	if err := client.deletes.compactJournal(); err != nil {
+		client.levelLog.Warn("RetryDeletes: Compacting delete journal failed: %s", err)
+	}
+}
@@ -0,0 +1,174 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDeleteRetryClient returns a client of a new cluster of 3 nodes retrying missed deletes for ttl, healthchecking
+// every 10ms
+func newDeleteRetryClient(t *testing.T, ttl time.Duration) (*memcacheha.Client, *memcachehatest.Cluster) {
+	// Healthcheck soon after Start, without waiting on slow lookups of the unresolvable test hostnames
+	startDelay, resolveTimeout := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT
+	t.Cleanup(func() { memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = startDelay, resolveTimeout })
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.DeleteRetryTTL = ttl
+		client.HealthCheckPeriod = 10 * time.Millisecond
+	})
+	return client, cluster
+}
+
+func TestDeleteRetryAfterRecovery(t *testing.T) {
+	client, cluster := newDeleteRetryClient(t, time.Minute)
+	cluster.Put(&memcacheha.Item{Key: "failed", Value: []byte("value")})
+	cluster.Put(&memcacheha.Item{Key: "unhealthy", Value: []byte("value")})
+
+	// A delete that fails on a node is retried once it recovers
+	client.Nodes.GetNodes()["node1:11211"].ForceHealth(true)
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	if err := client.Delete("failed"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if pending := client.PendingDeletes(); pending != 1 {
+		t.Fatalf("%d pending deletes, expected 1", pending)
+	}
+	cluster.Recover("node1:11211")
+	eventually(t, func() bool { return client.PendingDeletes() == 0 })
+	cluster.AssertValue(t, "failed", nil)
+
+	// As is one not sent to a node that was unhealthy
+	client.Nodes.GetNodes()["node1:11211"].ClearForcedHealth()
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node2:11211")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 2 })
+	if err := client.Delete("unhealthy"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if pending := client.PendingDeletes(); pending != 1 {
+		t.Fatalf("%d pending deletes, expected 1", pending)
+	}
+	if value := cluster.Value("node2:11211", "unhealthy"); value == nil {
+		t.Fatal("The delete reached the unhealthy node")
+	}
+	cluster.Recover("node2:11211")
+	eventually(t, func() bool { return client.PendingDeletes() == 0 })
+	cluster.AssertValue(t, "unhealthy", nil)
+}
+
+func TestDeleteRetryExpiry(t *testing.T) {
+	client, cluster := newDeleteRetryClient(t, 50*time.Millisecond)
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")})
+
+	// A node recovering after DeleteRetryTTL is not retried
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 2 })
+	if err := client.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cluster.Recover("node1:11211")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 3 && client.PendingDeletes() == 0 })
+	if value := cluster.Value("node1:11211", "key"); value == nil {
+		t.Fatal("An expired delete was retried")
+	}
+}
+
+func TestDeleteRetryMaxKeys(t *testing.T) {
+	defer func(max int) { memcacheha.DELETE_RETRY_MAX_KEYS = max }(memcacheha.DELETE_RETRY_MAX_KEYS)
+	memcacheha.DELETE_RETRY_MAX_KEYS = 2
+	client, cluster := newDeleteRetryClient(t, time.Minute)
+
+	// Deletes beyond the limit of a node are not queued
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 2 })
+	for i := 0; i < 3; i++ {
+		client.Delete(fmt.Sprintf("key%d", i))
+	}
+	if pending := client.PendingDeletes(); pending != 2 {
+		t.Fatalf("%d pending deletes, expected 2", pending)
+	}
+
+	// Retrying is disabled by a zero DeleteRetryTTL
+	client.DeleteRetryTTL = 0
+	client.Delete("key3")
+	if pending := client.PendingDeletes(); pending != 2 {
+		t.Fatalf("%d pending deletes without DeleteRetryTTL, expected 2", pending)
+	}
+}
+
+func TestDeleteJournalReplay(t *testing.T) {
+	startDelay, resolveTimeout := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT
+	t.Cleanup(func() { memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = startDelay, resolveTimeout })
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+
+	// A journal with completed, expired and malformed entries, a corrupt line too long to scan and a torn last write
+	journal := filepath.Join(t.TempDir(), "deletes")
+	expires, expired := time.Now().Add(time.Minute).UnixNano(), time.Now().Add(-time.Minute).UnixNano()
+	lines := []string{
+		fmt.Sprintf("add node1:11211 %d a", expires),
+		fmt.Sprintf("add node2:11211 %d b", expires),
+		fmt.Sprintf("add node1:11211 %d done", expires),
+		"done node1:11211 done",
+		fmt.Sprintf("add node1:11211 %d expired", expired),
+		"add node1:11211 never malformed",
+		"add node1:11211",
+		strings.Repeat("\x00", 100000),
+		fmt.Sprintf("add node3:11211 %d c", expires),
+		fmt.Sprintf("add node1:11211 %d torn", expires),
+	}
+	if err := os.WriteFile(journal, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatalf("Writing the journal failed: %s", err)
+	}
+
+	cluster := memcachehatest.NewCluster(3)
+	keys := []string{"a", "b", "c", "done", "expired", "malformed", "torn"}
+	for _, key := range keys {
+		cluster.Put(&memcacheha.Item{Key: key, Value: []byte("value")})
+	}
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.DeleteRetryTTL = time.Minute
+		client.DeleteJournal = journal
+		client.HealthCheckPeriod = 10 * time.Millisecond
+	})
+
+	// Only the pending deletes are retried, each on its own node
+	eventually(t, func() bool { return client.PendingDeletes() == 0 && cluster.Value("node1:11211", "a") == nil })
+	eventually(t, func() bool {
+		return cluster.Value("node2:11211", "b") == nil && cluster.Value("node3:11211", "c") == nil
+	})
+	deleted := map[string]string{"a": "node1:11211", "b": "node2:11211", "c": "node3:11211"}
+	for _, key := range keys {
+		for _, endpoint := range cluster.Endpoints() {
+			if value := cluster.Value(endpoint, key); value == nil && deleted[key] != endpoint {
+				t.Fatalf("%s was deleted from %s", key, endpoint)
+			}
+		}
+	}
+
+	// The journal was rewritten with the pending deletes only, and their completion appended
+	if err := client.Stop(); err != nil {
+		t.Fatalf("Stop failed: %s", err)
+	}
+	data, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatalf("Reading the journal failed: %s", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[0] != "add" && fields[0] != "done") || deleted[fields[len(fields)-1]] != fields[1] {
+			t.Fatalf("The rewritten journal holds %q", line)
+		}
+	}
+	if _, err := os.Stat(journal + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("The temporary journal remains: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package memcacheha
+
+import (
+	"sync/atomic"
+)
+
+// Disable takes the cache out of the request path, e.g. during a memcached incident. Until Enable is called, every
+// operation returns at once without contacting any node: reads return ErrCacheMiss, writes, deletes and touches
+// succeed, Increment and Decrement return ErrCacheMiss, and locks return ErrDisabled. Discovery and healthchecks
+// carry on, so the client is ready when enabled again.
+func (client *Client) Disable() {
+	if atomic.SwapInt32(&client.disabled, 1) == 0 {
+		client.levelLog.Warn("Disable: Client disabled, operations bypass the cache")
+	}
+}
+
+// Enable returns the cache to the request path after Disable
+func (client *Client) Enable() {
+	if atomic.SwapInt32(&client.disabled, 0) == 1 {
+		client.levelLog.Info("Enable: Client enabled")
+	}
+}
+
+// Disabled returns true between Disable and Enable
+func (client *Client) Disabled() bool {
+	return atomic.LoadInt32(&client.disabled) == 1
+}
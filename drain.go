@@ -0,0 +1,103 @@
+package memcacheha
+
+import (
+	"sync/atomic"
+)
+
+// Values of Node.drainState, see DrainNode
+const (
+	nodeNotDrained int32 = iota
+	nodeDraining
+	nodeRemovable
+)
+
+// DrainOptions are the options of DrainNode
+type DrainOptions struct {
+	// CopyHotKeys is the number of the hottest keys, see HotKeys, read from the node and added to the other healthy
+	// nodes before it is marked removable. TrackHotKeys must be enabled.
+	CopyHotKeys int
+}
+
+// DrainOption configures DrainNode
+type DrainOption interface {
+	applyDrain(*DrainOptions)
+}
+
+type drainOptionFunc func(*DrainOptions)
+
+func (f drainOptionFunc) applyDrain(options *DrainOptions) { f(options) }
+
+// WithCopyHotKeys copies up to n of the hottest keys held by a drained node to the other healthy nodes
+func WithCopyHotKeys(n int) DrainOption {
+	return drainOptionFunc(func(options *DrainOptions) {
+		options.CopyHotKeys = n
+	})
+}
+
+// IsDraining returns true if this node was drained with DrainNode. Draining nodes are not read from, but are still
+// written to.
+func (node *Node) IsDraining() bool {
+	return atomic.LoadInt32(&node.drainState) != nodeNotDrained
+}
+
+// IsRemovable returns true if this node was drained with DrainNode and its hot keys were copied, so it can be taken
+// out of the cluster
+func (node *Node) IsRemovable() bool {
+	return atomic.LoadInt32(&node.drainState) == nodeRemovable
+}
+
+// withoutDraining returns nodes without those that are draining, unless all of them are
+func withoutDraining(nodes map[string]*Node) map[string]*Node {
+	draining := 0
+	for _, node := range nodes {
+		if node.IsDraining() {
+			draining++
+		}
+	}
+	if draining == 0 || draining == len(nodes) {
+		return nodes
+	}
+
+	out := make(map[string]*Node, len(nodes)-draining)
+	for endpoint, node := range nodes {
+		if !node.IsDraining() {
+			out[endpoint] = node
+		}
+	}
+	return out
+}
+
+// copyHotKeys reads up to n of the hottest keys from the draining node and adds them to the other healthy nodes the
+// keys are sent to, returning the number of keys copied. Items already on a node are not overwritten.
+func (client *Client) copyHotKeys(node *Node, n int) int {
+	opID := newOperationID()
+	copied := 0
+	for _, hotKey := range client.HotKeys(n) {
+		key, err := client.mapKey(hotKey.Key)
+		if err != nil {
+			continue
+		}
+		response := node.doGet(opID, key)
+		item, err := response.Item, response.Error
+		releaseNodeResponse(response)
+		if err != nil || item == nil {
+			continue
+		}
+
+		stored := false
+		for _, target := range client.getHealthyNodes(hotKey.Key) {
+			if target == node {
+				continue
+			}
+			response := target.doAdd(opID, item)
+			if response.Error == nil {
+				stored = true
+			}
+			releaseNodeResponse(response)
+		}
+		if stored {
+			copied++
+		}
+	}
+	return copied
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elasticache"
 
+	"context"
 	"errors"
 	"fmt"
 )
@@ -35,6 +36,16 @@ func NewElastiCacheNodeSource(log logger.Logger, awsRegion string, cacheClusterI
 
 // GetNodes implements NodeSource, querying the AWS API to get the nodes in the configured CacheClusterId
 func (elastiCacheNodeSource *ElastiCacheNodeSource) GetNodes() ([]string, error) {
+	specs, err := elastiCacheNodeSource.GetNodeSpecs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return specAddresses(specs), nil
+}
+
+// GetNodeSpecs implements NodeSpecSource, querying the AWS API to get the nodes in the configured CacheClusterId with
+// their availability zones
+func (elastiCacheNodeSource *ElastiCacheNodeSource) GetNodeSpecs(ctx context.Context) ([]NodeSpec, error) {
 	// AWS Session / Client
 	sess := session.New(&aws.Config{Region: aws.String(elastiCacheNodeSource.AWSRegion)})
 	client := elasticache.New(sess)
@@ -47,13 +58,13 @@ func (elastiCacheNodeSource *ElastiCacheNodeSource) GetNodes() ([]string, error)
 	}
 
 	// Get the AWS cache cluster
-	output, err := client.DescribeCacheClusters(input)
+	output, err := client.DescribeCacheClustersWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set up output
-	var out []string
+	var out []NodeSpec
 
 	// Check that there is only one cluster, and that it is a memcache cluster
 	if len(output.CacheClusters) > 1 {
@@ -64,12 +75,16 @@ func (elastiCacheNodeSource *ElastiCacheNodeSource) GetNodes() ([]string, error)
 		return nil, fmt.Errorf("Not a memcache cluster, type %s", *cluster.Engine)
 	}
 
-	// Iterate nodes, get addresses
+	// Iterate nodes, get addresses and zones
 	for _, node := range cluster.CacheNodes {
 		if node != nil {
 			ep := node.Endpoint
 			if ep != nil {
-				out = append(out, fmt.Sprintf("%s:%d", *ep.Address, *ep.Port))
+				spec := NodeSpec{Address: fmt.Sprintf("%s:%d", *ep.Address, *ep.Port)}
+				if node.CustomerAvailabilityZone != nil {
+					spec.Zone = *node.CustomerAvailabilityZone
+				}
+				out = append(out, spec)
 			}
 		}
 	}
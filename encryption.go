@@ -0,0 +1,172 @@
+package memcacheha
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+	"sync"
+)
+
+// ENCRYPTION_HEADER marks values encrypted by an Encryption. It is followed by the big-endian key ID, the nonce and
+// the AES-GCM ciphertext.
+var ENCRYPTION_HEADER []byte = []byte{0xfd, 0x37, 0xd3, 0x1d}
+
+// encryptionOverhead is the number of bytes an Encryption adds to a value: header, key ID, nonce and tag
+const encryptionOverhead = 4 + 4 + 12 + 16
+
+// EncryptionStatus describes the keys of an Encryption, without the key material
+type EncryptionStatus struct {
+	CurrentKey     uint32   `json:"current_key"`
+	Keys           []uint32 `json:"keys"`
+	AllowPlaintext bool     `json:"allow_plaintext,omitempty"`
+}
+
+// Encryption encrypts item values with AES-GCM before they are sent to the nodes, and decrypts them when read, see
+// Client.Encryption. Values are encrypted with the current key, and store its ID so that values written with earlier
+// keys can be read until they expire. Each value is bound to its key, so values cannot be swapped between keys.
+//
+// To rotate keys, add the new key to every client with AddKey, then make it current with SetCurrentKey. Keys can be
+// removed once no values written with them remain. A key should encrypt no more than 2^32 values, as nonces are random.
+//
+// The zero value has no keys: writes fail with ErrUnknownEncryptionKey until the current key, ID 0 unless changed
+// with SetCurrentKey, is added.
+type Encryption struct {
+	mutex          sync.RWMutex
+	keys           map[uint32]cipher.AEAD
+	current        uint32
+	allowPlaintext bool
+}
+
+// NewEncryption returns an Encryption with the given AES key (16, 24 or 32 bytes) as its current key
+func NewEncryption(keyID uint32, key []byte) (*Encryption, error) {
+	encryption := &Encryption{keys: map[uint32]cipher.AEAD{}}
+	if err := encryption.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	encryption.current = keyID
+	return encryption, nil
+}
+
+// AddKey adds or replaces the AES key (16, 24 or 32 bytes) with the given ID, used to decrypt values written with it
+func (encryption *Encryption) AddKey(keyID uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	encryption.mutex.Lock()
+	defer encryption.mutex.Unlock()
+	if encryption.keys == nil {
+		encryption.keys = map[uint32]cipher.AEAD{}
+	}
+	encryption.keys[keyID] = aead
+	return nil
+}
+
+// SetCurrentKey encrypts values written from now on with the key with the given ID, returning
+// ErrUnknownEncryptionKey if it has not been added
+func (encryption *Encryption) SetCurrentKey(keyID uint32) error {
+	encryption.mutex.Lock()
+	defer encryption.mutex.Unlock()
+	if _, found := encryption.keys[keyID]; !found {
+		return ErrUnknownEncryptionKey
+	}
+	encryption.current = keyID
+	return nil
+}
+
+// RemoveKey removes the key with the given ID. Values written with it can no longer be read. The current key cannot
+// be removed.
+func (encryption *Encryption) RemoveKey(keyID uint32) error {
+	encryption.mutex.Lock()
+	defer encryption.mutex.Unlock()
+	if keyID == encryption.current {
+		return ErrCurrentEncryptionKey
+	}
+	delete(encryption.keys, keyID)
+	return nil
+}
+
+// SetAllowPlaintext enables or disables reading values that are not encrypted, e.g. written before encryption was
+// enabled. Otherwise they are read as ErrDecryptionFailed.
+func (encryption *Encryption) SetAllowPlaintext(allow bool) {
+	encryption.mutex.Lock()
+	defer encryption.mutex.Unlock()
+	encryption.allowPlaintext = allow
+}
+
+// Status returns the ID of the current key and of all keys
+func (encryption *Encryption) Status() EncryptionStatus {
+	encryption.mutex.RLock()
+	defer encryption.mutex.RUnlock()
+	status := EncryptionStatus{CurrentKey: encryption.current, AllowPlaintext: encryption.allowPlaintext}
+	for keyID := range encryption.keys {
+		status.Keys = append(status.Keys, keyID)
+	}
+	sort.Slice(status.Keys, func(i, j int) bool { return status.Keys[i] < status.Keys[j] })
+	return status
+}
+
+// seal returns value encrypted with the current key for the given item key. ErrUnknownEncryptionKey is returned if
+// the current key has not been added.
+func (encryption *Encryption) seal(key string, value []byte) ([]byte, error) {
+	encryption.mutex.RLock()
+	keyID := encryption.current
+	aead, found := encryption.keys[keyID]
+	encryption.mutex.RUnlock()
+	if !found {
+		return nil, ErrUnknownEncryptionKey
+	}
+
+	out := make([]byte, len(ENCRYPTION_HEADER)+4+aead.NonceSize(), len(value)+encryptionOverhead)
+	copy(out, ENCRYPTION_HEADER)
+	binary.BigEndian.PutUint32(out[len(ENCRYPTION_HEADER):], keyID)
+	nonce := out[len(ENCRYPTION_HEADER)+4:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, []byte(key)), nil
+}
+
+// open returns the value sealed for the given item key. ErrDecryptionFailed is returned if it was not encrypted,
+// unless plaintext is allowed, if its key is unknown, or if it has been modified or was sealed for another key.
+func (encryption *Encryption) open(key string, value []byte) ([]byte, error) {
+	encryption.mutex.RLock()
+	allowPlaintext := encryption.allowPlaintext
+	encryption.mutex.RUnlock()
+	if len(value) < len(ENCRYPTION_HEADER) || !hasHeader(value, ENCRYPTION_HEADER) {
+		if allowPlaintext {
+			return value, nil
+		}
+		return nil, ErrDecryptionFailed
+	}
+	if len(value) < encryptionOverhead {
+		return nil, ErrDecryptionFailed
+	}
+
+	keyID := binary.BigEndian.Uint32(value[len(ENCRYPTION_HEADER):])
+	encryption.mutex.RLock()
+	aead, found := encryption.keys[keyID]
+	encryption.mutex.RUnlock()
+	if !found {
+		return nil, ErrDecryptionFailed
+	}
+	nonce := value[len(ENCRYPTION_HEADER)+4 : len(ENCRYPTION_HEADER)+4+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, value[len(ENCRYPTION_HEADER)+4+aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// encryption returns the Encryption of this client, or nil if values are not encrypted
+func (client *Client) encryption() *Encryption {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.Encryption
+}
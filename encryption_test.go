@@ -0,0 +1,163 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// storeRaw stores a copy of the raw item of from on every node of cluster as key, with its value changed by modify if
+// not nil
+func storeRaw(t *testing.T, cluster *memcachehatest.Cluster, from string, key string, modify func(value []byte)) {
+	t.Helper()
+	for _, endpoint := range cluster.Endpoints() {
+		node := cluster.Node(endpoint)
+		item := node.Peek(from)
+		if item == nil {
+			t.Fatalf("%s does not hold %s", endpoint, from)
+		}
+		copied := &memcache.Item{Key: key, Value: append([]byte(nil), item.Value...), Flags: item.Flags}
+		if modify != nil {
+			modify(copied.Value)
+		}
+		node.Store(copied)
+	}
+}
+
+func TestEncryptionZeroValue(t *testing.T) {
+	encryption := &memcacheha.Encryption{}
+	client := memcachehatest.NewCluster(3).NewClient(t, func(client *memcacheha.Client) { client.Encryption = encryption })
+
+	// Without a current key, writes fail rather than panic
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("secret")}); !errors.Is(err, memcacheha.ErrUnknownEncryptionKey) {
+		t.Fatalf("Set returned %v, expected ErrUnknownEncryptionKey", err)
+	}
+	if err := encryption.AddKey(0, bytes.Repeat([]byte("k"), 16)); err != nil {
+		t.Fatalf("AddKey failed: %s", err)
+	}
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("secret")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if item, err := client.Get("key"); err != nil || string(item.Value) != "secret" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	encryption, err := memcacheha.NewEncryption(1, bytes.Repeat([]byte("1"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := memcachehatest.NewCluster(3).NewClient(t, func(client *memcacheha.Client) { client.Encryption = encryption })
+	if err := client.Set(&memcacheha.Item{Key: "old", Value: []byte("old value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := encryption.SetCurrentKey(2); err != memcacheha.ErrUnknownEncryptionKey {
+		t.Fatalf("SetCurrentKey of a missing key returned %v, expected ErrUnknownEncryptionKey", err)
+	}
+	if err := encryption.AddKey(2, []byte("short")); err == nil {
+		t.Fatal("AddKey of an invalid AES key succeeded")
+	}
+	if err := encryption.AddKey(2, bytes.Repeat([]byte("2"), 32)); err != nil {
+		t.Fatalf("AddKey failed: %s", err)
+	}
+	if err := encryption.SetCurrentKey(2); err != nil {
+		t.Fatalf("SetCurrentKey failed: %s", err)
+	}
+	if status := encryption.Status(); status.CurrentKey != 2 || !reflect.DeepEqual(status.Keys, []uint32{1, 2}) {
+		t.Fatalf("Status is %+v", status)
+	}
+	if err := client.Set(&memcacheha.Item{Key: "new", Value: []byte("new value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	// Values of both keys are read
+	for key, value := range map[string]string{"old": "old value", "new": "new value"} {
+		if item, err := client.Get(key); err != nil || string(item.Value) != value {
+			t.Fatalf("Get of %s returned %v, %v", key, item, err)
+		}
+	}
+
+	// The current key cannot be removed, and values of removed keys are no longer read
+	if err := encryption.RemoveKey(2); err != memcacheha.ErrCurrentEncryptionKey {
+		t.Fatalf("RemoveKey of the current key returned %v, expected ErrCurrentEncryptionKey", err)
+	}
+	if err := encryption.RemoveKey(1); err != nil {
+		t.Fatalf("RemoveKey failed: %s", err)
+	}
+	if _, err := client.Get("old"); !errors.Is(err, memcacheha.ErrDecryptionFailed) {
+		t.Fatalf("Get of a value of a removed key returned %v, expected ErrDecryptionFailed", err)
+	}
+	if item, err := client.Get("new"); err != nil || string(item.Value) != "new value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
+
+func TestEncryptionAllowPlaintext(t *testing.T) {
+	encryption, err := memcacheha.NewEncryption(1, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Encryption = encryption })
+	cluster.Put(&memcacheha.Item{Key: "plain", Value: []byte("plaintext")})
+
+	if _, err := client.Get("plain"); !errors.Is(err, memcacheha.ErrDecryptionFailed) {
+		t.Fatalf("Get of a plaintext value returned %v, expected ErrDecryptionFailed", err)
+	}
+	encryption.SetAllowPlaintext(true)
+	if item, err := client.Get("plain"); err != nil || string(item.Value) != "plaintext" {
+		t.Fatalf("Get with AllowPlaintext returned %v, %v", item, err)
+	}
+	// Writes are still encrypted
+	if err := client.Set(&memcacheha.Item{Key: "plain", Value: []byte("plaintext")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if value := cluster.Value("node1:11211", "plain"); bytes.Contains(value, []byte("plaintext")) {
+		t.Fatalf("node1 holds the plaintext value %q", value)
+	}
+}
+
+func TestEncryptionRejectsModifiedValues(t *testing.T) {
+	encryption, err := memcacheha.NewEncryption(1, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Encryption = encryption })
+	if err := client.Set(&memcacheha.Item{Key: "a", Value: []byte("value of a")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	// A modified ciphertext fails authentication
+	storeRaw(t, cluster, "a", "tampered", func(value []byte) { value[len(value)-1] ^= 0xff })
+	storeRaw(t, cluster, "a", "a", func(value []byte) { value[len(value)-1] ^= 0xff })
+	if _, err := client.Get("a"); !errors.Is(err, memcacheha.ErrDecryptionFailed) {
+		t.Fatalf("Get of a tampered value returned %v, expected ErrDecryptionFailed", err)
+	}
+
+	// A value moved to another key is bound to its own
+	if err := client.Set(&memcacheha.Item{Key: "a", Value: []byte("value of a")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	storeRaw(t, cluster, "a", "b", nil)
+	if _, err := client.Get("b"); !errors.Is(err, memcacheha.ErrDecryptionFailed) {
+		t.Fatalf("Get of a value swapped from another key returned %v, expected ErrDecryptionFailed", err)
+	}
+
+	// As is a value sealed with another key of the same ID
+	other, err := memcacheha.NewEncryption(1, bytes.Repeat([]byte("o"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetEncryption(other)
+	if _, err := client.Get("a"); !errors.Is(err, memcacheha.ErrDecryptionFailed) {
+		t.Fatalf("Get with another key returned %v, expected ErrDecryptionFailed", err)
+	}
+}
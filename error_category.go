@@ -0,0 +1,83 @@
+package memcacheha
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrorCategory decides how an error from a node is handled, see Client.ErrorMapper
+type ErrorCategory int
+
+const (
+	// ERROR_CATEGORY_DEFAULT leaves the error to the built-in handling: the errors of gomemcache for a missing key,
+	// a CAS conflict, an unstored item or a malformed key are returned as they are, and any other error is a
+	// NodeError marking the node unhealthy
+	ERROR_CATEGORY_DEFAULT ErrorCategory = iota
+	// ERROR_CATEGORY_UNHEALTHY returns the error as a NodeError and marks the node unhealthy
+	ERROR_CATEGORY_UNHEALTHY
+	// ERROR_CATEGORY_RETRYABLE retries the request on the node up to NODE_ERROR_RETRIES times. The error is returned
+	// as a NodeError if every attempt fails, but does not mark the node unhealthy.
+	ERROR_CATEGORY_RETRYABLE
+	// ERROR_CATEGORY_FATAL fails the request on the node with the error as it is, without retrying it or changing
+	// the health of the node, e.g. for a request the node rejects by policy
+	ERROR_CATEGORY_FATAL
+	// ERROR_CATEGORY_MISS treats the error as memcache.ErrCacheMiss, e.g. for a proxy reporting a missing key with
+	// its own error
+	ERROR_CATEGORY_MISS
+)
+
+// ErrUnknownErrorCategory is an error meaning an ErrorCategory name is not default, unhealthy, retryable, fatal or
+// miss
+var ErrUnknownErrorCategory = errors.New("memcacheha: unknown error category")
+
+var errorCategoryNames = map[ErrorCategory]string{
+	ERROR_CATEGORY_DEFAULT:   "default",
+	ERROR_CATEGORY_UNHEALTHY: "unhealthy",
+	ERROR_CATEGORY_RETRYABLE: "retryable",
+	ERROR_CATEGORY_FATAL:     "fatal",
+	ERROR_CATEGORY_MISS:      "miss",
+}
+
+// UnmarshalText parses a category name: default, unhealthy, retryable, fatal or miss
+func (category *ErrorCategory) UnmarshalText(text []byte) error {
+	for c, name := range errorCategoryNames {
+		if name == string(text) {
+			*category = c
+			return nil
+		}
+	}
+	return ErrUnknownErrorCategory
+}
+
+// MarshalText returns the category name
+func (category ErrorCategory) MarshalText() ([]byte, error) {
+	name, found := errorCategoryNames[category]
+	if !found {
+		return nil, ErrUnknownErrorCategory
+	}
+	return []byte(name), nil
+}
+
+// errorCategory returns the category of an error from a node: that of ErrorMapper, if it returns one, otherwise that
+// of the longest of ErrorPatterns found in the error message
+func (client *Client) errorCategory(err error) ErrorCategory {
+	client.configMutex.RLock()
+	mapper, patterns := client.ErrorMapper, client.ErrorPatterns
+	client.configMutex.RUnlock()
+
+	if mapper != nil {
+		if category := mapper(err); category != ERROR_CATEGORY_DEFAULT {
+			return category
+		}
+	}
+	category, longest := ERROR_CATEGORY_DEFAULT, -1
+	if len(patterns) > 0 {
+		message := err.Error()
+		for pattern, c := range patterns {
+			if len(pattern) > longest && strings.Contains(message, pattern) {
+				category, longest = c, len(pattern)
+			}
+		}
+	}
+	return category
+}
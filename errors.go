@@ -1,7 +1,18 @@
 package memcacheha
 
 import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
@@ -14,6 +25,298 @@ var (
 	// ErrNoHealthyNodes is an error meaning there are no nodes that can be contacted
 	ErrNoHealthyNodes = errors.New("memcacheha: no healthy nodes")
 
-	// ErrUnknown represents an internal panic()
+	// ErrQuorumNotReached is an error meaning fewer nodes than the requested write quorum acknowledged a write
+	ErrQuorumNotReached = errors.New("memcacheha: write quorum not reached")
+
+	// ErrPartialWrite is an error meaning a write was acknowledged by some nodes, but fewer than required
+	ErrPartialWrite = errors.New("memcacheha: partial write")
+
+	// ErrValueTooLarge is an error meaning an item was not written because its value exceeds the client's MaxValueSize
+	ErrValueTooLarge = errors.New("memcacheha: value too large")
+
+	// ErrNotNumeric is an error meaning Increment or Decrement was called on an item whose value is not a decimal number
+	ErrNotNumeric = errors.New("memcacheha: value is not numeric")
+
+	// ErrDisabled is an error meaning a lock was not acquired because the client is disabled, see Client.Disable
+	ErrDisabled = errors.New("memcacheha: client disabled")
+
+	// ErrDecryptionFailed is an error meaning a value read from a node could not be decrypted, see Encryption
+	ErrDecryptionFailed = errors.New("memcacheha: value could not be decrypted")
+
+	// ErrUnknownEncryptionKey is an error meaning an encryption key ID has not been added, see Encryption.AddKey
+	ErrUnknownEncryptionKey = errors.New("memcacheha: unknown encryption key")
+
+	// ErrCurrentEncryptionKey is an error meaning the current encryption key cannot be removed
+	ErrCurrentEncryptionKey = errors.New("memcacheha: encryption key is current")
+
+	// ErrUnknownIntegrityKey is an error meaning an integrity key ID has not been added, see Integrity.AddKey
+	ErrUnknownIntegrityKey = errors.New("memcacheha: unknown integrity key")
+
+	// ErrCurrentIntegrityKey is an error meaning the current integrity key cannot be removed
+	ErrCurrentIntegrityKey = errors.New("memcacheha: integrity key is current")
+
+	// ErrIntegrityKeyTooShort is an error meaning an integrity key is shorter than INTEGRITY_MIN_KEY_SIZE
+	ErrIntegrityKeyTooShort = errors.New("memcacheha: integrity key too short")
+
+	// ErrStatsUnsupported is an error meaning the NodeClient of a node cannot read the stats of its server, see Usage
+	ErrStatsUnsupported = errors.New("memcacheha: stats not supported by node client")
+
+	// ErrQuotaExceeded is an error meaning an operation exceeded the TenantQuota of its tenant
+	ErrQuotaExceeded = errors.New("memcacheha: tenant quota exceeded")
+
+	// ErrNoStagedNodes is an error meaning a BlueGreenClient has no staged node set to switch to, see Stage
+	ErrNoStagedNodes = errors.New("memcacheha: no staged node set")
+
+	// ErrOperationTimeout is an error meaning an operation failed because nodes timed out, see TimeoutError
+	ErrOperationTimeout = errors.New("memcacheha: operation timed out")
+
+	// ErrUnknown represents an internal panic(). It is no longer returned by operations.
 	ErrUnknown = errors.New("memcacheha: unknown error occurred")
 )
+
+var (
+	// ErrNodeTimeout is the class of node errors caused by a timeout
+	ErrNodeTimeout = errors.New("memcacheha: node timeout")
+
+	// ErrNodeNetwork is the class of node errors caused by a failed or closed connection
+	ErrNodeNetwork = errors.New("memcacheha: node network error")
+
+	// ErrNodeProtocol is the class of node errors caused by an unexpected response
+	ErrNodeProtocol = errors.New("memcacheha: node protocol error")
+
+	// ErrNodeServer is the class of node errors caused by a memcached server error
+	ErrNodeServer = errors.New("memcacheha: node server error")
+
+	// ErrTLSRequired is an error meaning a NodeSpec requires TLS, but the client has no NewTLSNodeClient
+	ErrTLSRequired = errors.New("memcacheha: node requires TLS, but NewTLSNodeClient is not set")
+
+	// ErrNoNodeClient is an error meaning a NodeSpec does not require TLS, but the client has no NewNodeClient
+	ErrNoNodeClient = errors.New("memcacheha: NewNodeClient is not set")
+)
+
+// NodeError is an error from a node that caused it to be marked unhealthy. It matches its Class (ErrNodeTimeout,
+// ErrNodeNetwork, ErrNodeProtocol or ErrNodeServer) and the underlying error with errors.Is.
+type NodeError struct {
+	Endpoint string
+	Class    error
+	Err      error
+}
+
+// Error returns the endpoint and underlying error
+func (err *NodeError) Error() string {
+	return fmt.Sprintf("memcacheha: node %s: %s", err.Endpoint, err.Err)
+}
+
+// Unwrap returns the class and underlying error
+func (err *NodeError) Unwrap() []error {
+	return []error{err.Class, err.Err}
+}
+
+// newNodeError wraps err from the node with the given endpoint in a classified NodeError
+func newNodeError(endpoint string, err error) error {
+	var nodeErr *NodeError
+	if errors.As(err, &nodeErr) {
+		return err
+	}
+	return &NodeError{Endpoint: endpoint, Class: classifyNodeError(err), Err: err}
+}
+
+// classifyNodeError returns the class of the given node error
+func classifyNodeError(err error) error {
+	var connectTimeout *memcache.ConnectTimeoutError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &connectTimeout), errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrNodeTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrNodeTimeout
+		}
+		return ErrNodeNetwork
+	case errors.Is(err, memcache.ErrServerError):
+		return ErrNodeServer
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, memcache.ErrNoServers),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrNodeNetwork
+	}
+	return ErrNodeProtocol
+}
+
+// QuorumError is returned when fewer nodes than the write quorum acknowledged a write. It matches
+// ErrQuorumNotReached and ErrPartialWrite with errors.Is, and unwraps to the errors of the nodes that failed.
+type QuorumError struct {
+	Acked  int
+	Quorum int
+	Errors map[string]error
+}
+
+// Error returns the number of nodes that acknowledged the write and the quorum
+func (err *QuorumError) Error() string {
+	return fmt.Sprintf("%s (%d of %d nodes)", ErrQuorumNotReached, err.Acked, err.Quorum)
+}
+
+// Is returns true for ErrQuorumNotReached and ErrPartialWrite
+func (err *QuorumError) Is(target error) bool {
+	return target == ErrQuorumNotReached || target == ErrPartialWrite
+}
+
+// Unwrap returns the errors of the nodes that failed
+func (err *QuorumError) Unwrap() []error {
+	return errorList(err.Errors)
+}
+
+// ErrAllNodesFailed is returned when an operation failed on every node it was sent to. Errors maps the endpoint of
+// each node to its error. It matches ErrNoHealthyNodes with errors.Is, and unwraps to the node errors.
+type ErrAllNodesFailed struct {
+	Errors map[string]error
+}
+
+// Error returns the error of each node
+func (err *ErrAllNodesFailed) Error() string {
+	var parts []string
+	for _, endpoint := range sortedEndpoints(err.Errors) {
+		parts = append(parts, endpoint+": "+err.Errors[endpoint].Error())
+	}
+	return "memcacheha: all nodes failed: " + strings.Join(parts, "; ")
+}
+
+// Is returns true for ErrNoHealthyNodes
+func (err *ErrAllNodesFailed) Is(target error) bool {
+	return target == ErrNoHealthyNodes
+}
+
+// Unwrap returns the errors of the nodes
+func (err *ErrAllNodesFailed) Unwrap() []error {
+	return errorList(err.Errors)
+}
+
+// ValueTooLargeError is returned when an item is not written because its stored size, the value with its header,
+// exceeds the client's MaxValueSize. It matches ErrValueTooLarge with errors.Is.
+type ValueTooLargeError struct {
+	Key   string
+	Size  int
+	Limit int
+}
+
+// Error returns the size of the value and the limit
+func (err *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("%s (%d bytes, limit %d)", ErrValueTooLarge, err.Size, err.Limit)
+}
+
+// Is returns true for ErrValueTooLarge
+func (err *ValueTooLargeError) Is(target error) bool {
+	return target == ErrValueTooLarge
+}
+
+// TimeoutError is returned in place of the error of an operation on a key that failed because nodes timed out, to
+// tell a slow node from a slow cluster. Responded and TimedOut map the endpoints of the nodes that replied, with a
+// result or another error, and of those that timed out, to the time their request took. It matches
+// ErrOperationTimeout with errors.Is, and unwraps to the error of the operation, e.g. *ErrAllNodesFailed.
+type TimeoutError struct {
+	// Elapsed is the longest time taken by a node
+	Elapsed   time.Duration
+	Responded map[string]time.Duration
+	TimedOut  map[string]time.Duration
+	Err       error
+}
+
+// Error returns the nodes that timed out and responded, with their times, and the error of the operation
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("%s after %s (timed out: %s; responded: %s): %s", ErrOperationTimeout, err.Elapsed,
+		formatTimings(err.TimedOut), formatTimings(err.Responded), err.Err)
+}
+
+// Is returns true for ErrOperationTimeout
+func (err *TimeoutError) Is(target error) bool {
+	return target == ErrOperationTimeout
+}
+
+// Unwrap returns the error of the operation
+func (err *TimeoutError) Unwrap() error {
+	return err.Err
+}
+
+func formatTimings(timings map[string]time.Duration) string {
+	if len(timings) == 0 {
+		return "none"
+	}
+	endpoints := make([]string, 0, len(timings))
+	for endpoint := range timings {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	parts := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		parts[i] = fmt.Sprintf("%s %s", endpoint, timings[endpoint])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// nodeTimings are the times taken by the nodes an operation was sent to, see TimeoutError
+type nodeTimings []nodeTiming
+
+type nodeTiming struct {
+	endpoint string
+	elapsed  time.Duration
+}
+
+// record adds the time taken by the node of response
+func (timings *nodeTimings) record(response *NodeResponse) {
+	*timings = append(*timings, nodeTiming{endpoint: response.Node.Endpoint, elapsed: response.Elapsed})
+}
+
+// timeoutError returns err wrapped in a TimeoutError if any of errs, the errors of the nodes that failed, is a timeout
+func timeoutError(err error, errs map[string]error, timings nodeTimings) error {
+	if err == nil || len(timings) == 0 {
+		return err
+	}
+	timeout := &TimeoutError{Responded: map[string]time.Duration{}, TimedOut: map[string]time.Duration{}, Err: err}
+	for _, timing := range timings {
+		if errors.Is(errs[timing.endpoint], ErrNodeTimeout) {
+			timeout.TimedOut[timing.endpoint] = timing.elapsed
+		} else {
+			timeout.Responded[timing.endpoint] = timing.elapsed
+		}
+		if timing.elapsed > timeout.Elapsed {
+			timeout.Elapsed = timing.elapsed
+		}
+	}
+	if len(timeout.TimedOut) == 0 {
+		return err
+	}
+	return timeout
+}
+
+// writeError returns the error of a write acknowledged by acked nodes, where errs holds the errors of the nodes that
+// failed: ErrAllNodesFailed if no node acknowledged it, a QuorumError if fewer than a non-zero quorum did, or nil.
+// A quorum of WRITE_QUORUM_ALL requires every node to acknowledge it. The error is a TimeoutError if nodes timed out,
+// given the timings of the nodes.
+func writeError(acked int, quorum int, errs map[string]error, timings nodeTimings) error {
+	if quorum == WRITE_QUORUM_ALL {
+		quorum = acked + len(errs)
+	}
+	if acked == 0 {
+		return timeoutError(&ErrAllNodesFailed{Errors: errs}, errs, timings)
+	}
+	if quorum > 0 && acked < quorum {
+		return timeoutError(&QuorumError{Acked: acked, Quorum: quorum, Errors: errs}, errs, timings)
+	}
+	return nil
+}
+
+func sortedEndpoints(errs map[string]error) []string {
+	endpoints := make([]string, 0, len(errs))
+	for endpoint := range errs {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+func errorList(errs map[string]error) []error {
+	var out []error
+	for _, endpoint := range sortedEndpoints(errs) {
+		out = append(out, errs[endpoint])
+	}
+	return out
+}
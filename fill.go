@@ -0,0 +1,104 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"time"
+)
+
+var (
+	// FILL_LEASE_SUFFIX is appended to a key to form the key of its fill lease, see FillLeases
+	FILL_LEASE_SUFFIX = "_fill_lease"
+	// FILL_LEASE_TTL is the period a fill lease is held, after which waiting callers fill the key themselves
+	FILL_LEASE_TTL time.Duration = time.Duration(5 * time.Second)
+	// FILL_POLL_PERIOD is the period between reads of a key being filled under another caller's lease
+	FILL_POLL_PERIOD time.Duration = time.Duration(50 * time.Millisecond)
+)
+
+// GetOrFill returns the item for the given key or, if it is not found, the item returned by fill, which must have
+// the same key, after writing it with Set. The error of fill is returned without writing.
+//
+// With FillLeases, a caller must first take the key's fill lease, so only one caller across all clients fills a
+// missing key at a time. The others read the key every FILL_POLL_PERIOD until it is written, filling it themselves
+// if it is not written within FILL_LEASE_TTL. If ctx is done first, they return its error without filling.
+func (client *Client) GetOrFill(ctx context.Context, key string, fill func(ctx context.Context) (*Item, error), opts ...ReadOption) (*Item, error) {
+	item, err := client.Get(key, opts...)
+	if err != memcache.ErrCacheMiss {
+		return item, err
+	}
+
+	client.configMutex.RLock()
+	leases := client.FillLeases
+	client.configMutex.RUnlock()
+	if leases {
+		expiration := client.Clock.Now().Add(FILL_LEASE_TTL)
+		lease := &Item{Key: key + FILL_LEASE_SUFFIX, Value: []byte{1}, Expiration: &expiration}
+		switch err := client.Add(lease); err {
+		case nil:
+			// Waiting callers read the key once it is written; release the lease early only if the fill fails
+			item, err := client.fill(ctx, fill)
+			if err != nil {
+				client.Delete(lease.Key)
+			}
+			return item, err
+		case memcache.ErrNotStored:
+			if item, err := client.waitForFill(ctx, key, expiration, opts); err != memcache.ErrCacheMiss {
+				return item, err
+			}
+		default:
+			client.levelLog.Info("GetOrFill: Filling %s without a lease: %s", client.LogKey(key), err)
+		}
+	}
+	return client.fill(ctx, fill)
+}
+
+// fill writes and returns the item returned by fill
+func (client *Client) fill(ctx context.Context, fill func(ctx context.Context) (*Item, error)) (*Item, error) {
+	item, err := fill(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return item, client.Set(item)
+}
+
+// waitForFill reads key every FILL_POLL_PERIOD until it is found or deadline passes, returning ErrCacheMiss if it was
+// not filled. If ctx is done first, its error is returned.
+func (client *Client) waitForFill(ctx context.Context, key string, deadline time.Time, opts []ReadOption) (*Item, error) {
+	ticker := time.NewTicker(FILL_POLL_PERIOD)
+	defer ticker.Stop()
+	for client.Clock.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		if item, err := client.Get(key, opts...); err != memcache.ErrCacheMiss {
+			return item, err
+		}
+	}
+	return nil, memcache.ErrCacheMiss
+}
+
+// FlushAndWarm removes all items from all nodes as FlushAll does, then runs warmer, with FillLeases enabled
+// throughout so the requests missing on the cold cache fill each key once rather than all at once. FillLeases is
+// restored when warmer returns. If the flush fails on any node, its error is returned without running warmer.
+func (client *Client) FlushAndWarm(ctx context.Context, warmer func(ctx context.Context) error) error {
+	client.configMutex.Lock()
+	leases := client.FillLeases
+	client.FillLeases = true
+	client.configMutex.Unlock()
+	defer client.SetFillLeases(leases)
+
+	if err := client.FlushAllContext(ctx); err != nil {
+		return err
+	}
+	client.levelLog.Info("FlushAndWarm: Warming")
+	start := time.Now()
+	if err := warmer(ctx); err != nil {
+		client.levelLog.Warn("FlushAndWarm: Warming failed after %s: %s", time.Since(start), err)
+		return err
+	}
+	client.levelLog.Info("FlushAndWarm: Warmed in %s", time.Since(start))
+	return nil
+}
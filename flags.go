@@ -0,0 +1,78 @@
+package memcacheha
+
+import (
+	"errors"
+)
+
+// Item flag bits. The high byte of Item.Flags is reserved for memcacheha library features, the remaining
+// bits (FLAGS_APPLICATION_MASK) are free for application use.
+const (
+	// FLAG_COMPRESSED marks an item whose value is compressed
+	FLAG_COMPRESSED uint32 = 1 << 31
+	// FLAG_TOMBSTONE marks an item as a deletion marker
+	FLAG_TOMBSTONE uint32 = 1 << 30
+	// FLAG_CODEC_MASK holds the codec id used to encode the value
+	FLAG_CODEC_MASK uint32 = 0x3F << FLAG_CODEC_SHIFT
+	// FLAG_CODEC_SHIFT is the bit offset of the codec id
+	FLAG_CODEC_SHIFT = 24
+
+	// FLAGS_RESERVED_MASK covers all bits reserved for library features
+	FLAGS_RESERVED_MASK uint32 = 0xFF000000
+	// FLAGS_APPLICATION_MASK covers all bits available for application use
+	FLAGS_APPLICATION_MASK uint32 = ^FLAGS_RESERVED_MASK
+)
+
+// ErrReservedFlags is an error meaning application flags collide with bits reserved for library features
+var ErrReservedFlags = errors.New("memcacheha: flags collide with reserved bits")
+
+// CheckApplicationFlags returns ErrReservedFlags if any of the given flags are reserved for library features
+func CheckApplicationFlags(flags uint32) error {
+	if flags&FLAGS_RESERVED_MASK != 0 {
+		return ErrReservedFlags
+	}
+	return nil
+}
+
+// ApplicationFlags returns the application flag bits of this item
+func (item *Item) ApplicationFlags() uint32 {
+	return item.Flags & FLAGS_APPLICATION_MASK
+}
+
+// SetApplicationFlags sets the application flag bits of this item, leaving library flags untouched.
+// ErrReservedFlags is returned if flags uses any reserved bits.
+func (item *Item) SetApplicationFlags(flags uint32) error {
+	if err := CheckApplicationFlags(flags); err != nil {
+		return err
+	}
+	item.Flags = (item.Flags & FLAGS_RESERVED_MASK) | flags
+	return nil
+}
+
+// HasFlag returns true if all bits of the given flag are set
+func (item *Item) HasFlag(flag uint32) bool {
+	return item.Flags&flag == flag
+}
+
+// SetFlag sets or clears the given flag bits
+func (item *Item) SetFlag(flag uint32, on bool) {
+	if on {
+		item.Flags |= flag
+	} else {
+		item.Flags &^= flag
+	}
+}
+
+// Codec returns the codec id stored in this item's flags
+func (item *Item) Codec() uint8 {
+	return uint8((item.Flags & FLAG_CODEC_MASK) >> FLAG_CODEC_SHIFT)
+}
+
+// SetCodec stores the given codec id in this item's flags. ErrReservedFlags is returned if the id does not fit in FLAG_CODEC_MASK.
+func (item *Item) SetCodec(id uint8) error {
+	codec := uint32(id) << FLAG_CODEC_SHIFT
+	if codec&^FLAG_CODEC_MASK != 0 {
+		return ErrReservedFlags
+	}
+	item.Flags = (item.Flags &^ FLAG_CODEC_MASK) | codec
+	return nil
+}
@@ -0,0 +1,189 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// GETINTO_MAX_NODES is the most healthy nodes getIntoFast reads from, on the stack. Larger clusters use the general
+// path of GetInto.
+const GETINTO_MAX_NODES = 16
+
+// getIntoScratchPool holds the buffers getIntoFast compares values in when the caller's buffer has no room
+var getIntoScratchPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// getIntoFast reads the mapped value of key into buf without decoding an Item, from the nodes Get would read, returning
+// its length and true. It returns false, having only counted the requests made, if the general path of GetInto must
+// be used: when the client encrypts, signs, pins keys, ramps nodes, tracks reads for ReadYourWrites or has key
+// policies, single-node mode, a read selection other than READ_SELECT_KEY or draining or degraded nodes; when a node
+// is not a NodeBufferGetter; when buf can't hold the stored value with its header; or when the nodes fail, miss the
+// key on some nodes or disagree, so the general path can repair them.
+func (client *Client) getIntoFast(key string, buf []byte) (int, bool, error) {
+	if client.Disabled() {
+		return 0, false, nil
+	}
+	client.configMutex.RLock()
+	options := client.DefaultReadOptions
+	eligible := len(client.KeyPolicies) == 0 && len(client.PinnedKeys) == 0 && client.NodeRampPeriod <= 0 &&
+		client.Encryption == nil && client.Integrity == nil && client.ReadYourWrites <= 0 &&
+		options.Selection == READ_SELECT_KEY
+	client.configMutex.RUnlock()
+	if !eligible || client.SingleNode() != "" {
+		return 0, false, nil
+	}
+	mappedKey, err := client.mapKey(key)
+	if err != nil {
+		return 0, true, err
+	}
+
+	var array [GETINTO_MAX_NODES]*Node
+	nodes, ok := client.Nodes.appendHealthyNodes(array[:0])
+	if !ok || len(nodes) == 0 {
+		return 0, false, nil
+	}
+	for _, node := range nodes {
+		if node.IsDraining() || node.IsDegraded() {
+			return 0, false, nil
+		}
+	}
+	nodes = selectReadNodesByKey(key, nodes, readCount(len(nodes), &options))
+
+	// Operation IDs are only needed to correlate debug logs, and are not built without a Log or below LOG_DEBUG
+	opID := ""
+	debug := client.levelLog.enabled(LOG_DEBUG)
+	if debug {
+		opID = newOperationID()
+	}
+
+	// Read the first node into buf, and compare the others with it in the rest of buf, or in a pooled buffer
+	var scratch *[]byte
+	defer func() {
+		if scratch != nil {
+			getIntoScratchPool.Put(scratch)
+		}
+	}()
+	n, flags := 0, uint32(0)
+	misses := 0
+	for i, node := range nodes {
+		dst := buf
+		if i > 0 {
+			if dst = buf[n:]; len(dst) < n {
+				if scratch == nil {
+					scratch = getIntoScratchPool.Get().(*[]byte)
+				}
+				if cap(*scratch) < n {
+					*scratch = make([]byte, n)
+				}
+				dst = (*scratch)[:n]
+			}
+		}
+		m, f, err := node.getInto(opID, mappedKey, dst, debug)
+		switch {
+		case err == memcache.ErrCacheMiss:
+			misses++
+			continue
+		case err != nil:
+			return 0, false, nil
+		case i == 0:
+			n, flags = m, f
+		case misses > 0 || m != n || f != flags || !bytes.Equal(dst[:m], buf[:n]):
+			return 0, false, nil
+		}
+	}
+	if misses == len(nodes) {
+		return 0, true, memcache.ErrCacheMiss
+	}
+	if misses > 0 {
+		return 0, false, nil
+	}
+	return decodeInPlace(buf[:n])
+}
+
+// errNotBufferGetter marks nodes whose client is not a NodeBufferGetter, see getIntoFast
+var errNotBufferGetter = errors.New("memcacheha: node client is not a NodeBufferGetter")
+
+// getInto reads the stored value of key into buf if the node client is a NodeBufferGetter, returning the error of the
+// node as getNodeResponse does
+func (node *Node) getInto(opID string, key string, buf []byte, debug bool) (int, uint32, error) {
+	getter, ok := node.getClient().(NodeBufferGetter)
+	if !ok {
+		return 0, 0, errNotBufferGetter
+	}
+	if debug {
+		node.debug(opID, "GET %s", node.keyForLog(key))
+	}
+	node.limiter.acquire()
+	start := time.Now()
+	n, flags, err := getter.GetInto(key, buf)
+	node.recordLatency(time.Since(start))
+	node.limiter.release()
+
+	// A short buffer is the caller's, not the node's, failure
+	if err == io.ErrShortBuffer {
+		releaseNodeResponse(node.getNodeResponse(opID, nil, nil))
+		return n, flags, err
+	}
+	response := node.getNodeResponse(opID, nil, err)
+	err = response.Error
+	releaseNodeResponse(response)
+	return n, flags, err
+}
+
+// decodeInPlace moves the value of the stored item in buf, without its header, to the start of buf, returning its
+// length and true, or false if buf does not hold a memcacheha item
+func decodeInPlace(buf []byte) (int, bool, error) {
+	header := 8
+	switch {
+	case len(buf) < 8:
+		return 0, false, nil
+	case hasHeader(buf, MEMCACHEHA_SOFT_HEADER) && len(buf) >= 12:
+		header = 12
+	case !hasHeader(buf, MEMCACHEHA_HEADER):
+		return 0, false, nil
+	}
+	return copy(buf, buf[header:]), true, nil
+}
+
+// selectReadNodesByKey returns the n of nodes READ_SELECT_KEY reads from, as selectReadNodes does, reordering nodes
+// in place without allocating
+func selectReadNodesByKey(key string, nodes []*Node, n int) []*Node {
+	if n <= 0 || n >= len(nodes) {
+		return nodes
+	}
+	// Insertion sort by descending weight, then endpoint
+	var weights [GETINTO_MAX_NODES]uint64
+	for i, node := range nodes {
+		weights[i] = shardWeight(node.Endpoint, key)
+	}
+	for i := 1; i < len(nodes); i++ {
+		for j := i; j > 0; j-- {
+			a, b := j-1, j
+			if weights[a] > weights[b] || (weights[a] == weights[b] && nodes[a].Endpoint < nodes[b].Endpoint) {
+				break
+			}
+			weights[a], weights[b] = weights[b], weights[a]
+			nodes[a], nodes[b] = nodes[b], nodes[a]
+		}
+	}
+	return nodes[:n]
+}
+
+// appendHealthyNodes appends the healthy nodes to dst, returning false if they don't fit in its capacity
+func (nodeList *NodeList) appendHealthyNodes(dst []*Node) ([]*Node, bool) {
+	nodeList.mutex.RLock()
+	defer nodeList.mutex.RUnlock()
+	for _, node := range nodeList.Nodes {
+		if node.IsHealthy() {
+			if len(dst) == cap(dst) {
+				return dst, false
+			}
+			dst = append(dst, node)
+		}
+	}
+	return dst, true
+}
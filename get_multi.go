@@ -0,0 +1,227 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"time"
+)
+
+// multiGetResponse is the result of reading a batch of keys from one node
+type multiGetResponse struct {
+	node  *Node
+	keys  []string
+	items map[string]*Item
+	err   error
+}
+
+// MultiResult is the result of GetMultiContext
+type MultiResult struct {
+	// Items are the items found, by key
+	Items map[string]*Item
+	// Partial is true if the context was done before every key was read
+	Partial bool
+	// Unresolved are the keys neither found nor missing on the nodes that responded, in the order given: those not
+	// read before the context was done, and those whose nodes all failed
+	Unresolved []string
+}
+
+// GetMultiFunc reads the given keys, calling fn with each item as soon as the first node holding it responds, rather
+// than waiting for every node. Each key is read from the nodes Get would read it from, and each node is sent its keys
+// in one batch. fn is called from the calling goroutine, at most once per key, with the item under the given key.
+// Keys that are not found are not passed to fn, and nodes with missing data are not synchronised.
+//
+// The error of the first invalid key is returned before anything is read, and nil for no keys. ErrNoHealthyNodes is
+// returned if there are no healthy nodes, and ErrAllNodesFailed if any key could not be read because all of its nodes
+// failed.
+func (client *Client) GetMultiFunc(keys []string, fn func(*Item), opts ...ReadOption) error {
+	_, _, err := client.getMulti(nil, keys, fn, opts)
+	return err
+}
+
+// GetMultiContext reads the given keys as GetMultiFunc does, returning the items that arrived before ctx is done
+// rather than failing the whole batch. If ctx is done first, the result is Partial and the keys not yet read are
+// Unresolved, so the caller can fall back to the source of truth for the keys not in Items only. ctx also sets the
+// context of the reads, as WithContext does.
+//
+// Errors are returned as for GetMultiFunc; with ErrAllNodesFailed, the result holds the items read, and the keys whose
+// nodes all failed are Unresolved.
+func (client *Client) GetMultiContext(ctx context.Context, keys []string, opts ...ReadOption) (*MultiResult, error) {
+	result := &MultiResult{Items: make(map[string]*Item, len(keys))}
+	unresolved, partial, err := client.getMulti(ctx, keys, func(item *Item) {
+		result.Items[item.Key] = item
+	}, append(append([]ReadOption(nil), opts...), WithContext(ctx)))
+	if err != nil && unresolved == nil {
+		return nil, err
+	}
+	result.Partial, result.Unresolved = partial, unresolved
+	return result, err
+}
+
+// getMulti performs GetMultiFunc until ctx, if not nil, is done. It returns the original keys not resolved, and true
+// if ctx was done first. Keys are unresolved if they were not read in time or all their nodes failed.
+func (client *Client) getMulti(ctx context.Context, keys []string, fn func(*Item), opts []ReadOption) ([]string, bool, error) {
+	if client.Disabled() || len(keys) == 0 {
+		return nil, false, nil
+	}
+	start := client.Clock.Now()
+	opID := newOperationID()
+	if err := client.checkQuota(client.newReadOptions("", opts).ctx, 0); err != nil {
+		return nil, false, err
+	}
+
+	// Assign each key to the nodes it is read from
+	keys = uniqueKeys(keys)
+	mappedKeys := make([]string, len(keys))
+	originalKeys := make(map[string]string, len(keys))
+	pending := make(map[string]int, len(keys))
+	batches := map[*Node][]string{}
+	for i, originalKey := range keys {
+		key, err := client.mapKey(originalKey)
+		if err != nil {
+			return nil, false, err
+		}
+		mappedKeys[i] = key
+		originalKeys[key] = originalKey
+		for _, node := range client.readNodes(originalKey, client.newReadOptions(originalKey, opts)) {
+			batches[node] = append(batches[node], key)
+			pending[key]++
+		}
+	}
+	if len(batches) == 0 {
+		return nil, false, ErrNoHealthyNodes
+	}
+
+	// A nil done channel never fires, so without ctx every node is waited for
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	statusChan := make(chan (*multiGetResponse), len(batches))
+	for node, batch := range batches {
+		node.getMulti(opID, batch, statusChan)
+	}
+
+	// Deliver items as the nodes respond. A key is missed if any node responded without it, and failed otherwise.
+	delivered := make(map[string]bool, len(keys))
+	missed := make(map[string]bool, len(keys))
+	failedKeys := map[string]bool{}
+	var errs map[string]error
+	expired := false
+	for nodeCount := len(batches); nodeCount > 0 && !expired; nodeCount-- {
+		var response *multiGetResponse
+		select {
+		case response = <-statusChan:
+		case <-done:
+			// Responses still to come are buffered in statusChan and dropped
+			expired = true
+			continue
+		}
+		if response.err != nil {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[response.node.Endpoint] = response.err
+		}
+		for _, key := range response.keys {
+			pending[key]--
+			item, found := response.items[key]
+			switch {
+			case delivered[key]:
+				continue
+			case found:
+				delivered[key] = true
+				if originalKey := originalKeys[key]; item.Key != originalKey {
+					result := *item
+					result.Key = originalKey
+					item = &result
+				}
+				client.observe(OP_GET, item.Key, start, item, nil)
+				fn(item)
+				continue
+			case response.err == nil:
+				missed[key] = true
+			}
+			if pending[key] > 0 {
+				continue
+			}
+			if missed[key] {
+				client.observe(OP_GET, originalKeys[key], start, nil, memcache.ErrCacheMiss)
+			} else {
+				client.observe(OP_GET, originalKeys[key], start, nil, response.err)
+				failedKeys[key] = true
+			}
+		}
+	}
+
+	var unresolved []string
+	late := 0
+	for i, key := range mappedKeys {
+		switch {
+		case failedKeys[key]:
+			unresolved = append(unresolved, keys[i])
+		case expired && !delivered[key] && !missed[key] && pending[key] > 0:
+			client.observe(OP_GET, keys[i], start, nil, ctx.Err())
+			unresolved = append(unresolved, keys[i])
+			late++
+		}
+	}
+	if late > 0 {
+		client.levelLog.Info("[%s] GetMultiContext: %d of %d keys not read before %s", opID, late, len(keys), ctx.Err())
+	}
+
+	if len(failedKeys) > 0 {
+		client.levelLog.Info("[%s] GetMultiFunc: %d of %d keys not read, all their nodes failed", opID, len(failedKeys), len(keys))
+		return unresolved, late > 0, &ErrAllNodesFailed{Errors: errs}
+	}
+	return unresolved, late > 0, nil
+}
+
+func (node *Node) getMulti(opID string, keys []string, finishChan chan (*multiGetResponse)) {
+	node.submit(func() {
+		start := time.Now()
+		response := node.doGetMulti(opID, keys)
+		node.recordLatency(time.Since(start))
+		node.limiter.release()
+		finishChan <- response
+	})
+}
+
+// doGetMulti reads keys from this node in one request, or one key at a time if its NodeClient cannot read several.
+// Values not written by memcacheha are treated as missing.
+func (node *Node) doGetMulti(opID string, keys []string) *multiGetResponse {
+	node.debug(opID, "GETMULTI %d keys", len(keys))
+	response := &multiGetResponse{node: node, keys: keys, items: make(map[string]*Item, len(keys))}
+
+	client := node.getClient()
+	multiGetter, ok := client.(NodeMultiGetter)
+	if !ok {
+		for _, key := range keys {
+			nodeResponse := node.doGet(opID, key)
+			switch nodeResponse.Error {
+			case nil:
+				response.items[key] = nodeResponse.Item
+			case memcache.ErrCacheMiss, ErrNotMemcacheHAKey:
+			default:
+				response.err = nodeResponse.Error
+			}
+			releaseNodeResponse(nodeResponse)
+			if response.err != nil {
+				break
+			}
+		}
+		return response
+	}
+
+	mcItems, err := multiGetter.GetMulti(keys)
+	nodeResponse := node.getNodeResponse(opID, nil, err)
+	response.err = nodeResponse.Error
+	releaseNodeResponse(nodeResponse)
+	for key, mcItem := range mcItems {
+		if item, err := node.decode(mcItem); err == nil {
+			response.items[key] = item
+		}
+	}
+	return response
+}
@@ -0,0 +1,232 @@
+// Package grpcadmin serves the admin and inspection API of a memcacheha client over gRPC, with the standard gRPC
+// health service, so orchestration tooling can query and manage many application instances programmatically.
+package grpcadmin
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Server implements the Admin service of admin.proto and the gRPC health service for a memcacheha client. Admin
+// calls require the metadata "authorization: Bearer <AdminToken>", as the admin HTTP API; health checks do not. Admin
+// actions are recorded in the client's AuditHook, with the identity of the call context or the peer address.
+type Server struct {
+	grpc_health_v1.UnimplementedHealthServer
+	client *memcacheha.Client
+}
+
+// New returns a new Server for the given client
+func New(client *memcacheha.Client) *Server {
+	return &Server{client: client}
+}
+
+// Register registers a new Server for the given client as the Admin and health services of registrar, e.g. a
+// *grpc.Server
+func Register(registrar grpc.ServiceRegistrar, client *memcacheha.Client) *Server {
+	server := New(client)
+	RegisterAdminServer(registrar, server)
+	grpc_health_v1.RegisterHealthServer(registrar, server)
+	return server
+}
+
+// Check implements grpc_health_v1.HealthServer. The client is SERVING if it is Ready (see ClusterHealth), for the
+// empty service name and SERVICE_NAME.
+func (server *Server) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if req.GetService() != "" && req.GetService() != SERVICE_NAME {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	response := &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}
+	if server.client.ClusterHealth().Ready {
+		response.Status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return response, nil
+}
+
+// State implements AdminServer
+func (server *Server) State(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if err := server.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return toStruct(server.client.DebugState())
+}
+
+// ListNodes implements AdminServer
+func (server *Server) ListNodes(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if err := server.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return toStruct(server.client.ListNodes())
+}
+
+// ForceHealthy implements AdminServer
+func (server *Server) ForceHealthy(ctx context.Context, endpoint *wrapperspb.StringValue) (*structpb.Struct, error) {
+	return server.nodeAction(ctx, memcacheha.AUDIT_FORCE_HEALTHY, endpoint, func(node *memcacheha.Node) { node.ForceHealth(true) })
+}
+
+// ForceUnhealthy implements AdminServer
+func (server *Server) ForceUnhealthy(ctx context.Context, endpoint *wrapperspb.StringValue) (*structpb.Struct, error) {
+	return server.nodeAction(ctx, memcacheha.AUDIT_FORCE_UNHEALTHY, endpoint, func(node *memcacheha.Node) { node.ForceHealth(false) })
+}
+
+// ClearForcedHealth implements AdminServer
+func (server *Server) ClearForcedHealth(ctx context.Context, endpoint *wrapperspb.StringValue) (*structpb.Struct, error) {
+	return server.nodeAction(ctx, memcacheha.AUDIT_CLEAR_FORCED_HEALTH, endpoint, func(node *memcacheha.Node) { node.ClearForcedHealth() })
+}
+
+// DrainNode implements AdminServer
+func (server *Server) DrainNode(ctx context.Context, endpoint *wrapperspb.StringValue) (*structpb.Struct, error) {
+	return server.action(ctx, memcacheha.AUDIT_DRAIN_NODE, endpoint.GetValue(), func(ctx context.Context) error {
+		return server.client.DrainNode(endpoint.GetValue())
+	})
+}
+
+// UndrainNode implements AdminServer
+func (server *Server) UndrainNode(ctx context.Context, endpoint *wrapperspb.StringValue) (*structpb.Struct, error) {
+	return server.action(ctx, memcacheha.AUDIT_UNDRAIN_NODE, endpoint.GetValue(), func(ctx context.Context) error {
+		if !server.client.UndrainNode(endpoint.GetValue()) {
+			return memcacheha.ErrUnknownNode
+		}
+		return nil
+	})
+}
+
+// Discover implements AdminServer
+func (server *Server) Discover(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return server.action(ctx, memcacheha.AUDIT_DISCOVER, "", func(ctx context.Context) error {
+		server.client.GetNodes()
+		return nil
+	})
+}
+
+// Flush implements AdminServer
+func (server *Server) Flush(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	// FlushAllContext records its own AuditRecord
+	return server.action(ctx, "", "", server.client.FlushAllContext)
+}
+
+// Disable implements AdminServer
+func (server *Server) Disable(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return server.action(ctx, memcacheha.AUDIT_DISABLE, "", func(ctx context.Context) error {
+		server.client.Disable()
+		return nil
+	})
+}
+
+// Enable implements AdminServer
+func (server *Server) Enable(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return server.action(ctx, memcacheha.AUDIT_ENABLE, "", func(ctx context.Context) error {
+		server.client.Enable()
+		return nil
+	})
+}
+
+// Repair implements AdminServer
+func (server *Server) Repair(ctx context.Context, key *wrapperspb.StringValue) (*structpb.Struct, error) {
+	if err := server.authorize(ctx); err != nil {
+		return nil, err
+	}
+	_, err := server.client.Get(key.GetValue(), memcacheha.WithReadAll())
+	if err != nil && err != memcache.ErrCacheMiss {
+		return nil, statusError(err)
+	}
+	return toStruct(map[string]interface{}{
+		"found":   err == nil,
+		"repairs": server.client.RepairStats(),
+	})
+}
+
+// nodeAction performs action on the node with the given endpoint, responding with the nodes
+func (server *Server) nodeAction(ctx context.Context, audit string, endpoint *wrapperspb.StringValue, action func(node *memcacheha.Node)) (*structpb.Struct, error) {
+	return server.action(ctx, audit, endpoint.GetValue(), func(ctx context.Context) error {
+		node, err := server.client.GetNode(endpoint.GetValue())
+		if err != nil {
+			return err
+		}
+		action(node)
+		return nil
+	})
+}
+
+// action performs an authorized change, recorded as audit with target unless empty, responding with the nodes
+func (server *Server) action(ctx context.Context, audit string, target string, action func(ctx context.Context) error) (*structpb.Struct, error) {
+	if err := server.authorize(ctx); err != nil {
+		return nil, err
+	}
+	ctx = auditContext(ctx)
+	start := server.client.Clock.Now()
+	err := action(ctx)
+	if audit != "" {
+		server.client.Audit(ctx, audit, target, start, err)
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return toStruct(server.client.ListNodes())
+}
+
+// auditContext returns ctx with the peer address as its audit identity if it has none, e.g. set by an interceptor
+// with memcacheha.WithAuditIdentity
+func auditContext(ctx context.Context) context.Context {
+	if memcacheha.AuditIdentity(ctx) != "" {
+		return ctx
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return memcacheha.WithAuditIdentity(ctx, p.Addr.String())
+	}
+	return ctx
+}
+
+// authorize returns an Unauthenticated error unless the call carries the AdminToken of the client
+func (server *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if server.client.CheckAdminAuthorization(value) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
+
+// statusError returns the gRPC status of a memcacheha error
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, memcacheha.ErrUnknownNode):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, memcacheha.ErrInvalidEndpoint), errors.Is(err, memcacheha.ErrKeyEmpty),
+		errors.Is(err, memcacheha.ErrKeyTooLong), errors.Is(err, memcacheha.ErrKeyInvalidCharacter):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, memcacheha.ErrNoHealthyNodes):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// toStruct returns the JSON of v as a Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
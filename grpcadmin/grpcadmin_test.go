@@ -0,0 +1,295 @@
+package grpcadmin_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/grpcadmin"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+)
+
+// auditLog collects the records delivered to an AuditHook
+type auditLog struct {
+	records []memcacheha.AuditRecord
+	mutex   sync.Mutex
+}
+
+func (log *auditLog) hook(record memcacheha.AuditRecord) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.records = append(log.records, record)
+}
+
+// take returns the records delivered since the last take
+func (log *auditLog) take() []memcacheha.AuditRecord {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	records := log.records
+	log.records = nil
+	return records
+}
+
+// serve registers a Server for client on a gRPC server listening in memory, returning a connection to it
+func serve(t *testing.T, client *memcacheha.Client) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	grpcadmin.Register(server, client)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Connecting failed: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// invoke calls method of the Admin service with in and the given authorization metadata, if not empty
+func invoke(conn *grpc.ClientConn, method string, in proto.Message, authorization string) (*structpb.Struct, error) {
+	ctx := context.Background()
+	if authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+	}
+	out := &structpb.Struct{}
+	if err := conn.Invoke(ctx, "/"+grpcadmin.SERVICE_NAME+"/"+method, in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decode decodes the JSON of out into v
+func decode(t *testing.T, out *structpb.Struct, v interface{}) {
+	t.Helper()
+	data, err := out.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Encoding %v failed: %s", out, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("Decoding %s failed: %s", data, err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t, func(client *memcacheha.Client) { client.AdminToken = "secret" })
+	conn := serve(t, client)
+
+	for name, authorization := range map[string]string{
+		"missing token":    "",
+		"wrong token":      "Bearer wrong",
+		"no bearer prefix": "secret",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := invoke(conn, "Disable", &emptypb.Empty{}, authorization); status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("Disable returned %v, expected Unauthenticated", err)
+			}
+		})
+	}
+	if client.Disabled() {
+		t.Fatal("Client disabled by an unauthenticated call")
+	}
+	if _, err := invoke(conn, "State", &emptypb.Empty{}, "Bearer secret"); err != nil {
+		t.Fatalf("State failed: %s", err)
+	}
+
+	// Without an AdminToken, admin calls are refused
+	client.SetAdminToken("")
+	if _, err := invoke(conn, "State", &emptypb.Empty{}, "Bearer "); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("State without an AdminToken returned %v, expected Unauthenticated", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+	health := grpc_health_v1.NewHealthClient(serve(t, client))
+	ctx := context.Background()
+
+	// Health checks do not need the token
+	for _, service := range []string{"", grpcadmin.SERVICE_NAME} {
+		response, err := health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil || response.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("Check of %q returned %v, %v, expected SERVING", service, response, err)
+		}
+	}
+	if _, err := health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "other"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Check of an unknown service returned %v, expected NotFound", err)
+	}
+
+	for _, node := range client.Nodes.GetNodes() {
+		node.ForceHealth(false)
+	}
+	if response, err := health.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil || response.GetStatus() != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Check without healthy nodes returned %v, %v, expected NOT_SERVING", response, err)
+	}
+}
+
+func TestAdminCalls(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	audit := &auditLog{}
+	client := cluster.NewClient(t, func(client *memcacheha.Client) {
+		client.AdminToken = "secret"
+		client.ClientID = "grpc-test"
+		client.AuditHook = audit.hook
+	})
+	conn := serve(t, client)
+
+	// call performs a call with the token, returning its nodes and the records it audited
+	call := func(t *testing.T, method string, in proto.Message) (*memcacheha.AdminNodes, []memcacheha.AuditRecord) {
+		t.Helper()
+		out, err := invoke(conn, method, in, "Bearer secret")
+		if err != nil {
+			t.Fatalf("%s failed: %s", method, err)
+		}
+		nodes := &memcacheha.AdminNodes{}
+		decode(t, out, nodes)
+		return nodes, audit.take()
+	}
+	forced := func(nodes *memcacheha.AdminNodes, endpoint string) string {
+		for _, node := range nodes.Nodes {
+			if node.Endpoint == endpoint {
+				return node.Forced
+			}
+		}
+		t.Fatalf("%s not listed in %+v", endpoint, nodes)
+		return ""
+	}
+
+	// Inspection calls are not audited
+	state := &memcacheha.DebugState{}
+	out, err := invoke(conn, "State", &emptypb.Empty{}, "Bearer secret")
+	if err != nil {
+		t.Fatalf("State failed: %s", err)
+	}
+	if decode(t, out, state); len(state.Nodes) != 3 {
+		t.Fatalf("State returned %+v, expected 3 nodes", state)
+	}
+	if nodes, records := call(t, "ListNodes", &emptypb.Empty{}); len(nodes.Nodes) != 3 || len(records) != 0 {
+		t.Fatalf("ListNodes returned %+v and audited %+v", nodes, records)
+	}
+
+	node1, node2 := wrapperspb.String("node1:11211"), wrapperspb.String("node2:11211")
+	cluster.AddNode("node4:11211")
+	actions := []struct {
+		method string
+		in     proto.Message
+		action string
+		target string
+		check  func(t *testing.T, nodes *memcacheha.AdminNodes)
+	}{
+		{"ForceUnhealthy", node1, memcacheha.AUDIT_FORCE_UNHEALTHY, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "unhealthy" {
+				t.Fatalf("node1 not forced unhealthy: %+v", nodes)
+			}
+		}},
+		{"ForceHealthy", node1, memcacheha.AUDIT_FORCE_HEALTHY, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "healthy" {
+				t.Fatalf("node1 not forced healthy: %+v", nodes)
+			}
+		}},
+		{"ClearForcedHealth", node1, memcacheha.AUDIT_CLEAR_FORCED_HEALTH, "node1:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if forced(nodes, "node1:11211") != "" {
+				t.Fatalf("node1 still forced: %+v", nodes)
+			}
+		}},
+		{"DrainNode", node2, memcacheha.AUDIT_DRAIN_NODE, "node2:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Drained) != 1 || nodes.Drained[0] != "node2:11211" {
+				t.Fatalf("node2 not drained: %+v", nodes)
+			}
+		}},
+		{"UndrainNode", node2, memcacheha.AUDIT_UNDRAIN_NODE, "node2:11211", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Drained) != 0 {
+				t.Fatalf("node2 still drained: %+v", nodes)
+			}
+		}},
+		{"Disable", &emptypb.Empty{}, memcacheha.AUDIT_DISABLE, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if !nodes.Disabled || !client.Disabled() {
+				t.Fatalf("Client not disabled: %+v", nodes)
+			}
+		}},
+		{"Enable", &emptypb.Empty{}, memcacheha.AUDIT_ENABLE, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if nodes.Disabled || client.Disabled() {
+				t.Fatalf("Client still disabled: %+v", nodes)
+			}
+		}},
+		{"Discover", &emptypb.Empty{}, memcacheha.AUDIT_DISCOVER, "", func(t *testing.T, nodes *memcacheha.AdminNodes) {
+			if len(nodes.Nodes) != 4 {
+				t.Fatalf("Added node not discovered: %+v", nodes)
+			}
+		}},
+	}
+	for _, action := range actions {
+		t.Run(action.method, func(t *testing.T) {
+			nodes, records := call(t, action.method, action.in)
+			if len(records) != 1 {
+				t.Fatalf("%s audited %+v, expected one record", action.method, records)
+			}
+			record := records[0]
+			if record.Action != action.action || record.Target != action.target || record.Error != "" {
+				t.Fatalf("%s audited %+v, expected %s of %q without error", action.method, record, action.action, action.target)
+			}
+			if record.ClientID != "grpc-test" || record.Identity != "bufconn" {
+				t.Fatalf("%s audited %+v, expected the client ID and peer address", action.method, record)
+			}
+			action.check(t, nodes)
+		})
+	}
+
+	// Failed calls return the status of their error, and are audited with it
+	failures := []struct {
+		method string
+		in     proto.Message
+		code   codes.Code
+	}{
+		{"ForceHealthy", wrapperspb.String("unknown:11211"), codes.NotFound},
+		{"UndrainNode", node2, codes.NotFound},
+		{"DrainNode", wrapperspb.String("a:b:c"), codes.InvalidArgument},
+	}
+	for _, failure := range failures {
+		if _, err := invoke(conn, failure.method, failure.in, "Bearer secret"); status.Code(err) != failure.code {
+			t.Fatalf("%s returned %v, expected %s", failure.method, err, failure.code)
+		}
+		if records := audit.take(); len(records) != 1 || records[0].Error == "" {
+			t.Fatalf("%s audited %+v, expected one record with its error", failure.method, records)
+		}
+	}
+
+	// Repair reads a key from every node, repairing those missing it
+	cluster.Put(&memcacheha.Item{Key: "key", Value: []byte("value")}, "node1:11211")
+	out, err = invoke(conn, "Repair", wrapperspb.String("key"), "Bearer secret")
+	if err != nil {
+		t.Fatalf("Repair failed: %s", err)
+	}
+	repair := struct {
+		Found   bool                   `json:"found"`
+		Repairs memcacheha.RepairStats `json:"repairs"`
+	}{}
+	if decode(t, out, &repair); !repair.Found || repair.Repairs.Performed == 0 {
+		t.Fatalf("Repair returned %+v", repair)
+	}
+	if _, err := invoke(conn, "Repair", wrapperspb.String(""), "Bearer secret"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Repair of an empty key returned %v, expected InvalidArgument", err)
+	}
+
+	// Flush removes all items, audited by the client
+	if _, records := call(t, "Flush", &emptypb.Empty{}); len(records) != 1 || records[0].Action != memcacheha.AUDIT_FLUSH_ALL || records[0].Identity != "bufconn" {
+		t.Fatalf("Flush audited %+v", records)
+	}
+	cluster.AssertValue(t, "key", nil)
+}
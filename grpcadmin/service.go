@@ -0,0 +1,93 @@
+package grpcadmin
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"context"
+)
+
+// SERVICE_NAME is the full name of the Admin service in admin.proto
+const SERVICE_NAME = "memcacheha.admin.v1.Admin"
+
+// AdminServer is the server API of the Admin service in admin.proto
+type AdminServer interface {
+	State(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	ListNodes(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	ForceHealthy(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	ForceUnhealthy(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	ClearForcedHealth(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	DrainNode(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	UndrainNode(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	Discover(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Flush(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Disable(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Enable(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Repair(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+}
+
+// RegisterAdminServer registers srv as the Admin service of registrar, e.g. a *grpc.Server
+func RegisterAdminServer(registrar grpc.ServiceRegistrar, srv AdminServer) {
+	registrar.RegisterService(&adminServiceDesc, srv)
+}
+
+// adminServiceDesc is written by hand as the Admin service uses only well-known message types
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: SERVICE_NAME,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "State", Handler: emptyHandler("State", AdminServer.State)},
+		{MethodName: "ListNodes", Handler: emptyHandler("ListNodes", AdminServer.ListNodes)},
+		{MethodName: "ForceHealthy", Handler: stringHandler("ForceHealthy", AdminServer.ForceHealthy)},
+		{MethodName: "ForceUnhealthy", Handler: stringHandler("ForceUnhealthy", AdminServer.ForceUnhealthy)},
+		{MethodName: "ClearForcedHealth", Handler: stringHandler("ClearForcedHealth", AdminServer.ClearForcedHealth)},
+		{MethodName: "DrainNode", Handler: stringHandler("DrainNode", AdminServer.DrainNode)},
+		{MethodName: "UndrainNode", Handler: stringHandler("UndrainNode", AdminServer.UndrainNode)},
+		{MethodName: "Discover", Handler: emptyHandler("Discover", AdminServer.Discover)},
+		{MethodName: "Flush", Handler: emptyHandler("Flush", AdminServer.Flush)},
+		{MethodName: "Disable", Handler: emptyHandler("Disable", AdminServer.Disable)},
+		{MethodName: "Enable", Handler: emptyHandler("Enable", AdminServer.Enable)},
+		{MethodName: "Repair", Handler: stringHandler("Repair", AdminServer.Repair)},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
+
+// unaryHandler is the signature of grpc.MethodDesc handlers
+type unaryHandler = func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+// emptyHandler returns the handler of a method taking google.protobuf.Empty
+func emptyHandler(method string, call func(AdminServer, context.Context, *emptypb.Empty) (*structpb.Struct, error)) unaryHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(emptypb.Empty)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(AdminServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + SERVICE_NAME + "/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(AdminServer), ctx, req.(*emptypb.Empty))
+		})
+	}
+}
+
+// stringHandler returns the handler of a method taking google.protobuf.StringValue
+func stringHandler(method string, call func(AdminServer, context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)) unaryHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(wrapperspb.StringValue)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(AdminServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + SERVICE_NAME + "/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(AdminServer), ctx, req.(*wrapperspb.StringValue))
+		})
+	}
+}
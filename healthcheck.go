@@ -0,0 +1,178 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// HEALTHCHECK_KEY_PREFIX is the default prefix of the random keys read by HEALTHCHECK_PROBE_GET
+var HEALTHCHECK_KEY_PREFIX = "memcacheha_probe_"
+
+// HealthCheckProbe decides the request a node healthcheck sends
+type HealthCheckProbe int
+
+const (
+	// HEALTHCHECK_PROBE_GET reads a random key with the client's HealthCheckKeyPrefix, expecting a miss. It checks
+	// the read path, but counts as a get miss in the memcached stats.
+	HEALTHCHECK_PROBE_GET HealthCheckProbe = iota
+	// HEALTHCHECK_PROBE_VERSION sends the version command, which touches no keys or stats. Node clients without
+	// a Ping method use HEALTHCHECK_PROBE_GET.
+	HEALTHCHECK_PROBE_VERSION
+)
+
+// ErrUnknownHealthCheckProbe is an error meaning a HealthCheckProbe name is not get or version
+var ErrUnknownHealthCheckProbe = errors.New("memcacheha: unknown healthcheck probe")
+
+var healthCheckProbeNames = map[HealthCheckProbe]string{
+	HEALTHCHECK_PROBE_GET:     "get",
+	HEALTHCHECK_PROBE_VERSION: "version",
+}
+
+// UnmarshalText parses a probe name: get or version
+func (probe *HealthCheckProbe) UnmarshalText(text []byte) error {
+	for p, name := range healthCheckProbeNames {
+		if name == string(text) {
+			*probe = p
+			return nil
+		}
+	}
+	return ErrUnknownHealthCheckProbe
+}
+
+// MarshalText returns the probe name
+func (probe HealthCheckProbe) MarshalText() ([]byte, error) {
+	name, found := healthCheckProbeNames[probe]
+	if !found {
+		return nil, ErrUnknownHealthCheckProbe
+	}
+	return []byte(name), nil
+}
+
+// healthCheckProbe returns the probe and key prefix of node healthchecks
+func (client *Client) healthCheckProbe() (HealthCheckProbe, string) {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.HealthCheckProbe, client.HealthCheckKeyPrefix
+}
+
+// probe sends the healthcheck probe of this node, returning nil if the node responded
+func (node *Node) probe() error {
+	probe, prefix := HEALTHCHECK_PROBE_GET, HEALTHCHECK_KEY_PREFIX
+	if node.probeConfig != nil {
+		probe, prefix = node.probeConfig()
+	}
+
+	client := node.getClient()
+	if pinger, ok := client.(NodePinger); ok && probe == HEALTHCHECK_PROBE_VERSION {
+		return pinger.Ping()
+	}
+
+	// Read a random key, expect ErrCacheMiss
+	x := make([]byte, 16)
+	if _, err := rand.Read(x); err != nil {
+		return err
+	}
+	_, err := client.Get(prefix + hex.EncodeToString(x))
+	if err == memcache.ErrCacheMiss {
+		err = nil
+	}
+	return err
+}
+
+// updateStats reads the version and uptime of this node's server if they are older than NODE_STATS_PERIOD and its
+// NodeClient can read them. Failures leave the previous values.
+func (node *Node) updateStats() {
+	statter, ok := node.getClient().(NodeStatter)
+	if !ok {
+		return
+	}
+	node.historyMutex.Lock()
+	due := node.clock.Now().Sub(node.statsTime) >= NODE_STATS_PERIOD
+	node.historyMutex.Unlock()
+	if !due {
+		return
+	}
+
+	stats, err := statter.Stats()
+	if err != nil {
+		node.Log.Debug("Reading stats failed: %s", err)
+		return
+	}
+	uptime, _ := strconv.Atoi(stats["uptime"])
+
+	node.historyMutex.Lock()
+	defer node.historyMutex.Unlock()
+	node.version = stats["version"]
+	node.uptime = time.Duration(uptime) * time.Second
+	node.statsTime = node.clock.Now()
+}
+
+// serverStats returns the last version and current uptime of this node's server, if known
+func (node *Node) serverStats() (string, time.Duration) {
+	node.historyMutex.Lock()
+	defer node.historyMutex.Unlock()
+	if node.statsTime.IsZero() {
+		return "", 0
+	}
+	return node.version, node.uptime + node.clock.Now().Sub(node.statsTime).Truncate(time.Second)
+}
+
+// LastHealthCheckResult returns the result of the most recent healthcheck of this node, and false if there has been
+// none
+func (node *Node) LastHealthCheckResult() (HealthCheckResult, bool) {
+	node.historyMutex.Lock()
+	defer node.historyMutex.Unlock()
+	if len(node.history) == 0 {
+		return HealthCheckResult{}, false
+	}
+	last := node.historyNext - 1
+	if last < 0 {
+		last = len(node.history) - 1
+	}
+	return node.history[last], true
+}
+
+// ClusterHealth summarises the health of all nodes of a client, e.g. for a readiness probe
+type ClusterHealth struct {
+	// Ready is true if any node is healthy and no partition is suspected
+	Ready     bool `json:"ready"`
+	Total     int  `json:"total"`
+	Healthy   int  `json:"healthy"`
+	Unhealthy int  `json:"unhealthy"`
+	// Degraded is the number of healthy nodes not read from because of their latency, see Node.IsDegraded
+	Degraded int `json:"degraded"`
+	// Partition is the error of PartitionStatus, if any
+	Partition string `json:"partition,omitempty"`
+	// Nodes are the results of the last healthcheck of each node, by endpoint
+	Nodes map[string]HealthCheckResult `json:"nodes"`
+}
+
+// ClusterHealth returns the health of all nodes of this client from their last healthchecks
+func (client *Client) ClusterHealth() ClusterHealth {
+	health := ClusterHealth{Nodes: map[string]HealthCheckResult{}}
+	for endpoint, node := range client.Nodes.GetNodes() {
+		health.Total++
+		switch {
+		case !node.IsHealthy():
+			health.Unhealthy++
+		case node.IsDegraded():
+			health.Degraded++
+			health.Healthy++
+		default:
+			health.Healthy++
+		}
+		if result, found := node.LastHealthCheckResult(); found {
+			health.Nodes[endpoint] = result
+		}
+	}
+	if err := client.PartitionStatus(); err != nil {
+		health.Partition = err.Error()
+	}
+	health.Ready = health.Healthy > 0 && health.Partition == ""
+	return health
+}
@@ -0,0 +1,163 @@
+package memcacheha
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// HOTKEY_SKETCH_WIDTH is the number of counters per row of the count-min sketch used to track hot keys
+	HOTKEY_SKETCH_WIDTH = 2048
+	// HOTKEY_SKETCH_DEPTH is the number of rows of the count-min sketch used to track hot keys
+	HOTKEY_SKETCH_DEPTH = 4
+	// HOTKEY_TOP_K is the number of hot keys tracked
+	HOTKEY_TOP_K = 100
+	// HOTKEY_DECAY_PERIOD is the period after which all hot key counts are halved, so counts reflect recent accesses
+	HOTKEY_DECAY_PERIOD time.Duration = time.Duration(1 * time.Minute)
+	// HOTKEY_LOG_PERIOD is the period between logging the top hot keys
+	HOTKEY_LOG_PERIOD time.Duration = time.Duration(1 * time.Minute)
+	// HOTKEY_LOG_COUNT is the number of hot keys logged every HOTKEY_LOG_PERIOD
+	HOTKEY_LOG_COUNT = 10
+)
+
+// HotKey is a frequently accessed key and its estimated access count
+type HotKey struct {
+	Key   string
+	Count uint64
+}
+
+// hotKeyTracker estimates the most frequently accessed keys with a count-min sketch and a top-k min-heap
+type hotKeyTracker struct {
+	mutex  sync.Mutex
+	sketch [][]uint64
+	top    hotKeyHeap
+	index  map[string]*hotKeyEntry
+	k      int
+}
+
+type hotKeyEntry struct {
+	HotKey
+	position int
+}
+
+func newHotKeyTracker(width int, depth int, k int) *hotKeyTracker {
+	sketch := make([][]uint64, depth)
+	for i := range sketch {
+		sketch[i] = make([]uint64, width)
+	}
+	return &hotKeyTracker{
+		sketch: sketch,
+		index:  map[string]*hotKeyEntry{},
+		k:      k,
+	}
+}
+
+// Add records an access of the given key
+func (tracker *hotKeyTracker) Add(key string) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	// Increment each row, the estimate is the minimum
+	var estimate uint64
+	width := uint64(len(tracker.sketch[0]))
+	for i, row := range tracker.sketch {
+		j := (h1 + uint64(i)*h2) % width
+		row[j]++
+		if i == 0 || row[j] < estimate {
+			estimate = row[j]
+		}
+	}
+
+	if entry, found := tracker.index[key]; found {
+		entry.Count = estimate
+		heap.Fix(&tracker.top, entry.position)
+		return
+	}
+	if len(tracker.top) < tracker.k {
+		entry := &hotKeyEntry{HotKey: HotKey{Key: key, Count: estimate}}
+		heap.Push(&tracker.top, entry)
+		tracker.index[key] = entry
+		return
+	}
+	if estimate > tracker.top[0].Count {
+		evicted := tracker.top[0]
+		delete(tracker.index, evicted.Key)
+		evicted.Key = key
+		evicted.Count = estimate
+		tracker.index[key] = evicted
+		heap.Fix(&tracker.top, 0)
+	}
+}
+
+// Top returns up to n hot keys, most frequently accessed first
+func (tracker *hotKeyTracker) Top(n int) []HotKey {
+	tracker.mutex.Lock()
+	out := make([]HotKey, 0, len(tracker.top))
+	for _, entry := range tracker.top {
+		out = append(out, entry.HotKey)
+	}
+	tracker.mutex.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	if n >= 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Decay halves all counts
+func (tracker *hotKeyTracker) Decay() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for _, row := range tracker.sketch {
+		for j := range row {
+			row[j] /= 2
+		}
+	}
+	for _, entry := range tracker.top {
+		entry.Count /= 2
+	}
+}
+
+// hotKeyHeap is a min-heap of hot keys by count, implementing heap.Interface
+type hotKeyHeap []*hotKeyEntry
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position = i
+	h[j].position = j
+}
+func (h *hotKeyHeap) Push(x interface{}) {
+	entry := x.(*hotKeyEntry)
+	entry.position = len(*h)
+	*h = append(*h, entry)
+}
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// HotKeys returns up to n of the most frequently accessed keys with their estimated access counts, most frequent
+// first. TrackHotKeys must be enabled.
+func (client *Client) HotKeys(n int) []HotKey {
+	return client.hotKeys.Top(n)
+}
+
+// logHotKeys logs the top HOTKEY_LOG_COUNT hot keys
+func (client *Client) logHotKeys() {
+	for i, hotKey := range client.HotKeys(HOTKEY_LOG_COUNT) {
+		client.levelLog.Info("HotKeys: #%d %s (%d)", i+1, client.LogKey(hotKey.Key), hotKey.Count)
+	}
+}
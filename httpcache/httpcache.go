@@ -0,0 +1,188 @@
+// Package httpcache provides http middleware caching GET responses in a memcacheha cluster.
+package httpcache
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// HTTPCACHE_KEY_PREFIX is the default prefix of the memcache keys of cached responses
+	HTTPCACHE_KEY_PREFIX = "httpcache_"
+	// HTTPCACHE_MAX_BODY is the size in bytes of the largest response body that will be cached
+	HTTPCACHE_MAX_BODY = 1000 * 1000
+	// HTTPCACHE_STATUS_HEADER is the response header set to HIT or MISS
+	HTTPCACHE_STATUS_HEADER = "X-Cache"
+)
+
+// Cache is http middleware caching the responses of GET requests
+type Cache struct {
+	Client *memcacheha.Client
+	// TTL is the expiry of cached responses, measured on the client's Clock. Zero or less stores responses without
+	// expiry.
+	TTL time.Duration
+	// KeyPrefix is the prefix of the memcache keys of cached responses
+	KeyPrefix string
+	// VaryHeaders are the request headers whose values, in addition to the URL, identify a cached response
+	VaryHeaders []string
+	// Bypass returns true for requests that should not be served from or stored in the cache. Defaults to
+	// DefaultBypass.
+	Bypass func(r *http.Request) bool
+}
+
+// cachedResponse is a stored response
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// New returns a new Cache using the given client, caching responses for ttl, or without expiry if ttl is zero or less
+func New(client *memcacheha.Client, ttl time.Duration) *Cache {
+	return &Cache{
+		Client:    client,
+		TTL:       ttl,
+		KeyPrefix: HTTPCACHE_KEY_PREFIX,
+		Bypass:    DefaultBypass,
+	}
+}
+
+// DefaultBypass bypasses the cache for requests that are not GET, carry an Authorization header, or have a
+// Cache-Control of no-cache or no-store
+func DefaultBypass(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+		return true
+	}
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// Middleware returns next wrapped with response caching
+func (cache *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cache.Bypass != nil && cache.Bypass(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cache.key(r)
+		if cache.serve(w, key) {
+			return
+		}
+
+		w.Header().Set(HTTPCACHE_STATUS_HEADER, "MISS")
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.cacheable() {
+			cache.store(key, recorder)
+		}
+	})
+}
+
+// key returns the memcache key of the response to r, from its URL and VaryHeaders
+func (cache *Cache) key(r *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.URL.String()))
+	for _, header := range cache.VaryHeaders {
+		hash.Write([]byte{0})
+		hash.Write([]byte(strings.Join(r.Header.Values(header), ",")))
+	}
+	return cache.KeyPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+// serve writes the cached response for key, returning false on a miss
+func (cache *Cache) serve(w http.ResponseWriter, key string) bool {
+	item, err := cache.Client.Get(key)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			cache.Client.Log.Warn("HTTPCache: Get %s failed: %s", cache.Client.LogKey(key), err)
+		}
+		return false
+	}
+	response := &cachedResponse{}
+	if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(response); err != nil {
+		cache.Client.Log.Warn("HTTPCache: Decoding %s failed: %s", cache.Client.LogKey(key), err)
+		return false
+	}
+
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(HTTPCACHE_STATUS_HEADER, "HIT")
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+	return true
+}
+
+// store writes the recorded response to the cache
+func (cache *Cache) store(key string, recorder *responseRecorder) {
+	header := recorder.Header().Clone()
+	header.Del(HTTPCACHE_STATUS_HEADER)
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(&cachedResponse{
+		Status: recorder.status,
+		Header: header,
+		Body:   recorder.body.Bytes(),
+	})
+	if err != nil {
+		cache.Client.Log.Warn("HTTPCache: Encoding %s failed: %s", cache.Client.LogKey(key), err)
+		return
+	}
+	item := &memcacheha.Item{Key: key, Value: buf.Bytes()}
+	if cache.TTL > 0 {
+		expiration := cache.Client.Clock.Now().Add(cache.TTL)
+		item.Expiration = &expiration
+	}
+	err = cache.Client.Set(item)
+	if err != nil {
+		cache.Client.Log.Warn("HTTPCache: Set %s failed: %s", cache.Client.LogKey(key), err)
+	}
+}
+
+// responseRecorder writes a response through to the client while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+// WriteHeader records and writes the status code
+func (recorder *responseRecorder) WriteHeader(statusCode int) {
+	recorder.status = statusCode
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records and writes data, recording no more than HTTPCACHE_MAX_BODY bytes
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	if !recorder.tooLarge {
+		if recorder.body.Len()+len(data) > HTTPCACHE_MAX_BODY {
+			recorder.tooLarge = true
+			recorder.body.Reset()
+		} else {
+			recorder.body.Write(data)
+		}
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// cacheable returns true if the recorded response is a complete 200 OK that permits caching
+func (recorder *responseRecorder) cacheable() bool {
+	if recorder.status != http.StatusOK || recorder.tooLarge {
+		return false
+	}
+	header := recorder.Header()
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
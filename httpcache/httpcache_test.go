@@ -0,0 +1,156 @@
+package httpcache_test
+
+import (
+	"github.com/apitalent/memcacheha/httpcache"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingHandler responds with status and body, counting the requests it serves
+type countingHandler struct {
+	status int
+	header http.Header
+	body   string
+	calls  int
+}
+
+func (handler *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler.calls++
+	for name, values := range handler.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(handler.status)
+	w.Write([]byte(handler.body))
+}
+
+// serve sends r through handler, returning the response
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func assertResponse(t *testing.T, response *httptest.ResponseRecorder, status string, body string) {
+	t.Helper()
+	if got := response.Header().Get(httpcache.HTTPCACHE_STATUS_HEADER); got != status {
+		t.Fatalf("%s is %q, expected %q", httpcache.HTTPCACHE_STATUS_HEADER, got, status)
+	}
+	if response.Code != http.StatusOK || response.Body.String() != body {
+		t.Fatalf("Response is %d %q, expected 200 %q", response.Code, response.Body.String(), body)
+	}
+}
+
+func TestMiddlewareHitAndMiss(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Now())
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	client := cluster.NewClient(t)
+	handler := &countingHandler{status: http.StatusOK, header: http.Header{"Content-Type": {"text/plain"}}, body: "hello"}
+	cache := httpcache.New(client, time.Minute)
+	cache.VaryHeaders = []string{"Accept-Language"}
+	middleware := cache.Middleware(handler)
+
+	assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil)), "MISS", "hello")
+	response := serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assertResponse(t, response, "HIT", "hello")
+	if response.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("Cached response has Content-Type %q, expected text/plain", response.Header().Get("Content-Type"))
+	}
+	if handler.calls != 1 {
+		t.Fatalf("Handler called %d times, expected once", handler.calls)
+	}
+
+	// Other URLs and vary header values are other responses
+	assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/other", nil)), "MISS", "hello")
+	r := httptest.NewRequest(http.MethodGet, "/page", nil)
+	r.Header.Set("Accept-Language", "fr")
+	assertResponse(t, serve(middleware, r), "MISS", "hello")
+
+	// Responses expire after the TTL of the client's clock
+	clk.Advance(time.Minute + time.Second)
+	assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil)), "MISS", "hello")
+	if handler.calls != 4 {
+		t.Fatalf("Handler called %d times, expected 4", handler.calls)
+	}
+}
+
+func TestMiddlewareWithoutTTL(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+	handler := &countingHandler{status: http.StatusOK, body: "hello"}
+
+	for name, cache := range map[string]*httpcache.Cache{
+		"zero value": {Client: client, KeyPrefix: "zero_"},
+		"zero ttl":   httpcache.New(client, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			middleware := cache.Middleware(handler)
+			assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil)), "MISS", "hello")
+			assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil)), "HIT", "hello")
+		})
+	}
+}
+
+func TestMiddlewareBypass(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+	handler := &countingHandler{status: http.StatusOK, body: "hello"}
+	middleware := httpcache.New(client, time.Minute).Middleware(handler)
+
+	requests := map[string]func() *http.Request{
+		"post": func() *http.Request { return httptest.NewRequest(http.MethodPost, "/page", nil) },
+		"authorization": func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/page", nil)
+			r.Header.Set("Authorization", "Bearer token")
+			return r
+		},
+		"no-cache": func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/page", nil)
+			r.Header.Set("Cache-Control", "no-cache")
+			return r
+		},
+	}
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			calls := handler.calls
+			for i := 0; i < 2; i++ {
+				response := serve(middleware, request())
+				if status := response.Header().Get(httpcache.HTTPCACHE_STATUS_HEADER); status != "" {
+					t.Fatalf("Bypassed request has %s %q", httpcache.HTTPCACHE_STATUS_HEADER, status)
+				}
+			}
+			if handler.calls != calls+2 {
+				t.Fatalf("Handler called %d times, expected twice", handler.calls-calls)
+			}
+		})
+	}
+
+	// Bypassed requests are not stored either
+	assertResponse(t, serve(middleware, httptest.NewRequest(http.MethodGet, "/page", nil)), "MISS", "hello")
+}
+
+func TestMiddlewareUncacheableResponses(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+
+	handlers := map[string]*countingHandler{
+		"not-found":  {status: http.StatusNotFound, body: "missing"},
+		"set-cookie": {status: http.StatusOK, header: http.Header{"Set-Cookie": {"session=1"}}, body: "hello"},
+		"private":    {status: http.StatusOK, header: http.Header{"Cache-Control": {"private"}}, body: "hello"},
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			middleware := httpcache.New(client, time.Minute).Middleware(handler)
+			for i := 0; i < 2; i++ {
+				response := serve(middleware, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+				if status := response.Header().Get(httpcache.HTTPCACHE_STATUS_HEADER); status != "MISS" {
+					t.Fatalf("%s is %q, expected MISS", httpcache.HTTPCACHE_STATUS_HEADER, status)
+				}
+			}
+			if handler.calls != 2 {
+				t.Fatalf("Handler called %d times, expected twice", handler.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+//go:build integration
+
+// Integration tests against real memcached containers. Requires docker:
+//
+//	go test -tags integration ./...
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	// INTEGRATION_MEMCACHED_IMAGE is the docker image used for integration tests
+	INTEGRATION_MEMCACHED_IMAGE = "memcached"
+	// INTEGRATION_MEMCACHED_TAG is the docker image tag used for integration tests
+	INTEGRATION_MEMCACHED_TAG = "1.6-alpine"
+)
+
+// memcachedContainer is a memcached docker container bound to a fixed host port, so it keeps its endpoint across restarts
+type memcachedContainer struct {
+	Endpoint string
+	resource *dockertest.Resource
+	pool     *dockertest.Pool
+}
+
+func newPool(t *testing.T) *dockertest.Pool {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Could not connect to docker: %s", err)
+	}
+	pool.MaxWait = 30 * time.Second
+	return pool
+}
+
+func startMemcached(t *testing.T, pool *dockertest.Pool) *memcachedContainer {
+	t.Helper()
+
+	// Reserve a free host port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not reserve a port: %s", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   INTEGRATION_MEMCACHED_IMAGE,
+		Tag:          INTEGRATION_MEMCACHED_TAG,
+		ExposedPorts: []string{"11211/tcp"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"11211/tcp": {{HostIP: "127.0.0.1", HostPort: strconv.Itoa(port)}},
+		},
+	}, func(config *docker.HostConfig) {
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("Could not start memcached: %s", err)
+	}
+
+	container := &memcachedContainer{
+		Endpoint: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		resource: resource,
+		pool:     pool,
+	}
+	t.Cleanup(func() {
+		pool.Purge(resource)
+	})
+	container.waitReady(t)
+	return container
+}
+
+// Stop kills the container
+func (container *memcachedContainer) Stop(t *testing.T) {
+	t.Helper()
+	if err := container.pool.Client.StopContainer(container.resource.Container.ID, 0); err != nil {
+		t.Fatalf("Could not stop %s: %s", container.Endpoint, err)
+	}
+}
+
+// Start restarts a stopped container. memcached restarts empty.
+func (container *memcachedContainer) Start(t *testing.T) {
+	t.Helper()
+	if err := container.pool.Client.StartContainer(container.resource.Container.ID, nil); err != nil {
+		t.Fatalf("Could not start %s: %s", container.Endpoint, err)
+	}
+	container.waitReady(t)
+}
+
+func (container *memcachedContainer) waitReady(t *testing.T) {
+	t.Helper()
+	err := container.pool.Retry(func() error {
+		return memcache.New(container.Endpoint).Ping()
+	})
+	if err != nil {
+		t.Fatalf("memcached %s did not become ready: %s", container.Endpoint, err)
+	}
+}
+
+func newIntegrationClient(t *testing.T, count int) (*Client, []*memcachedContainer) {
+	pool := newPool(t)
+	var containers []*memcachedContainer
+	var endpoints []string
+	for i := 0; i < count; i++ {
+		container := startMemcached(t, pool)
+		containers = append(containers, container)
+		endpoints = append(endpoints, container.Endpoint)
+	}
+
+	client := New(logger.NewConsoleLogger("error"), NewStaticNodeSource(endpoints...))
+	client.GetNodes()
+	if client.Nodes.GetHealthyNodeCount() != count {
+		t.Fatalf("Expected %d healthy nodes, got %d", count, client.Nodes.GetHealthyNodeCount())
+	}
+	return client, containers
+}
+
+func TestIntegrationFailover(t *testing.T) {
+	client, containers := newIntegrationClient(t, 3)
+
+	if err := client.Set(&Item{Key: "failover", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	containers[0].Stop(t)
+	client.HealthCheck()
+	if client.Nodes.GetHealthyNodeCount() != 2 {
+		t.Fatalf("Expected 2 healthy nodes after stop, got %d", client.Nodes.GetHealthyNodeCount())
+	}
+
+	item, err := client.Get("failover", WithReadAll())
+	if err != nil {
+		t.Fatalf("Get after failover failed: %s", err)
+	}
+	if string(item.Value) != "value" {
+		t.Fatalf("Get after failover returned %q", item.Value)
+	}
+	if err := client.Set(&Item{Key: "failover", Value: []byte("updated")}); err != nil {
+		t.Fatalf("Set after failover failed: %s", err)
+	}
+}
+
+func TestIntegrationReadRepair(t *testing.T) {
+	client, containers := newIntegrationClient(t, 3)
+
+	if err := client.Set(&Item{Key: "repair", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	// Restart a node, which rejoins empty
+	containers[0].Stop(t)
+	client.HealthCheck()
+	containers[0].Start(t)
+	client.HealthCheck()
+	if client.Nodes.GetHealthyNodeCount() != 3 {
+		t.Fatalf("Expected 3 healthy nodes after restart, got %d", client.Nodes.GetHealthyNodeCount())
+	}
+
+	if _, err := client.Get("repair", WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+
+	// Repair writes are asynchronous
+	raw := memcache.New(containers[0].Endpoint)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mcItem, err := raw.Get("repair")
+		if err == nil {
+			item, err := NewItemFromMemcacheItem(mcItem)
+			if err != nil {
+				t.Fatalf("Repaired item is not a memcacheha item: %s", err)
+			}
+			if string(item.Value) != "value" {
+				t.Fatalf("Repaired item has value %q", item.Value)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Restarted node was not repaired: %s", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIntegrationAllNodesDown(t *testing.T) {
+	client, containers := newIntegrationClient(t, 2)
+
+	for _, container := range containers {
+		container.Stop(t)
+	}
+	client.HealthCheck()
+
+	if _, err := client.Get("down"); err != ErrNoHealthyNodes {
+		t.Fatalf("Expected ErrNoHealthyNodes, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package memcacheha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"sync"
+)
+
+// INTEGRITY_HEADER marks values signed by an Integrity. It is followed by the big-endian key ID and the value, and the
+// value by its HMAC-SHA256.
+var INTEGRITY_HEADER []byte = []byte{0xfd, 0x37, 0xd3, 0x1e}
+
+// INTEGRITY_MIN_KEY_SIZE is the minimum size in bytes of an Integrity key
+var INTEGRITY_MIN_KEY_SIZE = 16
+
+// integrityOverhead is the number of bytes an Integrity adds to a value: header, key ID and HMAC
+const integrityOverhead = 4 + 4 + sha256.Size
+
+// IntegrityStatus describes the keys of an Integrity, without the key material
+type IntegrityStatus struct {
+	CurrentKey    uint32   `json:"current_key"`
+	Keys          []uint32 `json:"keys"`
+	AllowUnsigned bool     `json:"allow_unsigned,omitempty"`
+}
+
+// Integrity signs item values with an HMAC-SHA256 of the item's key, flags and value before they are sent to the
+// nodes, and verifies them when read, see Client.Integrity. Values that fail verification are read as missing, so a
+// corrupted or modified value is never returned and is repaired from the other nodes. Values are signed with the
+// current key and store its ID, so keys are rotated as for Encryption.
+//
+// The zero value has no keys: writes fail with ErrUnknownIntegrityKey until the current key, ID 0 unless changed with
+// SetCurrentKey, is added.
+type Integrity struct {
+	mutex         sync.RWMutex
+	keys          map[uint32][]byte
+	current       uint32
+	allowUnsigned bool
+}
+
+// NewIntegrity returns an Integrity with the given key, at least INTEGRITY_MIN_KEY_SIZE bytes, as its current key
+func NewIntegrity(keyID uint32, key []byte) (*Integrity, error) {
+	integrity := &Integrity{keys: map[uint32][]byte{}}
+	if err := integrity.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	integrity.current = keyID
+	return integrity, nil
+}
+
+// AddKey adds or replaces the key with the given ID, used to verify values signed with it. ErrIntegrityKeyTooShort is
+// returned if it is shorter than INTEGRITY_MIN_KEY_SIZE.
+func (integrity *Integrity) AddKey(keyID uint32, key []byte) error {
+	if len(key) < INTEGRITY_MIN_KEY_SIZE {
+		return ErrIntegrityKeyTooShort
+	}
+	integrity.mutex.Lock()
+	defer integrity.mutex.Unlock()
+	if integrity.keys == nil {
+		integrity.keys = map[uint32][]byte{}
+	}
+	integrity.keys[keyID] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetCurrentKey signs values written from now on with the key with the given ID, returning ErrUnknownIntegrityKey if
+// it has not been added
+func (integrity *Integrity) SetCurrentKey(keyID uint32) error {
+	integrity.mutex.Lock()
+	defer integrity.mutex.Unlock()
+	if _, found := integrity.keys[keyID]; !found {
+		return ErrUnknownIntegrityKey
+	}
+	integrity.current = keyID
+	return nil
+}
+
+// RemoveKey removes the key with the given ID. Values signed with it are read as missing. The current key cannot be
+// removed.
+func (integrity *Integrity) RemoveKey(keyID uint32) error {
+	integrity.mutex.Lock()
+	defer integrity.mutex.Unlock()
+	if keyID == integrity.current {
+		return ErrCurrentIntegrityKey
+	}
+	delete(integrity.keys, keyID)
+	return nil
+}
+
+// SetAllowUnsigned enables or disables reading values that are not signed, e.g. written before signing was enabled.
+// Otherwise they are read as missing.
+func (integrity *Integrity) SetAllowUnsigned(allow bool) {
+	integrity.mutex.Lock()
+	defer integrity.mutex.Unlock()
+	integrity.allowUnsigned = allow
+}
+
+// Status returns the ID of the current key and of all keys
+func (integrity *Integrity) Status() IntegrityStatus {
+	integrity.mutex.RLock()
+	defer integrity.mutex.RUnlock()
+	status := IntegrityStatus{CurrentKey: integrity.current, AllowUnsigned: integrity.allowUnsigned}
+	for keyID := range integrity.keys {
+		status.Keys = append(status.Keys, keyID)
+	}
+	sort.Slice(status.Keys, func(i, j int) bool { return status.Keys[i] < status.Keys[j] })
+	return status
+}
+
+// sign returns value with the header and HMAC of the current key for the given item key and flags.
+// ErrUnknownIntegrityKey is returned if the current key has not been added.
+func (integrity *Integrity) sign(key string, flags uint32, value []byte) ([]byte, error) {
+	integrity.mutex.RLock()
+	keyID := integrity.current
+	secret, found := integrity.keys[keyID]
+	integrity.mutex.RUnlock()
+	if !found {
+		return nil, ErrUnknownIntegrityKey
+	}
+
+	out := make([]byte, len(INTEGRITY_HEADER)+4, len(value)+integrityOverhead)
+	copy(out, INTEGRITY_HEADER)
+	binary.BigEndian.PutUint32(out[len(INTEGRITY_HEADER):], keyID)
+	out = append(out, value...)
+	return append(out, integrityMAC(secret, key, flags, out)...), nil
+}
+
+// verify returns the value signed for the given item key and flags, and false if it is unsigned, unless unsigned
+// values are allowed, if its key is unknown, or if its HMAC does not match
+func (integrity *Integrity) verify(key string, flags uint32, value []byte) ([]byte, bool) {
+	integrity.mutex.RLock()
+	defer integrity.mutex.RUnlock()
+	if len(value) < len(INTEGRITY_HEADER) || !hasHeader(value, INTEGRITY_HEADER) {
+		return value, integrity.allowUnsigned
+	}
+	if len(value) < integrityOverhead {
+		return nil, false
+	}
+
+	secret, found := integrity.keys[binary.BigEndian.Uint32(value[len(INTEGRITY_HEADER):])]
+	if !found {
+		return nil, false
+	}
+	signed, mac := value[:len(value)-sha256.Size], value[len(value)-sha256.Size:]
+	if !hmac.Equal(mac, integrityMAC(secret, key, flags, signed)) {
+		return nil, false
+	}
+	return signed[len(INTEGRITY_HEADER)+4:], true
+}
+
+// integrityMAC returns the HMAC-SHA256 of the given item key, flags and signed value, header included
+func integrityMAC(secret []byte, key string, flags uint32, signed []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	var prefix [8]byte
+	binary.BigEndian.PutUint32(prefix[:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(prefix[4:], flags)
+	mac.Write(prefix[:])
+	mac.Write([]byte(key))
+	mac.Write(signed)
+	return mac.Sum(nil)
+}
+
+// integrity returns the Integrity of this client, or nil if values are not signed
+func (client *Client) integrity() *Integrity {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.Integrity
+}
@@ -0,0 +1,165 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIntegrityZeroValue(t *testing.T) {
+	integrity := &memcacheha.Integrity{}
+	client := memcachehatest.NewCluster(3).NewClient(t, func(client *memcacheha.Client) { client.Integrity = integrity })
+
+	// Without a current key, writes fail rather than being signed with an empty key
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); !errors.Is(err, memcacheha.ErrUnknownIntegrityKey) {
+		t.Fatalf("Set returned %v, expected ErrUnknownIntegrityKey", err)
+	}
+	if err := integrity.AddKey(0, []byte("short")); err != memcacheha.ErrIntegrityKeyTooShort {
+		t.Fatalf("AddKey of a short key returned %v, expected ErrIntegrityKeyTooShort", err)
+	}
+	if err := integrity.AddKey(0, bytes.Repeat([]byte("k"), 16)); err != nil {
+		t.Fatalf("AddKey failed: %s", err)
+	}
+	if err := client.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if item, err := client.Get("key"); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
+
+func TestIntegrityModifiedValueIsNotRepaired(t *testing.T) {
+	integrity, err := memcacheha.NewIntegrity(1, bytes.Repeat([]byte("k"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Integrity = integrity })
+
+	// A value signed with another key of the same ID, and a signed value with a modified byte
+	other, err := memcacheha.NewIntegrity(1, bytes.Repeat([]byte("o"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCluster := memcachehatest.NewCluster(1)
+	otherClient := otherCluster.NewClient(t, func(client *memcacheha.Client) { client.Integrity = other })
+	if err := otherClient.Set(&memcacheha.Item{Key: "resigned", Value: []byte("forged")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	cluster.Node("node1:11211").Store(otherCluster.Node("node1:11211").Peek("resigned"))
+
+	if err := client.Set(&memcacheha.Item{Key: "tampered", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	tampered := cluster.Node("node1:11211").Peek("tampered")
+	tampered.Value[len(tampered.Value)-1] ^= 0xff
+	cluster.Node("node1:11211").Store(tampered)
+	cluster.Remove("tampered", "node2:11211", "node3:11211")
+
+	// Held by node1 only, they are misses, and node2 and node3 are not repaired with them
+	for _, key := range []string{"resigned", "tampered"} {
+		if _, err := client.Get(key, memcacheha.WithReadAll()); err != memcache.ErrCacheMiss {
+			t.Fatalf("Get of %s returned %v, expected ErrCacheMiss", key, err)
+		}
+		for _, endpoint := range []string{"node2:11211", "node3:11211"} {
+			if item := cluster.Node(endpoint).Peek(key); item != nil {
+				t.Fatalf("%s was repaired with %s: %v", endpoint, key, item)
+			}
+		}
+	}
+	if stats := client.RepairStats(); stats.Performed != 0 {
+		t.Fatalf("RepairStats is %+v, expected no repairs", stats)
+	}
+
+	// A signed value moved to another key does not verify either
+	if err := client.Set(&memcacheha.Item{Key: "a", Value: []byte("value of a")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	storeRaw(t, cluster, "a", "b", nil)
+	if _, err := client.Get("b"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a value swapped from another key returned %v, expected ErrCacheMiss", err)
+	}
+}
+
+func TestIntegrityKeyRotation(t *testing.T) {
+	integrity, err := memcacheha.NewIntegrity(1, bytes.Repeat([]byte("1"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := memcachehatest.NewCluster(3).NewClient(t, func(client *memcacheha.Client) { client.Integrity = integrity })
+	if err := client.Set(&memcacheha.Item{Key: "old", Value: []byte("old value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := integrity.SetCurrentKey(2); err != memcacheha.ErrUnknownIntegrityKey {
+		t.Fatalf("SetCurrentKey of a missing key returned %v, expected ErrUnknownIntegrityKey", err)
+	}
+	if err := integrity.AddKey(2, bytes.Repeat([]byte("2"), 16)); err != nil {
+		t.Fatalf("AddKey failed: %s", err)
+	}
+	if err := integrity.SetCurrentKey(2); err != nil {
+		t.Fatalf("SetCurrentKey failed: %s", err)
+	}
+	if status := integrity.Status(); status.CurrentKey != 2 || !reflect.DeepEqual(status.Keys, []uint32{1, 2}) {
+		t.Fatalf("Status is %+v", status)
+	}
+	if err := client.Set(&memcacheha.Item{Key: "new", Value: []byte("new value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	// Values signed with the old key ID still verify
+	for key, value := range map[string]string{"old": "old value", "new": "new value"} {
+		if item, err := client.Get(key); err != nil || string(item.Value) != value {
+			t.Fatalf("Get of %s returned %v, %v", key, item, err)
+		}
+	}
+
+	// The current key cannot be removed, and values of removed keys are misses
+	if err := integrity.RemoveKey(2); err != memcacheha.ErrCurrentIntegrityKey {
+		t.Fatalf("RemoveKey of the current key returned %v, expected ErrCurrentIntegrityKey", err)
+	}
+	if err := integrity.RemoveKey(1); err != nil {
+		t.Fatalf("RemoveKey failed: %s", err)
+	}
+	if _, err := client.Get("old"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a value of a removed key returned %v, expected ErrCacheMiss", err)
+	}
+	if item, err := client.Get("new"); err != nil || string(item.Value) != "new value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
+
+func TestIntegrityAllowUnsigned(t *testing.T) {
+	integrity, err := memcacheha.NewIntegrity(1, bytes.Repeat([]byte("k"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.Integrity = integrity })
+	cluster.Put(&memcacheha.Item{Key: "unsigned", Value: []byte("value")})
+
+	if _, err := client.Get("unsigned"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of an unsigned value returned %v, expected ErrCacheMiss", err)
+	}
+	integrity.SetAllowUnsigned(true)
+	if !integrity.Status().AllowUnsigned {
+		t.Fatal("Status does not report AllowUnsigned")
+	}
+	if item, err := client.Get("unsigned"); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get with AllowUnsigned returned %v, %v", item, err)
+	}
+
+	// Writes are still signed, so are read once unsigned values are refused again
+	if err := client.Set(&memcacheha.Item{Key: "unsigned", Value: []byte("signed")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	integrity.SetAllowUnsigned(false)
+	if item, err := client.Get("unsigned"); err != nil || string(item.Value) != "signed" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+}
@@ -7,6 +7,10 @@ import (
 )
 
 var MEMCACHEHA_HEADER []byte = []byte{0xfd, 0x37, 0xd3, 0x1b}
+
+// MEMCACHEHA_SOFT_HEADER marks items written with a SoftExpiration. The expiry is followed by the soft expiry.
+// Clients older than SoftExpiration read these items as ErrNotMemcacheHAKey.
+var MEMCACHEHA_SOFT_HEADER []byte = []byte{0xfd, 0x37, 0xd3, 0x1c}
 var ErrNotMemcacheHAKey = errors.New("not a memcacheha key")
 
 type Item struct {
@@ -17,11 +21,28 @@ type Item struct {
 	Value []byte
 
 	// Flags are server-opaque flags whose semantics are entirely
-	// up to the app.
+	// up to the app. Flags are stored on every node and preserved
+	// when nodes are synchronised. The bits in FLAGS_RESERVED_MASK
+	// are reserved for library features such as codecs, see
+	// SetApplicationFlags.
 	Flags uint32
 
 	// Expiration is either nil (no expiry) or an absolute expiry time
 	Expiration *time.Time
+
+	// SoftExpiration is either nil or an absolute time, before Expiration, after which the item is Stale: still
+	// served, but due to be refreshed
+	SoftExpiration *time.Time
+}
+
+// Stale returns true if the item's SoftExpiration has passed by the wall clock, see Client.Stale
+func (item *Item) Stale() bool {
+	return item.StaleAt(time.Now())
+}
+
+// StaleAt returns true if the item's SoftExpiration is not after now
+func (item *Item) StaleAt(now time.Time) bool {
+	return item.SoftExpiration != nil && !now.Before(*item.SoftExpiration)
 }
 
 func NewItemFromMemcacheItem(item *memcache.Item) (*Item, error) {
@@ -32,61 +53,138 @@ func NewItemFromMemcacheItem(item *memcache.Item) (*Item, error) {
 	}
 
 	// Check header
-	for i, x := range MEMCACHEHA_HEADER {
-		if item.Value[i] != x {
-			return nil, ErrNotMemcacheHAKey
+	soft := hasHeader(item.Value, MEMCACHEHA_SOFT_HEADER)
+	if !soft && !hasHeader(item.Value, MEMCACHEHA_HEADER) {
+		return nil, ErrNotMemcacheHAKey
+	}
+	if soft && len(item.Value) < 12 {
+		return nil, ErrNotMemcacheHAKey
+	}
+
+	haItem := &Item{
+		Key:        item.Key,
+		Value:      item.Value[8:],
+		Flags:      item.Flags,
+		Expiration: readExpiry(item.Value[4:8]),
+	}
+	if soft {
+		haItem.Value = item.Value[12:]
+		haItem.SoftExpiration = readExpiry(item.Value[8:12])
+	}
+	return haItem, nil
+}
+
+// errIntegrityCheck marks stored items whose signature does not verify, see decodeItem
+var errIntegrityCheck = errors.New("memcacheha: integrity check failed")
+
+// decodeItem returns the Item stored as mcItem, verifying it with integrity and decrypting it with encryption if they
+// are not nil. errIntegrityCheck is returned if the signature does not verify.
+func decodeItem(mcItem *memcache.Item, integrity *Integrity, encryption *Encryption) (*Item, error) {
+	item, err := NewItemFromMemcacheItem(mcItem)
+	if err != nil {
+		return nil, err
+	}
+	if integrity != nil {
+		value, ok := integrity.verify(mcItem.Key, mcItem.Flags, item.Value)
+		if !ok {
+			return nil, errIntegrityCheck
 		}
+		item.Value = value
 	}
+	if encryption != nil {
+		if item.Value, err = encryption.open(mcItem.Key, item.Value); err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
 
-	// Read Expiration
+// DecodeItem returns the Item stored as mcItem on a node of this client, verifying and decrypting it with the
+// Integrity and Encryption of the client. ErrNotMemcacheHAKey is returned if mcItem was not written by memcacheha, and
+// memcache.ErrCacheMiss if its signature does not verify.
+func (client *Client) DecodeItem(mcItem *memcache.Item) (*Item, error) {
+	item, err := decodeItem(mcItem, client.integrity(), client.encryption())
+	if err == errIntegrityCheck {
+		return nil, memcache.ErrCacheMiss
+	}
+	return item, err
+}
+
+// hasHeader returns true if value starts with header
+func hasHeader(value []byte, header []byte) bool {
+	for i, x := range header {
+		if value[i] != x {
+			return false
+		}
+	}
+	return true
+}
+
+// readExpiry reads a big-endian Unix time, or nil for zero
+func readExpiry(data []byte) *time.Time {
 	var mcExpiry uint32
-	mcExpiry = mcExpiry | uint32(item.Value[4])<<24
-	mcExpiry = mcExpiry | uint32(item.Value[5])<<16
-	mcExpiry = mcExpiry | uint32(item.Value[6])<<8
-	mcExpiry = mcExpiry | uint32(item.Value[7])
+	mcExpiry = mcExpiry | uint32(data[0])<<24
+	mcExpiry = mcExpiry | uint32(data[1])<<16
+	mcExpiry = mcExpiry | uint32(data[2])<<8
+	mcExpiry = mcExpiry | uint32(data[3])
 
-	var haExpiry *time.Time
-	if mcExpiry != 0 {
-		x := time.Unix(int64(mcExpiry), 0)
-		haExpiry = &x
+	if mcExpiry == 0 {
+		return nil
 	}
+	x := time.Unix(int64(mcExpiry), 0)
+	return &x
+}
 
-	return &Item{
-		Key:        item.Key,
-		Value:      item.Value[8:],
-		Flags:      item.Flags,
-		Expiration: haExpiry,
-	}, nil
+// writeExpiry returns expiration as a big-endian Unix time, or zero for nil
+func writeExpiry(expiration *time.Time) []byte {
+	var binTime []byte = make([]byte, 4)
+	if expiration != nil {
+		unix := int32(expiration.Unix())
+		binTime[0] = byte((unix >> 24) & 0xFF)
+		binTime[1] = byte((unix >> 16) & 0xFF)
+		binTime[2] = byte((unix >> 8) & 0xFF)
+		binTime[3] = byte(unix & 0xFF)
+	}
+	return binTime
+}
+
+// storedSize returns the size of the value written to memcache for this item, including its header
+func (item *Item) storedSize() int {
+	if item.SoftExpiration != nil {
+		return len(MEMCACHEHA_SOFT_HEADER) + 8 + len(item.Value)
+	}
+	return len(MEMCACHEHA_HEADER) + 4 + len(item.Value)
 }
 
+// AsMemcacheItem returns the memcache.Item stored for this item, with its header and remaining TTL
 func (item *Item) AsMemcacheItem() *memcache.Item {
+	return item.asMemcacheItem(time.Now())
+}
+
+// asMemcacheItem returns the memcache.Item stored for this item when sent at now
+func (item *Item) asMemcacheItem(now time.Time) *memcache.Item {
 	var mcExpiry int32
-	var binTime []byte = make([]byte, 4)
 
 	if item.Expiration != nil {
-		// Write Expiration Int32
-		mcExpiry = int32(item.Expiration.Unix())
-		binTime[0] = byte((mcExpiry >> 24) & 0xFF)
-		binTime[1] = byte((mcExpiry >> 16) & 0xFF)
-		binTime[2] = byte((mcExpiry >> 8) & 0xFF)
-		binTime[3] = byte(mcExpiry & 0xFF)
-
-		// Change to relative for memcached
-		mcExpiry = mcExpiry - int32(time.Now().Unix())
-
-		// Catch negative offset (expire now)
-		if mcExpiry < 1 {
-			mcExpiry = 1
-		}
+		// Recompute the remaining TTL for memcached, so copies written later (e.g. by synchronisation) expire
+		// with the original
+		mcExpiry = expirationToSeconds(item.Expiration, now)
 	}
 
 	var value []byte
 
 	// Write Header
-	value = append(value, MEMCACHEHA_HEADER...)
+	if item.SoftExpiration != nil {
+		value = append(value, MEMCACHEHA_SOFT_HEADER...)
+	} else {
+		value = append(value, MEMCACHEHA_HEADER...)
+	}
 
 	// Write expiry time
-	value = append(value, binTime...)
+	value = append(value, writeExpiry(item.Expiration)...)
+	if item.SoftExpiration != nil {
+		value = append(value, writeExpiry(item.SoftExpiration)...)
+	}
 
 	// Write Data
 	value = append(value, item.Value...)
@@ -0,0 +1,162 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"time"
+)
+
+// ItemInfo is an Item read with GetWithInfo, with its metadata on the nodes it was read from
+type ItemInfo struct {
+	// Item is the value read, with its Flags and Expiration
+	Item *Item
+	// TTL is the time remaining until the item expires, or zero if it does not expire
+	TTL time.Duration
+	// Agreed is the number of nodes that returned this value
+	Agreed int
+	// CasIDs are the compare-and-swap IDs of the item on each node that returned this value, by endpoint, see
+	// CompareAndSwap
+	CasIDs map[string]uint64
+}
+
+// GetWithInfo gets the item for the given key as Get does, from all healthy nodes, with its remaining TTL and the
+// compare-and-swap ID of each node holding it. Gets are not coalesced, as each caller needs its own CAS IDs.
+func (client *Client) GetWithInfo(key string, opts ...ReadOption) (result *ItemInfo, err error) {
+	start, originalKey := client.Clock.Now(), key
+	defer func() {
+		var item *Item
+		if result != nil {
+			item = result.Item
+		}
+		client.observe(OP_GET, originalKey, start, item, err)
+	}()
+
+	opID := newOperationID()
+	options := client.newReadOptions(key, opts)
+	// CompareAndSwap writes only to the nodes read
+	options.ReadAll = true
+	if err := client.checkQuota(options.ctx, 0); err != nil {
+		return nil, err
+	}
+	key, err = client.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ItemInfo{}
+	info.Item, err = client.get(opID, originalKey, key, options, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Item.Expiration != nil {
+		info.TTL = info.Item.Expiration.Sub(client.Clock.Now())
+	}
+	return info, nil
+}
+
+// CompareAndSwap writes item to the nodes of info, a previous GetWithInfo of the same key, on each node only if the
+// item there has not been modified since it was read. memcache.ErrCASConflict is returned if it was modified on any
+// node, and memcache.ErrNotStored if it was deleted; the write stands on the nodes where it succeeded. Otherwise,
+// errors are returned as for Set. After a successful swap, the key is deleted from healthy nodes not in info, which
+// held another value or none, and queued for deletion on unhealthy nodes, see DeleteRetryTTL.
+func (client *Client) CompareAndSwap(info *ItemInfo, item *Item, opts ...WriteOption) (err error) {
+	start, original := client.Clock.Now(), item
+	defer func() { client.observe(OP_SET, original.Key, start, original, err) }()
+
+	if client.Disabled() {
+		return nil
+	}
+	opID := newOperationID()
+	options := client.newWriteOptions(item.Key, opts)
+	if err := client.checkQuota(options.ctx, len(item.Value)); err != nil {
+		return err
+	}
+	item, err = client.mapItem(options.applyTTL(item, client.Clock.Now()))
+	if err != nil {
+		return err
+	}
+
+	nodes := client.Nodes.GetHealthyNodes()
+	statusChan := make(chan (*NodeResponse), len(info.CasIDs))
+	nodeCount := 0
+	for endpoint, casID := range info.CasIDs {
+		if node, found := nodes[endpoint]; found {
+			node.compareAndSwap(opID, item, casID, statusChan)
+			nodeCount++
+		}
+	}
+	if nodeCount == 0 {
+		return ErrNoHealthyNodes
+	}
+
+	acked := 0
+	var conflict error
+	errs := map[string]error{}
+	timings := make(nodeTimings, 0, nodeCount)
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		switch response.Error {
+		case nil:
+			acked++
+			client.deletes.cancel(response.Node.Endpoint, item.Key)
+		case memcache.ErrCASConflict, memcache.ErrNotStored, memcache.ErrCacheMiss:
+			if conflict != memcache.ErrCASConflict {
+				conflict = response.Error
+			}
+		default:
+			errs[response.Node.Endpoint] = response.Error
+		}
+		timings.record(response)
+		releaseNodeResponse(response)
+	}
+
+	if conflict == memcache.ErrCacheMiss {
+		conflict = memcache.ErrNotStored
+	}
+	if conflict != nil {
+		client.levelLog.Info("[%s] CompareAndSwap: %s, written to %d nodes", opID, conflict, acked)
+		return conflict
+	}
+	if err := writeError(acked, options.Quorum, errs, timings); err != nil {
+		return err
+	}
+	client.deleteUnswapped(opID, original.Key, item.Key, info)
+	return nil
+}
+
+// deleteUnswapped deletes the mapped key from the healthy nodes not in info, so they don't keep a value that
+// differs from the swapped one, and queues the delete for nodes that missed it
+func (client *Client) deleteUnswapped(opID string, originalKey string, key string, info *ItemInfo) {
+	nodes := client.getHealthyNodes(originalKey)
+	statusChan := make(chan (*NodeResponse), len(nodes))
+	nodeCount := 0
+	for endpoint, node := range nodes {
+		if _, found := info.CasIDs[endpoint]; !found {
+			node.delete(opID, key, statusChan)
+			nodeCount++
+		}
+	}
+	errs := map[string]error{}
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		if response.Error != nil && response.Error != memcache.ErrCacheMiss {
+			errs[response.Node.Endpoint] = response.Error
+		}
+		releaseNodeResponse(response)
+	}
+	client.queueMissedDeletes(originalKey, key, nodes, errs)
+}
+
+func (node *Node) compareAndSwap(opID string, item *Item, casID uint64, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doCompareAndSwap(opID, item, casID) })
+}
+
+func (node *Node) doCompareAndSwap(opID string, item *Item, casID uint64) *NodeResponse {
+	node.debug(opID, "CAS %s", node.keyForLog(item.Key))
+	mcItem, err := node.encode(item, node.clock.Now())
+	if err != nil {
+		return NewNodeResponse(node, nil, err)
+	}
+	mcItem.CasID = casID
+	return node.getNodeResponse(opID, nil, node.getClient().CompareAndSwap(mcItem))
+}
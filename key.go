@@ -0,0 +1,94 @@
+package memcacheha
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// MAX_VALUE_SIZE is the default MaxValueSize: the 1MB default item size of memcached, less room for the key and the
+// server's per-item overhead
+var MAX_VALUE_SIZE = 1024*1024 - 512
+
+const (
+	// MAX_KEY_LENGTH is the maximum length of a memcache key in bytes
+	MAX_KEY_LENGTH = 250
+
+	// HASHED_KEY_PREFIX is prepended to the SHA-256 hex digest of keys hashed because they exceed MAX_KEY_LENGTH
+	HASHED_KEY_PREFIX = "memcacheha:sha256:"
+)
+
+var (
+	// ErrKeyEmpty is an error meaning the key is an empty string
+	ErrKeyEmpty = errors.New("memcacheha: key is empty")
+
+	// ErrKeyTooLong is an error meaning the key is longer than MAX_KEY_LENGTH bytes
+	ErrKeyTooLong = errors.New("memcacheha: key is longer than 250 bytes")
+
+	// ErrKeyInvalidCharacter is an error meaning the key contains a space or control character
+	ErrKeyInvalidCharacter = errors.New("memcacheha: key contains a space or control character")
+)
+
+// ValidateKey returns an error if the given key cannot be stored in memcache
+func ValidateKey(key string) error {
+	if len(key) == 0 {
+		return ErrKeyEmpty
+	}
+	if len(key) > MAX_KEY_LENGTH {
+		return ErrKeyTooLong
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return ErrKeyInvalidCharacter
+		}
+	}
+	return nil
+}
+
+// HashKey returns HASHED_KEY_PREFIX followed by the SHA-256 hex digest of the given key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return HASHED_KEY_PREFIX + hex.EncodeToString(sum[:])
+}
+
+// mapKey returns the key to send to the nodes for the given key, hashing it if required, or an error if it is invalid
+func (client *Client) mapKey(key string) (string, error) {
+	client.configMutex.RLock()
+	hashLongKeys := client.HashLongKeys
+	client.configMutex.RUnlock()
+
+	if hashLongKeys && len(key) > MAX_KEY_LENGTH {
+		key = HashKey(key)
+	}
+	return key, ValidateKey(key)
+}
+
+// mapItem returns the item to send to the nodes for the given item, or an error if its key is invalid or its value
+// exceeds MaxValueSize. The given item is not modified.
+func (client *Client) mapItem(item *Item) (*Item, error) {
+	key, err := client.mapKey(item.Key)
+	if err != nil {
+		return nil, err
+	}
+	client.configMutex.RLock()
+	limit := client.MaxValueSize
+	encrypted, signed := client.Encryption != nil, client.Integrity != nil
+	client.configMutex.RUnlock()
+	size := item.storedSize()
+	if encrypted {
+		size += encryptionOverhead
+	}
+	if signed {
+		size += integrityOverhead
+	}
+	if limit > 0 && size > limit {
+		client.metrics.rejectOversized()
+		return nil, &ValueTooLargeError{Key: item.Key, Size: size, Limit: limit}
+	}
+	if key == item.Key {
+		return item, nil
+	}
+	mapped := *item
+	mapped.Key = key
+	return &mapped, nil
+}
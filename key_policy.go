@@ -0,0 +1,110 @@
+package memcacheha
+
+import (
+	"path"
+	"regexp"
+	"time"
+)
+
+// KeyPolicy replaces the DefaultReadOptions and DefaultWriteOptions for keys matching a pattern, e.g. counters
+// written and read on all nodes, and page fragments written to any node and read from one. Per-call options are
+// applied over those of the policy.
+type KeyPolicy struct {
+	// Pattern is a pattern of keys, see path.Match
+	Pattern string
+	// Regexp, if set, matches keys instead of Pattern
+	Regexp *regexp.Regexp
+
+	Read  ReadOptions
+	Write WriteOptions
+}
+
+// matches returns true if key matches this policy
+func (policy *KeyPolicy) matches(key string) bool {
+	if policy.Regexp != nil {
+		return policy.Regexp.MatchString(key)
+	}
+	matched, _ := path.Match(policy.Pattern, key)
+	return matched
+}
+
+// keyPolicy returns the first of the KeyPolicies matching key, or nil. The caller must hold configMutex.
+func (client *Client) keyPolicy(key string) *KeyPolicy {
+	for i := range client.KeyPolicies {
+		if client.KeyPolicies[i].matches(key) {
+			return &client.KeyPolicies[i]
+		}
+	}
+	return nil
+}
+
+// KeyPolicyConfig is the configuration of a KeyPolicy, with the same options as Config
+type KeyPolicyConfig struct {
+	// Pattern is a pattern of keys, see path.Match
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Regexp is a regular expression matching keys instead of Pattern
+	Regexp string `json:"regexp,omitempty" yaml:"regexp,omitempty"`
+
+	ReadAll       bool          `json:"read_all,omitempty" yaml:"read_all,omitempty"`
+	ReadCount     int           `json:"read_count,omitempty" yaml:"read_count,omitempty"`
+	ReadSelection ReadSelection `json:"read_selection,omitempty" yaml:"read_selection,omitempty"`
+	RepairQuorum  int           `json:"repair_quorum,omitempty" yaml:"repair_quorum,omitempty"`
+	// WriteQuorum is the number of nodes that must acknowledge a write, -1 (WRITE_QUORUM_ALL) for all
+	WriteQuorum int               `json:"write_quorum,omitempty" yaml:"write_quorum,omitempty"`
+	NoRepair    bool              `json:"no_repair,omitempty" yaml:"no_repair,omitempty"`
+	AddConflict AddConflictPolicy `json:"add_conflict,omitempty" yaml:"add_conflict,omitempty"`
+	TouchRepair bool              `json:"touch_repair,omitempty" yaml:"touch_repair,omitempty"`
+	DefaultTTL  Duration          `json:"default_ttl,omitempty" yaml:"default_ttl,omitempty"`
+	MaxTTL      Duration          `json:"max_ttl,omitempty" yaml:"max_ttl,omitempty"`
+}
+
+// KeyPolicy returns the KeyPolicy described by this config, or an error if its Regexp is invalid
+func (cfg *KeyPolicyConfig) KeyPolicy() (KeyPolicy, error) {
+	policy := KeyPolicy{
+		Pattern: cfg.Pattern,
+		Read: ReadOptions{
+			ReadAll:      cfg.ReadAll,
+			ReadCount:    cfg.ReadCount,
+			NoRepair:     cfg.NoRepair,
+			RepairQuorum: cfg.RepairQuorum,
+			Selection:    cfg.ReadSelection,
+		},
+		Write: WriteOptions{
+			Quorum:      cfg.WriteQuorum,
+			NoRepair:    cfg.NoRepair,
+			AddConflict: cfg.AddConflict,
+			TouchRepair: cfg.TouchRepair,
+			DefaultTTL:  time.Duration(cfg.DefaultTTL),
+			MaxTTL:      time.Duration(cfg.MaxTTL),
+		},
+	}
+	if cfg.Regexp != "" {
+		re, err := regexp.Compile(cfg.Regexp)
+		if err != nil {
+			return policy, err
+		}
+		policy.Regexp = re
+	}
+	return policy, nil
+}
+
+// keyPolicyConfig returns the config describing policy
+func keyPolicyConfig(policy KeyPolicy) KeyPolicyConfig {
+	cfg := KeyPolicyConfig{
+		Pattern:       policy.Pattern,
+		ReadAll:       policy.Read.ReadAll,
+		ReadCount:     policy.Read.ReadCount,
+		ReadSelection: policy.Read.Selection,
+		RepairQuorum:  policy.Read.RepairQuorum,
+		WriteQuorum:   policy.Write.Quorum,
+		NoRepair:      policy.Read.NoRepair || policy.Write.NoRepair,
+		AddConflict:   policy.Write.AddConflict,
+		TouchRepair:   policy.Write.TouchRepair,
+		DefaultTTL:    Duration(policy.Write.DefaultTTL),
+		MaxTTL:        Duration(policy.Write.MaxTTL),
+	}
+	if policy.Regexp != nil {
+		cfg.Regexp = policy.Regexp.String()
+	}
+	return cfg
+}
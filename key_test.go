@@ -0,0 +1,90 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{"empty", "", memcacheha.ErrKeyEmpty},
+		{"max length", strings.Repeat("k", memcacheha.MAX_KEY_LENGTH), nil},
+		{"too long", strings.Repeat("k", memcacheha.MAX_KEY_LENGTH+1), memcacheha.ErrKeyTooLong},
+		{"punctuation", "user:1/profile#v2", nil},
+		{"utf-8", "ключ", nil},
+		{"space", "a key", memcacheha.ErrKeyInvalidCharacter},
+		{"tab", "a\tkey", memcacheha.ErrKeyInvalidCharacter},
+		{"newline", "key\r\n", memcacheha.ErrKeyInvalidCharacter},
+		{"nul", "key\x00", memcacheha.ErrKeyInvalidCharacter},
+		{"del", "key\x7f", memcacheha.ErrKeyInvalidCharacter},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := memcacheha.ValidateKey(test.key); err != test.err {
+				t.Fatalf("ValidateKey returned %v, expected %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestInvalidKeysRejected(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+
+	invalid := map[string]error{
+		"a key": memcacheha.ErrKeyInvalidCharacter,
+		"key\n": memcacheha.ErrKeyInvalidCharacter,
+		strings.Repeat("k", memcacheha.MAX_KEY_LENGTH+1): memcacheha.ErrKeyTooLong,
+	}
+	for key, expected := range invalid {
+		if err := client.Set(&memcacheha.Item{Key: key, Value: []byte("value")}); err != expected {
+			t.Fatalf("Set of %q returned %v, expected %v", key, err, expected)
+		}
+		if _, err := client.Get(key); err != expected {
+			t.Fatalf("Get of %q returned %v, expected %v", key, err, expected)
+		}
+		if err := client.Delete(key); err != expected {
+			t.Fatalf("Delete of %q returned %v, expected %v", key, err, expected)
+		}
+	}
+}
+
+func TestHashLongKeys(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.HashLongKeys = true })
+
+	key := strings.Repeat("k", memcacheha.MAX_KEY_LENGTH+1)
+	if err := client.Set(&memcacheha.Item{Key: key, Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	cluster.AssertValue(t, memcacheha.HashKey(key), []byte("value"))
+
+	// The item is returned under the caller's key
+	item, err := client.Get(key)
+	if err != nil || item.Key != key || string(item.Value) != "value" {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	if err := client.Delete(key); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	cluster.AssertValue(t, memcacheha.HashKey(key), nil)
+	if _, err := client.Get(key); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get after Delete returned %v, expected ErrCacheMiss", err)
+	}
+
+	// Keys within the limit are stored as given, and invalid characters are still rejected
+	if err := client.Set(&memcacheha.Item{Key: "short", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	cluster.AssertValue(t, "short", []byte("value"))
+	if err := client.Set(&memcacheha.Item{Key: "a key", Value: []byte("value")}); err != memcacheha.ErrKeyInvalidCharacter {
+		t.Fatalf("Set returned %v, expected ErrKeyInvalidCharacter", err)
+	}
+}
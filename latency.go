@@ -0,0 +1,106 @@
+package memcacheha
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// NODE_LATENCY_WINDOW is the number of recent request latencies kept by each node to compute its p99 latency
+	NODE_LATENCY_WINDOW = 1000
+	// NODE_LATENCY_MIN_SAMPLES is the number of latencies a node must have recorded before it can be degraded
+	NODE_LATENCY_MIN_SAMPLES = 20
+)
+
+// recordLatency adds the latency of a request to the rolling window of this node
+func (node *Node) recordLatency(latency time.Duration) {
+	node.latencyMutex.Lock()
+	defer node.latencyMutex.Unlock()
+	if len(node.latencies) < NODE_LATENCY_WINDOW {
+		node.latencies = append(node.latencies, latency)
+		return
+	}
+	if len(node.latencies) == 0 {
+		return
+	}
+	node.latencies[node.latencyNext] = latency
+	node.latencyNext = (node.latencyNext + 1) % len(node.latencies)
+}
+
+// P99Latency returns the 99th percentile latency of the last NODE_LATENCY_WINDOW requests to this node, or zero if
+// none have been recorded
+func (node *Node) P99Latency() time.Duration {
+	return p99(node.latencySamples())
+}
+
+// latencySamples returns a copy of the recent request latencies of this node
+func (node *Node) latencySamples() []time.Duration {
+	node.latencyMutex.Lock()
+	defer node.latencyMutex.Unlock()
+	return append([]time.Duration{}, node.latencies...)
+}
+
+// IsDegraded returns true if the p99 latency of this node exceeded the client's DegradedLatency at the last
+// healthcheck. Degraded nodes are not read from, but are still written to.
+func (node *Node) IsDegraded() bool {
+	node.latencyMutex.Lock()
+	defer node.latencyMutex.Unlock()
+	return node.degraded
+}
+
+// updateDegraded marks this node degraded if its p99 latency exceeds threshold, or restores it if not. A zero
+// threshold restores the node.
+func (node *Node) updateDegraded(threshold time.Duration) {
+	node.latencyMutex.Lock()
+	defer node.latencyMutex.Unlock()
+
+	latency := p99(append([]time.Duration{}, node.latencies...))
+	degraded := threshold > 0 && len(node.latencies) >= NODE_LATENCY_MIN_SAMPLES && latency > threshold
+	if degraded && !node.degraded {
+		node.Log.Warn("Degraded (p99 latency %s)", latency)
+	} else if !degraded && node.degraded {
+		node.Log.Info("Restored (p99 latency %s)", latency)
+	}
+	node.degraded = degraded
+}
+
+// p99 returns the 99th percentile of latencies, sorting them in place
+func p99(latencies []time.Duration) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[(len(latencies)*99-1)/100]
+}
+
+// getReadableNodes returns nodes without those that are degraded, unless all of them are
+func getReadableNodes(nodes map[string]*Node) map[string]*Node {
+	degraded := 0
+	for _, node := range nodes {
+		if node.IsDegraded() {
+			degraded++
+		}
+	}
+	if degraded == 0 || degraded == len(nodes) {
+		return nodes
+	}
+
+	readable := make(map[string]*Node, len(nodes)-degraded)
+	for endpoint, node := range nodes {
+		if !node.IsDegraded() {
+			readable[endpoint] = node
+		}
+	}
+	return readable
+}
+
+// updateDegradedNodes marks nodes degraded or restores them according to DegradedLatency
+func (client *Client) updateDegradedNodes() {
+	client.configMutex.RLock()
+	threshold := client.DegradedLatency
+	client.configMutex.RUnlock()
+
+	for _, node := range client.Nodes.GetNodes() {
+		node.updateDegraded(threshold)
+	}
+}
@@ -0,0 +1,85 @@
+package memcacheha
+
+import (
+	"sync"
+)
+
+// MAX_CONCURRENT_REQUESTS is the default limit of in-flight node requests per Client. Zero means no limit.
+var MAX_CONCURRENT_REQUESTS = 0
+
+// requestLimiter bounds the number of in-flight node requests of a Client, see MaxConcurrentRequests. A nil
+// requestLimiter does not limit requests.
+type requestLimiter struct {
+	limit    func() int
+	inFlight int
+	mutex    sync.Mutex
+	cond     *sync.Cond
+}
+
+func newRequestLimiter(limit func() int) *requestLimiter {
+	limiter := &requestLimiter{limit: limit}
+	limiter.cond = sync.NewCond(&limiter.mutex)
+	return limiter
+}
+
+// acquire blocks until a request can be sent
+func (limiter *requestLimiter) acquire() {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for {
+		limit := limiter.limit()
+		if limit <= 0 || limiter.inFlight < limit {
+			break
+		}
+		limiter.cond.Wait()
+	}
+	limiter.inFlight++
+}
+
+// tryAcquire marks a request as sent and returns true if it can be sent without waiting
+func (limiter *requestLimiter) tryAcquire() bool {
+	if limiter == nil {
+		return true
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limit := limiter.limit(); limit > 0 && limiter.inFlight >= limit {
+		return false
+	}
+	limiter.inFlight++
+	return true
+}
+
+// wake wakes the requests waiting in acquire, so they see a changed limit
+func (limiter *requestLimiter) wake() {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	limiter.cond.Broadcast()
+	limiter.mutex.Unlock()
+}
+
+// release marks a request as complete
+func (limiter *requestLimiter) release() {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	limiter.inFlight--
+	limiter.mutex.Unlock()
+	limiter.cond.Signal()
+}
+
+// current returns the number of node requests in progress
+func (limiter *requestLimiter) current() int {
+	if limiter == nil {
+		return 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.inFlight
+}
@@ -0,0 +1,196 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// LOCK_FENCE_SUFFIX is appended to a lock key to form the key of its fencing token counter
+	LOCK_FENCE_SUFFIX = "_fence"
+	// LOCK_RENEW_DIVISOR divides the lock TTL to give the period between renewals
+	LOCK_RENEW_DIVISOR = 3
+	// LOCK_MIN_TTL is the shortest lock TTL, since memcached expires items in whole seconds
+	LOCK_MIN_TTL = time.Second
+)
+
+// ErrLockNotAcquired is an error meaning a lock is held by another owner on a quorum of nodes
+var ErrLockNotAcquired = errors.New("memcacheha: lock not acquired")
+
+// ErrLockTTLTooShort is an error meaning a lock TTL is shorter than LOCK_MIN_TTL
+var ErrLockTTLTooShort = errors.New("memcacheha: lock TTL too short")
+
+// Lock is a distributed lock held on a quorum of nodes, renewed automatically until Unlock is called
+type Lock struct {
+	// Key is the lock key
+	Key string
+	// Token is a best-effort fencing token, normally greater than the token of any previous holder of the lock.
+	// Pass it to the resources protected by the lock so they can reject writes from a holder whose lock has
+	// expired. It is a cached counter, so it restarts at 1 if evicted from all nodes, and may repeat while nodes
+	// disagree on it; resources that must never accept an older holder need a fence of their own.
+	Token uint64
+
+	client   *Client
+	key      string
+	owner    []byte
+	ttl      time.Duration
+	nodes    []*Node
+	lostChan chan (struct{})
+	stopChan chan (struct{})
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// Lock acquires the lock with the given key, returning a function that releases it. See AcquireLock.
+func (client *Client) Lock(key string, ttl time.Duration) (unlock func(), err error) {
+	lock, err := client.AcquireLock(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return lock.Unlock, nil
+}
+
+// AcquireLock acquires the lock with the given key by adding it with the given TTL to all healthy nodes. The lock is
+// acquired if a majority of all known nodes accepted it, otherwise ErrLockNotAcquired is returned. The lock is
+// renewed every ttl/LOCK_RENEW_DIVISOR. TTLs are rounded to whole seconds by memcached, and ErrLockTTLTooShort is
+// returned for a TTL under LOCK_MIN_TTL.
+func (client *Client) AcquireLock(key string, ttl time.Duration) (*Lock, error) {
+	if client.Disabled() {
+		return nil, ErrDisabled
+	}
+	if ttl < LOCK_MIN_TTL {
+		return nil, ErrLockTTLTooShort
+	}
+	mappedKey, err := client.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := client.Nodes.GetHealthyNodes()
+	quorum := len(client.Nodes.GetNodes())/2 + 1
+	if len(nodes) < quorum {
+		return nil, ErrNoHealthyNodes
+	}
+
+	token, err := client.nextFencingToken(key)
+	if err != nil {
+		return nil, err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{
+		Key:      key,
+		Token:    token,
+		client:   client,
+		key:      mappedKey,
+		owner:    []byte(hex.EncodeToString(random) + ":" + strconv.FormatUint(token, 10)),
+		ttl:      ttl,
+		lostChan: make(chan (struct{})),
+		stopChan: make(chan (struct{})),
+	}
+
+	// Concurrently add to all healthy nodes
+	opID := newOperationID()
+	expiration := client.Clock.Now().Add(ttl)
+	item := &Item{Key: mappedKey, Value: lock.owner, Expiration: &expiration}
+	statusChan := make(chan (*NodeResponse), len(nodes))
+	for _, node := range nodes {
+		node.add(opID, item, statusChan)
+	}
+	for i := 0; i < len(nodes); i++ {
+		response := <-statusChan
+		if response.Error == nil {
+			lock.nodes = append(lock.nodes, response.Node)
+		}
+		releaseNodeResponse(response)
+	}
+
+	if len(lock.nodes) < quorum {
+		client.levelLog.Debug("[%s] Lock: %s held by another owner (%d of %d nodes acquired)", opID, client.LogKey(key), len(lock.nodes), quorum)
+		lock.release()
+		return nil, ErrLockNotAcquired
+	}
+
+	lock.wg.Add(1)
+	go lock.renew()
+	return lock, nil
+}
+
+// nextFencingToken increments and returns the fencing token counter of the given lock key. The counter has no
+// expiry but can be evicted, see Lock.Token.
+func (client *Client) nextFencingToken(key string) (uint64, error) {
+	fenceKey := key + LOCK_FENCE_SUFFIX
+	err := client.Add(&Item{Key: fenceKey, Value: []byte("0")}, WithAddConflictPolicy(ADD_CONFLICT_PROPAGATE))
+	if err != nil && err != memcache.ErrNotStored {
+		return 0, err
+	}
+	return client.Increment(fenceKey, 1)
+}
+
+// Lost returns a channel that is closed if renewal fails to keep the lock on a quorum of nodes
+func (lock *Lock) Lost() <-chan struct{} {
+	return lock.lostChan
+}
+
+// Unlock stops renewal and releases the lock on all nodes where it is still held. It is safe to call more than once.
+func (lock *Lock) Unlock() {
+	lock.once.Do(func() {
+		close(lock.stopChan)
+		lock.wg.Wait()
+		lock.release()
+	})
+}
+
+// renew extends the lock expiry on the nodes holding it until stopped, or until fewer than a quorum hold it
+func (lock *Lock) renew() {
+	defer lock.wg.Done()
+	period := lock.ttl / time.Duration(LOCK_RENEW_DIVISOR)
+
+	for {
+		// Renewals are timed by the client's Clock, so tests can drive them
+		select {
+		case <-lock.client.Clock.After(period):
+			quorum := len(lock.client.Nodes.GetNodes())/2 + 1
+			expiration := lock.client.Clock.Now().Add(lock.ttl)
+			var held []*Node
+			for _, node := range lock.nodes {
+				renewed, err := node.doCompareAndExpire(lock.key, lock.owner, &expiration)
+				if err != nil {
+					lock.client.levelLog.Warn("Lock: Renewing %s on %s failed: %s", lock.client.LogKey(lock.Key), node.Endpoint, err)
+				}
+				if renewed {
+					held = append(held, node)
+				}
+			}
+			lock.nodes = held
+			if len(held) < quorum {
+				lock.client.levelLog.Warn("Lock: %s lost (held on %d nodes, quorum %d)", lock.client.LogKey(lock.Key), len(held), quorum)
+				close(lock.lostChan)
+				return
+			}
+
+		case <-lock.stopChan:
+			return
+		}
+	}
+}
+
+// release expires the lock on the nodes where it is held by this owner
+func (lock *Lock) release() {
+	for _, node := range lock.nodes {
+		_, err := node.doCompareAndExpire(lock.key, lock.owner, nil)
+		if err != nil {
+			lock.client.levelLog.Warn("Lock: Releasing %s on %s failed: %s", lock.client.LogKey(lock.Key), node.Endpoint, err)
+		}
+	}
+	lock.nodes = nil
+}
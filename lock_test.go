@@ -0,0 +1,129 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"testing"
+	"time"
+)
+
+func TestLockQuorum(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	lock, err := client.AcquireLock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %s", err)
+	}
+	if _, err := client.AcquireLock("lock", 3*time.Second); err != memcacheha.ErrLockNotAcquired {
+		t.Fatalf("Second AcquireLock returned %v, expected ErrLockNotAcquired", err)
+	}
+
+	// Released locks can be acquired again, with a greater fencing token
+	lock.Unlock()
+	next, err := client.AcquireLock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock after Unlock failed: %s", err)
+	}
+	defer next.Unlock()
+	if next.Token <= lock.Token {
+		t.Fatalf("Fencing token %d after %d, expected it to increase", next.Token, lock.Token)
+	}
+}
+
+func TestLockHeldByMinority(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	// Another owner holding the lock on one node doesn't prevent a majority
+	cluster.Put(&memcacheha.Item{Key: "lock", Value: []byte("other")}, "node1:11211")
+	lock, err := client.AcquireLock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %s", err)
+	}
+	lock.Unlock()
+
+	// Nor does a failed node
+	cluster.Remove("lock")
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	lock, err = client.AcquireLock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock with a failed node failed: %s", err)
+	}
+	lock.Unlock()
+
+	// But another owner on a majority does
+	cluster.Recover("node1:11211")
+	client.Nodes.GetNodes()["node1:11211"].ForceHealth(true)
+	cluster.Put(&memcacheha.Item{Key: "lock", Value: []byte("other")}, "node1:11211", "node2:11211")
+	if _, err := client.AcquireLock("lock", 3*time.Second); err != memcacheha.ErrLockNotAcquired {
+		t.Fatalf("AcquireLock returned %v, expected ErrLockNotAcquired", err)
+	}
+}
+
+func TestLockTTLTooShort(t *testing.T) {
+	client := memcachehatest.NewCluster(3).NewClient(t)
+	if _, err := client.AcquireLock("lock", memcacheha.LOCK_MIN_TTL-time.Millisecond); err != memcacheha.ErrLockTTLTooShort {
+		t.Fatalf("AcquireLock returned %v, expected ErrLockTTLTooShort", err)
+	}
+}
+
+func TestLockRenewal(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Now())
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	client := cluster.NewClient(t)
+
+	lock, err := client.AcquireLock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %s", err)
+	}
+	defer lock.Unlock()
+	// advance moves the clock forward by d in small steps, giving the renewals due at each step time to run
+	advance := func(d time.Duration) {
+		for step := time.Duration(0); step < d; step += 100 * time.Millisecond {
+			clk.Advance(100 * time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The lock outlives its TTL while it is renewed, with a failed minority of nodes
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node1:11211")
+	advance(10 * time.Second)
+	select {
+	case <-lock.Lost():
+		t.Fatal("Lock lost with a majority of nodes")
+	default:
+	}
+	for _, endpoint := range []string{"node2:11211", "node3:11211"} {
+		if cluster.Value(endpoint, "lock") == nil {
+			t.Fatalf("%s no longer holds the renewed lock", endpoint)
+		}
+	}
+
+	// The lock is lost once renewal fails on a majority
+	cluster.Fail(memcacheha.ErrNodeNetwork, "node2:11211")
+	eventually(t, func() bool {
+		advance(100 * time.Millisecond)
+		select {
+		case <-lock.Lost():
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestLockRelease(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t)
+
+	unlock, err := client.Lock("lock", 3*time.Second)
+	if err != nil {
+		t.Fatalf("Lock failed: %s", err)
+	}
+	unlock()
+	unlock()
+	cluster.AssertValue(t, "lock", nil)
+}
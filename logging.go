@@ -0,0 +1,155 @@
+package memcacheha
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+)
+
+// LogLevel is the minimum level of messages the client writes to its Log
+type LogLevel int
+
+const (
+	// LOG_DEBUG writes all messages
+	LOG_DEBUG LogLevel = iota
+	// LOG_INFO writes info, warning and error messages
+	LOG_INFO
+	// LOG_WARN writes warning and error messages
+	LOG_WARN
+	// LOG_ERROR writes error messages
+	LOG_ERROR
+	// LOG_NONE writes no messages
+	LOG_NONE
+)
+
+// ErrUnknownLogLevel is an error meaning a LogLevel name is not debug, info, warn, error or none
+var ErrUnknownLogLevel = errors.New("memcacheha: unknown log level")
+
+var logLevelNames = map[LogLevel]string{
+	LOG_DEBUG: "debug",
+	LOG_INFO:  "info",
+	LOG_WARN:  "warn",
+	LOG_ERROR: "error",
+	LOG_NONE:  "none",
+}
+
+// UnmarshalText parses a level name: debug, info, warn, error or none
+func (level *LogLevel) UnmarshalText(text []byte) error {
+	for l, name := range logLevelNames {
+		if name == string(text) {
+			*level = l
+			return nil
+		}
+	}
+	return ErrUnknownLogLevel
+}
+
+// MarshalText returns the level name
+func (level LogLevel) MarshalText() ([]byte, error) {
+	name, found := logLevelNames[level]
+	if !found {
+		return nil, ErrUnknownLogLevel
+	}
+	return []byte(name), nil
+}
+
+// KeyLogMode decides how cache keys appear in log messages and AccessRecords, as keys may contain personal data
+type KeyLogMode int
+
+const (
+	// KEY_LOG_PLAIN logs keys as they are
+	KEY_LOG_PLAIN KeyLogMode = iota
+	// KEY_LOG_HASH logs a stable hash of each key, so entries for the same key can still be correlated
+	KEY_LOG_HASH
+	// KEY_LOG_REDACT replaces keys with KEY_LOG_REDACTED
+	KEY_LOG_REDACT
+)
+
+// KEY_LOG_REDACTED replaces keys in logs with KEY_LOG_REDACT
+var KEY_LOG_REDACTED = "[redacted]"
+
+// ErrUnknownKeyLogMode is an error meaning a KeyLogMode name is not plain, hash or redact
+var ErrUnknownKeyLogMode = errors.New("memcacheha: unknown key log mode")
+
+var keyLogModeNames = map[KeyLogMode]string{
+	KEY_LOG_PLAIN:  "plain",
+	KEY_LOG_HASH:   "hash",
+	KEY_LOG_REDACT: "redact",
+}
+
+// UnmarshalText parses a mode name: plain, hash or redact
+func (mode *KeyLogMode) UnmarshalText(text []byte) error {
+	for m, name := range keyLogModeNames {
+		if name == string(text) {
+			*mode = m
+			return nil
+		}
+	}
+	return ErrUnknownKeyLogMode
+}
+
+// MarshalText returns the mode name
+func (mode KeyLogMode) MarshalText() ([]byte, error) {
+	name, found := keyLogModeNames[mode]
+	if !found {
+		return nil, ErrUnknownKeyLogMode
+	}
+	return []byte(name), nil
+}
+
+// LogKey returns key as it should appear in logs under the client's KeyLogMode: the key, "#" and its hash, or
+// KEY_LOG_REDACTED
+func (client *Client) LogKey(key string) string {
+	client.configMutex.RLock()
+	mode := client.KeyLogMode
+	client.configMutex.RUnlock()
+	switch mode {
+	case KEY_LOG_HASH:
+		return "#" + keyHash(key)
+	case KEY_LOG_REDACT:
+		return KEY_LOG_REDACTED
+	}
+	return key
+}
+
+var lastOperationID uint64
+
+// newOperationID returns a short ID, unique within the process, identifying a client operation in log lines
+func newOperationID() string {
+	return strconv.FormatUint(atomic.AddUint64(&lastOperationID, 1), 36)
+}
+
+// levelLogger writes messages at or above the client's LogLevel to the client's Log
+type levelLogger struct {
+	client *Client
+}
+
+func (log *levelLogger) enabled(level LogLevel) bool {
+	log.client.configMutex.RLock()
+	defer log.client.configMutex.RUnlock()
+	return log.client.Log != nil && level >= log.client.LogLevel
+}
+
+func (log *levelLogger) Debug(format string, args ...interface{}) {
+	if log.enabled(LOG_DEBUG) {
+		log.client.Log.Debug(format, args...)
+	}
+}
+
+func (log *levelLogger) Info(format string, args ...interface{}) {
+	if log.enabled(LOG_INFO) {
+		log.client.Log.Info(format, args...)
+	}
+}
+
+func (log *levelLogger) Warn(format string, args ...interface{}) {
+	if log.enabled(LOG_WARN) {
+		log.client.Log.Warn(format, args...)
+	}
+}
+
+func (log *levelLogger) Error(format string, args ...interface{}) {
+	if log.enabled(LOG_ERROR) {
+		log.client.Log.Error(format, args...)
+	}
+}
@@ -0,0 +1,277 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownCluster is an error meaning a Manager has no cluster with the requested name
+	ErrUnknownCluster = errors.New("memcacheha: unknown cluster")
+
+	// ErrClusterExists is an error meaning a cluster with the given name has already been added to a Manager
+	ErrClusterExists = errors.New("memcacheha: cluster already exists")
+)
+
+// ManagerConfig is the declarative configuration of a Manager, with a Config for each named cluster
+type ManagerConfig struct {
+	Clusters map[string]*Config `json:"clusters" yaml:"clusters"`
+}
+
+// LoadManagerConfig reads a ManagerConfig from the JSON (.json) or YAML (.yaml, .yml) file at path. Fields of each
+// cluster left unset keep the Client defaults, and can be overridden by environment variables prefixed with
+// CONFIG_ENV_PREFIX followed by the upper case cluster name, e.g. MEMCACHEHA_SESSIONS_NODES.
+func LoadManagerConfig(path string) (*ManagerConfig, error) {
+	cfg := &ManagerConfig{}
+	if err := decodeConfigFile(path, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := applyNamedEnv(cfg.Clusters, CONFIG_ENV_PREFIX); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Manager holds several named Clients, e.g. "sessions" and "fragments", and runs discovery and healthchecks for
+// all of them from a single runloop, and aggregates their metrics, see Metrics. Clients added to a Manager should not be started individually; the Manager opens
+// and closes their DeleteJournal on Start and Stop instead.
+type Manager struct {
+	Log logger.Logger
+
+	clusters map[string]*Client
+	mutex    sync.RWMutex
+	run      runState
+	wake     *wakeSignal
+}
+
+// NewManager returns a new Manager with no clusters
+func NewManager(log logger.Logger) *Manager {
+	return &Manager{
+		Log:      logger.NewScopedLogger("memcache-ha manager", log),
+		clusters: map[string]*Client{},
+		wake:     newWakeSignal(),
+	}
+}
+
+// NewManagerFromConfig returns a new Manager with a Client for each cluster in cfg
+func NewManagerFromConfig(log logger.Logger, cfg *ManagerConfig) (*Manager, error) {
+	manager := NewManager(log)
+	for name, clusterCfg := range cfg.Clusters {
+		client, err := NewFromConfig(logger.NewScopedLogger(name, log), clusterCfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := manager.Add(name, client); err != nil {
+			return nil, err
+		}
+	}
+	return manager, nil
+}
+
+// Add the given client as the cluster with the given name. If the Manager is running, the DeleteJournal of the client
+// is opened.
+func (manager *Manager) Add(name string, client *Client) error {
+	// Lock in the order of Start and Stop, so the journal is opened exactly when the Manager runs
+	manager.run.mutex.Lock()
+	defer manager.run.mutex.Unlock()
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if _, found := manager.clusters[name]; found {
+		return ErrClusterExists
+	}
+	if manager.run.stop != nil {
+		if err := client.openJournal(); err != nil {
+			return err
+		}
+	}
+	manager.clusters[name] = client
+
+	// Changes to the periods of the client wake the shared runloop
+	client.configMutex.Lock()
+	client.wake = manager.wake
+	client.wakeRunloop()
+	client.configMutex.Unlock()
+	return nil
+}
+
+// Cluster returns the Client for the cluster with the given name, or ErrUnknownCluster
+func (manager *Manager) Cluster(name string) (*Client, error) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	client, found := manager.clusters[name]
+	if !found {
+		return nil, ErrUnknownCluster
+	}
+	return client, nil
+}
+
+// Names returns the sorted names of all clusters
+func (manager *Manager) Names() []string {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	names := make([]string, 0, len(manager.clusters))
+	for name := range manager.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start the shared runloop for all clusters, opening the DeleteJournal of each as Client.Start does
+func (manager *Manager) Start() error {
+	return manager.run.start(manager.openJournals, manager.runloop)
+}
+
+// openJournals opens the DeleteJournal of every cluster, closing those already opened if one fails
+func (manager *Manager) openJournals() error {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	opened := make([]*Client, 0, len(manager.clusters))
+	for name, client := range manager.clusters {
+		if err := client.openJournal(); err != nil {
+			manager.Log.Error("Start: Opening the delete journal of cluster %s: %s", name, err)
+			for _, client := range opened {
+				client.deletes.closeJournal()
+			}
+			return err
+		}
+		opened = append(opened, client)
+	}
+	return nil
+}
+
+// closeJournals closes the DeleteJournal of every cluster, returning the first error
+func (manager *Manager) closeJournals() error {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	var firstErr error
+	for name, client := range manager.clusters {
+		if err := client.deletes.closeJournal(); err != nil {
+			manager.Log.Error("Stop: Closing the delete journal of cluster %s: %s", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// StartAndWait discovers and healthchecks the nodes of every cluster before starting the Manager, then waits for a
+// healthy node in every cluster, see Client.StartAndWait
+func (manager *Manager) StartAndWait(ctx context.Context) error {
+	if manager.run.running() {
+		return ErrAlreadyRunning
+	}
+	for _, name := range manager.Names() {
+		client, _ := manager.Cluster(name)
+		client.GetNodes()
+	}
+	if err := manager.Start(); err != nil {
+		return err
+	}
+	return manager.WaitForNodesContext(ctx, 1)
+}
+
+// WaitForNodes waits for at least one available node in every cluster, timing out on the deadline with ErrNoHealthyNodes
+func (manager *Manager) WaitForNodes(deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := manager.WaitForNodesContext(ctx, 1); err != nil {
+		return ErrNoHealthyNodes
+	}
+	return nil
+}
+
+// WaitForNodesContext waits for at least minNodes healthy nodes in every cluster, see Client.WaitForNodesContext
+func (manager *Manager) WaitForNodesContext(ctx context.Context, minNodes int) error {
+	for _, name := range manager.Names() {
+		client, _ := manager.Cluster(name)
+		if err := client.WaitForNodesContext(ctx, minNodes); err != nil {
+			manager.Log.Warn("WaitForNodes: No healthy nodes in cluster %s", name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (manager *Manager) runloop(stop <-chan struct{}) {
+	manager.Log.Info("Running")
+	clients := func() []*Client {
+		manager.mutex.RLock()
+		defer manager.mutex.RUnlock()
+		clients := make([]*Client, 0, len(manager.clusters))
+		for _, client := range manager.clusters {
+			clients = append(clients, client)
+		}
+		return clients
+	}
+
+	var wg sync.WaitGroup
+	for _, worker := range maintenanceWorkers {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			superviseWorker(worker, clients, manager.wake.wait, stop)
+		}(worker)
+	}
+	wg.Wait()
+	manager.Log.Info("Stopped")
+}
+
+// Stop the shared runloop, waiting for it to return, then close the DeleteJournal of each cluster
+func (manager *Manager) Stop() error {
+	return manager.run.stopAndWait(manager.closeJournals)
+}
+
+// ManagerSnapshot is a copy of the metrics of every cluster of a Manager, see Manager.Metrics
+type ManagerSnapshot struct {
+	// Clusters is the Snapshot of each cluster, by cluster name
+	Clusters map[string]Snapshot `json:"clusters"`
+	// Hits, Misses, Ops, Repairs and OversizedValues are summed over all clusters
+	Hits            uint64              `json:"hits"`
+	Misses          uint64              `json:"misses"`
+	Ops             map[string]OpCounts `json:"ops"`
+	Repairs         RepairStats         `json:"repairs"`
+	OversizedValues uint64              `json:"oversized_values"`
+}
+
+// Metrics returns the metrics Snapshot of every cluster, labelled by cluster name, and their totals
+func (manager *Manager) Metrics() ManagerSnapshot {
+	snapshot := ManagerSnapshot{Clusters: map[string]Snapshot{}, Ops: map[string]OpCounts{}}
+	for _, name := range manager.Names() {
+		client, err := manager.Cluster(name)
+		if err != nil {
+			continue
+		}
+		cluster := client.Metrics()
+		snapshot.Clusters[name] = cluster
+		snapshot.Hits += cluster.Hits
+		snapshot.Misses += cluster.Misses
+		snapshot.Repairs.Performed += cluster.Repairs.Performed
+		snapshot.Repairs.Dropped += cluster.Repairs.Dropped
+		snapshot.OversizedValues += cluster.OversizedValues
+		for op, counts := range cluster.Ops {
+			total := snapshot.Ops[op]
+			total.Calls += counts.Calls
+			total.Misses += counts.Misses
+			total.Errors += counts.Errors
+			snapshot.Ops[op] = total
+		}
+	}
+	return snapshot
+}
+
+// ResetMetrics resets the metrics of every cluster, see Client.ResetMetrics
+func (manager *Manager) ResetMetrics() {
+	for _, name := range manager.Names() {
+		if client, err := manager.Cluster(name); err == nil {
+			client.ResetMetrics()
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newManagedClient returns a client of cluster for a Manager, discovering nodes every 50ms
+func newManagedClient(cluster *memcachehatest.Cluster) *memcacheha.Client {
+	client := memcacheha.New(nil, cluster)
+	client.NewNodeClient = cluster.NewNodeClient
+	client.GetNodesPeriod = 50 * time.Millisecond
+	return client
+}
+
+func TestManagerClusters(t *testing.T) {
+	manager := memcacheha.NewManager(nil)
+	sessions, fragments := newManagedClient(memcachehatest.NewCluster(1)), newManagedClient(memcachehatest.NewCluster(1))
+	if err := manager.Add("sessions", sessions); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := manager.Add("fragments", fragments); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := manager.Add("sessions", fragments); err != memcacheha.ErrClusterExists {
+		t.Fatalf("Add of an existing name returned %v, expected ErrClusterExists", err)
+	}
+
+	if names := manager.Names(); !reflect.DeepEqual(names, []string{"fragments", "sessions"}) {
+		t.Fatalf("Names returned %v", names)
+	}
+	if client, err := manager.Cluster("sessions"); err != nil || client != sessions {
+		t.Fatalf("Cluster returned %p, %v, expected %p", client, err, sessions)
+	}
+	if _, err := manager.Cluster("missing"); err != memcacheha.ErrUnknownCluster {
+		t.Fatalf("Cluster of an unknown name returned %v, expected ErrUnknownCluster", err)
+	}
+}
+
+func TestManagerLifecycle(t *testing.T) {
+	// Discover soon after Start, without waiting on slow lookups of the unresolvable test hostnames
+	startDelay, resolveTimeout := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT
+	t.Cleanup(func() { memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = startDelay, resolveTimeout })
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+
+	sessions, fragments := memcachehatest.NewCluster(2), memcachehatest.NewCluster(1)
+	manager := memcacheha.NewManager(nil)
+	t.Cleanup(func() { manager.Stop() })
+	manager.Add("sessions", newManagedClient(sessions))
+	manager.Add("fragments", newManagedClient(fragments))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := manager.StartAndWait(ctx); err != nil {
+		t.Fatalf("StartAndWait failed: %s", err)
+	}
+	if err := manager.Start(); err != memcacheha.ErrAlreadyRunning {
+		t.Fatalf("Start of a running Manager returned %v, expected ErrAlreadyRunning", err)
+	}
+	if err := manager.StartAndWait(ctx); err != memcacheha.ErrAlreadyRunning {
+		t.Fatalf("StartAndWait of a running Manager returned %v, expected ErrAlreadyRunning", err)
+	}
+
+	// The shared runloop discovers nodes for every cluster, including clusters added while running
+	sessions.AddNode("node3:11211")
+	client, _ := manager.Cluster("sessions")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 3 })
+	late := memcachehatest.NewCluster(1)
+	if err := manager.Add("late", newManagedClient(late)); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	client, _ = manager.Cluster("late")
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 1 })
+
+	if err := manager.Stop(); err != nil {
+		t.Fatalf("Stop failed: %s", err)
+	}
+	if err := manager.Stop(); err != memcacheha.ErrNotRunning {
+		t.Fatalf("Stop of a stopped Manager returned %v, expected ErrNotRunning", err)
+	}
+
+	// Stopped, clusters no longer discover nodes
+	sessions.AddNode("node4:11211")
+	time.Sleep(50 * time.Millisecond)
+	client, _ = manager.Cluster("sessions")
+	if count := client.Nodes.GetHealthyNodeCount(); count != 3 {
+		t.Fatalf("%d healthy nodes after Stop, expected 3", count)
+	}
+}
+
+func TestManagerWaitForNodes(t *testing.T) {
+	healthy, failed := memcachehatest.NewCluster(1), memcachehatest.NewCluster(1)
+	failed.Fail(memcacheha.ErrNodeNetwork, failed.Endpoints()...)
+	manager := memcacheha.NewManager(nil)
+	manager.Add("healthy", newManagedClient(healthy))
+	manager.Add("failed", newManagedClient(failed))
+	defer manager.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := manager.StartAndWait(ctx); err == nil {
+		t.Fatal("StartAndWait succeeded without a healthy node in every cluster")
+	}
+	if err := manager.WaitForNodes(time.Now().Add(50 * time.Millisecond)); err != memcacheha.ErrNoHealthyNodes {
+		t.Fatalf("WaitForNodes returned %v, expected ErrNoHealthyNodes", err)
+	}
+}
+
+func TestNewManagerFromConfig(t *testing.T) {
+	manager, err := memcacheha.NewManagerFromConfig(nil, &memcacheha.ManagerConfig{
+		Clusters: map[string]*memcacheha.Config{
+			"sessions":  {Nodes: []string{"sessions1:11211"}},
+			"fragments": {Nodes: []string{"fragments1:11211"}, Timeout: memcacheha.Duration(50 * time.Millisecond)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewManagerFromConfig failed: %s", err)
+	}
+	if names := manager.Names(); !reflect.DeepEqual(names, []string{"fragments", "sessions"}) {
+		t.Fatalf("Names returned %v", names)
+	}
+	if client, _ := manager.Cluster("fragments"); client.Timeout != 50*time.Millisecond {
+		t.Fatalf("Cluster fragments has timeout %s, expected 50ms", client.Timeout)
+	}
+
+	// A cluster without sources is an error
+	_, err = memcacheha.NewManagerFromConfig(nil, &memcacheha.ManagerConfig{
+		Clusters: map[string]*memcacheha.Config{"empty": {}},
+	})
+	if err != memcacheha.ErrConfigNoSources {
+		t.Fatalf("NewManagerFromConfig returned %v, expected ErrConfigNoSources", err)
+	}
+}
+
+func TestManagerMetrics(t *testing.T) {
+	manager := memcacheha.NewManager(nil)
+	for _, name := range []string{"sessions", "fragments"} {
+		client := newManagedClient(memcachehatest.NewCluster(2))
+		client.GetNodes()
+		manager.Add(name, client)
+	}
+	sessions, _ := manager.Cluster("sessions")
+	fragments, _ := manager.Cluster("fragments")
+
+	sessions.Set(&memcacheha.Item{Key: "key", Value: []byte("value")})
+	sessions.Get("key")
+	fragments.Get("key")
+	fragments.Get("other")
+
+	snapshot := manager.Metrics()
+	if len(snapshot.Clusters) != 2 {
+		t.Fatalf("Metrics returned %d clusters, expected 2", len(snapshot.Clusters))
+	}
+	if cluster := snapshot.Clusters["sessions"]; cluster.Hits != 1 || cluster.Misses != 0 || cluster.Ops[memcacheha.OP_SET].Calls != 1 {
+		t.Fatalf("Cluster sessions has %+v", cluster)
+	}
+	if cluster := snapshot.Clusters["fragments"]; cluster.Hits != 0 || cluster.Misses != 2 {
+		t.Fatalf("Cluster fragments has %+v", cluster)
+	}
+	if snapshot.Hits != 1 || snapshot.Misses != 2 || snapshot.Ops[memcacheha.OP_GET].Calls != 3 || snapshot.Ops[memcacheha.OP_SET].Calls != 1 {
+		t.Fatalf("Metrics totals are %+v", snapshot)
+	}
+
+	manager.ResetMetrics()
+	if snapshot := manager.Metrics(); snapshot.Hits != 0 || snapshot.Misses != 0 || len(snapshot.Ops) != 0 {
+		t.Fatalf("Metrics after ResetMetrics are %+v", snapshot)
+	}
+}
@@ -0,0 +1,143 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"time"
+)
+
+// MemcacheClient is the subset of the github.com/bradfitz/gomemcache/memcache Client API supported by MemcacheAdapter.
+// Code written against MemcacheClient can use either a *memcache.Client or a *MemcacheAdapter.
+type MemcacheClient interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+	Delete(key string) error
+	Touch(key string, seconds int32) error
+	Increment(key string, delta uint64) (uint64, error)
+	Decrement(key string, delta uint64) (uint64, error)
+}
+
+var (
+	_ MemcacheClient = (*memcache.Client)(nil)
+	_ MemcacheClient = (*MemcacheAdapter)(nil)
+)
+
+// MemcacheAdapter wraps a Client to provide the same method signatures as a *memcache.Client
+type MemcacheAdapter struct {
+	Client *Client
+}
+
+// NewMemcacheAdapter returns a new MemcacheAdapter for the given Client
+func NewMemcacheAdapter(client *Client) *MemcacheAdapter {
+	return &MemcacheAdapter{
+		Client: client,
+	}
+}
+
+// Get gets the item for the given key. ErrCacheMiss is returned for a memcache cache miss.
+func (adapter *MemcacheAdapter) Get(key string) (*memcache.Item, error) {
+	item, err := adapter.Client.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &memcache.Item{
+		Key:        item.Key,
+		Value:      item.Value,
+		Flags:      item.Flags,
+		Expiration: ExpirationToSeconds(item.Expiration),
+	}, nil
+}
+
+// Set writes the given item, unconditionally. ErrReservedFlags is returned if its flags use FLAGS_RESERVED_MASK.
+func (adapter *MemcacheAdapter) Set(item *memcache.Item) error {
+	if err := CheckApplicationFlags(item.Flags); err != nil {
+		return err
+	}
+	return adapter.Client.Set(NewItemFromMemcacheExpiry(item))
+}
+
+// Add writes the given item, if no value already exists for its key. ErrNotStored is returned if that condition is not met,
+// and ErrReservedFlags if its flags use FLAGS_RESERVED_MASK.
+func (adapter *MemcacheAdapter) Add(item *memcache.Item) error {
+	if err := CheckApplicationFlags(item.Flags); err != nil {
+		return err
+	}
+	return adapter.Client.Add(NewItemFromMemcacheExpiry(item))
+}
+
+// Delete deletes the item with the provided key. ErrCacheMiss is returned if the item didn't already exist in the cache.
+func (adapter *MemcacheAdapter) Delete(key string) error {
+	return adapter.Client.Delete(key)
+}
+
+// Touch updates the expiry for the given key.
+func (adapter *MemcacheAdapter) Touch(key string, seconds int32) error {
+	return adapter.Client.Touch(key, seconds)
+}
+
+// Increment increments key by delta with a CAS loop on each node, see Client.Increment. It is atomic per node, not
+// across nodes: nodes may briefly disagree, and the highest value is returned.
+func (adapter *MemcacheAdapter) Increment(key string, delta uint64) (uint64, error) {
+	return adapter.Client.Increment(key, delta)
+}
+
+// Decrement decrements key by delta with a CAS loop on each node, see Client.Decrement. It is atomic per node, not
+// across nodes: nodes may briefly disagree, and the lowest value is returned.
+func (adapter *MemcacheAdapter) Decrement(key string, delta uint64) (uint64, error) {
+	return adapter.Client.Decrement(key, delta)
+}
+
+// NewItemFromMemcacheExpiry returns a new Item from a standard memcache.Item, converting the memcached expiration
+// (relative seconds, or a Unix timestamp if greater than 30 days) to an absolute expiry time.
+func NewItemFromMemcacheExpiry(item *memcache.Item) *Item {
+	return &Item{
+		Key:        item.Key,
+		Value:      item.Value,
+		Flags:      item.Flags,
+		Expiration: SecondsToExpiration(item.Expiration),
+	}
+}
+
+// MEMCACHE_RELATIVE_EXPIRY_MAX is the largest memcached expiration value treated as relative seconds rather than a Unix timestamp
+const MEMCACHE_RELATIVE_EXPIRY_MAX = 60 * 60 * 24 * 30
+
+// SecondsToExpiration converts a memcached expiration value to an absolute expiry time, or nil for no expiry.
+func SecondsToExpiration(seconds int32) *time.Time {
+	return secondsToExpiration(seconds, time.Now())
+}
+
+// secondsToExpiration converts a memcached expiration value received at now to an absolute expiry time
+func secondsToExpiration(seconds int32, now time.Time) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	var expiry time.Time
+	if seconds > MEMCACHE_RELATIVE_EXPIRY_MAX {
+		expiry = time.Unix(int64(seconds), 0)
+	} else {
+		expiry = now.Add(time.Duration(seconds) * time.Second)
+	}
+	return &expiry
+}
+
+// ExpirationToSeconds converts an absolute expiry time to a memcached expiration value: the whole seconds remaining
+// (at least 1), a Unix timestamp if more than 30 days remain, or 0 for no expiry.
+func ExpirationToSeconds(expiration *time.Time) int32 {
+	return expirationToSeconds(expiration, time.Now())
+}
+
+// expirationToSeconds converts an absolute expiry time to a memcached expiration value sent at now
+func expirationToSeconds(expiration *time.Time, now time.Time) int32 {
+	if expiration == nil {
+		return 0
+	}
+	remaining := expiration.Sub(now) / time.Second
+	if remaining < 1 {
+		return 1
+	}
+	if remaining > MEMCACHE_RELATIVE_EXPIRY_MAX {
+		return int32(expiration.Unix())
+	}
+	return int32(remaining)
+}
@@ -0,0 +1,120 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"testing"
+	"time"
+)
+
+func TestMemcacheAdapter(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Now())
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	var adapter memcacheha.MemcacheClient = memcacheha.NewMemcacheAdapter(cluster.NewClient(t))
+
+	// Flags and a relative expiration are stored on every node and read back
+	if err := adapter.Set(&memcache.Item{Key: "key", Value: []byte("value"), Flags: 42, Expiration: 60}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	for _, endpoint := range cluster.Endpoints() {
+		if item := cluster.Node(endpoint).Peek("key"); item == nil || item.Flags != 42 {
+			t.Fatalf("%s holds %v, expected flags 42", endpoint, item)
+		}
+	}
+	item, err := adapter.Get("key")
+	if err != nil || string(item.Value) != "value" || item.Flags != 42 {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	if item.Expiration < 58 || item.Expiration > 60 {
+		t.Fatalf("Get returned expiration %d, expected about 60 seconds", item.Expiration)
+	}
+
+	// Expirations beyond 30 days are Unix times, and zero is no expiry
+	expiry := time.Now().Add(60 * 24 * time.Hour).Unix()
+	if err := adapter.Set(&memcache.Item{Key: "long", Value: []byte("value"), Expiration: int32(expiry)}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if item, err := adapter.Get("long"); err != nil || int64(item.Expiration) != expiry {
+		t.Fatalf("Get returned %v, %v, expected expiration %d", item, err, expiry)
+	}
+	if err := adapter.Set(&memcache.Item{Key: "forever", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if item, err := adapter.Get("forever"); err != nil || item.Expiration != 0 {
+		t.Fatalf("Get returned %v, %v, expected no expiration", item, err)
+	}
+
+	// Results are the errors of gomemcache
+	if _, err := adapter.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if err := adapter.Add(&memcache.Item{Key: "key", Value: []byte("other")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+	if err := adapter.Add(&memcache.Item{Key: "added", Value: []byte("value")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := adapter.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if err := adapter.Delete("key"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Delete of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if _, err := adapter.Increment("missing", 1); err != memcache.ErrCacheMiss {
+		t.Fatalf("Increment of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if err := adapter.Set(&memcache.Item{Key: "key", Value: []byte("value"), Flags: memcacheha.FLAG_COMPRESSED}); err != memcacheha.ErrReservedFlags {
+		t.Fatalf("Set with reserved flags returned %v, expected ErrReservedFlags", err)
+	}
+
+	if err := adapter.Set(&memcache.Item{Key: "counter", Value: []byte("10")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if value, err := adapter.Increment("counter", 5); err != nil || value != 15 {
+		t.Fatalf("Increment returned %d, %v, expected 15", value, err)
+	}
+	if value, err := adapter.Decrement("counter", 20); err != nil || value != 0 {
+		t.Fatalf("Decrement returned %d, %v, expected 0", value, err)
+	}
+
+	// Touch sets a new relative expiration on the nodes
+	if err := adapter.Touch("forever", 10); err != nil {
+		t.Fatalf("Touch failed: %s", err)
+	}
+	if err := adapter.Touch("missing", 10); err != memcache.ErrCacheMiss {
+		t.Fatalf("Touch of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	clk.Advance(11 * time.Second)
+	if _, err := adapter.Get("forever"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get after the touched expiration returned %v, expected ErrCacheMiss", err)
+	}
+}
+
+func TestExpirationSeconds(t *testing.T) {
+	if expiry := memcacheha.SecondsToExpiration(0); expiry != nil {
+		t.Fatalf("SecondsToExpiration(0) returned %s, expected nil", expiry)
+	}
+	before := time.Now()
+	if expiry := memcacheha.SecondsToExpiration(60); expiry == nil || expiry.Before(before.Add(60*time.Second)) || expiry.After(time.Now().Add(60*time.Second)) {
+		t.Fatalf("SecondsToExpiration(60) returned %v, expected 60s from now", expiry)
+	}
+	unix := int32(memcacheha.MEMCACHE_RELATIVE_EXPIRY_MAX + 1)
+	if expiry := memcacheha.SecondsToExpiration(unix); expiry == nil || expiry.Unix() != int64(unix) {
+		t.Fatalf("SecondsToExpiration(%d) returned %v, expected a Unix time", unix, expiry)
+	}
+
+	if seconds := memcacheha.ExpirationToSeconds(nil); seconds != 0 {
+		t.Fatalf("ExpirationToSeconds(nil) returned %d, expected 0", seconds)
+	}
+	past := time.Now().Add(-time.Minute)
+	if seconds := memcacheha.ExpirationToSeconds(&past); seconds != 1 {
+		t.Fatalf("ExpirationToSeconds of a past time returned %d, expected 1", seconds)
+	}
+	far := time.Now().Add(60 * 24 * time.Hour)
+	if seconds := memcacheha.ExpirationToSeconds(&far); int64(seconds) != far.Unix() {
+		t.Fatalf("ExpirationToSeconds beyond 30 days returned %d, expected the Unix time %d", seconds, far.Unix())
+	}
+}
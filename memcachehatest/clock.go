@@ -0,0 +1,94 @@
+// Package memcachehatest provides helpers for testing code that uses memcacheha
+package memcachehatest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock.Clock that only moves when Advance or Set is called, so tests can expire items and trigger
+// healthchecks without sleeping. It is safe for concurrent use.
+type Clock struct {
+	now    time.Time
+	timers []*timer
+	mutex  sync.Mutex
+}
+
+// timer is a pending After
+type timer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewClock returns a Clock stopped at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// After returns a channel receiving the time of the clock once it has been advanced by d
+func (clock *Clock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	t := &timer{deadline: clock.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- clock.now
+		return t.c
+	}
+	clock.timers = append(clock.timers, t)
+	return t.c
+}
+
+// Advance moves the clock forward by d, firing the timers due
+func (clock *Clock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.setLocked(clock.now.Add(d))
+}
+
+// Set moves the clock to now, firing the timers due. The clock may be moved backwards, which fires no timers.
+func (clock *Clock) Set(now time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.setLocked(now)
+}
+
+func (clock *Clock) setLocked(now time.Time) {
+	clock.now = now
+	pending := clock.timers[:0]
+	for _, t := range clock.timers {
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- now
+	}
+	clock.timers = pending
+}
+
+// Timers returns the number of timers waiting to fire, e.g. to wait until a client's runloop is idle before
+// advancing the clock
+func (clock *Clock) Timers() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return len(clock.timers)
+}
+
+// WaitForTimers waits, in real time, until at least n timers are waiting to fire, returning false if timeout passes
+// first
+func (clock *Clock) WaitForTimers(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for clock.Timers() < n {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package memcachehatest
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Cluster is an in-process fake memcache cluster of MemoryNodeClients, with controllable contents, latencies and
+// failures. It is safe for concurrent use.
+type Cluster struct {
+	mutex     sync.Mutex
+	nodes     map[string]*memcacheha.MemoryNodeClient
+	endpoints []string
+	clock     *Clock
+}
+
+// NewCluster returns a Cluster of n empty nodes, with the endpoints node1:11211 to nodeN:11211
+func NewCluster(n int) *Cluster {
+	cluster := &Cluster{nodes: map[string]*memcacheha.MemoryNodeClient{}}
+	for i := 1; i <= n; i++ {
+		cluster.AddNode(fmt.Sprintf("node%d:11211", i))
+	}
+	return cluster
+}
+
+// AddNode adds an empty node with the given endpoint, returning it. Clients created with NewClient discover it on
+// their next discovery.
+func (cluster *Cluster) AddNode(endpoint string) *memcacheha.MemoryNodeClient {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if node, found := cluster.nodes[endpoint]; found {
+		return node
+	}
+	node := memcacheha.NewMemoryNodeClient()
+	if cluster.clock != nil {
+		node.Clock = cluster.clock
+	}
+	cluster.nodes[endpoint] = node
+	cluster.endpoints = append(cluster.endpoints, endpoint)
+	sort.Strings(cluster.endpoints)
+	return node
+}
+
+// RemoveNode removes the node with the given endpoint. Clients created with NewClient drop it on their next discovery.
+func (cluster *Cluster) RemoveNode(endpoint string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	delete(cluster.nodes, endpoint)
+	for i, e := range cluster.endpoints {
+		if e == endpoint {
+			cluster.endpoints = append(cluster.endpoints[:i], cluster.endpoints[i+1:]...)
+			break
+		}
+	}
+}
+
+// SetClock makes the nodes, and clients created afterwards with NewClient, use clk. Call it before the nodes are
+// used.
+func (cluster *Cluster) SetClock(clk *Clock) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	cluster.clock = clk
+	for _, node := range cluster.nodes {
+		node.Clock = clk
+	}
+}
+
+// Endpoints returns the endpoints of the nodes, sorted
+func (cluster *Cluster) Endpoints() []string {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	return append([]string(nil), cluster.endpoints...)
+}
+
+// Node returns the node with the given endpoint, or nil if there is none
+func (cluster *Cluster) Node(endpoint string) *memcacheha.MemoryNodeClient {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	return cluster.nodes[endpoint]
+}
+
+// GetNodes implements memcacheha.NodeSource, returning the endpoints of the nodes
+func (cluster *Cluster) GetNodes() ([]string, error) {
+	return cluster.Endpoints(), nil
+}
+
+// NewNodeClient implements memcacheha.NodeClientFactory. Unknown endpoints get a node that fails every operation.
+func (cluster *Cluster) NewNodeClient(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+	if node := cluster.Node(endpoint); node != nil {
+		return node
+	}
+	node := memcacheha.NewMemoryNodeClient()
+	node.SetError(memcacheha.ErrNodeNetwork)
+	return node
+}
+
+// Fail makes all operations on the given endpoints, or on all nodes if none are given, return err. A nil err restores
+// normal operation.
+func (cluster *Cluster) Fail(err error, endpoints ...string) {
+	for _, node := range cluster.selectNodes(endpoints) {
+		node.SetError(err)
+	}
+}
+
+// Recover restores normal operation of the given endpoints, or of all nodes if none are given
+func (cluster *Cluster) Recover(endpoints ...string) {
+	cluster.Fail(nil, endpoints...)
+}
+
+// SetLatency delays all operations on the given endpoints, or on all nodes if none are given
+func (cluster *Cluster) SetLatency(latency time.Duration, endpoints ...string) {
+	for _, node := range cluster.selectNodes(endpoints) {
+		node.SetLatency(latency)
+	}
+}
+
+// Put stores item on the given endpoints, or on all nodes if none are given, bypassing any failures, as if it had
+// been written by a memcacheha client
+func (cluster *Cluster) Put(item *memcacheha.Item, endpoints ...string) {
+	for _, node := range cluster.selectNodes(endpoints) {
+		node.Store(item.AsMemcacheItem())
+	}
+}
+
+// Remove deletes key from the given endpoints, or from all nodes if none are given, bypassing any failures
+func (cluster *Cluster) Remove(key string, endpoints ...string) {
+	for _, node := range cluster.selectNodes(endpoints) {
+		node.Evict(key)
+	}
+}
+
+// Value returns the value of key held by the node with the given endpoint, bypassing any failures, or nil if the node
+// doesn't hold key. Values not written by memcacheha are returned as stored.
+func (cluster *Cluster) Value(endpoint string, key string) []byte {
+	node := cluster.Node(endpoint)
+	if node == nil {
+		return nil
+	}
+	item := node.Peek(key)
+	if item == nil {
+		return nil
+	}
+	haItem, err := memcacheha.NewItemFromMemcacheItem(item)
+	if err != nil {
+		return item.Value
+	}
+	return haItem.Value
+}
+
+// Flush removes all items from all nodes
+func (cluster *Cluster) Flush() {
+	for _, node := range cluster.selectNodes(nil) {
+		node.Flush()
+	}
+}
+
+// NewClient returns a started memcacheha.Client using the nodes of this cluster, once all of them are healthy. The
+// client is stopped when the test finishes. Use configure to set fields of the client before it starts.
+func (cluster *Cluster) NewClient(t testing.TB, configure ...func(*memcacheha.Client)) *memcacheha.Client {
+	t.Helper()
+	client := memcacheha.New(logger.NewConsoleLogger("error"), cluster)
+	client.NewNodeClient = cluster.NewNodeClient
+	cluster.mutex.Lock()
+	if cluster.clock != nil {
+		client.Clock = cluster.clock
+	}
+	cluster.mutex.Unlock()
+	for _, fn := range configure {
+		fn(client)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.GetNodes()
+	if err := client.Start(); err != nil {
+		t.Fatalf("memcachehatest: Start failed: %s", err)
+	}
+	t.Cleanup(func() { client.Stop() })
+	if err := client.WaitForNodesContext(ctx, len(cluster.Endpoints())); err != nil {
+		t.Fatalf("memcachehatest: %s", err)
+	}
+	return client
+}
+
+// AssertConsistent fails the test if the nodes don't all hold the same value for key, bypassing any failures. Values
+// are decoded by client, see the AssertConsistent function.
+func (cluster *Cluster) AssertConsistent(t testing.TB, client *memcacheha.Client, key string) {
+	t.Helper()
+	items := map[string]*memcache.Item{}
+	for endpoint, node := range cluster.selectNodes(nil) {
+		items[endpoint] = node.Peek(key)
+	}
+	AssertConsistent(t, client, key, items)
+}
+
+// AssertConsistent fails the test if items, the item stored for key by each endpoint or nil if it holds none, don't
+// all have the same value once decoded by client with Client.DecodeItem, so values encrypted or signed by client are
+// compared as written. A nil client only strips the memcacheha header. Values that fail to decode are compared as
+// stored.
+func AssertConsistent(t testing.TB, client *memcacheha.Client, key string, items map[string]*memcache.Item) {
+	t.Helper()
+	endpoints := make([]string, 0, len(items))
+	for endpoint := range items {
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return
+	}
+	sort.Strings(endpoints)
+	expected := decodedValue(client, items[endpoints[0]])
+	for _, endpoint := range endpoints[1:] {
+		if value := decodedValue(client, items[endpoint]); !bytes.Equal(value, expected) {
+			t.Fatalf("memcachehatest: %s is inconsistent, %s holds %q and %s holds %q", key, endpoints[0], expected, endpoint, value)
+		}
+	}
+}
+
+// decodedValue returns the value of item decoded by client, or by NewItemFromMemcacheItem if client is nil. Nil is
+// returned for a nil item, and the stored value if it does not decode.
+func decodedValue(client *memcacheha.Client, item *memcache.Item) []byte {
+	if item == nil {
+		return nil
+	}
+	decode := memcacheha.NewItemFromMemcacheItem
+	if client != nil {
+		decode = client.DecodeItem
+	}
+	haItem, err := decode(item)
+	if err != nil {
+		return item.Value
+	}
+	return haItem.Value
+}
+
+// AssertValue fails the test if any node doesn't hold value for key, bypassing any failures. A nil value asserts
+// that no node holds key.
+func (cluster *Cluster) AssertValue(t testing.TB, key string, value []byte) {
+	t.Helper()
+	for _, endpoint := range cluster.Endpoints() {
+		if got := cluster.Value(endpoint, key); !bytes.Equal(got, value) {
+			t.Fatalf("memcachehatest: %s holds %q for %s, expected %q", endpoint, got, key, value)
+		}
+	}
+}
+
+// selectNodes returns the nodes with the given endpoints, or all nodes if none are given, keyed by endpoint
+func (cluster *Cluster) selectNodes(endpoints []string) map[string]*memcacheha.MemoryNodeClient {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	out := map[string]*memcacheha.MemoryNodeClient{}
+	if len(endpoints) == 0 {
+		endpoints = cluster.endpoints
+	}
+	for _, endpoint := range endpoints {
+		if node, found := cluster.nodes[endpoint]; found {
+			out[endpoint] = node
+		}
+	}
+	return out
+}
@@ -0,0 +1,371 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/memcacheha/clock"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryNodeClient is an in-memory NodeClient for testing, with controllable failures and latency.
+// It is safe for concurrent use.
+type MemoryNodeClient struct {
+	// Clock is the time at which items expire. Defaults to clock.Real.
+	Clock clock.Clock
+
+	mutex   sync.Mutex
+	items   map[string]*memcache.Item
+	expiry  map[string]time.Time
+	casid   uint64
+	err     error
+	latency time.Duration
+	created time.Time
+}
+
+// NewMemoryNodeClient returns a new, empty MemoryNodeClient
+func NewMemoryNodeClient() *MemoryNodeClient {
+	return &MemoryNodeClient{
+		items:   map[string]*memcache.Item{},
+		expiry:  map[string]time.Time{},
+		created: time.Now(),
+		Clock:   clock.Real,
+	}
+}
+
+// SetError causes all subsequent operations to return err without being performed. A nil err restores normal operation.
+func (memoryNodeClient *MemoryNodeClient) SetError(err error) {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.err = err
+}
+
+// SetLatency causes all subsequent operations to sleep for latency before being performed
+func (memoryNodeClient *MemoryNodeClient) SetLatency(latency time.Duration) {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.latency = latency
+}
+
+// Flush removes all items
+func (memoryNodeClient *MemoryNodeClient) Flush() {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.items = map[string]*memcache.Item{}
+	memoryNodeClient.expiry = map[string]time.Time{}
+}
+
+// FlushAll removes all items, see Client.FlushAll
+func (memoryNodeClient *MemoryNodeClient) FlushAll() error {
+	// begin requires a valid key
+	if err := memoryNodeClient.begin("flush_all"); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.items = map[string]*memcache.Item{}
+	memoryNodeClient.expiry = map[string]time.Time{}
+	return nil
+}
+
+// Ping responds as the version command does, see HEALTHCHECK_PROBE_VERSION
+func (memoryNodeClient *MemoryNodeClient) Ping() error {
+	// begin requires a valid key
+	if err := memoryNodeClient.begin("version"); err != nil {
+		return err
+	}
+	memoryNodeClient.mutex.Unlock()
+	return nil
+}
+
+// Stats returns the version "memory", the uptime of this client, and the number and size of its unexpired items as
+// curr_items and bytes, as the stats command does
+func (memoryNodeClient *MemoryNodeClient) Stats() (map[string]string, error) {
+	// begin requires a valid key
+	if err := memoryNodeClient.begin("stats"); err != nil {
+		return nil, err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	items, size := 0, 0
+	for key, item := range memoryNodeClient.items {
+		if memoryNodeClient.lookup(key) != nil {
+			items++
+			size += len(key) + len(item.Value)
+		}
+	}
+	return map[string]string{
+		"version":    "memory",
+		"uptime":     strconv.Itoa(int(time.Since(memoryNodeClient.created).Seconds())),
+		"curr_items": strconv.Itoa(items),
+		"bytes":      strconv.Itoa(size),
+	}, nil
+}
+
+// Len returns the number of unexpired items
+func (memoryNodeClient *MemoryNodeClient) Len() int {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	count := 0
+	for key := range memoryNodeClient.items {
+		if memoryNodeClient.lookup(key) != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Peek returns a copy of the unexpired item for key, or nil, bypassing SetError and SetLatency
+func (memoryNodeClient *MemoryNodeClient) Peek(key string) *memcache.Item {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	item := memoryNodeClient.lookup(key)
+	if item == nil {
+		return nil
+	}
+	return copyMemcacheItem(item)
+}
+
+// Store stores item as Set does, bypassing SetError and SetLatency
+func (memoryNodeClient *MemoryNodeClient) Store(item *memcache.Item) {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.store(item)
+}
+
+// Evict removes the item for key, as if memcached had evicted it, bypassing SetError and SetLatency
+func (memoryNodeClient *MemoryNodeClient) Evict(key string) {
+	memoryNodeClient.mutex.Lock()
+	defer memoryNodeClient.mutex.Unlock()
+	delete(memoryNodeClient.items, key)
+	delete(memoryNodeClient.expiry, key)
+}
+
+// DumpKeys calls fn with the metadata of each unexpired item, as DumpKeys does for a memcache server. Endpoint is not
+// set, and Size is that of the key and value.
+func (memoryNodeClient *MemoryNodeClient) DumpKeys(ctx context.Context, fn func(*KeyMeta) error) error {
+	// begin requires a valid key
+	if err := memoryNodeClient.begin("lru_crawler"); err != nil {
+		return err
+	}
+	var metas []*KeyMeta
+	for key, item := range memoryNodeClient.items {
+		if memoryNodeClient.lookup(key) == nil {
+			continue
+		}
+		meta := &KeyMeta{Key: key, Size: len(key) + len(item.Value)}
+		if expiry, found := memoryNodeClient.expiry[key]; found {
+			meta.Expiration = &expiry
+		}
+		metas = append(metas, meta)
+	}
+	memoryNodeClient.mutex.Unlock()
+
+	for _, meta := range metas {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) Get(key string) (*memcache.Item, error) {
+	if err := memoryNodeClient.begin(key); err != nil {
+		return nil, err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	item := memoryNodeClient.lookup(key)
+	if item == nil {
+		return nil, memcache.ErrCacheMiss
+	}
+	return copyMemcacheItem(item), nil
+}
+
+// GetInto implements NodeBufferGetter
+func (memoryNodeClient *MemoryNodeClient) GetInto(key string, buf []byte) (int, uint32, error) {
+	if err := memoryNodeClient.begin(key); err != nil {
+		return 0, 0, err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	item := memoryNodeClient.lookup(key)
+	if item == nil {
+		return 0, 0, memcache.ErrCacheMiss
+	}
+	if len(buf) < len(item.Value) {
+		return len(item.Value), item.Flags, io.ErrShortBuffer
+	}
+	return copy(buf, item.Value), item.Flags, nil
+}
+
+// GetMulti reads several keys in one request, as *memcache.Client does. Keys not found are not in the result.
+func (memoryNodeClient *MemoryNodeClient) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	items := map[string]*memcache.Item{}
+	if len(keys) == 0 {
+		return items, nil
+	}
+	for _, key := range keys {
+		if ValidateKey(key) != nil {
+			return nil, memcache.ErrMalformedKey
+		}
+	}
+	if err := memoryNodeClient.begin(keys[0]); err != nil {
+		return nil, err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	for _, key := range keys {
+		if item := memoryNodeClient.lookup(key); item != nil {
+			items[key] = copyMemcacheItem(item)
+		}
+	}
+	return items, nil
+}
+
+// Set implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) Set(item *memcache.Item) error {
+	if err := memoryNodeClient.begin(item.Key); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	memoryNodeClient.store(item)
+	return nil
+}
+
+// Add implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) Add(item *memcache.Item) error {
+	if err := memoryNodeClient.begin(item.Key); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	if memoryNodeClient.lookup(item.Key) != nil {
+		return memcache.ErrNotStored
+	}
+	memoryNodeClient.store(item)
+	return nil
+}
+
+// CompareAndSwap implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) CompareAndSwap(item *memcache.Item) error {
+	if err := memoryNodeClient.begin(item.Key); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	existing := memoryNodeClient.lookup(item.Key)
+	if existing == nil {
+		return memcache.ErrCacheMiss
+	}
+	if existing.CasID != item.CasID {
+		return memcache.ErrCASConflict
+	}
+	memoryNodeClient.store(item)
+	return nil
+}
+
+// Delete implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) Delete(key string) error {
+	if err := memoryNodeClient.begin(key); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	if memoryNodeClient.lookup(key) == nil {
+		return memcache.ErrCacheMiss
+	}
+	delete(memoryNodeClient.items, key)
+	delete(memoryNodeClient.expiry, key)
+	return nil
+}
+
+// Touch implements NodeClient
+func (memoryNodeClient *MemoryNodeClient) Touch(key string, seconds int32) error {
+	if err := memoryNodeClient.begin(key); err != nil {
+		return err
+	}
+	defer memoryNodeClient.mutex.Unlock()
+	if memoryNodeClient.lookup(key) == nil {
+		return memcache.ErrCacheMiss
+	}
+	memoryNodeClient.setExpiry(key, seconds)
+	return nil
+}
+
+// Batch implements NodeBatcher, performing each operation as its NodeClient method does. The first error failing the
+// node is returned, as a connection error would be.
+func (memoryNodeClient *MemoryNodeClient) Batch(ops []*NodeBatchOp) error {
+	for _, op := range ops {
+		switch op.Type {
+		case NODE_BATCH_SET:
+			op.Err = memoryNodeClient.Set(op.Item)
+		case NODE_BATCH_DELETE:
+			op.Err = memoryNodeClient.Delete(op.Key)
+		case NODE_BATCH_TOUCH:
+			op.Err = memoryNodeClient.Touch(op.Key, op.Seconds)
+		}
+		if isNodeFailure(op.Err) {
+			return op.Err
+		}
+	}
+	return nil
+}
+
+// begin applies latency and failures, validates the key and, if no error is returned, leaves the mutex locked
+func (memoryNodeClient *MemoryNodeClient) begin(key string) error {
+	memoryNodeClient.mutex.Lock()
+	latency := memoryNodeClient.latency
+	memoryNodeClient.mutex.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	memoryNodeClient.mutex.Lock()
+	if memoryNodeClient.err != nil {
+		err := memoryNodeClient.err
+		memoryNodeClient.mutex.Unlock()
+		return err
+	}
+	if ValidateKey(key) != nil {
+		memoryNodeClient.mutex.Unlock()
+		return memcache.ErrMalformedKey
+	}
+	return nil
+}
+
+func (memoryNodeClient *MemoryNodeClient) lookup(key string) *memcache.Item {
+	item, found := memoryNodeClient.items[key]
+	if !found {
+		return nil
+	}
+	if expiry, found := memoryNodeClient.expiry[key]; found && !expiry.After(memoryNodeClient.Clock.Now()) {
+		delete(memoryNodeClient.items, key)
+		delete(memoryNodeClient.expiry, key)
+		return nil
+	}
+	return item
+}
+
+func (memoryNodeClient *MemoryNodeClient) store(item *memcache.Item) {
+	memoryNodeClient.casid++
+	stored := copyMemcacheItem(item)
+	stored.CasID = memoryNodeClient.casid
+	memoryNodeClient.items[item.Key] = stored
+	memoryNodeClient.setExpiry(item.Key, item.Expiration)
+}
+
+func (memoryNodeClient *MemoryNodeClient) setExpiry(key string, seconds int32) {
+	expiry := secondsToExpiration(seconds, memoryNodeClient.Clock.Now())
+	if expiry == nil {
+		delete(memoryNodeClient.expiry, key)
+		return
+	}
+	memoryNodeClient.expiry[key] = *expiry
+}
+
+func copyMemcacheItem(item *memcache.Item) *memcache.Item {
+	out := *item
+	out.Value = append([]byte(nil), item.Value...)
+	return &out
+}
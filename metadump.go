@@ -0,0 +1,281 @@
+package memcacheha
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDumpNotSupported is an error meaning a server supports neither lru_crawler metadump nor stats cachedump
+var ErrDumpNotSupported = errors.New("memcacheha: server does not support key enumeration")
+
+// KeyMeta is the metadata of a key enumerated from a memcache server
+type KeyMeta struct {
+	// Key is the item's key
+	Key string
+	// Expiration is the absolute expiry time of the item, or nil for no expiry
+	Expiration *time.Time
+	// LastAccess is the time the item was last accessed, or the zero time if unknown
+	LastAccess time.Time
+	// Size is the size of the item in bytes, including memcached overhead
+	Size int
+	// Endpoint is the server the key was enumerated from
+	Endpoint string
+}
+
+// DumpKeys enumerates the keys held by the memcache server at endpoint, calling fn for each key. `lru_crawler
+// metadump all` is used where supported (memcached 1.4.31+), otherwise `stats cachedump`, which is limited to
+// 2MB of keys per slab class. Enumeration stops if fn returns an error or ctx is done.
+func DumpKeys(ctx context.Context, endpoint string, timeout time.Duration, fn func(*KeyMeta) error) error {
+	conn, err := net.DialTimeout("tcp", endpoint, timeout)
+	if err != nil {
+		return err
+	}
+	return dumpKeys(ctx, conn, endpoint, timeout, fn)
+}
+
+// dumpKeys performs DumpKeys on conn, closing it on return
+func dumpKeys(ctx context.Context, conn net.Conn, endpoint string, timeout time.Duration, fn func(*KeyMeta) error) error {
+	defer conn.Close()
+
+	// Unblock reads when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	dump := &keyDump{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		endpoint: endpoint,
+		timeout:  timeout,
+	}
+	err := dump.metadump(fn)
+	if err == ErrDumpNotSupported {
+		err = dump.cachedump(fn)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Keys streams the metadata of the keys on the healthy nodes matching pattern (see path.Match, "" for all keys), e.g.
+// for audits, sampling or migrations. Keys held by several nodes are sent once. Nodes are enumerated one at a time
+// with NodeKeyDumper, `lru_crawler metadump` for memcached nodes (see DumpKeys); nodes that fail or cannot enumerate
+// are logged and skipped, so keys held only by them are not sent. Expired keys and healthcheck keys are skipped, and
+// keys replaced by HashLongKeys are sent hashed. The channel is closed when all nodes are done or ctx is done.
+func (client *Client) Keys(ctx context.Context, pattern string) (<-chan KeyMeta, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	nodes := client.Nodes.GetHealthyNodes()
+	if len(nodes) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+	endpoints := make([]string, 0, len(nodes))
+	for endpoint := range nodes {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	client.configMutex.RLock()
+	healthCheckPrefix := client.HealthCheckKeyPrefix
+	client.configMutex.RUnlock()
+
+	keys := make(chan KeyMeta)
+	go func() {
+		defer close(keys)
+		seen := map[string]bool{}
+		for _, endpoint := range endpoints {
+			dumper, ok := nodes[endpoint].getClient().(NodeKeyDumper)
+			if !ok {
+				client.levelLog.Warn("Keys: Node %s cannot enumerate keys, skipping it", endpoint)
+				continue
+			}
+			now := client.Clock.Now()
+			err := dumper.DumpKeys(ctx, func(meta *KeyMeta) error {
+				switch {
+				case seen[meta.Key]:
+					return nil
+				case healthCheckPrefix != "" && strings.HasPrefix(meta.Key, healthCheckPrefix):
+					return nil
+				case meta.Expiration != nil && !meta.Expiration.After(now):
+					return nil
+				}
+				if pattern != "" {
+					if matched, _ := path.Match(pattern, meta.Key); !matched {
+						return nil
+					}
+				}
+				seen[meta.Key] = true
+				if meta.Endpoint == "" {
+					meta.Endpoint = endpoint
+				}
+				select {
+				case keys <- *meta:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				client.levelLog.Warn("Keys: Enumerating node %s failed, skipping it: %s", endpoint, err)
+			}
+		}
+	}()
+	return keys, nil
+}
+
+// keyDump is a connection to a single memcache server used for key enumeration
+type keyDump struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	endpoint string
+	timeout  time.Duration
+}
+
+func (dump *keyDump) command(cmd string) error {
+	dump.conn.SetWriteDeadline(time.Now().Add(dump.timeout))
+	_, err := fmt.Fprintf(dump.conn, "%s\r\n", cmd)
+	return err
+}
+
+func (dump *keyDump) readLine() (string, error) {
+	dump.conn.SetReadDeadline(time.Now().Add(dump.timeout))
+	line, err := dump.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return line, ErrDumpNotSupported
+	}
+	return line, nil
+}
+
+// metadump enumerates keys with `lru_crawler metadump all`. Lines are of the form:
+// key=<url encoded key> exp=<unix time or -1> la=<unix time> cas=<cas> fetch=<yes|no> cls=<class> size=<bytes>
+func (dump *keyDump) metadump(fn func(*KeyMeta) error) error {
+	if err := dump.command("lru_crawler metadump all"); err != nil {
+		return err
+	}
+	for {
+		line, err := dump.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+
+		meta := &KeyMeta{Endpoint: dump.endpoint}
+		for _, field := range strings.Fields(line) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "key":
+				meta.Key, err = url.QueryUnescape(parts[1])
+				if err != nil {
+					meta.Key = parts[1]
+				}
+			case "exp":
+				exp, _ := strconv.ParseInt(parts[1], 10, 64)
+				if exp > 0 {
+					expiry := time.Unix(exp, 0)
+					meta.Expiration = &expiry
+				}
+			case "la":
+				if la, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+					meta.LastAccess = time.Unix(la, 0)
+				}
+			case "size":
+				meta.Size, _ = strconv.Atoi(parts[1])
+			}
+		}
+		if meta.Key == "" {
+			continue
+		}
+		if err := fn(meta); err != nil {
+			return err
+		}
+	}
+}
+
+// cachedump enumerates keys with `stats items` and `stats cachedump <class> 0`. Lines are of the form:
+// ITEM <key> [<size> b; <unix time> s]
+func (dump *keyDump) cachedump(fn func(*KeyMeta) error) error {
+	// Find slab classes
+	if err := dump.command("stats items"); err != nil {
+		return err
+	}
+	var classes []string
+	seen := map[string]bool{}
+	for {
+		line, err := dump.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			break
+		}
+		// STAT items:<class>:number <count>
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		parts := strings.Split(fields[1], ":")
+		if len(parts) == 3 && parts[0] == "items" && !seen[parts[1]] {
+			seen[parts[1]] = true
+			classes = append(classes, parts[1])
+		}
+	}
+
+	for _, class := range classes {
+		if err := dump.command("stats cachedump " + class + " 0"); err != nil {
+			return err
+		}
+		for {
+			line, err := dump.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				break
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 6 || fields[0] != "ITEM" {
+				continue
+			}
+			meta := &KeyMeta{Key: fields[1], Endpoint: dump.endpoint}
+			meta.Size, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "["))
+			exp, _ := strconv.ParseInt(fields[4], 10, 64)
+			if exp > time.Now().Unix() {
+				expiry := time.Unix(exp, 0)
+				meta.Expiration = &expiry
+			}
+			if err := fn(meta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
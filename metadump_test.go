@@ -0,0 +1,213 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpServer starts a fake memcache server answering each command with its response in responses, and with none to
+// unknown commands. It returns the server's endpoint.
+func dumpServer(t *testing.T, responses map[string]string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if response, found := responses[strings.TrimRight(line, "\r\n")]; found {
+						conn.Write([]byte(response))
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dumpKeys returns the keys enumerated from the server at endpoint
+func dumpKeys(t *testing.T, endpoint string) []memcacheha.KeyMeta {
+	var keys []memcacheha.KeyMeta
+	err := memcacheha.DumpKeys(context.Background(), endpoint, time.Second, func(meta *memcacheha.KeyMeta) error {
+		keys = append(keys, *meta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DumpKeys failed: %s", err)
+	}
+	return keys
+}
+
+func TestDumpKeysMetadump(t *testing.T) {
+	endpoint := dumpServer(t, map[string]string{
+		"lru_crawler metadump all": strings.Join([]string{
+			"key=plain exp=-1 la=1700000000 cas=1 fetch=no cls=1 size=70",
+			"key=with%20space%2Bplus exp=1700000100 la=1700000050 cas=2 fetch=yes cls=1 size=80",
+			"garbage",
+			"key= exp=-1 la=1700000000 size=10",
+			"exp=-1 la=1700000000 size=10",
+			"key=%zz exp=never la= size=large",
+			"key=fields size noequals",
+			"END",
+		}, "\r\n") + "\r\n",
+	})
+
+	// Lines without a key are skipped, and unparseable fields left unset
+	expiry := time.Unix(1700000100, 0)
+	expected := []memcacheha.KeyMeta{
+		{Key: "plain", LastAccess: time.Unix(1700000000, 0), Size: 70, Endpoint: endpoint},
+		{Key: "with space+plus", Expiration: &expiry, LastAccess: time.Unix(1700000050, 0), Size: 80, Endpoint: endpoint},
+		{Key: "%zz", Endpoint: endpoint},
+		{Key: "fields", Endpoint: endpoint},
+	}
+	if keys := dumpKeys(t, endpoint); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("DumpKeys returned %+v, expected %+v", keys, expected)
+	}
+}
+
+func TestDumpKeysCachedump(t *testing.T) {
+	future := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	endpoint := dumpServer(t, map[string]string{
+		"lru_crawler metadump all": "ERROR\r\n",
+		"stats items":              "STAT items:1:number 2\r\nSTAT items:1:age 10\r\nSTAT items:3:number 1\r\nSTAT\r\nEND\r\n",
+		"stats cachedump 1 0":      "ITEM a [10 b; 0 s]\r\nITEM b [20 b; " + strconv.FormatInt(future.Unix(), 10) + " s]\r\nITEM short\r\nEND\r\n",
+		"stats cachedump 3 0":      "ITEM c [30 b; 1 s]\r\nEND\r\n",
+	})
+
+	// Servers without metadump are enumerated by slab class, and past or zero expiries are not expiries
+	expected := []memcacheha.KeyMeta{
+		{Key: "a", Size: 10, Endpoint: endpoint},
+		{Key: "b", Expiration: &future, Size: 20, Endpoint: endpoint},
+		{Key: "c", Size: 30, Endpoint: endpoint},
+	}
+	if keys := dumpKeys(t, endpoint); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("DumpKeys returned %+v, expected %+v", keys, expected)
+	}
+}
+
+func TestDumpKeysStops(t *testing.T) {
+	endpoint := dumpServer(t, map[string]string{
+		"lru_crawler metadump all": "key=a size=1\r\nkey=b size=1\r\nEND\r\n",
+	})
+
+	// An error of fn stops enumeration and is returned
+	stop := errors.New("stop")
+	calls := 0
+	err := memcacheha.DumpKeys(context.Background(), endpoint, time.Second, func(meta *memcacheha.KeyMeta) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("DumpKeys returned %v after %d calls, expected the error of the first call", err, calls)
+	}
+
+	// As does ctx, even while waiting for the server
+	silent := dumpServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = memcacheha.DumpKeys(ctx, silent, time.Minute, func(meta *memcacheha.KeyMeta) error { return nil })
+	if err != context.DeadlineExceeded {
+		t.Fatalf("DumpKeys returned %v, expected the error of ctx", err)
+	}
+}
+
+// collectKeys returns the metadata of the keys sent by keys, by key
+func collectKeys(keys <-chan memcacheha.KeyMeta) map[string]memcacheha.KeyMeta {
+	metas := map[string]memcacheha.KeyMeta{}
+	for meta := range keys {
+		metas[meta.Key] = meta
+	}
+	return metas
+}
+
+func TestClientKeys(t *testing.T) {
+	if _, err := memcacheha.New(nil).Keys(context.Background(), ""); err != memcacheha.ErrNoHealthyNodes {
+		t.Fatalf("Keys without nodes returned %v, expected ErrNoHealthyNodes", err)
+	}
+
+	clk := memcachehatest.NewClock(time.Now())
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	client := cluster.NewClient(t)
+	if _, err := client.Keys(context.Background(), "["); err != path.ErrBadPattern {
+		t.Fatalf("Keys with a malformed pattern returned %v, expected ErrBadPattern", err)
+	}
+
+	expiry := clk.Now().Add(time.Second)
+	cluster.Put(&memcacheha.Item{Key: "all", Value: []byte("value")})
+	cluster.Put(&memcacheha.Item{Key: "user:2", Value: []byte("value")}, "node2:11211")
+	cluster.Put(&memcacheha.Item{Key: "user:3", Value: []byte("value")}, "node3:11211")
+	cluster.Put(&memcacheha.Item{Key: "expired", Value: []byte("value"), Expiration: &expiry})
+	cluster.Put(&memcacheha.Item{Key: client.HealthCheckKeyPrefix + "probe", Value: []byte("value")})
+	clk.Advance(2 * time.Second)
+
+	// Keys held by several nodes are sent once, from the first node holding them, and expired and healthcheck keys
+	// are skipped
+	keys, err := client.Keys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Keys failed: %s", err)
+	}
+	metas := collectKeys(keys)
+	endpoints := map[string]string{}
+	for key, meta := range metas {
+		endpoints[key] = meta.Endpoint
+	}
+	expected := map[string]string{"all": "node1:11211", "user:2": "node2:11211", "user:3": "node3:11211"}
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Fatalf("Keys sent %v, expected %v", endpoints, expected)
+	}
+
+	// Only keys matching the pattern are sent
+	keys, err = client.Keys(context.Background(), "user:*")
+	if err != nil {
+		t.Fatalf("Keys failed: %s", err)
+	}
+	if metas := collectKeys(keys); len(metas) != 2 || metas["user:2"].Key == "" || metas["user:3"].Key == "" {
+		t.Fatalf("Keys matching user:* sent %v", metas)
+	}
+
+	// A node that fails is skipped, and keys held only by it are not sent
+	cluster.Fail(errors.New("node failure"), "node2:11211")
+	keys, err = client.Keys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Keys failed: %s", err)
+	}
+	if metas := collectKeys(keys); len(metas) != 2 || metas["all"].Key == "" || metas["user:3"].Key == "" {
+		t.Fatalf("Keys with a failed node sent %v", metas)
+	}
+	cluster.Recover("node2:11211")
+
+	// The channel is closed once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	keys, err = client.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys failed: %s", err)
+	}
+	<-keys
+	cancel()
+	for range keys {
+	}
+}
@@ -0,0 +1,290 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// METRICS_LATENCY_BUCKETS are the upper bounds of the latency histogram buckets of Metrics. Latencies above the
+// last bound are counted in a final bucket bounded by the maximum latency.
+var METRICS_LATENCY_BUCKETS = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyBucket is the number of operations that completed within UpperBound, and above the previous bucket
+type LatencyBucket struct {
+	UpperBound time.Duration `json:"upper_bound"`
+	Count      uint64        `json:"count"`
+}
+
+// LatencyHistogram is the distribution of the latencies of an operation. Percentiles are the upper bound of the
+// bucket they fall in.
+type LatencyHistogram struct {
+	Count   uint64          `json:"count"`
+	Sum     time.Duration   `json:"sum"`
+	Max     time.Duration   `json:"max"`
+	P50     time.Duration   `json:"p50"`
+	P90     time.Duration   `json:"p90"`
+	P99     time.Duration   `json:"p99"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// Snapshot is a copy of the metrics of a client since it was created or last reset, see Metrics
+type Snapshot struct {
+	Since time.Time `json:"since"`
+	// Hits and Misses are the Gets that found and did not find their key
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Ops are the counts of each operation
+	Ops map[string]OpCounts `json:"ops"`
+	// Latency is the latency histogram of each operation
+	Latency map[string]LatencyHistogram `json:"latency"`
+	// Repairs are the repair writes sent and dropped
+	Repairs RepairStats `json:"repairs"`
+	// OversizedValues are the writes rejected because their value exceeded MaxValueSize
+	OversizedValues uint64 `json:"oversized_values"`
+	// ValueSizes is the size histogram of the values written, and PrefixValueSizes that of the keys with each of
+	// SizePrefixes
+	ValueSizes       SizeHistogram            `json:"value_sizes"`
+	PrefixValueSizes map[string]SizeHistogram `json:"prefix_value_sizes,omitempty"`
+	// Tenants are the operations of each tenant allowed and rejected by TenantQuotas
+	Tenants map[string]TenantCounts `json:"tenants,omitempty"`
+	// NodeErrors are the number of failed requests to each current node
+	NodeErrors map[string]uint64 `json:"node_errors"`
+	// HealthChecks are the results of the last healthcheck of each current node
+	HealthChecks map[string]HealthCheckResult `json:"healthchecks"`
+}
+
+// opMetrics are the counts and latencies of one operation
+type opMetrics struct {
+	counts  OpCounts
+	buckets []uint64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// clientMetrics holds the metrics of a client since it was created or last reset. Repairs and node errors are
+// counted elsewhere since the client was created, so their values at the last reset are kept as baselines.
+type clientMetrics struct {
+	since      time.Time
+	ops        map[string]*opMetrics
+	repairs    RepairStats
+	nodeErrors map[string]uint64
+	oversized  uint64
+	sizes      *sizeMetrics
+	prefixes   map[string]*sizeMetrics
+	tenants    map[string]*TenantCounts
+	mutex      sync.Mutex
+}
+
+func newClientMetrics() *clientMetrics {
+	metrics := &clientMetrics{}
+	metrics.reset(RepairStats{}, nil)
+	return metrics
+}
+
+// reset clears all metrics, with the given repair stats and node error counts as baselines
+func (metrics *clientMetrics) reset(repairs RepairStats, nodeErrors map[string]uint64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.since = time.Now()
+	metrics.ops = map[string]*opMetrics{}
+	metrics.repairs = repairs
+	metrics.nodeErrors = nodeErrors
+	metrics.oversized = 0
+	metrics.sizes = newSizeMetrics()
+	metrics.prefixes = map[string]*sizeMetrics{}
+	metrics.tenants = map[string]*TenantCounts{}
+}
+
+// recordSize counts a value of size bytes written to a key with the given prefix of SizePrefixes, if found
+func (metrics *clientMetrics) recordSize(size int, prefix string, found bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.sizes.add(size)
+	if !found {
+		return
+	}
+	m, ok := metrics.prefixes[prefix]
+	if !ok {
+		m = newSizeMetrics()
+		metrics.prefixes[prefix] = m
+	}
+	m.add(size)
+}
+
+// recordTenant counts an operation of tenant writing size bytes, allowed or rejected by its quota
+func (metrics *clientMetrics) recordTenant(tenant string, size int, allowed bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	counts, found := metrics.tenants[tenant]
+	if !found {
+		counts = &TenantCounts{}
+		metrics.tenants[tenant] = counts
+	}
+	if !allowed {
+		counts.Rejected++
+		return
+	}
+	counts.Allowed++
+	counts.BytesWritten += uint64(size)
+}
+
+// rejectOversized counts a write rejected because its value exceeded MaxValueSize
+func (metrics *clientMetrics) rejectOversized() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.oversized++
+}
+
+// record counts an operation completed with the given latency and error
+func (metrics *clientMetrics) record(op string, latency time.Duration, err error) {
+	bucket := len(METRICS_LATENCY_BUCKETS)
+	for i, bound := range METRICS_LATENCY_BUCKETS {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	m, found := metrics.ops[op]
+	if !found {
+		m = &opMetrics{buckets: make([]uint64, len(METRICS_LATENCY_BUCKETS)+1)}
+		metrics.ops[op] = m
+	}
+	m.counts.Calls++
+	switch err {
+	case nil:
+	case memcache.ErrCacheMiss, memcache.ErrNotStored:
+		m.counts.Misses++
+	default:
+		m.counts.Errors++
+	}
+	m.buckets[bucket]++
+	m.sum += latency
+	if latency > m.max {
+		m.max = latency
+	}
+}
+
+// histogram returns the latency histogram of m
+func (m *opMetrics) histogram() LatencyHistogram {
+	histogram := LatencyHistogram{Count: m.counts.Calls, Sum: m.sum, Max: m.max}
+	for i, count := range m.buckets {
+		histogram.Buckets = append(histogram.Buckets, LatencyBucket{UpperBound: m.bound(i), Count: count})
+	}
+	histogram.P50, histogram.P90, histogram.P99 = m.percentile(50), m.percentile(90), m.percentile(99)
+	return histogram
+}
+
+// bound returns the upper bound of bucket i, the maximum latency for the last
+func (m *opMetrics) bound(i int) time.Duration {
+	if i < len(METRICS_LATENCY_BUCKETS) {
+		return METRICS_LATENCY_BUCKETS[i]
+	}
+	return m.max
+}
+
+// percentile returns the upper bound of the bucket holding the latency at percentile p, at most the maximum latency
+func (m *opMetrics) percentile(p uint64) time.Duration {
+	rank := (m.counts.Calls*p + 99) / 100
+	var cumulative uint64
+	for i, count := range m.buckets {
+		cumulative += count
+		if cumulative >= rank && cumulative > 0 {
+			if bound := m.bound(i); bound < m.max {
+				return bound
+			}
+			break
+		}
+	}
+	return m.max
+}
+
+// Metrics returns a snapshot of the operation counts, hits and misses, latency histograms, repairs and node errors
+// of this client since it was created or since ResetMetrics
+func (client *Client) Metrics() Snapshot {
+	repairs := client.RepairStats()
+	nodes := client.Nodes.GetNodes()
+
+	metrics := client.metrics
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	snapshot := Snapshot{
+		Since:           metrics.since,
+		OversizedValues: metrics.oversized,
+		ValueSizes:      metrics.sizes.histogram(),
+		Ops:             map[string]OpCounts{},
+		Latency:         map[string]LatencyHistogram{},
+		NodeErrors:      map[string]uint64{},
+		HealthChecks:    map[string]HealthCheckResult{},
+		Repairs: RepairStats{
+			Performed: repairs.Performed - metrics.repairs.Performed,
+			Dropped:   repairs.Dropped - metrics.repairs.Dropped,
+		},
+	}
+	for op, m := range metrics.ops {
+		snapshot.Ops[op] = m.counts
+		snapshot.Latency[op] = m.histogram()
+	}
+	if len(metrics.prefixes) > 0 {
+		snapshot.PrefixValueSizes = map[string]SizeHistogram{}
+		for prefix, m := range metrics.prefixes {
+			snapshot.PrefixValueSizes[prefix] = m.histogram()
+		}
+	}
+	if len(metrics.tenants) > 0 {
+		snapshot.Tenants = map[string]TenantCounts{}
+		for tenant, counts := range metrics.tenants {
+			snapshot.Tenants[tenant] = *counts
+		}
+	}
+	if get, found := metrics.ops[OP_GET]; found {
+		snapshot.Misses = get.counts.Misses
+		snapshot.Hits = get.counts.Calls - get.counts.Misses - get.counts.Errors
+	}
+	for endpoint, node := range nodes {
+		errors, baseline := node.ErrorCount(), metrics.nodeErrors[endpoint]
+		// A node replaced since the reset counts from zero
+		if errors >= baseline {
+			errors -= baseline
+		}
+		snapshot.NodeErrors[endpoint] = errors
+		if result, found := node.LastHealthCheckResult(); found {
+			snapshot.HealthChecks[endpoint] = result
+		}
+	}
+	return snapshot
+}
+
+// ResetMetrics resets the snapshot returned by Metrics to zero. OperationStats and RepairStats are not reset.
+func (client *Client) ResetMetrics() {
+	nodeErrors := map[string]uint64{}
+	for endpoint, node := range client.Nodes.GetNodes() {
+		nodeErrors[endpoint] = node.ErrorCount()
+	}
+	client.metrics.reset(client.RepairStats(), nodeErrors)
+}
+
+// ErrorCount returns the number of failed requests to this node
+func (node *Node) ErrorCount() uint64 {
+	return atomic.LoadUint64(&node.errorCount)
+}
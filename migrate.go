@@ -0,0 +1,98 @@
+package memcacheha
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ExportItems enumerates all keys on the memcache servers at endpoints with DumpKeys, reads each key once from the
+// server that holds it, and sends the items to the given channel, which is closed on return. Values written by
+// memcacheha are decoded, other values are exported as-is with the expiry reported by the server. Keys that
+// expire or are deleted during the export are skipped.
+func ExportItems(ctx context.Context, endpoints []string, timeout time.Duration, concurrency int, items chan<- *Item) error {
+	defer close(items)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type export struct {
+		meta   *KeyMeta
+		client NodeClient
+	}
+	keys := make(chan *export, concurrency)
+
+	// Fetch values concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				mcItem, err := key.client.Get(key.meta.Key)
+				if err != nil {
+					continue
+				}
+				item, err := NewItemFromMemcacheItem(mcItem)
+				if err == ErrNotMemcacheHAKey {
+					item = &Item{
+						Key:        mcItem.Key,
+						Value:      mcItem.Value,
+						Flags:      mcItem.Flags,
+						Expiration: key.meta.Expiration,
+					}
+				} else if err != nil {
+					continue
+				}
+				select {
+				case items <- item:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// Enumerate keys, deduplicating across servers
+	seen := map[string]bool{}
+	var err error
+	for _, endpoint := range endpoints {
+		client := NewMemcacheNodeClient(endpoint, timeout)
+		err = DumpKeys(ctx, endpoint, timeout, func(meta *KeyMeta) error {
+			if seen[meta.Key] {
+				return nil
+			}
+			seen[meta.Key] = true
+			select {
+			case keys <- &export{meta: meta, client: client}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			break
+		}
+	}
+	close(keys)
+	wg.Wait()
+	return err
+}
+
+// Migrate copies all items from the memcache servers at endpoints into the dest cluster, using ExportItems and Warm.
+// The source servers may be a memcacheha cluster or a standard memcache cluster.
+func Migrate(ctx context.Context, endpoints []string, dest *Client, timeout time.Duration, concurrency int, progress func(WarmStats)) (*WarmStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	items := make(chan *Item, concurrency)
+	exportErr := make(chan error, 1)
+	go func() {
+		exportErr <- ExportItems(ctx, endpoints, timeout, concurrency, items)
+	}()
+
+	stats, err := dest.Warm(ctx, items, concurrency, progress)
+	if err != nil {
+		return stats, err
+	}
+	return stats, <-exportErr
+}
@@ -0,0 +1,292 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationStats are the reads served from the old cluster and the writes that failed on the secondary cluster of a
+// MigrationClient, see Stats
+type MigrationStats struct {
+	// Fallbacks are the reads that missed on the new cluster and were read from the old one, of which FallbackHits
+	// were found there
+	Fallbacks    uint64 `json:"fallbacks"`
+	FallbackHits uint64 `json:"fallback_hits"`
+	// Backfills are the items found on the old cluster written to the new one
+	Backfills uint64 `json:"backfills"`
+	// SecondaryErrors are the writes that succeeded on the primary cluster of their key but failed on the other
+	SecondaryErrors uint64 `json:"secondary_errors"`
+}
+
+// MigrationClient moves a cache from an old cluster to a new one in place of a migration proxy. Every write goes to
+// both clusters. The cutover percentage of keys (see SetCutover) have the new cluster as their primary: they are read
+// from the new cluster first, falling back to the old one on a miss, and optionally backfilling the new cluster with
+// the item found. The other keys are read from the old cluster only. The result of a write is that of the primary
+// cluster of its key; failures on the other cluster are logged and counted in Stats.
+//
+// A key is in the cutover if its hash falls below the percentage, so raising it only moves keys to the new cluster.
+// Once the new cluster is warm and the cutover is 100%, the old cluster can be retired.
+type MigrationClient struct {
+	Log logger.Logger
+
+	old     *Client
+	new     *Client
+	manager *Manager
+
+	mutex    sync.RWMutex
+	cutover  float64
+	backfill bool
+
+	fallbacks       uint64
+	fallbackHits    uint64
+	backfills       uint64
+	secondaryErrors uint64
+}
+
+// NewMigrationClient returns a new MigrationClient writing to the old and new clusters, with a cutover of zero. The
+// clients are started and stopped by the MigrationClient, and should not be started individually.
+func NewMigrationClient(log logger.Logger, oldCluster *Client, newCluster *Client) (*MigrationClient, error) {
+	migration := &MigrationClient{
+		Log:     log,
+		old:     oldCluster,
+		new:     newCluster,
+		manager: NewManager(log),
+	}
+	if err := migration.manager.Add("old", oldCluster); err != nil {
+		return nil, err
+	}
+	if err := migration.manager.Add("new", newCluster); err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+// Old returns the Client of the old cluster
+func (migration *MigrationClient) Old() *Client {
+	return migration.old
+}
+
+// New returns the Client of the new cluster
+func (migration *MigrationClient) New() *Client {
+	return migration.new
+}
+
+// SetCutover changes the percentage (0 to 100) of keys with the new cluster as their primary
+func (migration *MigrationClient) SetCutover(percent float64) {
+	migration.mutex.Lock()
+	defer migration.mutex.Unlock()
+	migration.cutover = math.Max(0, math.Min(100, percent))
+	migration.Log.Info("MigrationClient: Cutover %.1f%%", migration.cutover)
+}
+
+// Cutover returns the percentage of keys with the new cluster as their primary
+func (migration *MigrationClient) Cutover() float64 {
+	migration.mutex.RLock()
+	defer migration.mutex.RUnlock()
+	return migration.cutover
+}
+
+// SetBackfill enables or disables writing items found on the old cluster by reads of cutover keys to the new
+// cluster. Items are backfilled with Add, so a concurrent write to the new cluster is not overwritten.
+func (migration *MigrationClient) SetBackfill(backfill bool) {
+	migration.mutex.Lock()
+	defer migration.mutex.Unlock()
+	migration.backfill = backfill
+}
+
+// Stats returns the fallback reads, backfills and secondary write failures of this client
+func (migration *MigrationClient) Stats() MigrationStats {
+	return MigrationStats{
+		Fallbacks:       atomic.LoadUint64(&migration.fallbacks),
+		FallbackHits:    atomic.LoadUint64(&migration.fallbackHits),
+		Backfills:       atomic.LoadUint64(&migration.backfills),
+		SecondaryErrors: atomic.LoadUint64(&migration.secondaryErrors),
+	}
+}
+
+// IsCutover returns true if the new cluster is the primary of key
+func (migration *MigrationClient) IsCutover(key string) bool {
+	cutover := migration.Cutover()
+	if cutover >= 100 {
+		return true
+	}
+	return float64(shardWeight("cutover", key)) < cutover/100*math.MaxUint64
+}
+
+// clients returns the primary and secondary Client of key
+func (migration *MigrationClient) clients(key string) (*Client, *Client) {
+	if migration.IsCutover(key) {
+		return migration.new, migration.old
+	}
+	return migration.old, migration.new
+}
+
+// Start discovery and healthchecks for both clusters
+func (migration *MigrationClient) Start() error {
+	return migration.manager.Start()
+}
+
+// StartAndWait discovers and healthchecks the nodes of both clusters, then starts them and waits for a healthy node
+// in each, see Client.StartAndWait
+func (migration *MigrationClient) StartAndWait(ctx context.Context) error {
+	return migration.manager.StartAndWait(ctx)
+}
+
+// Stop discovery and healthchecks for both clusters
+func (migration *MigrationClient) Stop() error {
+	return migration.manager.Stop()
+}
+
+// WaitForNodes waits for at least one available node in both clusters, timing out on the deadline with
+// ErrNoHealthyNodes
+func (migration *MigrationClient) WaitForNodes(deadline time.Time) error {
+	return migration.manager.WaitForNodes(deadline)
+}
+
+// write performs op on the primary and secondary Client of key concurrently, returning the error of the primary.
+// A failure of the secondary is logged and counted, unless ignore returns true for its error.
+func (migration *MigrationClient) write(name string, key string, op func(client *Client) error, ignore func(err error) bool) error {
+	primary, secondary := migration.clients(key)
+	secondaryErr := make(chan error, 1)
+	go func() {
+		secondaryErr <- op(secondary)
+	}()
+	err := op(primary)
+	if serr := <-secondaryErr; serr != nil && !ignore(serr) {
+		atomic.AddUint64(&migration.secondaryErrors, 1)
+		migration.Log.Warn("MigrationClient: %s of %s failed on the secondary cluster: %s", name, primary.LogKey(key), serr)
+	}
+	return err
+}
+
+// isMiss returns true for the errors of a write that found no key, or a key already present
+func isMiss(err error) bool {
+	return err == memcache.ErrCacheMiss || err == memcache.ErrNotStored
+}
+
+// Set performs Client.Set on both clusters
+func (migration *MigrationClient) Set(item *Item, opts ...WriteOption) error {
+	return migration.write("Set", item.Key, func(client *Client) error {
+		return client.Set(item, opts...)
+	}, isMiss)
+}
+
+// Add performs Client.Add on the primary cluster of the item's key and, if it is stored, Client.Set on the other, so
+// both hold the same value
+func (migration *MigrationClient) Add(item *Item, opts ...WriteOption) error {
+	primary, secondary := migration.clients(item.Key)
+	if err := primary.Add(item, opts...); err != nil {
+		return err
+	}
+	if err := secondary.Set(item, opts...); err != nil {
+		atomic.AddUint64(&migration.secondaryErrors, 1)
+		migration.Log.Warn("MigrationClient: Add of %s failed on the secondary cluster: %s", primary.LogKey(item.Key), err)
+	}
+	return nil
+}
+
+// Get performs Client.Get on the primary cluster of the key. Cutover keys missing on the new cluster are read from
+// the old one, and backfilled if enabled.
+func (migration *MigrationClient) Get(key string, opts ...ReadOption) (*Item, error) {
+	if !migration.IsCutover(key) {
+		return migration.old.Get(key, opts...)
+	}
+	item, err := migration.new.Get(key, opts...)
+	if err != memcache.ErrCacheMiss {
+		return item, err
+	}
+
+	atomic.AddUint64(&migration.fallbacks, 1)
+	item, err = migration.old.Get(key, opts...)
+	if err != nil {
+		return item, err
+	}
+	atomic.AddUint64(&migration.fallbackHits, 1)
+
+	migration.mutex.RLock()
+	backfill := migration.backfill
+	migration.mutex.RUnlock()
+	if backfill {
+		if err := migration.new.Add(item); err == nil {
+			atomic.AddUint64(&migration.backfills, 1)
+		} else if err != memcache.ErrNotStored {
+			migration.Log.Warn("MigrationClient: Backfilling %s failed: %s", migration.new.LogKey(key), err)
+		}
+	}
+	return item, nil
+}
+
+// Delete performs Client.Delete on both clusters, returning the result of the primary cluster of the key
+func (migration *MigrationClient) Delete(key string, opts ...WriteOption) error {
+	return migration.write("Delete", key, func(client *Client) error {
+		return client.Delete(key, opts...)
+	}, isMiss)
+}
+
+// Touch performs Client.Touch on both clusters, returning the result of the primary cluster of the key
+func (migration *MigrationClient) Touch(key string, seconds int32, opts ...WriteOption) error {
+	return migration.write("Touch", key, func(client *Client) error {
+		return client.Touch(key, seconds, opts...)
+	}, isMiss)
+}
+
+// Increment performs Client.Increment on the primary cluster of the key, deleting the key from the other so a stale
+// value is never read from it. Cutover keys missing on the new cluster are incremented on the old one, and the result
+// added to the new one.
+func (migration *MigrationClient) Increment(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return migration.incrDecr("Increment", key, func(client *Client) (uint64, error) {
+		return client.Increment(key, delta, opts...)
+	})
+}
+
+// Decrement performs Client.Decrement on the primary cluster of the key, deleting the key from the other so a stale
+// value is never read from it. Cutover keys missing on the new cluster are decremented on the old one, and the result
+// added to the new one.
+func (migration *MigrationClient) Decrement(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return migration.incrDecr("Decrement", key, func(client *Client) (uint64, error) {
+		return client.Decrement(key, delta, opts...)
+	})
+}
+
+func (migration *MigrationClient) incrDecr(name string, key string, op func(client *Client) (uint64, error)) (uint64, error) {
+	primary, secondary := migration.clients(key)
+	value, err := op(primary)
+	if err == memcache.ErrCacheMiss && primary == migration.new {
+		return migration.incrDecrFallback(name, key, op)
+	}
+	if err != nil {
+		return value, err
+	}
+	if err := secondary.Delete(key); err != nil && err != memcache.ErrCacheMiss {
+		atomic.AddUint64(&migration.secondaryErrors, 1)
+		migration.Log.Warn("MigrationClient: %s of %s failed on the secondary cluster: %s", name, primary.LogKey(key), err)
+	}
+	return value, nil
+}
+
+// incrDecrFallback performs op on the old cluster for a cutover key missing on the new one, and adds the result to the
+// new cluster so both hold the same value. A concurrent write to the new cluster is not overwritten.
+func (migration *MigrationClient) incrDecrFallback(name string, key string, op func(client *Client) (uint64, error)) (uint64, error) {
+	atomic.AddUint64(&migration.fallbacks, 1)
+	value, err := op(migration.old)
+	if err != nil {
+		return value, err
+	}
+	atomic.AddUint64(&migration.fallbackHits, 1)
+
+	err = migration.new.Add(&Item{Key: key, Value: []byte(strconv.FormatUint(value, 10))})
+	if err == nil {
+		atomic.AddUint64(&migration.backfills, 1)
+	} else if err != memcache.ErrNotStored {
+		atomic.AddUint64(&migration.secondaryErrors, 1)
+		migration.Log.Warn("MigrationClient: %s of %s failed on the new cluster: %s", name, migration.new.LogKey(key), err)
+	}
+	return value, nil
+}
@@ -0,0 +1,249 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newMigrationClient returns a started MigrationClient from an old to a new cluster of 3 nodes each
+func newMigrationClient(t *testing.T) (*memcacheha.MigrationClient, *memcachehatest.Cluster, *memcachehatest.Cluster) {
+	oldCluster, newCluster := memcachehatest.NewCluster(3), memcachehatest.NewCluster(3)
+	migration, err := memcacheha.NewMigrationClient(logger.NewConsoleLogger("error"), newManagedClient(oldCluster), newManagedClient(newCluster))
+	if err != nil {
+		t.Fatalf("NewMigrationClient failed: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := migration.StartAndWait(ctx); err != nil {
+		t.Fatalf("StartAndWait failed: %s", err)
+	}
+	t.Cleanup(func() { migration.Stop() })
+	eventually(t, func() bool {
+		return migration.Old().Nodes.GetHealthyNodeCount() == 3 && migration.New().Nodes.GetHealthyNodeCount() == 3
+	})
+	return migration, oldCluster, newCluster
+}
+
+// migrationKey returns a key with the given prefix that is in the cutover of migration or not
+func migrationKey(t *testing.T, migration *memcacheha.MigrationClient, prefix string, cutover bool) string {
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprintf("%s%d", prefix, i); migration.IsCutover(key) == cutover {
+			return key
+		}
+	}
+	t.Fatalf("No key with IsCutover %t at a cutover of %.1f%%", cutover, migration.Cutover())
+	return ""
+}
+
+func TestMigrationCutover(t *testing.T) {
+	migration, _, _ := newMigrationClient(t)
+
+	// Raising the cutover only adds keys to it, in proportion to the percentage
+	var previous map[string]bool
+	for _, percent := range []float64{0, 10, 25, 50, 90, 99.9, 100} {
+		migration.SetCutover(percent)
+		cutover := map[string]bool{}
+		for i := 0; i < 1000; i++ {
+			if key := fmt.Sprintf("key%d", i); migration.IsCutover(key) {
+				cutover[key] = true
+			}
+		}
+		for key := range previous {
+			if !cutover[key] {
+				t.Fatalf("%s left the cutover when it was raised to %.1f%%", key, percent)
+			}
+		}
+		if n := float64(len(cutover)) / 10; n < percent-5 || n > percent+5 {
+			t.Fatalf("%.1f%% of keys are in a cutover of %.1f%%", n, percent)
+		}
+		previous = cutover
+	}
+	if len(previous) != 1000 {
+		t.Fatalf("%d of 1000 keys are in a cutover of 100%%", len(previous))
+	}
+
+	migration.SetCutover(150)
+	if cutover := migration.Cutover(); cutover != 100 {
+		t.Fatalf("Cutover is %.1f after SetCutover(150), expected 100", cutover)
+	}
+	migration.SetCutover(-5)
+	if cutover := migration.Cutover(); cutover != 0 {
+		t.Fatalf("Cutover is %.1f after SetCutover(-5), expected 0", cutover)
+	}
+}
+
+func TestMigrationReads(t *testing.T) {
+	migration, oldCluster, newCluster := newMigrationClient(t)
+	migration.SetCutover(50)
+	oldKey, cutoverKey := migrationKey(t, migration, "key", false), migrationKey(t, migration, "key", true)
+
+	// Keys not in the cutover are read from the old cluster only
+	newCluster.Put(&memcacheha.Item{Key: oldKey, Value: []byte("new")})
+	if _, err := migration.Get(oldKey); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a key on the new cluster only returned %v, expected ErrCacheMiss", err)
+	}
+	if stats := migration.Stats(); stats != (memcacheha.MigrationStats{}) {
+		t.Fatalf("Stats are %+v, expected no fallbacks", stats)
+	}
+
+	// Cutover keys missing on the new cluster fall back to the old one, without backfilling by default
+	oldCluster.Put(&memcacheha.Item{Key: cutoverKey, Value: []byte("old")})
+	if item, err := migration.Get(cutoverKey); err != nil || string(item.Value) != "old" {
+		t.Fatalf("Get returned %v, %v, expected the item of the old cluster", item, err)
+	}
+	newCluster.AssertValue(t, cutoverKey, nil)
+	if _, err := migration.Get(migrationKey(t, migration, "missing", true)); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if stats := migration.Stats(); stats != (memcacheha.MigrationStats{Fallbacks: 2, FallbackHits: 1}) {
+		t.Fatalf("Stats are %+v, expected 2 fallbacks and 1 hit", stats)
+	}
+
+	// Backfilled items are read from the new cluster from then on
+	migration.SetBackfill(true)
+	if item, err := migration.Get(cutoverKey); err != nil || string(item.Value) != "old" {
+		t.Fatalf("Get returned %v, %v, expected the item of the old cluster", item, err)
+	}
+	newCluster.AssertValue(t, cutoverKey, []byte("old"))
+	oldCluster.Put(&memcacheha.Item{Key: cutoverKey, Value: []byte("changed")})
+	if item, err := migration.Get(cutoverKey); err != nil || string(item.Value) != "old" {
+		t.Fatalf("Get returned %v, %v, expected the backfilled item", item, err)
+	}
+	if stats := migration.Stats(); stats != (memcacheha.MigrationStats{Fallbacks: 3, FallbackHits: 2, Backfills: 1}) {
+		t.Fatalf("Stats are %+v, expected 3 fallbacks, 2 hits and 1 backfill", stats)
+	}
+}
+
+func TestMigrationWrites(t *testing.T) {
+	migration, oldCluster, newCluster := newMigrationClient(t)
+	migration.SetCutover(50)
+	failure := errors.New("node failure")
+	healthy := func() bool {
+		migration.Old().HealthCheck()
+		migration.New().HealthCheck()
+		return migration.Old().Nodes.GetHealthyNodeCount() == 3 && migration.New().Nodes.GetHealthyNodeCount() == 3
+	}
+
+	for _, cutover := range []bool{false, true} {
+		key := migrationKey(t, migration, "key", cutover)
+		errs := migration.Stats().SecondaryErrors
+		primary, secondary := oldCluster, newCluster
+		if cutover {
+			primary, secondary = newCluster, oldCluster
+		}
+
+		// Writes go to both clusters
+		if err := migration.Set(&memcacheha.Item{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+		oldCluster.AssertValue(t, key, []byte("value"))
+		newCluster.AssertValue(t, key, []byte("value"))
+		if err := migration.Add(&memcacheha.Item{Key: key, Value: []byte("other")}); err != memcache.ErrNotStored {
+			t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+		}
+
+		// Misses on the secondary cluster are not failures
+		secondary.Remove(key)
+		if err := migration.Delete(key); err != nil {
+			t.Fatalf("Delete failed: %s", err)
+		}
+		primary.AssertValue(t, key, nil)
+		if err := migration.Add(&memcacheha.Item{Key: key, Value: []byte("added")}); err != nil {
+			t.Fatalf("Add failed: %s", err)
+		}
+		secondary.AssertValue(t, key, []byte("added"))
+		if stats := migration.Stats(); stats.SecondaryErrors != errs {
+			t.Fatalf("Stats are %+v, expected no new secondary errors", stats)
+		}
+
+		// Failures of the secondary cluster are counted, and those of the primary returned
+		secondary.Fail(failure)
+		if err := migration.Set(&memcacheha.Item{Key: key, Value: []byte("primary only")}); err != nil {
+			t.Fatalf("Set with a failed secondary cluster returned %s", err)
+		}
+		if err := migration.Touch(key, 60); err != nil {
+			t.Fatalf("Touch with a failed secondary cluster returned %s", err)
+		}
+		secondary.Recover()
+		eventually(t, healthy)
+		primary.AssertValue(t, key, []byte("primary only"))
+		if stats := migration.Stats(); stats.SecondaryErrors != errs+2 {
+			t.Fatalf("Stats are %+v, expected 2 new secondary errors", stats)
+		}
+		primary.Fail(failure)
+		if err := migration.Set(&memcacheha.Item{Key: key, Value: []byte("secondary only")}); err == nil {
+			t.Fatal("Set with a failed primary cluster succeeded")
+		}
+		primary.Recover()
+		eventually(t, healthy)
+		secondary.AssertValue(t, key, []byte("secondary only"))
+		if stats := migration.Stats(); stats.SecondaryErrors != errs+2 {
+			t.Fatalf("Stats are %+v, expected 2 new secondary errors", stats)
+		}
+	}
+}
+
+func TestMigrationIncrDecr(t *testing.T) {
+	migration, oldCluster, newCluster := newMigrationClient(t)
+	migration.SetCutover(50)
+	oldKey, cutoverKey := migrationKey(t, migration, "key", false), migrationKey(t, migration, "key", true)
+
+	// Counters are changed on the primary cluster and deleted from the other
+	for _, key := range []string{oldKey, cutoverKey} {
+		oldCluster.Put(&memcacheha.Item{Key: key, Value: []byte("5")})
+		newCluster.Put(&memcacheha.Item{Key: key, Value: []byte("5")})
+	}
+	if value, err := migration.Increment(oldKey, 2); err != nil || value != 7 {
+		t.Fatalf("Increment returned %d, %v, expected 7", value, err)
+	}
+	oldCluster.AssertValue(t, oldKey, []byte("7"))
+	newCluster.AssertValue(t, oldKey, nil)
+	if value, err := migration.Decrement(cutoverKey, 2); err != nil || value != 3 {
+		t.Fatalf("Decrement returned %d, %v, expected 3", value, err)
+	}
+	newCluster.AssertValue(t, cutoverKey, []byte("3"))
+	oldCluster.AssertValue(t, cutoverKey, nil)
+
+	// A cutover counter missing on the new cluster is changed on the old one, and the result added to the new one
+	oldCluster.Put(&memcacheha.Item{Key: cutoverKey, Value: []byte("10")})
+	newCluster.Remove(cutoverKey)
+	if value, err := migration.Increment(cutoverKey, 1); err != nil || value != 11 {
+		t.Fatalf("Increment returned %d, %v, expected 11", value, err)
+	}
+	oldCluster.AssertValue(t, cutoverKey, []byte("11"))
+	newCluster.AssertValue(t, cutoverKey, []byte("11"))
+	if stats := migration.Stats(); stats != (memcacheha.MigrationStats{Fallbacks: 1, FallbackHits: 1, Backfills: 1}) {
+		t.Fatalf("Stats are %+v, expected 1 fallback, hit and backfill", stats)
+	}
+	if value, err := migration.Increment(cutoverKey, 1); err != nil || value != 12 {
+		t.Fatalf("Increment returned %d, %v, expected 12", value, err)
+	}
+	newCluster.AssertValue(t, cutoverKey, []byte("12"))
+	oldCluster.AssertValue(t, cutoverKey, nil)
+
+	// A counter missing on both is a miss
+	if _, err := migration.Increment(migrationKey(t, migration, "missing", true), 1); err != memcache.ErrCacheMiss {
+		t.Fatalf("Increment of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if stats := migration.Stats(); stats != (memcacheha.MigrationStats{Fallbacks: 2, FallbackHits: 1, Backfills: 1}) {
+		t.Fatalf("Stats are %+v, expected 2 fallbacks, 1 hit and 1 backfill", stats)
+	}
+
+	// Failing to delete from the secondary cluster is counted
+	newCluster.Fail(errors.New("node failure"))
+	if value, err := migration.Increment(oldKey, 1); err != nil || value != 8 {
+		t.Fatalf("Increment with a failed secondary cluster returned %d, %v, expected 8", value, err)
+	}
+	newCluster.Recover()
+	if stats := migration.Stats(); stats.SecondaryErrors != 1 {
+		t.Fatalf("Stats are %+v, expected 1 secondary error", stats)
+	}
+}
@@ -0,0 +1,63 @@
+package memcacheha
+
+// MULTI_PARALLELISM is the number of concurrent batches sent to each node by SetMulti, DeleteMulti and TouchMulti
+var MULTI_PARALLELISM = 4
+
+// SetMulti unconditionally writes the given items to all healthy nodes as a Pipeline does, split into
+// MULTI_PARALLELISM concurrent batches per node. The result for each key follows the semantics of Set. If several
+// items have the same key, only the last is written. ErrNoHealthyNodes is returned as it is by Pipeline.Flush.
+func (client *Client) SetMulti(items []*Item, opts ...WriteOption) (map[string]error, error) {
+	pipeline := client.Pipeline(opts...)
+	for _, item := range lastItemPerKey(items) {
+		pipeline.Set(item)
+	}
+	return pipeline.flush(MULTI_PARALLELISM)
+}
+
+// lastItemPerKey returns the given items with only the last item for each key, in order
+func lastItemPerKey(items []*Item) []*Item {
+	last := make(map[string]int, len(items))
+	for i, item := range items {
+		last[item.Key] = i
+	}
+	out := make([]*Item, 0, len(last))
+	for i, item := range items {
+		if last[item.Key] == i {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// DeleteMulti deletes the given keys from all healthy nodes as SetMulti writes items. The result for each key follows
+// the semantics of Delete. ErrNoHealthyNodes is returned as it is by Pipeline.Flush.
+func (client *Client) DeleteMulti(keys []string, opts ...WriteOption) (map[string]error, error) {
+	pipeline := client.Pipeline(opts...)
+	for _, key := range uniqueKeys(keys) {
+		pipeline.Delete(key)
+	}
+	return pipeline.flush(MULTI_PARALLELISM)
+}
+
+// TouchMulti updates the expiry of the given keys on all healthy nodes as SetMulti writes items. The result for each
+// key follows the semantics of Touch. ErrNoHealthyNodes is returned as it is by Pipeline.Flush.
+func (client *Client) TouchMulti(keys []string, seconds int32, opts ...WriteOption) (map[string]error, error) {
+	pipeline := client.Pipeline(opts...)
+	for _, key := range uniqueKeys(keys) {
+		pipeline.Touch(key, seconds)
+	}
+	return pipeline.flush(MULTI_PARALLELISM)
+}
+
+// uniqueKeys returns the given keys without duplicates, in order
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	return out
+}
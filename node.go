@@ -2,157 +2,641 @@ package memcacheha
 
 import (
 	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha/clock"
 
 	"github.com/bradfitz/gomemcache/memcache"
 
-	"crypto/rand"
-	"fmt"
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// NODE_CAS_RETRIES is the number of compare-and-swap attempts made by Increment and Decrement before giving up
+	NODE_CAS_RETRIES = 10
+	// NODE_HISTORY_SIZE is the number of recent healthcheck results kept by each node, see History
+	NODE_HISTORY_SIZE = 32
+	// NODE_STATS_PERIOD is the period between reads of the version and uptime of a node's server by healthchecks
+	NODE_STATS_PERIOD = time.Minute
+	// NODE_ERROR_RETRIES is the number of times a request failing with an ERROR_CATEGORY_RETRYABLE error is retried
+	NODE_ERROR_RETRIES = 1
+)
+
+// Values of Node.forcedHealth, see ForceHealth
+const (
+	nodeNotForced int32 = iota
+	nodeForcedHealthy
+	nodeForcedUnhealthy
+)
+
+// HealthCheckResult is the result of a single healthcheck of a node
+type HealthCheckResult struct {
+	Time    time.Time     `json:"time"`
+	Latency time.Duration `json:"latency"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+	// ErrorClass is the class of Error, e.g. "memcacheha: node timeout", see NodeError
+	ErrorClass string `json:"error_class,omitempty"`
+	// Version and Uptime are those of the memcached server, if its NodeClient can read them. They are read at most
+	// every NODE_STATS_PERIOD.
+	Version string        `json:"version,omitempty"`
+	Uptime  time.Duration `json:"uptime,omitempty"`
+}
+
 // Node represents a single Memcache server.
 type Node struct {
+	// errorCount and requestCount are first to be 64-bit aligned for atomic access
+	errorCount   uint64
+	requestCount uint64
+
 	Endpoint string
 	Log      logger.Logger
+	// Spec describes the node as its source did when it was added, see NodeSpecSource
+	Spec NodeSpec
+	// rampStart is when the node was added by discovery to a client that already had nodes, zero for nodes read from
+	// in full straight away, see NodeRampPeriod
+	rampStart time.Time
+
+	// healthy is read with IsHealthy, and lastHealthCheck, the Unix time in nanoseconds of the last response of the
+	// node, with LastHealthCheck
+	healthy         atomic.Bool
+	lastHealthCheck atomic.Int64
+
+	forcedHealth int32
+	drainState   int32
 
-	IsHealthy       bool
-	LastHealthCheck time.Time
+	client      NodeClient
+	clientMutex sync.RWMutex
+	addrs       []string
+	limiter     *requestLimiter
+	onError     func(opID string, err error)
+	logKey      func(key string) string
+	probeConfig func() (HealthCheckProbe, string)
+	// encryption returns the Encryption of the client, see Client.Encryption
+	encryption func() *Encryption
+	// integrity returns the Integrity of the client, see Client.Integrity
+	integrity func() *Integrity
+	// errorCategory returns the category of an error of the node, see Client.ErrorMapper
+	errorCategory func(err error) ErrorCategory
+	// onHealthChange is called when the health of the node changes, see NodeList.Changed
+	onHealthChange func()
+	// clock is the clock of the client, for healthcheck times and expiries
+	clock clock.Clock
 
-	client *memcache.Client
+	latencies    []time.Duration
+	latencyNext  int
+	degraded     bool
+	latencyMutex sync.Mutex
+
+	history      []HealthCheckResult
+	historyNext  int
+	version      string
+	uptime       time.Duration
+	statsTime    time.Time
+	historyMutex sync.Mutex
 }
 
 // NewNode returns a new Node with the given Logger and endpoint (host:port)
 func NewNode(log logger.Logger, endpoint string, timeout time.Duration) *Node {
+	return NewNodeWithClient(log, endpoint, NewMemcacheNodeClient(endpoint, timeout))
+}
+
+// NewNodeWithClient returns a new Node with the given Logger and endpoint (host:port), using the given NodeClient
+func NewNodeWithClient(log logger.Logger, endpoint string, client NodeClient) *Node {
 	node := &Node{
-		Endpoint:        endpoint,
-		Log:             logger.NewScopedLogger("Node "+endpoint, log),
-		IsHealthy:       false,
-		LastHealthCheck: time.Now().Add(-1 * HEALTHCHECK_PERIOD),
-		client:          memcache.New(endpoint),
+		Endpoint: endpoint,
+		Log:      logger.NewScopedLogger("Node "+endpoint, log),
+		client:   client,
+		clock:    clock.Real,
 	}
-	node.client.Timeout = timeout
+	node.lastHealthCheck.Store(time.Now().Add(-1 * HEALTHCHECK_PERIOD).UnixNano())
 	return node
 }
 
+// IsHealthy returns true if the node is healthy: its last response succeeded, or its health is forced, see
+// ForceHealth
+func (node *Node) IsHealthy() bool {
+	return node.healthy.Load()
+}
+
+// LastHealthCheck returns the time of the last response of the node, to a request or a healthcheck
+func (node *Node) LastHealthCheck() time.Time {
+	return time.Unix(0, node.lastHealthCheck.Load())
+}
+
+// SetClient replaces the NodeClient used by this node, e.g. after a change of timeout. Operations in progress
+// complete on the previous NodeClient.
+func (node *Node) SetClient(client NodeClient) {
+	node.clientMutex.Lock()
+	defer node.clientMutex.Unlock()
+	node.client = client
+}
+
+func (node *Node) getClient() NodeClient {
+	node.clientMutex.RLock()
+	defer node.clientMutex.RUnlock()
+	return node.client
+}
+
 // Add an item to the memcache server represented by this node and send the response to the given channel
 func (node *Node) Add(item *Item, finishChan chan (*NodeResponse)) {
-	go func() {
-		if item.Expiration != nil && !item.Expiration.After(time.Now()) {
-			if finishChan != nil {
-				finishChan <- NewNodeResponse(node, nil, nil)
-			}
-			return
-		}
-		if item.Expiration != nil {
-			node.Log.Debug("ADD %s Expire %s", item.Key, *item.Expiration)
-		} else {
-			node.Log.Debug("ADD %s", item.Key)
-		}
-		err := node.client.Add(item.AsMemcacheItem())
-		if finishChan != nil {
-			finishChan <- node.getNodeResponse(nil, err)
-		}
-	}()
+	node.add("", item, finishChan)
 }
 
 // Set an item in the memcache server represented by this node and send the response to the given channel
 func (node *Node) Set(item *Item, finishChan chan (*NodeResponse)) {
-	go func() {
-		if item.Expiration != nil && !item.Expiration.After(time.Now()) {
-			if finishChan != nil {
-				finishChan <- NewNodeResponse(node, nil, nil)
-			}
-			return
-		}
-		if item.Expiration != nil {
-			node.Log.Debug("SET %s Expire %s", item.Key, *item.Expiration)
-		} else {
-			node.Log.Debug("SET %s", item.Key)
-		}
-		err := node.client.Set(item.AsMemcacheItem())
-		if finishChan != nil {
-			finishChan <- node.getNodeResponse(nil, err)
-		}
-	}()
+	node.set("", item, finishChan)
 }
 
 // Get an item with the given key from the memcache server represented by this node and send the response to the given channel
 func (node *Node) Get(key string, finishChan chan (*NodeResponse)) {
-	go func() {
-		node.Log.Debug("GET %s", key)
-		item, err := node.client.Get(key)
-		if finishChan != nil {
-			finishChan <- node.getNodeResponse(item, err)
-		}
-	}()
+	node.get("", key, finishChan)
 }
 
 // Delete an item with the given key from the memcache server represented by this node and send the response to the given channel
 func (node *Node) Delete(key string, finishChan chan (*NodeResponse)) {
-	go func() {
-		node.Log.Debug("DELETE %s", key)
-		err := node.client.Delete(key)
-		if finishChan != nil {
-			finishChan <- node.getNodeResponse(nil, err)
-		}
-	}()
+	node.delete("", key, finishChan)
 }
 
 // Touch an item with the given key, updating its expiry.
 func (node *Node) Touch(key string, seconds int32, finishChan chan (*NodeResponse)) {
-	go func() {
-		node.Log.Debug("TOUCH %s", key)
-		err := node.client.Touch(key, seconds)
+	node.touch("", key, seconds, finishChan)
+}
+
+// Increment atomically increases the decimal value of the item with the given key by delta and sends the new item to the given channel
+func (node *Node) Increment(key string, delta uint64, finishChan chan (*NodeResponse)) {
+	node.incrementOrDecrement("", key, delta, true, finishChan)
+}
+
+// Decrement atomically decreases the decimal value of the item with the given key by delta and sends the new item to the given channel.
+// The value will not go below zero.
+func (node *Node) Decrement(key string, delta uint64, finishChan chan (*NodeResponse)) {
+	node.incrementOrDecrement("", key, delta, false, finishChan)
+}
+
+// The following perform operations as part of the client operation with the given ID, which is included in log lines
+
+func (node *Node) add(opID string, item *Item, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doAdd(opID, item) })
+}
+
+func (node *Node) set(opID string, item *Item, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doSet(opID, item) })
+}
+
+func (node *Node) get(opID string, key string, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doGet(opID, key) })
+}
+
+func (node *Node) delete(opID string, key string, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doDelete(opID, key) })
+}
+
+func (node *Node) touch(opID string, key string, seconds int32, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse { return node.doTouch(opID, key, seconds) })
+}
+
+func (node *Node) incrementOrDecrement(opID string, key string, delta uint64, incr bool, finishChan chan (*NodeResponse)) {
+	node.run(finishChan, func() *NodeResponse {
+		if incr {
+			node.debug(opID, "INCR %s %d", node.keyForLog(key), delta)
+		} else {
+			node.debug(opID, "DECR %s %d", node.keyForLog(key), delta)
+		}
+		item, err := node.incrDecr(key, delta, incr)
+		return node.getNodeResponse(opID, item, err)
+	})
+}
+
+// run performs op on the worker pool, see submit, and sends the response to the given channel, if not nil
+func (node *Node) run(finishChan chan (*NodeResponse), op func() *NodeResponse) {
+	node.submit(func() {
+		start := time.Now()
+		response := op()
+		for i := 0; i < NODE_ERROR_RETRIES && response.retryable; i++ {
+			releaseNodeResponse(response)
+			response = op()
+		}
+		response.Elapsed = time.Since(start)
+		node.recordLatency(response.Elapsed)
+		node.limiter.release()
 		if finishChan != nil {
-			finishChan <- node.getNodeResponse(nil, err)
+			finishChan <- response
+		} else {
+			releaseNodeResponse(response)
 		}
+	})
+}
+
+// submit runs job, which must release the limiter, on the worker pool once a request can be sent. If
+// MaxConcurrentRequests is reached, job waits for a request to complete on a goroutine of its own rather than on the
+// pool, which is shared by all clients.
+func (node *Node) submit(job func()) {
+	if node.limiter.tryAcquire() {
+		getWorkerPool().Submit(job)
+		return
+	}
+	go func() {
+		node.limiter.acquire()
+		job()
 	}()
 }
 
-// HealthCheck performs a healthcheck on the memcache server represented by this node, update IsHealthy, and return it
-func (node *Node) HealthCheck() (bool, error) {
-	// Read a Random key, expect ErrCacheMiss
-	x := make([]byte, 32)
-	_, err := rand.Read(x)
+// keyForLog returns key as it should appear in logs, see KeyLogMode
+func (node *Node) keyForLog(key string) string {
+	if node.logKey == nil {
+		return key
+	}
+	return node.logKey(key)
+}
+
+// debug logs at debug level, prefixed with the operation ID if there is one
+func (node *Node) debug(opID string, format string, args ...interface{}) {
+	if opID != "" {
+		format = "[" + opID + "] " + format
+	}
+	node.Log.Debug(format, args...)
+}
+
+// encode returns the memcache.Item stored for item when sent at now, with its value encrypted and signed if the
+// client encrypts or signs values
+func (node *Node) encode(item *Item, now time.Time) (*memcache.Item, error) {
+	var encryption *Encryption
+	var integrity *Integrity
+	if node.encryption != nil {
+		encryption = node.encryption()
+	}
+	if node.integrity != nil {
+		integrity = node.integrity()
+	}
+	if encryption != nil || integrity != nil {
+		encoded := *item
+		if encryption != nil {
+			value, err := encryption.seal(item.Key, encoded.Value)
+			if err != nil {
+				return nil, err
+			}
+			encoded.Value = value
+		}
+		if integrity != nil {
+			value, err := integrity.sign(item.Key, item.Flags, encoded.Value)
+			if err != nil {
+				return nil, err
+			}
+			encoded.Value = value
+		}
+		item = &encoded
+	}
+	return item.asMemcacheItem(now), nil
+}
+
+// decode returns the Item stored in mcItem, with its value verified and decrypted if the client signs or encrypts
+// values. Values failing verification are returned as memcache.ErrCacheMiss.
+func (node *Node) decode(mcItem *memcache.Item) (*Item, error) {
+	var integrity *Integrity
+	var encryption *Encryption
+	if node.integrity != nil {
+		integrity = node.integrity()
+	}
+	if node.encryption != nil {
+		encryption = node.encryption()
+	}
+	item, err := decodeItem(mcItem, integrity, encryption)
+	if err == errIntegrityCheck {
+		node.Log.Warn("Integrity check of %s failed, treating it as missing", node.keyForLog(mcItem.Key))
+		return nil, memcache.ErrCacheMiss
+	}
+	return item, err
+}
+
+func (node *Node) doAdd(opID string, item *Item) *NodeResponse {
+	if item.Expiration != nil && !item.Expiration.After(node.clock.Now()) {
+		return NewNodeResponse(node, nil, nil)
+	}
+	if item.Expiration != nil {
+		node.debug(opID, "ADD %s Expire %s", node.keyForLog(item.Key), *item.Expiration)
+	} else {
+		node.debug(opID, "ADD %s", node.keyForLog(item.Key))
+	}
+	mcItem, err := node.encode(item, node.clock.Now())
+	if err != nil {
+		return NewNodeResponse(node, nil, err)
+	}
+	return node.getNodeResponse(opID, nil, node.getClient().Add(mcItem))
+}
+
+func (node *Node) doSet(opID string, item *Item) *NodeResponse {
+	if item.Expiration != nil && !item.Expiration.After(node.clock.Now()) {
+		return NewNodeResponse(node, nil, nil)
+	}
+	if item.Expiration != nil {
+		node.debug(opID, "SET %s Expire %s", node.keyForLog(item.Key), *item.Expiration)
+	} else {
+		node.debug(opID, "SET %s", node.keyForLog(item.Key))
+	}
+	mcItem, err := node.encode(item, node.clock.Now())
 	if err != nil {
+		return NewNodeResponse(node, nil, err)
+	}
+	return node.getNodeResponse(opID, nil, node.getClient().Set(mcItem))
+}
+
+func (node *Node) doGet(opID string, key string) *NodeResponse {
+	node.debug(opID, "GET %s", node.keyForLog(key))
+	item, err := node.getClient().Get(key)
+	return node.getNodeResponse(opID, item, err)
+}
+
+func (node *Node) doDelete(opID string, key string) *NodeResponse {
+	node.debug(opID, "DELETE %s", node.keyForLog(key))
+	return node.getNodeResponse(opID, nil, node.getClient().Delete(key))
+}
+
+func (node *Node) doTouch(opID string, key string, seconds int32) *NodeResponse {
+	node.debug(opID, "TOUCH %s", node.keyForLog(key))
+	return node.getNodeResponse(opID, nil, node.getClient().Touch(key, seconds))
+}
+
+// incrDecr performs a read-modify-write of a decimal value with compare-and-swap, as the memcacheha header prevents
+// the use of the memcached incr/decr commands.
+func (node *Node) incrDecr(key string, delta uint64, incr bool) (*memcache.Item, error) {
+	for i := 0; i < NODE_CAS_RETRIES; i++ {
+		mcItem, err := node.getClient().Get(key)
+		if err != nil {
+			return nil, err
+		}
+		item, err := node.decode(mcItem)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseUint(string(item.Value), 10, 64)
+		if err != nil {
+			return nil, ErrNotNumeric
+		}
+		if incr {
+			value += delta
+		} else if delta > value {
+			value = 0
+		} else {
+			value -= delta
+		}
+		item.Value = []byte(strconv.FormatUint(value, 10))
+
+		// Keep the CAS ID of the item we read
+		newItem, err := node.encode(item, node.clock.Now())
+		if err != nil {
+			return nil, err
+		}
+		mcItem.Value = newItem.Value
+		mcItem.Expiration = newItem.Expiration
+		err = node.getClient().CompareAndSwap(mcItem)
+		if err == memcache.ErrCASConflict {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return mcItem, nil
+	}
+	return nil, memcache.ErrCASConflict
+}
+
+// alignCounter sets the numeric value of key to target if it is lower, when incr is true, or higher otherwise, with
+// CAS so a concurrent increment or decrement is not lost. A missing or non-numeric value is left as it is.
+func (node *Node) alignCounter(opID string, key string, target uint64, incr bool) *NodeResponse {
+	node.debug(opID, "ALIGN %s %d", node.keyForLog(key), target)
+	for i := 0; i < NODE_CAS_RETRIES; i++ {
+		mcItem, err := node.getClient().Get(key)
+		if err != nil {
+			return node.getNodeResponse(opID, nil, err)
+		}
+		item, err := node.decode(mcItem)
+		if err != nil {
+			return node.getNodeResponse(opID, nil, err)
+		}
+		value, err := strconv.ParseUint(string(item.Value), 10, 64)
+		if err != nil {
+			return node.getNodeResponse(opID, nil, ErrNotNumeric)
+		}
+		if (incr && value >= target) || (!incr && value <= target) {
+			return node.getNodeResponse(opID, nil, nil)
+		}
+		item.Value = []byte(strconv.FormatUint(target, 10))
+
+		// Keep the CAS ID of the item we read
+		newItem, err := node.encode(item, node.clock.Now())
+		if err != nil {
+			return node.getNodeResponse(opID, nil, err)
+		}
+		mcItem.Value = newItem.Value
+		mcItem.Expiration = newItem.Expiration
+		err = node.getClient().CompareAndSwap(mcItem)
+		if err == memcache.ErrCASConflict {
+			continue
+		}
+		return node.getNodeResponse(opID, nil, err)
+	}
+	return node.getNodeResponse(opID, nil, memcache.ErrCASConflict)
+}
+
+// doCompareAndExpire sets the expiry of the item with the given key using compare-and-swap, if its value equals
+// expected. A nil expiration expires the item immediately. It returns false if the value differs, the item does not
+// exist or was modified concurrently.
+func (node *Node) doCompareAndExpire(key string, expected []byte, expiration *time.Time) (bool, error) {
+	mcItem, err := node.getClient().Get(key)
+	if err != nil {
+		releaseNodeResponse(node.getNodeResponse("", nil, err))
+		if err == memcache.ErrCacheMiss {
+			return false, nil
+		}
 		return false, err
 	}
-	_, err = node.client.Get(fmt.Sprintf("%02x", x))
-	if err != nil && err != memcache.ErrCacheMiss {
+	item, err := node.decode(mcItem)
+	if err != nil || !bytes.Equal(item.Value, expected) {
+		return false, nil
+	}
+
+	if expiration == nil {
+		mcItem.Expiration = -1
+	} else {
+		item.Expiration = expiration
+		newItem, err := node.encode(item, node.clock.Now())
+		if err != nil {
+			return false, err
+		}
+		mcItem.Value = newItem.Value
+		mcItem.Expiration = newItem.Expiration
+	}
+	err = node.getClient().CompareAndSwap(mcItem)
+	releaseNodeResponse(node.getNodeResponse("", nil, err))
+	if err == memcache.ErrCASConflict || err == memcache.ErrNotStored || err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// HealthCheck performs a healthcheck on the memcache server represented by this node, updating its health, and
+// returns IsHealthy. The request sent is decided by the client's HealthCheckProbe.
+func (node *Node) HealthCheck() (bool, error) {
+	if _, err := node.CheckHealth(); err != nil {
 		return false, err
 	}
-	node.getNodeResponse(nil, err)
-	return node.IsHealthy, nil
+	return node.IsHealthy(), nil
+}
+
+// CheckHealth performs a healthcheck as HealthCheck does, returning its result and error
+func (node *Node) CheckHealth() (HealthCheckResult, error) {
+	start := time.Now()
+	err := node.probe()
+	result := HealthCheckResult{Time: node.clock.Now(), Latency: time.Since(start)}
+	node.recordLatency(result.Latency)
+	response := node.getNodeResponse("", nil, err)
+	err = response.Error
+	releaseNodeResponse(response)
+	result.Healthy = err == nil
+	if err != nil {
+		result.Error = err.Error()
+		var nodeErr *NodeError
+		if errors.As(err, &nodeErr) {
+			result.ErrorClass = nodeErr.Class.Error()
+		}
+	} else {
+		node.updateStats()
+	}
+	result.Version, result.Uptime = node.serverStats()
+	node.recordHealthCheck(result)
+	return result, err
 }
 
-func (node *Node) getNodeResponse(item *memcache.Item, err error) *NodeResponse {
+// History returns the most recent healthcheck results of this node, oldest first, up to NODE_HISTORY_SIZE
+func (node *Node) History() []HealthCheckResult {
+	node.historyMutex.Lock()
+	defer node.historyMutex.Unlock()
+	out := make([]HealthCheckResult, 0, len(node.history))
+	out = append(out, node.history[node.historyNext:]...)
+	return append(out, node.history[:node.historyNext]...)
+}
+
+func (node *Node) recordHealthCheck(result HealthCheckResult) {
+	node.historyMutex.Lock()
+	defer node.historyMutex.Unlock()
+	if len(node.history) < NODE_HISTORY_SIZE {
+		node.history = append(node.history, result)
+		return
+	}
+	if len(node.history) == 0 {
+		return
+	}
+	node.history[node.historyNext] = result
+	node.historyNext = (node.historyNext + 1) % len(node.history)
+}
+
+func (node *Node) getNodeResponse(opID string, item *memcache.Item, err error) *NodeResponse {
 	var haitem *Item
-	node.LastHealthCheck = time.Now()
-	if err != nil &&
+	atomic.AddUint64(&node.requestCount, 1)
+	node.lastHealthCheck.Store(node.clock.Now().UnixNano())
+	category := ERROR_CATEGORY_DEFAULT
+	if err != nil && node.errorCategory != nil {
+		category = node.errorCategory(err)
+	}
+	if category == ERROR_CATEGORY_MISS {
+		err, category = memcache.ErrCacheMiss, ERROR_CATEGORY_DEFAULT
+	}
+	if category == ERROR_CATEGORY_DEFAULT && !isNodeFailure(err) {
+		node.markHealthy()
+		if item != nil {
+			haitem, err = node.decode(item)
+		}
+	} else if category != ERROR_CATEGORY_FATAL {
+		err = newNodeError(node.Endpoint, err)
+		atomic.AddUint64(&node.errorCount, 1)
+		if node.onError != nil {
+			node.onError(opID, err)
+		}
+		if category != ERROR_CATEGORY_RETRYABLE {
+			node.markUnhealthy(opID, err)
+		}
+	}
+	response := NewNodeResponse(node, haitem, err)
+	response.retryable = category == ERROR_CATEGORY_RETRYABLE
+	if item != nil {
+		response.CasID = item.CasID
+	}
+	return response
+}
+
+// isNodeFailure returns true for errors other than the expected results of a request, such as a missing key
+func isNodeFailure(err error) bool {
+	return err != nil &&
 		err != memcache.ErrCacheMiss &&
 		err != memcache.ErrCASConflict &&
 		err != memcache.ErrNotStored &&
 		err != memcache.ErrNoStats &&
-		err != memcache.ErrMalformedKey {
-		node.markUnhealthy(err)
+		err != memcache.ErrMalformedKey &&
+		err != ErrNotNumeric &&
+		err != ErrNotMemcacheHAKey
+}
+
+// ForceHealth overrides the health of this node, ignoring healthchecks and errors until ClearForcedHealth is called
+func (node *Node) ForceHealth(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&node.forcedHealth, nodeForcedHealthy)
+		node.Log.Info("Forced healthy")
 	} else {
-		node.markHealthy()
-		if item != nil {
-			haitem, err = NewItemFromMemcacheItem(item)
-		}
+		atomic.StoreInt32(&node.forcedHealth, nodeForcedUnhealthy)
+		node.Log.Warn("Forced unhealthy")
 	}
-	return NewNodeResponse(node, haitem, err)
+	node.setHealthy(healthy)
+}
+
+// ClearForcedHealth removes an override of ForceHealth. The node's health is updated by its next healthcheck.
+func (node *Node) ClearForcedHealth() {
+	if atomic.SwapInt32(&node.forcedHealth, nodeNotForced) != nodeNotForced {
+		node.Log.Info("Forced health cleared")
+	}
+}
+
+// ForcedHealth returns the health set by ForceHealth, and whether it is in effect
+func (node *Node) ForcedHealth() (healthy bool, forced bool) {
+	switch atomic.LoadInt32(&node.forcedHealth) {
+	case nodeForcedHealthy:
+		return true, true
+	case nodeForcedUnhealthy:
+		return false, true
+	}
+	return false, false
 }
 
 func (node *Node) markHealthy() {
-	if !node.IsHealthy {
+	if _, forced := node.ForcedHealth(); forced {
+		return
+	}
+	if !node.IsHealthy() {
 		node.Log.Info("Healthy")
 	}
-	node.IsHealthy = true
+	node.setHealthy(true)
 }
-func (node *Node) markUnhealthy(err error) {
-	if node.IsHealthy {
-		node.Log.Warn("Unhealthy (%s)", err)
+func (node *Node) markUnhealthy(opID string, err error) {
+	if _, forced := node.ForcedHealth(); forced {
+		return
+	}
+	if node.IsHealthy() {
+		if opID != "" {
+			node.Log.Warn("[%s] Unhealthy (%s)", opID, err)
+		} else {
+			node.Log.Warn("Unhealthy (%s)", err)
+		}
+	}
+	node.setHealthy(false)
+}
+
+// setHealthy sets the health of the node, calling onHealthChange if it changed
+func (node *Node) setHealthy(healthy bool) {
+	changed := node.healthy.Swap(healthy) != healthy
+	if changed && node.onHealthChange != nil {
+		node.onHealthChange()
 	}
-	node.IsHealthy = false
 }
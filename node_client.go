@@ -0,0 +1,209 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeClient is the transport used by a Node to talk to a single memcache server. *memcache.Client implements
+// NodeClient with the text protocol, MemoryNodeClient holds items in memory for tests, and the redisnode package speaks
+// the Redis protocol. Other transports, e.g. through a proxy or a tunnel, can be used by setting Client.NewNodeClient
+// to a NodeClientFactory returning them.
+//
+// Operations must return the errors of *memcache.Client: memcache.ErrCacheMiss, memcache.ErrNotStored and
+// memcache.ErrCASConflict are results, any other error fails the node. A NodeClient may also implement NodePinger,
+// NodeMultiGetter, NodeStatter, NodeFlusher, NodePrewarmer, NodeKeyDumper and NodeBufferGetter to support the features
+// that use them.
+type NodeClient interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+	Delete(key string) error
+	Touch(key string, seconds int32) error
+	CompareAndSwap(item *memcache.Item) error
+}
+
+// NodePinger is implemented by NodeClients that support the version command, used by HEALTHCHECK_PROBE_VERSION
+type NodePinger interface {
+	Ping() error
+}
+
+// NodeMultiGetter is implemented by NodeClients that can read several keys in one request, used by GetMulti.
+// Keys that are not found are not in the result.
+type NodeMultiGetter interface {
+	GetMulti(keys []string) (map[string]*memcache.Item, error)
+}
+
+// NodeStatter is implemented by NodeClients that can read the stats of their server, see HealthCheckResult. The
+// "version" and "uptime" (in seconds) stats are used.
+type NodeStatter interface {
+	Stats() (map[string]string, error)
+}
+
+// NodeFlusher is implemented by NodeClients able to remove all items, used by Client.FlushAll
+type NodeFlusher interface {
+	FlushAll() error
+}
+
+// NodePrewarmer is implemented by NodeClients pooling connections, e.g. those of NewMemcacheNodeClient, able to open
+// their idle connections before the first request. It returns the number of connections opened.
+type NodePrewarmer interface {
+	Prewarm() (int, error)
+}
+
+// NodeKeyDumper is implemented by NodeClients able to enumerate the keys of their server, used by Client.Keys. It
+// calls fn for each key until fn returns an error or ctx is done.
+type NodeKeyDumper interface {
+	DumpKeys(ctx context.Context, fn func(*KeyMeta) error) error
+}
+
+// NodeBufferGetter is implemented by NodeClients able to copy a stored value into a caller's buffer, used by GetInto
+// to read without decoding an Item. It returns the length of the value and its flags, or the length with
+// io.ErrShortBuffer if buf is too short. MemoryNodeClient reads without allocating; the NodeClients of
+// NewNodeClientFactory copy the item read by *memcache.Client, which allocates it.
+type NodeBufferGetter interface {
+	GetInto(key string, buf []byte) (int, uint32, error)
+}
+
+var (
+	_ NodeClient       = (*memcache.Client)(nil)
+	_ NodePinger       = (*memcache.Client)(nil)
+	_ NodeMultiGetter  = (*memcache.Client)(nil)
+	_ NodeFlusher      = (*memcache.Client)(nil)
+	_ NodeStatter      = (*memcacheNodeClient)(nil)
+	_ NodePrewarmer    = (*memcacheNodeClient)(nil)
+	_ NodeKeyDumper    = (*memcacheNodeClient)(nil)
+	_ NodeBufferGetter = (*memcacheNodeClient)(nil)
+	_ NodeBatcher      = (*memcacheNodeClient)(nil)
+	_ NodeBufferGetter = (*MemoryNodeClient)(nil)
+	_ NodeBatcher      = (*MemoryNodeClient)(nil)
+)
+
+// memcacheNodeClient is a *memcache.Client that can also read the stats of its server and send batches of writes
+type memcacheNodeClient struct {
+	*memcache.Client
+	endpoint string
+
+	// batchConn is the idle connection of the last Batch, see takeBatchConn
+	batchConn  net.Conn
+	batchMutex sync.Mutex
+}
+
+// Stats returns the general-purpose statistics of the server, e.g. "version" and "uptime"
+func (client *memcacheNodeClient) Stats() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	dial := client.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", client.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(client.Timeout))
+
+	if _, err := conn.Write([]byte("stats\r\n")); err != nil {
+		return nil, err
+	}
+	stats := map[string]string{}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1 && fields[0] == "END":
+			return stats, nil
+		case len(fields) >= 3 && fields[0] == "STAT":
+			stats[fields[1]] = strings.Join(fields[2:], " ")
+		default:
+			return nil, memcache.ErrServerError
+		}
+	}
+}
+
+// GetInto implements NodeBufferGetter, copying the value read by Get into buf
+func (client *memcacheNodeClient) GetInto(key string, buf []byte) (int, uint32, error) {
+	item, err := client.Get(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) < len(item.Value) {
+		return len(item.Value), item.Flags, io.ErrShortBuffer
+	}
+	return copy(buf, item.Value), item.Flags, nil
+}
+
+// DumpKeys enumerates the keys of the server as DumpKeys does, over a connection of the client, e.g. using TLS
+func (client *memcacheNodeClient) DumpKeys(ctx context.Context, fn func(*KeyMeta) error) error {
+	dial := client.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: client.Timeout}).DialContext
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, client.Timeout)
+	defer cancel()
+	conn, err := dial(dialCtx, "tcp", client.endpoint)
+	if err != nil {
+		return err
+	}
+	return dumpKeys(ctx, conn, client.endpoint, client.Timeout, fn)
+}
+
+// NodeClientFactory returns a new NodeClient for the given endpoint (host:port) and timeout
+type NodeClientFactory func(endpoint string, timeout time.Duration) NodeClient
+
+// NodeClientOptions configure the connections of the *memcache.Clients returned by NewNodeClientFactory
+type NodeClientOptions struct {
+	// TLS connects over TLS with the given config, if not nil
+	TLS *tls.Config
+	// KeepAlive is the period of TCP keep-alive probes, and the idle time before the first, so that connections to a
+	// crashed server are closed by the operating system. Zero uses the default of net.Dialer (15 seconds), and a
+	// negative value disables keep-alives.
+	KeepAlive time.Duration
+	// MaxIdleConns is the number of idle connections kept per node. Zero uses memcache.DefaultMaxIdleConns.
+	MaxIdleConns int
+}
+
+// NewNodeClientFactory returns a NodeClientFactory for *memcache.Clients connecting with the given options, which can
+// also read the server's stats and prewarm their connections
+func NewNodeClientFactory(options NodeClientOptions) NodeClientFactory {
+	return func(endpoint string, timeout time.Duration) NodeClient {
+		client := memcache.New(endpoint)
+		client.Timeout = timeout
+		client.MaxIdleConns = options.MaxIdleConns
+		netDialer := &net.Dialer{Timeout: timeout, KeepAlive: options.KeepAlive}
+		if options.TLS != nil {
+			dialer := &tls.Dialer{
+				NetDialer: netDialer,
+				Config:    options.TLS,
+			}
+			client.DialContext = dialer.DialContext
+		} else if options.KeepAlive != 0 {
+			client.DialContext = netDialer.DialContext
+		}
+		return &memcacheNodeClient{Client: client, endpoint: endpoint}
+	}
+}
+
+// NewMemcacheNodeClient implements NodeClientFactory, returning a *memcache.Client for the given endpoint, which can
+// also read the server's stats
+func NewMemcacheNodeClient(endpoint string, timeout time.Duration) NodeClient {
+	return NewNodeClientFactory(NodeClientOptions{})(endpoint, timeout)
+}
+
+// NewTLSNodeClientFactory returns a NodeClientFactory for *memcache.Clients connecting over TLS with the given config
+func NewTLSNodeClientFactory(tlsConfig *tls.Config) NodeClientFactory {
+	return NewNodeClientFactory(NodeClientOptions{TLS: tlsConfig})
+}
@@ -1,8 +1,16 @@
 package memcacheha
 
-// NodeList represents a list of memcache servers configured/discovered by this client.
+import (
+	"sync"
+)
+
+// NodeList represents a list of memcache servers configured/discovered by this client. It is safe for concurrent use.
 type NodeList struct {
 	Nodes map[string]*Node
+	mutex sync.RWMutex
+
+	changed     chan (struct{})
+	changeMutex sync.Mutex
 }
 
 // NewNodeList returns a new, empty NodeList
@@ -12,22 +20,37 @@ func NewNodeList() *NodeList {
 	}
 }
 
-// GetHealthyNodes returns a map of config endpoints to Nodes where the node IsHealthy is true
+// GetNodes returns a map of config endpoints to all Nodes
+func (nodeList *NodeList) GetNodes() map[string]*Node {
+	nodeList.mutex.RLock()
+	defer nodeList.mutex.RUnlock()
+	out := make(map[string]*Node, len(nodeList.Nodes))
+	for endpoint, node := range nodeList.Nodes {
+		out[endpoint] = node
+	}
+	return out
+}
+
+// GetHealthyNodes returns a map of config endpoints to Nodes where the node IsHealthy
 func (nodeList *NodeList) GetHealthyNodes() map[string]*Node {
+	nodeList.mutex.RLock()
+	defer nodeList.mutex.RUnlock()
 	out := map[string]*Node{}
 	for _, node := range nodeList.Nodes {
-		if node.IsHealthy {
+		if node.IsHealthy() {
 			out[node.Endpoint] = node
 		}
 	}
 	return out
 }
 
-// GetHealthyNodeCount returns the count of Nodes where the node IsHealthy is true
+// GetHealthyNodeCount returns the count of Nodes where the node IsHealthy
 func (nodeList *NodeList) GetHealthyNodeCount() int {
+	nodeList.mutex.RLock()
+	defer nodeList.mutex.RUnlock()
 	healthy := 0
 	for _, node := range nodeList.Nodes {
-		if node.IsHealthy {
+		if node.IsHealthy() {
 			healthy++
 		}
 	}
@@ -36,11 +59,45 @@ func (nodeList *NodeList) GetHealthyNodeCount() int {
 
 // Exists returns true if a node for the given endpoint exists
 func (nodeList *NodeList) Exists(nodeAddr string) bool {
+	nodeList.mutex.RLock()
+	defer nodeList.mutex.RUnlock()
 	_, found := nodeList.Nodes[nodeAddr]
 	return found
 }
 
 // Add the given node to this list
 func (nodeList *NodeList) Add(node *Node) {
+	nodeList.mutex.Lock()
+	node.onHealthChange = nodeList.notify
 	nodeList.Nodes[node.Endpoint] = node
+	nodeList.mutex.Unlock()
+	nodeList.notify()
+}
+
+// Remove the node with the given endpoint from this list
+func (nodeList *NodeList) Remove(nodeAddr string) {
+	nodeList.mutex.Lock()
+	delete(nodeList.Nodes, nodeAddr)
+	nodeList.mutex.Unlock()
+	nodeList.notify()
+}
+
+// Changed returns a channel that is closed at the next change of the nodes in this list or of their health
+func (nodeList *NodeList) Changed() <-chan struct{} {
+	nodeList.changeMutex.Lock()
+	defer nodeList.changeMutex.Unlock()
+	if nodeList.changed == nil {
+		nodeList.changed = make(chan (struct{}))
+	}
+	return nodeList.changed
+}
+
+// notify closes the channel returned by Changed
+func (nodeList *NodeList) notify() {
+	nodeList.changeMutex.Lock()
+	defer nodeList.changeMutex.Unlock()
+	if nodeList.changed != nil {
+		close(nodeList.changed)
+		nodeList.changed = nil
+	}
 }
@@ -1,17 +1,62 @@
 package memcacheha
 
+import (
+	"sync"
+	"time"
+)
+
 // NodeResponse represents a reply from a node
 type NodeResponse struct {
 	Node  *Node
 	Item  *Item
 	Error error
+	// CasID is the compare-and-swap ID of Item on Node
+	CasID uint64
+	// Elapsed is the time the request took on Node, including retries
+	Elapsed time.Duration
+
+	// retryable is true if Error is in ERROR_CATEGORY_RETRYABLE, see Node.run
+	retryable bool
+}
+
+var nodeResponsePool = sync.Pool{
+	New: func() interface{} {
+		return &NodeResponse{}
+	},
 }
 
 // NewNodeResponse returns a new NodeResponse with the specified Node, Item and Error
 func NewNodeResponse(node *Node, item *Item, err error) *NodeResponse {
-	return &NodeResponse{
-		Node:  node,
-		Item:  item,
-		Error: err,
+	response := nodeResponsePool.Get().(*NodeResponse)
+	response.Node = node
+	response.Item = item
+	response.Error = err
+	return response
+}
+
+// releaseNodeResponse returns a NodeResponse to the pool. The response must not be used afterwards.
+func releaseNodeResponse(response *NodeResponse) {
+	response.Node = nil
+	response.Item = nil
+	response.Error = nil
+	response.CasID = 0
+	response.Elapsed = 0
+	response.retryable = false
+	nodeResponsePool.Put(response)
+}
+
+var responseChanPool = sync.Pool{}
+
+// getResponseChan returns an empty channel buffering at least n responses, reused from a pool if possible
+func getResponseChan(n int) chan (*NodeResponse) {
+	if c, ok := responseChanPool.Get().(chan (*NodeResponse)); ok && cap(c) >= n {
+		return c
 	}
+	return make(chan (*NodeResponse), n)
+}
+
+// releaseResponseChan returns a channel to the pool. Every response sent to it must have been received, and it must
+// not be used afterwards.
+func releaseResponseChan(c chan (*NodeResponse)) {
+	responseChanPool.Put(c)
 }
@@ -1,6 +1,76 @@
 package memcacheha
 
+import (
+	"context"
+	"time"
+)
+
 // NodeSource is an interface defining the GetNodes function. All node sources must implement NodeSource.
 type NodeSource interface {
 	GetNodes() ([]string, error)
 }
+
+// NodeSpec describes a node found by a NodeSpecSource
+type NodeSpec struct {
+	// Address is the endpoint of the node (host:port)
+	Address string `json:"address"`
+	// Zone is the availability zone of the node, if known
+	Zone string `json:"zone,omitempty"`
+	// Weight is the relative weight of the node, zero meaning the default of 1
+	Weight int `json:"weight,omitempty"`
+	// TLS requires connecting to the node with Client.NewTLSNodeClient
+	TLS bool `json:"tls,omitempty"`
+	// TTL is how long the node may be relied upon, e.g. the TTL of the DNS record it was found in. Discovery runs again
+	// within the shortest TTL found, if shorter than the GetNodesPeriod. Zero means no hint.
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// NodeSpecSource is a NodeSource describing its nodes with NodeSpecs. Clients call GetNodeSpecs rather than GetNodes;
+// GetNodes should return the addresses of the same nodes, for use as a plain NodeSource.
+type NodeSpecSource interface {
+	NodeSource
+	GetNodeSpecs(ctx context.Context) ([]NodeSpec, error)
+}
+
+// NodeSpecSourceFunc is a function implementing NodeSpecSource
+type NodeSpecSourceFunc func(ctx context.Context) ([]NodeSpec, error)
+
+// GetNodeSpecs implements NodeSpecSource, calling fn
+func (fn NodeSpecSourceFunc) GetNodeSpecs(ctx context.Context) ([]NodeSpec, error) {
+	return fn(ctx)
+}
+
+// GetNodes implements NodeSource, returning the addresses of the nodes returned by fn
+func (fn NodeSpecSourceFunc) GetNodes() ([]string, error) {
+	specs, err := fn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return specAddresses(specs), nil
+}
+
+// GetNodeSpecs returns the nodes of source, from GetNodeSpecs if it is a NodeSpecSource, or otherwise from GetNodes
+// as NodeSpecs with only an Address
+func GetNodeSpecs(ctx context.Context, source NodeSource) ([]NodeSpec, error) {
+	if specSource, ok := source.(NodeSpecSource); ok {
+		return specSource.GetNodeSpecs(ctx)
+	}
+	endpoints, err := source.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]NodeSpec, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		specs = append(specs, NodeSpec{Address: endpoint})
+	}
+	return specs, nil
+}
+
+// specAddresses returns the Addresses of specs
+func specAddresses(specs []NodeSpec) []string {
+	addresses := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		addresses = append(addresses, spec.Address)
+	}
+	return addresses
+}
@@ -0,0 +1,103 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync/atomic"
+	"time"
+)
+
+// Operation names, used in logs and statistics
+const (
+	OP_ADD       = "add"
+	OP_SET       = "set"
+	OP_GET       = "get"
+	OP_DELETE    = "delete"
+	OP_TOUCH     = "touch"
+	OP_INCREMENT = "increment"
+	OP_DECREMENT = "decrement"
+)
+
+// OpCounts are the number of calls of an operation, and of those, how many missed (ErrCacheMiss or ErrNotStored)
+// or failed with another error
+type OpCounts struct {
+	Calls  uint64 `json:"calls"`
+	Misses uint64 `json:"misses"`
+	Errors uint64 `json:"errors"`
+}
+
+// OperationStats are the operations performed by a client since it was created, tagged with its ClientID so the
+// load of each service on a shared cluster can be attributed
+type OperationStats struct {
+	ClientID string              `json:"client_id,omitempty"`
+	Ops      map[string]OpCounts `json:"ops"`
+}
+
+type opCounter struct {
+	calls  uint64
+	misses uint64
+	errors uint64
+}
+
+func newOpCounters() map[string]*opCounter {
+	counters := map[string]*opCounter{}
+	for _, op := range []string{OP_ADD, OP_SET, OP_GET, OP_DELETE, OP_TOUCH, OP_INCREMENT, OP_DECREMENT} {
+		counters[op] = &opCounter{}
+	}
+	return counters
+}
+
+// OperationStats returns the number of calls, misses and errors of each operation of this client
+func (client *Client) OperationStats() OperationStats {
+	client.configMutex.RLock()
+	stats := OperationStats{ClientID: client.ClientID, Ops: map[string]OpCounts{}}
+	client.configMutex.RUnlock()
+
+	for op, counter := range client.opCounters {
+		stats.Ops[op] = OpCounts{
+			Calls:  atomic.LoadUint64(&counter.calls),
+			Misses: atomic.LoadUint64(&counter.misses),
+			Errors: atomic.LoadUint64(&counter.errors),
+		}
+	}
+	return stats
+}
+
+// observe is called on completion of every client operation with the caller's key, the start time of the
+// operation, the item written or read (if any) and the error returned.
+func (client *Client) observe(op string, key string, start time.Time, item *Item, err error) {
+	client.configMutex.RLock()
+	trackHotKeys, clientID, logHandler := client.TrackHotKeys, client.ClientID, client.LogHandler
+	readYourWrites, ttlSampleRate := client.ReadYourWrites, client.TTLSampleRate
+	sizePrefixes := client.SizePrefixes
+	client.configMutex.RUnlock()
+
+	if counter, found := client.opCounters[op]; found {
+		atomic.AddUint64(&counter.calls, 1)
+		switch err {
+		case nil:
+		case memcache.ErrCacheMiss, memcache.ErrNotStored:
+			atomic.AddUint64(&counter.misses, 1)
+		default:
+			atomic.AddUint64(&counter.errors, 1)
+		}
+	}
+	client.metrics.record(op, client.Clock.Now().Sub(start), err)
+	if (op == OP_SET || op == OP_ADD) && item != nil && err == nil {
+		prefix, found := sizePrefix(sizePrefixes, key)
+		client.metrics.recordSize(len(item.Value), prefix, found)
+	}
+	if logHandler != nil {
+		client.logOperation(logHandler, clientID, op, key, start, err)
+	}
+	if trackHotKeys {
+		client.hotKeys.Add(key)
+	}
+	if readYourWrites > 0 {
+		client.observeWrite(op, key, item, err, readYourWrites)
+	}
+	if ttlSampleRate > 0 {
+		client.sampleTTL(op, key, item, err, ttlSampleRate)
+	}
+	client.sampleAccess(clientID, op, key, start, item, err)
+}
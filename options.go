@@ -0,0 +1,227 @@
+package memcacheha
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ReadOptions are the per-call options for read operations
+type ReadOptions struct {
+	// ReadAll reads from all healthy nodes rather than Ceil(n/2)
+	ReadAll bool
+	// ReadCount is the number of healthy nodes to read from. Zero means Ceil(n/2).
+	ReadCount int
+	// NoRepair disables synchronisation of nodes with missing data
+	NoRepair bool
+	// RepairQuorum is the number of nodes that must return the same value before nodes with missing data are
+	// synchronised. Zero means any one node.
+	RepairQuorum int
+	// Selection decides which nodes are read from when reading fewer than all healthy nodes
+	Selection ReadSelection
+
+	// ctx is the context of the call, see WithContext
+	ctx context.Context
+}
+
+// AddConflictPolicy decides how Add resolves a conflict where some nodes already have a value for the key and others
+// stored the new item
+type AddConflictPolicy int
+
+const (
+	// ADD_CONFLICT_PROPAGATE writes the existing value to the nodes that stored the new item, and returns ErrNotStored
+	ADD_CONFLICT_PROPAGATE AddConflictPolicy = iota
+	// ADD_CONFLICT_ABORT returns ErrNotStored without synchronising nodes, leaving them divergent
+	ADD_CONFLICT_ABORT
+	// ADD_CONFLICT_OVERWRITE writes the new item to the nodes that had an existing value, and returns success
+	ADD_CONFLICT_OVERWRITE
+)
+
+// ErrUnknownAddConflictPolicy is an error meaning an AddConflictPolicy name is not propagate, abort or overwrite
+var ErrUnknownAddConflictPolicy = errors.New("memcacheha: unknown add conflict policy")
+
+var addConflictPolicyNames = map[AddConflictPolicy]string{
+	ADD_CONFLICT_PROPAGATE: "propagate",
+	ADD_CONFLICT_ABORT:     "abort",
+	ADD_CONFLICT_OVERWRITE: "overwrite",
+}
+
+// UnmarshalText parses a policy name: propagate, abort or overwrite
+func (policy *AddConflictPolicy) UnmarshalText(text []byte) error {
+	for p, name := range addConflictPolicyNames {
+		if name == string(text) {
+			*policy = p
+			return nil
+		}
+	}
+	return ErrUnknownAddConflictPolicy
+}
+
+// MarshalText returns the policy name
+func (policy AddConflictPolicy) MarshalText() ([]byte, error) {
+	name, found := addConflictPolicyNames[policy]
+	if !found {
+		return nil, ErrUnknownAddConflictPolicy
+	}
+	return []byte(name), nil
+}
+
+// WriteOptions are the per-call options for write operations
+type WriteOptions struct {
+	// Quorum is the number of nodes that must acknowledge the write, otherwise ErrQuorumNotReached is returned.
+	// Zero means any one node, WRITE_QUORUM_ALL every node the write is sent to.
+	Quorum int
+	// NoRepair disables synchronisation of nodes with missing data
+	NoRepair bool
+	// AddConflict is the policy of Add when nodes disagree on whether the key exists
+	AddConflict AddConflictPolicy
+	// TouchRepair synchronises nodes missing the key of a Touch from a node that has it
+	TouchRepair bool
+	// DefaultTTL is the expiry of items written by Set, Add or Touch without one. Zero leaves them without expiry.
+	DefaultTTL time.Duration
+	// MaxTTL limits the expiry of items written by Set, Add or Touch, including those without one. Zero means no
+	// limit.
+	MaxTTL time.Duration
+
+	// ctx is the context of the call, see WithContext
+	ctx context.Context
+}
+
+// ReadOption configures a read operation such as Get
+type ReadOption interface {
+	applyRead(*ReadOptions)
+}
+
+// WriteOption configures a write operation such as Set, Add, Delete or Touch
+type WriteOption interface {
+	applyWrite(*WriteOptions)
+}
+
+// ReadWriteOption configures both read and write operations
+type ReadWriteOption interface {
+	ReadOption
+	WriteOption
+}
+
+type readOptionFunc func(*ReadOptions)
+
+func (f readOptionFunc) applyRead(options *ReadOptions) { f(options) }
+
+type writeOptionFunc func(*WriteOptions)
+
+func (f writeOptionFunc) applyWrite(options *WriteOptions) { f(options) }
+
+type noRepairOption struct{}
+
+func (noRepairOption) applyRead(options *ReadOptions)   { options.NoRepair = true }
+func (noRepairOption) applyWrite(options *WriteOptions) { options.NoRepair = true }
+
+type contextOption struct{ ctx context.Context }
+
+func (option contextOption) applyRead(options *ReadOptions)   { options.ctx = option.ctx }
+func (option contextOption) applyWrite(options *WriteOptions) { options.ctx = option.ctx }
+
+// WithReadAll reads from all healthy nodes
+func WithReadAll() ReadOption {
+	return readOptionFunc(func(options *ReadOptions) {
+		options.ReadAll = true
+	})
+}
+
+// WithReadCount reads from n healthy nodes, or all healthy nodes if there are fewer than n
+func WithReadCount(n int) ReadOption {
+	return readOptionFunc(func(options *ReadOptions) {
+		options.ReadCount = n
+	})
+}
+
+// WithReadSelection chooses the nodes read from by selection when reading fewer than all healthy nodes
+func WithReadSelection(selection ReadSelection) ReadOption {
+	return readOptionFunc(func(options *ReadOptions) {
+		options.Selection = selection
+	})
+}
+
+// WithRepairQuorum only synchronises nodes with missing data if n nodes returned the same value
+func WithRepairQuorum(n int) ReadOption {
+	return readOptionFunc(func(options *ReadOptions) {
+		options.RepairQuorum = n
+	})
+}
+
+// WRITE_QUORUM_ALL is a write Quorum requiring every node a write is sent to, all healthy nodes, to acknowledge it
+const WRITE_QUORUM_ALL = -1
+
+// WithWriteQuorum requires n nodes to acknowledge a write
+func WithWriteQuorum(n int) WriteOption {
+	return writeOptionFunc(func(options *WriteOptions) {
+		options.Quorum = n
+	})
+}
+
+// WithAddConflictPolicy sets the policy of Add when nodes disagree on whether the key exists
+func WithAddConflictPolicy(policy AddConflictPolicy) WriteOption {
+	return writeOptionFunc(func(options *WriteOptions) {
+		options.AddConflict = policy
+	})
+}
+
+// WithTouchRepair synchronises nodes missing the key of a Touch from a node that has it, with the new expiry
+func WithTouchRepair() WriteOption {
+	return writeOptionFunc(func(options *WriteOptions) {
+		options.TouchRepair = true
+	})
+}
+
+// WithContext sets the context of an operation, whose tenant is subject to TenantQuotas (see WithTenant) and whose
+// identity is recorded in the AuditRecords of deletes (see WithAuditIdentity)
+func WithContext(ctx context.Context) ReadWriteOption {
+	return contextOption{ctx: ctx}
+}
+
+// WithNoRepair disables synchronisation of nodes with missing data
+func WithNoRepair() ReadWriteOption {
+	return noRepairOption{}
+}
+
+// newReadOptions returns the options of the KeyPolicy of key, or the client's DefaultReadOptions, with the given
+// options applied
+func (client *Client) newReadOptions(key string, opts []ReadOption) *ReadOptions {
+	options := &ReadOptions{}
+	client.configMutex.RLock()
+	*options = client.DefaultReadOptions
+	if policy := client.keyPolicy(key); policy != nil {
+		*options = policy.Read
+	}
+	client.configMutex.RUnlock()
+	for _, opt := range opts {
+		opt.applyRead(options)
+	}
+	if client.repairsSuppressed() {
+		options.NoRepair = true
+	}
+	return options
+}
+
+// newWriteOptions returns the options of the KeyPolicy of key, or the client's DefaultWriteOptions, with the given
+// options applied
+func (client *Client) newWriteOptions(key string, opts []WriteOption) *WriteOptions {
+	options := &WriteOptions{}
+	client.configMutex.RLock()
+	*options = client.DefaultWriteOptions
+	if policy := client.keyPolicy(key); policy != nil {
+		*options = policy.Write
+	}
+	client.configMutex.RUnlock()
+	for _, opt := range opts {
+		opt.applyWrite(options)
+	}
+	if client.repairsSuppressed() {
+		options.NoRepair = true
+	}
+	// Writes to a single node are best-effort, see SingleNodeErrorRate
+	if client.SingleNode() != "" {
+		options.Quorum = 0
+	}
+	return options
+}
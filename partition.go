@@ -0,0 +1,46 @@
+package memcacheha
+
+import (
+	"errors"
+)
+
+// ErrPossiblePartition is an error meaning a majority of nodes became unreachable, which may be a network partition
+// isolating this client from the rest of the cluster rather than failed nodes
+var ErrPossiblePartition = errors.New("memcacheha: possible network partition")
+
+// PartitionStatus returns ErrPossiblePartition if a majority of nodes were unreachable at the last healthcheck, or nil
+func (client *Client) PartitionStatus() error {
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	if client.partitioned {
+		return ErrPossiblePartition
+	}
+	return nil
+}
+
+// updatePartition enters the possible partition state if a majority of at least two nodes are unhealthy, and leaves
+// it once a majority is healthy again
+func (client *Client) updatePartition() {
+	total := len(client.Nodes.GetNodes())
+	healthy := client.Nodes.GetHealthyNodeCount()
+	partitioned := total > 1 && (total-healthy)*2 > total
+
+	client.statusMutex.Lock()
+	changed := partitioned != client.partitioned
+	client.partitioned = partitioned
+	client.statusMutex.Unlock()
+
+	if changed && partitioned {
+		client.levelLog.Error("Partition: %d of %d nodes unreachable", total-healthy, total)
+	} else if changed {
+		client.levelLog.Info("Partition: Majority restored, %d of %d nodes healthy", healthy, total)
+	}
+}
+
+// repairsSuppressed returns true if nodes must not be synchronised, see SuppressRepairsOnPartition
+func (client *Client) repairsSuppressed() bool {
+	client.configMutex.RLock()
+	suppress := client.SuppressRepairsOnPartition
+	client.configMutex.RUnlock()
+	return suppress && client.PartitionStatus() != nil
+}
@@ -0,0 +1,63 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPartitionSuppressesRepairs(t *testing.T) {
+	// Healthcheck soon after Start, without waiting on slow lookups of the unresolvable test hostnames
+	startDelay, resolveTimeout := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT
+	t.Cleanup(func() { memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = startDelay, resolveTimeout })
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+
+	cluster := memcachehatest.NewCluster(5)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.HealthCheckPeriod = 10 * time.Millisecond })
+	endpoints := cluster.Endpoints()
+	failure := errors.New("node failure")
+
+	// A minority of unreachable nodes is not a partition
+	cluster.Fail(failure, endpoints[3:]...)
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 3 })
+	if err := client.PartitionStatus(); err != nil {
+		t.Fatalf("PartitionStatus with 2 of 5 nodes unreachable returned %v", err)
+	}
+
+	// Without SuppressRepairsOnPartition, nodes are still repaired during a possible partition
+	cluster.Fail(failure, endpoints[2])
+	eventually(t, func() bool { return client.PartitionStatus() == memcacheha.ErrPossiblePartition })
+	cluster.Put(&memcacheha.Item{Key: "repaired", Value: []byte("value")}, endpoints[0])
+	if _, err := client.Get("repaired", memcacheha.WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	eventually(t, func() bool { return cluster.Value(endpoints[1], "repaired") != nil })
+
+	// With it, a value read during a possible partition is not propagated
+	client.SetSuppressRepairsOnPartition(true)
+	performed := client.RepairStats().Performed
+	cluster.Put(&memcacheha.Item{Key: "suppressed", Value: []byte("value")}, endpoints[0])
+	if item, err := client.Get("suppressed", memcacheha.WithReadAll()); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Get during a partition returned %v, %v", item, err)
+	}
+	if stats := client.RepairStats(); stats.Performed != performed {
+		t.Fatalf("RepairStats are %+v during a partition, expected %d repairs", stats, performed)
+	}
+	if value := cluster.Value(endpoints[1], "suppressed"); value != nil {
+		t.Fatalf("%s was repaired with %q during a partition", endpoints[1], value)
+	}
+
+	// Once a majority is reachable again, repairs resume
+	cluster.Recover(endpoints[2:]...)
+	eventually(t, func() bool { return client.Nodes.GetHealthyNodeCount() == 5 && client.PartitionStatus() == nil })
+	if _, err := client.Get("suppressed", memcacheha.WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	eventually(t, func() bool { return consistent(cluster, "suppressed", []byte("value")) })
+	if stats := client.RepairStats(); stats.Performed != performed+4 {
+		t.Fatalf("RepairStats are %+v after the partition, expected %d repairs", stats, performed+4)
+	}
+}
@@ -0,0 +1,56 @@
+package memcacheha
+
+import (
+	"errors"
+	"hash/fnv"
+	"path"
+)
+
+// errNotSentToNode marks pipeline operations that were not sent to a node, e.g. for pinned keys
+var errNotSentToNode = errors.New("memcacheha: key not sent to node")
+
+// isPinned returns true if key matches any of the PinnedKeys patterns
+func (client *Client) isPinned(key string) bool {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	for _, pattern := range client.PinnedKeys {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// getHealthyNodes returns the healthy nodes an operation on key is sent to: all healthy nodes, only the PinnedNode for
+// pinned keys, chosen from nodes that are not draining, or only the SingleNode in single-node mode
+func (client *Client) getHealthyNodes(key string) map[string]*Node {
+	nodes := client.Nodes.GetHealthyNodes()
+	if len(nodes) > 1 {
+		if node := client.singleNodeOf(nodes); node != nil {
+			return map[string]*Node{node.Endpoint: node}
+		}
+	}
+	if len(nodes) <= 1 || !client.isPinned(key) {
+		return nodes
+	}
+	node := PinnedNode(key, withoutDraining(nodes))
+	return map[string]*Node{node.Endpoint: node}
+}
+
+// PinnedNode returns the node of nodes with the highest rendezvous hash of key and node endpoint. Every client
+// chooses the same node for a key while it is healthy; when it fails, the next highest is promoted, and the key
+// moves back (with the data it had) when the node recovers.
+func PinnedNode(key string, nodes map[string]*Node) *Node {
+	var pinned *Node
+	var highest uint64
+	for endpoint, node := range nodes {
+		hash := fnv.New64a()
+		hash.Write([]byte(endpoint))
+		hash.Write([]byte(key))
+		weight := hash.Sum64()
+		if pinned == nil || weight > highest || (weight == highest && endpoint < pinned.Endpoint) {
+			pinned, highest = node, weight
+		}
+	}
+	return pinned
+}
@@ -0,0 +1,261 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync"
+)
+
+type pipelineOpType int
+
+const (
+	pipelineSet pipelineOpType = iota
+	pipelineDelete
+	pipelineTouch
+)
+
+// pipelineOp is a single operation queued in a Pipeline
+type pipelineOp struct {
+	Type    pipelineOpType
+	Key     string
+	Item    *Item
+	Seconds int32
+	Error   error
+	// Nodes are the nodes the operation is sent to, see Client.getHealthyNodes
+	Nodes map[string]*Node
+	// Original is the item given to Set, before its key is mapped
+	Original *Item
+	// Result is the error returned for the operation by Flush
+	Result error
+}
+
+// opName returns the name of the Client operation op performs, see observe
+func (op *pipelineOp) opName() string {
+	switch op.Type {
+	case pipelineDelete:
+		return OP_DELETE
+	case pipelineTouch:
+		return OP_TOUCH
+	}
+	return OP_SET
+}
+
+// Pipeline groups Set, Delete and Touch operations, sending them when Flush is called. The operations for a node are
+// sent in order in one round trip when its NodeClient is a NodeBatcher, as those of NewNodeClientFactory are, or as
+// one request each otherwise, with the nodes written to in parallel. A Pipeline is not safe for concurrent use.
+type Pipeline struct {
+	client  *Client
+	options *WriteOptions
+	ops     []*pipelineOp
+}
+
+// Pipeline returns a new, empty Pipeline. The given options apply to every operation in the pipeline, in place of
+// any KeyPolicies.
+func (client *Client) Pipeline(opts ...WriteOption) *Pipeline {
+	return &Pipeline{
+		client:  client,
+		options: client.newWriteOptions("", opts),
+	}
+}
+
+// Set queues an unconditional write of the given item
+func (pipeline *Pipeline) Set(item *Item) {
+	op := &pipelineOp{Type: pipelineSet, Key: item.Key, Original: item}
+	op.Item, op.Error = pipeline.client.mapItem(pipeline.options.applyTTL(item, pipeline.client.Clock.Now()))
+	if op.Error == nil {
+		op.Error = pipeline.client.checkQuota(pipeline.options.ctx, len(item.Value))
+	}
+	pipeline.ops = append(pipeline.ops, op)
+}
+
+// Delete queues a delete of the given key
+func (pipeline *Pipeline) Delete(key string) {
+	op := &pipelineOp{Type: pipelineDelete, Key: key}
+	op.Item, op.Error = pipeline.client.mapItem(&Item{Key: key})
+	if op.Error == nil {
+		op.Error = pipeline.client.checkQuota(pipeline.options.ctx, 0)
+	}
+	pipeline.ops = append(pipeline.ops, op)
+}
+
+// Touch queues an expiry update of the given key
+func (pipeline *Pipeline) Touch(key string, seconds int32) {
+	op := &pipelineOp{Type: pipelineTouch, Key: key, Seconds: pipeline.options.clampSeconds(seconds, pipeline.client.Clock.Now())}
+	op.Item, op.Error = pipeline.client.mapItem(&Item{Key: key})
+	if op.Error == nil {
+		op.Error = pipeline.client.checkQuota(pipeline.options.ctx, 0)
+	}
+	pipeline.ops = append(pipeline.ops, op)
+}
+
+// Len returns the number of queued operations
+func (pipeline *Pipeline) Len() int {
+	return len(pipeline.ops)
+}
+
+// Flush sends all queued operations to their healthy nodes and empties the pipeline. The result for each key is
+// returned, following the semantics of the corresponding Client method. If a key was queued more than once,
+// the result of its last operation is returned. ErrNoHealthyNodes is returned, with the results, if there are no
+// healthy nodes for the operations that were not rejected before sending, such as for an invalid key.
+func (pipeline *Pipeline) Flush() (map[string]error, error) {
+	return pipeline.flush(1)
+}
+
+// flush sends all queued operations to their healthy nodes, split into parallelism concurrent batches per node. Each
+// operation is observed as the corresponding Client method is.
+func (pipeline *Pipeline) flush(parallelism int) (map[string]error, error) {
+	ops := pipeline.ops
+	pipeline.ops = nil
+	if len(ops) == 0 {
+		return map[string]error{}, nil
+	}
+	opID, start := newOperationID(), pipeline.client.Clock.Now()
+	defer func() {
+		for _, op := range ops {
+			pipeline.client.observe(op.opName(), op.Key, start, op.Original, op.Result)
+		}
+	}()
+
+	if pipeline.client.Disabled() {
+		results := make(map[string]error, len(ops))
+		for _, op := range ops {
+			op.Result = op.Error
+			results[op.Key] = op.Result
+		}
+		return results, nil
+	}
+
+	// Get the healthy nodes of each operation, and all the nodes they are sent to
+	nodes := map[string]*Node{}
+	for _, op := range ops {
+		if op.Error != nil {
+			continue
+		}
+		op.Nodes = pipeline.client.getHealthyNodes(op.Key)
+		for endpoint, node := range op.Nodes {
+			nodes[endpoint] = node
+		}
+	}
+	if len(nodes) == 0 {
+		// Operations rejected before sending keep their own error, the others have no node to be sent to
+		results := make(map[string]error, len(ops))
+		var err error
+		for _, op := range ops {
+			op.Result = op.Error
+			if op.Result == nil {
+				op.Result, err = ErrNoHealthyNodes, ErrNoHealthyNodes
+			}
+			results[op.Key] = op.Result
+		}
+		return results, err
+	}
+
+	// Send each node its batch concurrently
+	var nodeErrors [][]error
+	var endpoints []string
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		errs := make([]error, len(ops))
+		nodeErrors = append(nodeErrors, errs)
+		endpoints = append(endpoints, node.Endpoint)
+		batchSize := (len(ops) + parallelism - 1) / parallelism
+		for start := 0; start < len(ops); start += batchSize {
+			end := start + batchSize
+			if end > len(ops) {
+				end = len(ops)
+			}
+			wg.Add(1)
+			go func(node *Node, start int, end int) {
+				defer wg.Done()
+				pipeline.runNode(opID, node, ops[start:end], errs[start:end])
+			}(node, start, end)
+		}
+	}
+	wg.Wait()
+
+	results := map[string]error{}
+	for i, op := range ops {
+		if op.Error != nil {
+			op.Result = op.Error
+			results[op.Key] = op.Result
+			continue
+		}
+
+		// Count of nodes that acknowledged the operation
+		acked := 0
+		var errToReturn error
+		failed := map[string]error{}
+		for n, errs := range nodeErrors {
+			err := errs[i]
+			if err == errNotSentToNode {
+				continue
+			}
+			if err == memcache.ErrCacheMiss && op.Type != pipelineSet {
+				errToReturn = memcache.ErrCacheMiss
+				acked++
+			} else if err == nil {
+				acked++
+				if op.Type == pipelineSet {
+					pipeline.client.deletes.cancel(endpoints[n], op.Item.Key)
+				}
+			} else {
+				failed[endpoints[n]] = err
+			}
+		}
+
+		if err := writeError(acked, pipeline.options.Quorum, failed, nil); err != nil {
+			errToReturn = err
+		}
+		op.Result = errToReturn
+		results[op.Key] = op.Result
+		if op.Type == pipelineDelete {
+			pipeline.client.queueMissedDeletes(op.Key, op.Item.Key, op.Nodes, failed)
+			pipeline.client.Audit(pipeline.options.ctx, AUDIT_DELETE, pipeline.client.LogKey(op.Key), start, errToReturn)
+		}
+	}
+
+	return results, nil
+}
+
+// runNode performs ops on the given node in order, storing the error of each. Ops are sent in one round trip if the
+// node client is a NodeBatcher. Otherwise each is its own request, and if the node becomes unhealthy, the remaining
+// operations are not sent.
+func (pipeline *Pipeline) runNode(opID string, node *Node, ops []*pipelineOp, errs []error) {
+	for i, op := range ops {
+		if _, found := op.Nodes[node.Endpoint]; !found && op.Error == nil {
+			errs[i] = errNotSentToNode
+		}
+	}
+	if node.batch(opID, ops, errs) {
+		return
+	}
+
+	var failed error
+	for i, op := range ops {
+		if op.Error != nil || errs[i] == errNotSentToNode {
+			continue
+		}
+		if failed != nil {
+			errs[i] = failed
+			continue
+		}
+
+		var response *NodeResponse
+		node.limiter.acquire()
+		switch op.Type {
+		case pipelineSet:
+			response = node.doSet(opID, op.Item)
+		case pipelineDelete:
+			response = node.doDelete(opID, op.Item.Key)
+		case pipelineTouch:
+			response = node.doTouch(opID, op.Item.Key, op.Seconds)
+		}
+		node.limiter.release()
+		errs[i] = response.Error
+		releaseNodeResponse(response)
+
+		if !node.IsHealthy() {
+			failed = errs[i]
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"sync"
+	"time"
+)
+
+var (
+	// IDLE_CHECK_PERIOD is the default period between validating the idle connections of healthy nodes, see
+	// IdleCheckPeriod
+	IDLE_CHECK_PERIOD time.Duration = time.Duration(30 * time.Second)
+)
+
+// Prewarm opens and validates, with the version command, as many connections as the client keeps idle (MaxIdleConns,
+// or memcache.DefaultMaxIdleConns if unset), returning the number validated and the first error. The connections are
+// opened concurrently and left in the pool, though a fast server may let some requests share a connection.
+func (client *memcacheNodeClient) Prewarm() (int, error) {
+	n := client.MaxIdleConns
+	if n <= 0 {
+		n = memcache.DefaultMaxIdleConns
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	opened := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Ping()
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			opened++
+		}()
+	}
+	wg.Wait()
+	return opened, firstErr
+}
+
+// checkIdleConnections validates the idle connections of each healthy node with the version command, see
+// IdleCheckPeriod. Connections that fail, e.g. half-open after the server crashed, are closed rather than returned to
+// the pool.
+func (client *Client) checkIdleConnections() {
+	for nodeAddr, node := range client.Nodes.GetHealthyNodes() {
+		valid, err := node.prewarm()
+		if err != nil {
+			client.levelLog.Warn("IdleCheck: Node %s has %d valid connections, discarded failed connections: %s", nodeAddr, valid, err)
+		}
+	}
+}
+
+// prewarm opens the idle connections of this node's NodeClient, if it supports it, see PrewarmConnections
+func (node *Node) prewarm() (int, error) {
+	prewarmer, ok := node.getClient().(NodePrewarmer)
+	if !ok {
+		return 0, nil
+	}
+	return prewarmer.Prewarm()
+}
@@ -0,0 +1,47 @@
+package memcacheha
+
+import (
+	"time"
+)
+
+// readShare returns the fraction of the reads it would otherwise be sent that this node receives at now: rising
+// linearly from 0 to 1 over period after it was added, see NodeRampPeriod
+func (node *Node) readShare(now time.Time, period time.Duration) float64 {
+	if node.rampStart.IsZero() || period <= 0 {
+		return 1
+	}
+	elapsed := now.Sub(node.rampStart)
+	if elapsed >= period {
+		return 1
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(elapsed) / float64(period)
+}
+
+// rampNodes returns nodes without each node still ramping up that loses a random draw against its readShare, unless
+// that leaves none
+func rampNodes(nodes map[string]*Node, now time.Time, period time.Duration) map[string]*Node {
+	if period <= 0 {
+		return nodes
+	}
+	var ramped map[string]*Node
+	for endpoint, node := range nodes {
+		share := node.readShare(now, period)
+		if share >= 1 || randFloat64() < share {
+			continue
+		}
+		if ramped == nil {
+			ramped = make(map[string]*Node, len(nodes))
+			for e, n := range nodes {
+				ramped[e] = n
+			}
+		}
+		delete(ramped, endpoint)
+	}
+	if len(ramped) == 0 {
+		return nodes
+	}
+	return ramped
+}
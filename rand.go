@@ -0,0 +1,26 @@
+package memcacheha
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	randMutex  sync.Mutex
+	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randFloat64 returns a pseudo-random number in [0.0,1.0) from a source seeded at startup
+func randFloat64() float64 {
+	randMutex.Lock()
+	defer randMutex.Unlock()
+	return randSource.Float64()
+}
+
+// randShuffle shuffles n elements with swap, using the source seeded at startup
+func randShuffle(n int, swap func(i, j int)) {
+	randMutex.Lock()
+	defer randMutex.Unlock()
+	randSource.Shuffle(n, swap)
+}
@@ -0,0 +1,120 @@
+// Package ratelimit provides fixed-window and sliding-window rate limiters whose counters are kept in a memcacheha
+// cluster, shared by every process using the same cluster.
+package ratelimit
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RATELIMIT_KEY_PREFIX is the default prefix of the memcache keys of counters
+var RATELIMIT_KEY_PREFIX = "ratelimit_"
+
+var (
+	// ErrInvalidWindow is an error meaning Allow was called with a window that is not positive
+	ErrInvalidWindow = errors.New("ratelimit: window must be positive")
+
+	// ErrInvalidLimit is an error meaning Allow was called with a negative limit
+	ErrInvalidLimit = errors.New("ratelimit: limit must not be negative")
+)
+
+// Algorithm is a rate limiting algorithm
+type Algorithm int
+
+const (
+	// FIXED_WINDOW counts requests in consecutive windows, allowing up to limit requests in each. Bursts of up to
+	// twice the limit are possible across a window boundary.
+	FIXED_WINDOW Algorithm = iota
+	// SLIDING_WINDOW weights the count of the previous window by the part of it still within the sliding window,
+	// smoothing bursts across window boundaries.
+	SLIDING_WINDOW
+)
+
+// Limiter is a rate limiter backed by the cluster
+type Limiter struct {
+	Client    *memcacheha.Client
+	Algorithm Algorithm
+	KeyPrefix string
+}
+
+// New returns a new Limiter using the given client and algorithm
+func New(client *memcacheha.Client, algorithm Algorithm) *Limiter {
+	return &Limiter{
+		Client:    client,
+		Algorithm: algorithm,
+		KeyPrefix: RATELIMIT_KEY_PREFIX,
+	}
+}
+
+// Allow counts a request for key and returns true if no more than limit requests have been made in the window, as
+// measured by the Clock of the client. On error, false is returned with the error, and the caller may choose to fail
+// open. ErrInvalidWindow or ErrInvalidLimit is returned, without counting the request, if window is not positive or
+// limit is negative.
+func (limiter *Limiter) Allow(key string, limit int, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, ErrInvalidWindow
+	}
+	if limit < 0 {
+		return false, ErrInvalidLimit
+	}
+	now := limiter.Client.Clock.Now()
+	index := now.UnixNano() / int64(window)
+
+	count, err := limiter.increment(key, index, window)
+	if err != nil {
+		return false, err
+	}
+	if limiter.Algorithm == FIXED_WINDOW || count > uint64(limit) {
+		return count <= uint64(limit), nil
+	}
+
+	previous, err := limiter.count(key, index-1)
+	if err != nil {
+		return false, err
+	}
+	elapsed := float64(now.UnixNano()-index*int64(window)) / float64(window)
+	weighted := float64(previous)*(1-elapsed) + float64(count)
+	return math.Floor(weighted) <= float64(limit), nil
+}
+
+func (limiter *Limiter) counterKey(key string, index int64) string {
+	return limiter.KeyPrefix + key + "_" + strconv.FormatInt(index, 10)
+}
+
+// increment increments the counter of the window with the given index, creating it if needed. Counters expire
+// after the following window, which the sliding window algorithm reads. Replicas that missed increments are
+// repaired to the highest count by Client.Increment.
+func (limiter *Limiter) increment(key string, index int64, window time.Duration) (uint64, error) {
+	counterKey := limiter.counterKey(key, index)
+	for attempt := 0; ; attempt++ {
+		count, err := limiter.Client.Increment(counterKey, 1)
+		if err != memcache.ErrCacheMiss || attempt > 0 {
+			return count, err
+		}
+
+		// Create the counter, expiring at the end of the following window
+		expiration := time.Unix(0, (index+2)*int64(window))
+		err = limiter.Client.Add(&memcacheha.Item{Key: counterKey, Value: []byte("0"), Expiration: &expiration},
+			memcacheha.WithAddConflictPolicy(memcacheha.ADD_CONFLICT_PROPAGATE))
+		if err != nil && err != memcache.ErrNotStored {
+			return 0, err
+		}
+	}
+}
+
+// count returns the counter of the window with the given index, or 0 if it does not exist
+func (limiter *Limiter) count(key string, index int64) (uint64, error) {
+	item, err := limiter.Client.Get(limiter.counterKey(key, index))
+	if err == memcache.ErrCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(item.Value), 10, 64)
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		allowed bool
+	}
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		steps     []step
+	}{
+		{"fixed", FIXED_WINDOW, []step{{0, true}, {0, true}, {0, false}, {90 * time.Second, true}, {0, true}, {0, false}}},
+		{"sliding", SLIDING_WINDOW, []step{{0, true}, {0, true}, {0, false}, {90 * time.Second, true}, {0, false}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := memcachehatest.NewClock(time.Unix(1699999980, 0))
+			cluster := memcachehatest.NewCluster(3)
+			cluster.SetClock(clk)
+			limiter := New(cluster.NewClient(t), test.algorithm)
+
+			for i, step := range test.steps {
+				clk.Advance(step.advance)
+				allowed, err := limiter.Allow("key", 2, time.Minute)
+				if err != nil {
+					t.Fatalf("Allow %d failed: %s", i, err)
+				}
+				if allowed != step.allowed {
+					t.Fatalf("Allow %d returned %t, expected %t", i, allowed, step.allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowInvalidArguments(t *testing.T) {
+	tests := []struct {
+		limit  int
+		window time.Duration
+		err    error
+	}{
+		{1, 0, ErrInvalidWindow},
+		{1, -time.Second, ErrInvalidWindow},
+		{-1, time.Second, ErrInvalidLimit},
+	}
+	limiter := New(memcachehatest.NewCluster(1).NewClient(t), FIXED_WINDOW)
+	for _, test := range tests {
+		if _, err := limiter.Allow("key", test.limit, test.window); err != test.err {
+			t.Fatalf("Allow(%d, %s) returned %v, expected %v", test.limit, test.window, err, test.err)
+		}
+	}
+}
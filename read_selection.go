@@ -0,0 +1,82 @@
+package memcacheha
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ReadSelection decides which nodes a read is sent to when it reads fewer than all healthy nodes
+type ReadSelection int
+
+const (
+	// READ_SELECT_KEY reads from the nodes with the highest rendezvous hash of the key and node endpoint, so
+	// repeated reads of a key go to the same nodes while they are healthy
+	READ_SELECT_KEY ReadSelection = iota
+	// READ_SELECT_RANDOM reads from a random sample of nodes
+	READ_SELECT_RANDOM
+	// READ_SELECT_LATENCY reads from the nodes with the lowest p99 latency, see Node.P99Latency. Nodes with no
+	// recorded latency are ranked first.
+	READ_SELECT_LATENCY
+)
+
+// ErrUnknownReadSelection is an error meaning a ReadSelection name is not key, random or latency
+var ErrUnknownReadSelection = errors.New("memcacheha: unknown read selection")
+
+var readSelectionNames = map[ReadSelection]string{
+	READ_SELECT_KEY:     "key",
+	READ_SELECT_RANDOM:  "random",
+	READ_SELECT_LATENCY: "latency",
+}
+
+// UnmarshalText parses a selection name: key, random or latency
+func (selection *ReadSelection) UnmarshalText(text []byte) error {
+	for s, name := range readSelectionNames {
+		if name == string(text) {
+			*selection = s
+			return nil
+		}
+	}
+	return ErrUnknownReadSelection
+}
+
+// MarshalText returns the selection name
+func (selection ReadSelection) MarshalText() ([]byte, error) {
+	name, found := readSelectionNames[selection]
+	if !found {
+		return nil, ErrUnknownReadSelection
+	}
+	return []byte(name), nil
+}
+
+// selectReadNodes returns n of nodes chosen by selection for key, or all of them if n is not less than their count.
+// nodes is not modified.
+func selectReadNodes(key string, nodes map[string]*Node, n int, selection ReadSelection) []*Node {
+	selected := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		selected = append(selected, node)
+	}
+	if n <= 0 || n >= len(selected) {
+		return selected
+	}
+	// Sort first, so the selection does not depend on map order
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Endpoint < selected[j].Endpoint })
+
+	switch selection {
+	case READ_SELECT_LATENCY:
+		latencies := make(map[*Node]time.Duration, len(selected))
+		for _, node := range selected {
+			latencies[node] = node.P99Latency()
+		}
+		sort.SliceStable(selected, func(i, j int) bool { return latencies[selected[i]] < latencies[selected[j]] })
+	default:
+		weights := make(map[*Node]uint64, len(selected))
+		for _, node := range selected {
+			weights[node] = shardWeight(node.Endpoint, key)
+		}
+		sort.SliceStable(selected, func(i, j int) bool { return weights[selected[i]] > weights[selected[j]] })
+	case READ_SELECT_RANDOM:
+		randShuffle(len(selected), func(i, j int) { selected[i], selected[j] = selected[j], selected[i] })
+	}
+	return selected[:n]
+}
@@ -0,0 +1,107 @@
+package memcacheha
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// READ_YOUR_WRITES_MAX_KEYS is the maximum number of recently written keys remembered for ReadYourWrites. Writes
+// beyond it, once expired keys are removed, are not remembered.
+var READ_YOUR_WRITES_MAX_KEYS = 10000
+
+// recentWrite is the value last written to a key by this client
+type recentWrite struct {
+	hash    uint64
+	expires time.Time
+}
+
+// recentWrites remembers the values this client wrote to keys within the ReadYourWrites window
+type recentWrites struct {
+	mutex  sync.Mutex
+	writes map[string]recentWrite
+}
+
+func newRecentWrites() *recentWrites {
+	return &recentWrites{writes: map[string]recentWrite{}}
+}
+
+// record remembers item as the value written to its key at now until window has passed
+func (recent *recentWrites) record(item *Item, window time.Duration, now time.Time) {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+	if _, found := recent.writes[item.Key]; !found && len(recent.writes) >= READ_YOUR_WRITES_MAX_KEYS {
+		for key, write := range recent.writes {
+			if !write.expires.After(now) {
+				delete(recent.writes, key)
+			}
+		}
+		if len(recent.writes) >= READ_YOUR_WRITES_MAX_KEYS {
+			return
+		}
+	}
+	recent.writes[item.Key] = recentWrite{hash: valueHash(item), expires: now.Add(window)}
+}
+
+// forget removes key, whose value was changed or removed other than by writing an item
+func (recent *recentWrites) forget(key string) {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+	delete(recent.writes, key)
+}
+
+// lookup returns the hash of the value written to key within the window at now, and false if there was none
+func (recent *recentWrites) lookup(key string, now time.Time) (uint64, bool) {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+	write, found := recent.writes[key]
+	if !found {
+		return 0, false
+	}
+	if !write.expires.After(now) {
+		delete(recent.writes, key)
+		return 0, false
+	}
+	return write.hash, true
+}
+
+// valueHash returns a hash of the flags and value of item
+func valueHash(item *Item) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte{byte(item.Flags >> 24), byte(item.Flags >> 16), byte(item.Flags >> 8), byte(item.Flags)})
+	hash.Write(item.Value)
+	return hash.Sum64()
+}
+
+// observeWrite remembers or forgets the key of a completed operation for ReadYourWrites
+func (client *Client) observeWrite(op string, key string, item *Item, err error, window time.Duration) {
+	switch op {
+	case OP_SET, OP_ADD:
+		if item != nil && (err == nil || errors.Is(err, ErrPartialWrite)) {
+			client.recent.record(item, window, client.Clock.Now())
+		}
+	case OP_DELETE, OP_INCREMENT, OP_DECREMENT:
+		client.recent.forget(key)
+	}
+}
+
+// recentItem returns the item of items with the value this client last wrote to key within the ReadYourWrites
+// window, and the number of items with that value. If there is no such item, the agreed item is returned.
+func (client *Client) recentItem(key string, items []*Item) (*Item, int) {
+	hash, found := client.recent.lookup(key, client.Clock.Now())
+	if found {
+		var match *Item
+		count := 0
+		for _, item := range items {
+			if valueHash(item) == hash {
+				match = item
+				count++
+			}
+		}
+		if match != nil {
+			return match, count
+		}
+	}
+	return agreedItem(items)
+}
@@ -0,0 +1,341 @@
+package memcacheha
+
+import (
+	"log/slog"
+	"time"
+)
+
+// The methods in this file change the configuration of a running Client. Client fields may only be set directly
+// before Start is called.
+
+// UpdateConfig replaces the sources and options of this client with those in cfg. Existing nodes are reconnected
+// with the new timeout, TLS and connection settings, and nodes are added or removed on the next discovery.
+func (client *Client) UpdateConfig(cfg *Config) error {
+	sources := cfg.Sources(client.Log)
+	if len(sources) == 0 {
+		return ErrConfigNoSources
+	}
+	if err := cfg.Apply(client); err != nil {
+		return err
+	}
+	client.SetSources(sources...)
+	client.reconnectNodes()
+	client.levelLog.Info("UpdateConfig: Configuration updated")
+	return nil
+}
+
+// SetTimeout changes the node operation timeout, reconnecting existing nodes
+func (client *Client) SetTimeout(timeout time.Duration) {
+	client.configMutex.Lock()
+	client.Timeout = timeout
+	client.configMutex.Unlock()
+	client.reconnectNodes()
+	client.levelLog.Info("SetTimeout: Timeout set to %s", timeout)
+}
+
+// SetSources replaces the NodeSources of this client. Nodes are added or removed on the next discovery.
+func (client *Client) SetSources(sources ...NodeSource) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.Sources = sources
+	client.wakeRunloop()
+}
+
+// AddSource adds a NodeSource to this client. Its nodes are added on the next discovery.
+func (client *Client) AddSource(source NodeSource) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.Sources = append(append([]NodeSource{}, client.Sources...), source)
+	client.wakeRunloop()
+}
+
+// SetSourceMerge changes how the endpoints of multiple sources are combined, from the next discovery
+func (client *Client) SetSourceMerge(policy SourceMergePolicy) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SourceMerge = policy
+}
+
+// SetDefaultReadOptions replaces the options applied to every read operation
+func (client *Client) SetDefaultReadOptions(options ReadOptions) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.DefaultReadOptions = options
+}
+
+// SetDefaultWriteOptions replaces the options applied to every write operation
+func (client *Client) SetDefaultWriteOptions(options WriteOptions) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.DefaultWriteOptions = options
+}
+
+// SetKeyPolicies replaces the options applied to operations on matching keys, see KeyPolicies
+func (client *Client) SetKeyPolicies(policies ...KeyPolicy) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.KeyPolicies = policies
+}
+
+// SetPinnedKeys replaces the patterns of keys stored on a single node, see PinnedKeys
+func (client *Client) SetPinnedKeys(patterns ...string) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.PinnedKeys = patterns
+}
+
+// SetLogLevel changes the minimum level of messages written to Log
+func (client *Client) SetLogLevel(level LogLevel) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.LogLevel = level
+}
+
+// SetKeyLogMode changes how keys appear in logs, see KeyLogMode
+func (client *Client) SetKeyLogMode(mode KeyLogMode) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.KeyLogMode = mode
+}
+
+// SetLogHandler changes the handler receiving structured records, see LogHandler. A nil handler stops them.
+func (client *Client) SetLogHandler(handler slog.Handler) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.LogHandler = handler
+}
+
+// SetClientID changes the identifier of this client in statistics, see ClientID
+func (client *Client) SetClientID(clientID string) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.ClientID = clientID
+}
+
+// SetAdminToken changes the bearer token required by AdminHandler, an empty token disables the admin API
+func (client *Client) SetAdminToken(token string) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.AdminToken = token
+}
+
+// SetCoalesceGets enables or disables sharing the result of concurrent Gets of the same key, see CoalesceGets
+func (client *Client) SetCoalesceGets(enabled bool) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.CoalesceGets = enabled
+}
+
+// SetMaxValueSize changes the maximum size in bytes of a stored value, zero for no limit
+func (client *Client) SetMaxValueSize(size int) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.MaxValueSize = size
+}
+
+// SetReadYourWrites changes the period after writing a key during which Gets of it read all nodes and return the
+// value written, zero to disable
+func (client *Client) SetReadYourWrites(window time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.ReadYourWrites = window
+}
+
+// SetEncryption changes the Encryption of item values, nil to stop encrypting. Values encrypted with a key the new
+// Encryption does not have are read as ErrDecryptionFailed.
+func (client *Client) SetEncryption(encryption *Encryption) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.Encryption = encryption
+}
+
+// SetIntegrity changes the Integrity of item values, nil to stop signing. Values signed with a key the new Integrity
+// does not have are read as missing.
+func (client *Client) SetIntegrity(integrity *Integrity) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.Integrity = integrity
+}
+
+// SetAuditHook changes the hook receiving AuditRecords, nil to stop auditing
+func (client *Client) SetAuditHook(hook func(AuditRecord)) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.AuditHook = hook
+}
+
+// SetErrorMapper changes the function categorising node errors, nil to use ErrorPatterns and the built-in handling
+func (client *Client) SetErrorMapper(mapper func(err error) ErrorCategory) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.ErrorMapper = mapper
+}
+
+// SetErrorPatterns changes the substrings of node error messages mapped to categories, see ErrorPatterns
+func (client *Client) SetErrorPatterns(patterns map[string]ErrorCategory) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.ErrorPatterns = patterns
+}
+
+// SetTenantQuotas changes the quotas of tenants, and of tenants without one. Tokens already in the buckets of a tenant
+// are kept, refilling at the new rates.
+func (client *Client) SetTenantQuotas(quotas map[string]TenantQuota, defaultQuota TenantQuota) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.TenantQuotas = quotas
+	client.DefaultTenantQuota = defaultQuota
+}
+
+// SetSizePrefixes changes the key prefixes whose value sizes are accounted separately in Metrics. Prefixes already
+// accounted are kept until ResetMetrics.
+func (client *Client) SetSizePrefixes(prefixes ...string) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SizePrefixes = prefixes
+}
+
+// SetTTLSampleRate changes the fraction (0 to 1) of successful writes whose TTL is recorded in TTLStats
+func (client *Client) SetTTLSampleRate(rate float64) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.TTLSampleRate = rate
+}
+
+// SetFillLeases enables or disables fill leases in GetOrFill, see FillLeases
+func (client *Client) SetFillLeases(enabled bool) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.FillLeases = enabled
+}
+
+// SetDegradedLatency changes the p99 node latency above which a node is not read from, zero to disable. Nodes are
+// updated at the next healthcheck.
+func (client *Client) SetDegradedLatency(latency time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.DegradedLatency = latency
+}
+
+// SetLatencySLOs changes the latency objectives of node healthchecks, of requests to each node and of requests to the
+// cluster, zero to disable. They are checked at the next healthcheck.
+func (client *Client) SetLatencySLOs(healthCheck time.Duration, node time.Duration, cluster time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SLOHealthCheckLatency = healthCheck
+	client.SLONodeLatency = node
+	client.SLOClusterLatency = cluster
+}
+
+// SetSLOViolationHook changes the hook receiving NodeSLOReports, nil to stop reporting
+func (client *Client) SetSLOViolationHook(hook func(NodeSLOReport)) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SLOViolationHook = hook
+}
+
+// SetNodeRampPeriod changes the period over which new nodes ramp up to their full share of reads, zero to disable.
+// Nodes already ramping follow the new period.
+func (client *Client) SetNodeRampPeriod(period time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.NodeRampPeriod = period
+}
+
+// SetRepairLimits changes the limits of writes synchronising nodes with missing data, zero for no limit
+func (client *Client) SetRepairLimits(perSecond float64, maxConcurrent int) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.MaxRepairsPerSecond = perSecond
+	client.MaxConcurrentRepairs = maxConcurrent
+}
+
+// SetSuppressRepairsOnPartition enables or disables synchronisation of nodes while a majority of nodes are
+// unreachable, see SuppressRepairsOnPartition
+func (client *Client) SetSuppressRepairsOnPartition(suppress bool) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SuppressRepairsOnPartition = suppress
+}
+
+// SetSingleNodeErrorRate changes the fraction of failing node requests above which all requests are sent to one node,
+// zero to disable. Single-node mode is left at the next healthcheck if disabled.
+func (client *Client) SetSingleNodeErrorRate(rate float64) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.SingleNodeErrorRate = rate
+}
+
+// SetMaxConcurrentRequests changes the limit of in-flight node requests, zero for no limit
+func (client *Client) SetMaxConcurrentRequests(limit int) {
+	client.configMutex.Lock()
+	client.MaxConcurrentRequests = limit
+	client.configMutex.Unlock()
+
+	// Wake requests waiting on the previous limit
+	client.limiter.wake()
+}
+
+// SetPeriods changes the periods between discovery and between healthchecks
+func (client *Client) SetPeriods(getNodesPeriod time.Duration, healthCheckPeriod time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.GetNodesPeriod = getNodesPeriod
+	client.HealthCheckPeriod = healthCheckPeriod
+	client.wakeRunloop()
+}
+
+// SetPrewarmConnections changes whether the idle connections of nodes added from now on are opened before their
+// first request
+func (client *Client) SetPrewarmConnections(prewarm bool) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.PrewarmConnections = prewarm
+}
+
+// SetIdleCheckPeriod changes the period between validating the idle connections of healthy nodes, zero to disable
+func (client *Client) SetIdleCheckPeriod(period time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.IdleCheckPeriod = period
+	client.wakeRunloop()
+}
+
+// SetMaintenanceJitter changes the fraction by which the periods of discovery, healthchecks and the other periodic
+// tasks are randomly varied
+func (client *Client) SetMaintenanceJitter(jitter float64) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.MaintenanceJitter = jitter
+	client.wakeRunloop()
+}
+
+// SetHealthCheckProbe changes the request sent by healthchecks, and the prefix of the keys read by
+// HEALTHCHECK_PROBE_GET
+func (client *Client) SetHealthCheckProbe(probe HealthCheckProbe, keyPrefix string) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.HealthCheckProbe = probe
+	client.HealthCheckKeyPrefix = keyPrefix
+}
+
+// SetResolvePeriod changes the period between re-resolving node hostnames, zero to disable
+func (client *Client) SetResolvePeriod(period time.Duration) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+	client.ResolvePeriod = period
+	client.wakeRunloop()
+}
+
+// reconnectNodes replaces the NodeClient of every node with a new one from NewNodeClient, or NewTLSNodeClient for
+// nodes requiring TLS
+func (client *Client) reconnectNodes() {
+	for endpoint, node := range client.Nodes.GetNodes() {
+		nodeClient, err := client.newNodeClientFor(endpoint, node.Spec)
+		if err != nil {
+			client.levelLog.Error("Reconnect: Node %s not reconnected: %s", endpoint, err)
+			continue
+		}
+		node.SetClient(nodeClient)
+	}
+}
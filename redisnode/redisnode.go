@@ -0,0 +1,491 @@
+// Package redisnode provides memcacheha NodeClients speaking the Redis protocol (RESP), so that a cluster moving from
+// memcached to Redis can be served by one memcacheha client while it holds nodes of both kinds.
+//
+// Items are stored as Redis strings holding the item's flags, as 4 big-endian bytes, followed by its value. The CasID
+// of an item is a hash of the stored string, and CompareAndSwap is performed with WATCH and MULTI, so a swap succeeds
+// if the item holds the same flags and value as when it was read. Expirations given as Unix times need Redis 6.2 or
+// later.
+package redisnode
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/clock"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// REDISNODE_MAX_IDLE_CONNS is the default number of idle connections kept per node, see Options
+	REDISNODE_MAX_IDLE_CONNS = 2
+
+	// ErrMalformedValue is returned when reading a key whose value is too short to hold the flags of an item, e.g.
+	// one not written by a NodeClient
+	ErrMalformedValue = errors.New("redisnode: value has no flags")
+)
+
+// maxRelativeExpiration is the longest memcache expiration in seconds from now; longer ones are Unix times
+const maxRelativeExpiration = 60 * 60 * 24 * 30
+
+// Options configure the connections of a NodeClient
+type Options struct {
+	// Username and Password authenticate connections with AUTH, if Password is set. Username is only needed for
+	// Redis 6 ACL users.
+	Username string
+	Password string
+	// DB is the database selected by connections
+	DB int
+	// TLS connects over TLS with the given config, if not nil
+	TLS *tls.Config
+	// MaxIdleConns is the number of idle connections kept. Zero uses REDISNODE_MAX_IDLE_CONNS.
+	MaxIdleConns int
+	// Clock is the time expirations given as Unix times are compared to. Nil uses clock.Real.
+	Clock clock.Clock
+}
+
+// NodeClient is a memcacheha.NodeClient for a Redis server
+type NodeClient struct {
+	endpoint string
+	timeout  time.Duration
+	options  Options
+
+	mutex sync.Mutex
+	idle  []*conn
+}
+
+var (
+	_ memcacheha.NodeClient      = (*NodeClient)(nil)
+	_ memcacheha.NodePinger      = (*NodeClient)(nil)
+	_ memcacheha.NodeMultiGetter = (*NodeClient)(nil)
+	_ memcacheha.NodeStatter     = (*NodeClient)(nil)
+	_ memcacheha.NodeFlusher     = (*NodeClient)(nil)
+)
+
+// NewNodeClient returns a new NodeClient for the Redis server at endpoint, failing requests after timeout
+func NewNodeClient(endpoint string, timeout time.Duration, options Options) *NodeClient {
+	if options.Clock == nil {
+		options.Clock = clock.Real
+	}
+	return &NodeClient{
+		endpoint: endpoint,
+		timeout:  timeout,
+		options:  options,
+	}
+}
+
+// NewNodeClientFactory returns a memcacheha.NodeClientFactory for NodeClients connecting with the given options
+func NewNodeClientFactory(options Options) memcacheha.NodeClientFactory {
+	return func(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+		return NewNodeClient(endpoint, timeout, options)
+	}
+}
+
+// NewMixedNodeClientFactory returns a memcacheha.NodeClientFactory for clusters of Redis and memcached nodes, using
+// redis for endpoints isRedis returns true for and memcached for the others. A nil memcached uses
+// memcacheha.NewMemcacheNodeClient.
+func NewMixedNodeClientFactory(isRedis func(endpoint string) bool, redis memcacheha.NodeClientFactory, memcached memcacheha.NodeClientFactory) memcacheha.NodeClientFactory {
+	if memcached == nil {
+		memcached = memcacheha.NewMemcacheNodeClient
+	}
+	return func(endpoint string, timeout time.Duration) memcacheha.NodeClient {
+		if isRedis(endpoint) {
+			return redis(endpoint, timeout)
+		}
+		return memcached(endpoint, timeout)
+	}
+}
+
+// Get reads the item with the given key, returning memcache.ErrCacheMiss if it is not found
+func (client *NodeClient) Get(key string) (item *memcache.Item, err error) {
+	err = client.withConn(func(c *conn) error {
+		reply, err := c.do("GET", key)
+		if err != nil {
+			return err
+		}
+		item, err = decodeReply(key, reply)
+		return err
+	})
+	return item, err
+}
+
+// GetMulti reads the items with the given keys. Keys that are not found are not in the result.
+func (client *NodeClient) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	items := map[string]*memcache.Item{}
+	if len(keys) == 0 {
+		return items, nil
+	}
+	err := client.withConn(func(c *conn) error {
+		args := []interface{}{"MGET"}
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		reply, err := c.do(args...)
+		if err != nil {
+			return err
+		}
+		replies, ok := reply.([]interface{})
+		if !ok || len(replies) != len(keys) {
+			return errProtocol
+		}
+		for i, reply := range replies {
+			item, err := decodeReply(keys[i], reply)
+			if err == memcache.ErrCacheMiss {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			items[keys[i]] = item
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Set writes the item unconditionally
+func (client *NodeClient) Set(item *memcache.Item) error {
+	return client.withConn(func(c *conn) error {
+		args, expired := setArgs(item, client.options.Clock.Now())
+		if expired {
+			_, err := c.do("DEL", item.Key)
+			return err
+		}
+		_, err := c.do(args...)
+		return err
+	})
+}
+
+// Add writes the item if its key is not already present, returning memcache.ErrNotStored otherwise
+func (client *NodeClient) Add(item *memcache.Item) error {
+	return client.withConn(func(c *conn) error {
+		args, expired := setArgs(item, client.options.Clock.Now())
+		if expired {
+			exists, err := c.do("EXISTS", item.Key)
+			if err == nil && exists != int64(0) {
+				err = memcache.ErrNotStored
+			}
+			return err
+		}
+		reply, err := c.do(append(args, "NX")...)
+		if err == nil && reply == nil {
+			err = memcache.ErrNotStored
+		}
+		return err
+	})
+}
+
+// CompareAndSwap writes the item if the item under its key is unchanged since it was read with the item's CasID,
+// returning memcache.ErrCASConflict if it changed, or memcache.ErrCacheMiss if it is no longer present
+func (client *NodeClient) CompareAndSwap(item *memcache.Item) error {
+	return client.withConn(func(c *conn) error {
+		if _, err := c.do("WATCH", item.Key); err != nil {
+			return err
+		}
+		reply, err := c.do("GET", item.Key)
+		if err == nil {
+			var current *memcache.Item
+			current, err = decodeReply(item.Key, reply)
+			if err == nil && current.CasID != item.CasID {
+				err = memcache.ErrCASConflict
+			}
+		}
+		if err != nil {
+			if _, unwatchErr := c.do("UNWATCH"); unwatchErr != nil {
+				return unwatchErr
+			}
+			return err
+		}
+
+		args, expired := setArgs(item, client.options.Clock.Now())
+		if expired {
+			args = []interface{}{"DEL", item.Key}
+		}
+		replies, err := c.multi(args)
+		if err == nil && replies == nil {
+			err = memcache.ErrCASConflict
+		}
+		return err
+	})
+}
+
+// Delete removes the item with the given key, returning memcache.ErrCacheMiss if it is not found
+func (client *NodeClient) Delete(key string) error {
+	return client.withConn(func(c *conn) error {
+		reply, err := c.do("DEL", key)
+		if err == nil && reply == int64(0) {
+			err = memcache.ErrCacheMiss
+		}
+		return err
+	})
+}
+
+// Touch changes the expiry of the item with the given key, with the same meaning of seconds as memcache, returning
+// memcache.ErrCacheMiss if it is not found
+func (client *NodeClient) Touch(key string, seconds int32) error {
+	return client.withConn(func(c *conn) error {
+		ttl, absolute, expired := redisTTL(seconds, client.options.Clock.Now())
+		var reply interface{}
+		var err error
+		switch {
+		case expired:
+			reply, err = c.do("DEL", key)
+		case ttl == 0:
+			// PERSIST returns 0 for keys without an expiry as well as missing keys
+			var replies []interface{}
+			replies, err = c.multi([]interface{}{"EXISTS", key}, []interface{}{"PERSIST", key})
+			if err == nil && len(replies) > 0 {
+				reply = replies[0]
+			}
+		case absolute:
+			reply, err = c.do("EXPIREAT", key, ttl)
+		default:
+			reply, err = c.do("EXPIRE", key, ttl)
+		}
+		if err == nil && reply == int64(0) {
+			err = memcache.ErrCacheMiss
+		}
+		return err
+	})
+}
+
+// Ping checks the server responds to PING
+func (client *NodeClient) Ping() error {
+	return client.withConn(func(c *conn) error {
+		_, err := c.do("PING")
+		return err
+	})
+}
+
+// FlushAll removes all keys of the selected database, see Options.DB
+func (client *NodeClient) FlushAll() error {
+	return client.withConn(func(c *conn) error {
+		_, err := c.do("FLUSHDB")
+		return err
+	})
+}
+
+// Stats returns the fields of INFO, with "version", "uptime", "curr_items", "bytes" and "limit_maxbytes" set as for
+// memcached from the server, keyspace and memory sections. Items are the keys of the selected database, and bytes the
+// memory used by the whole server.
+func (client *NodeClient) Stats() (map[string]string, error) {
+	stats := map[string]string{}
+	err := client.withConn(func(c *conn) error {
+		reply, err := c.do("INFO")
+		if err != nil {
+			return err
+		}
+		info, ok := reply.([]byte)
+		if !ok {
+			return errProtocol
+		}
+		for _, line := range bytes.Split(info, []byte("\r\n")) {
+			if fields := bytes.SplitN(line, []byte(":"), 2); len(fields) == 2 {
+				stats[string(fields[0])] = string(fields[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats["version"] = stats["redis_version"]
+	stats["uptime"] = stats["uptime_in_seconds"]
+	stats["bytes"] = stats["used_memory"]
+	stats["limit_maxbytes"] = stats["maxmemory"]
+	// The keyspace section has a line for each non-empty database, e.g. db0:keys=1,expires=0,avg_ttl=0
+	stats["curr_items"] = "0"
+	for _, field := range strings.Split(stats["db"+strconv.Itoa(client.options.DB)], ",") {
+		if strings.HasPrefix(field, "keys=") {
+			stats["curr_items"] = strings.TrimPrefix(field, "keys=")
+		}
+	}
+	return stats, nil
+}
+
+// withConn calls fn with an idle or new connection, which is kept for reuse unless fn fails with a connection error
+func (client *NodeClient) withConn(fn func(c *conn) error) error {
+	c, err := client.getConn()
+	if err != nil {
+		return err
+	}
+	c.nc.SetDeadline(time.Now().Add(client.timeout))
+	err = fn(c)
+	if reusable(err) {
+		client.putConn(c)
+	} else {
+		c.nc.Close()
+	}
+	return err
+}
+
+// reusable returns true if a connection is still usable after a request returned err
+func reusable(err error) bool {
+	switch err.(type) {
+	case nil, Error:
+		return true
+	}
+	return err == memcache.ErrCacheMiss || err == memcache.ErrNotStored || err == memcache.ErrCASConflict || err == ErrMalformedValue
+}
+
+// getConn returns an idle connection, or dials a new one
+func (client *NodeClient) getConn() (*conn, error) {
+	client.mutex.Lock()
+	if n := len(client.idle); n > 0 {
+		c := client.idle[n-1]
+		client.idle = client.idle[:n-1]
+		client.mutex.Unlock()
+		return c, nil
+	}
+	client.mutex.Unlock()
+	return client.dial()
+}
+
+// putConn keeps c for reuse, or closes it if MaxIdleConns are already kept
+func (client *NodeClient) putConn(c *conn) {
+	maxIdle := client.options.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = REDISNODE_MAX_IDLE_CONNS
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.idle) >= maxIdle {
+		c.nc.Close()
+		return
+	}
+	client.idle = append(client.idle, c)
+}
+
+// dial opens a connection, authenticating and selecting the database of the options
+func (client *NodeClient) dial() (*conn, error) {
+	dialer := &net.Dialer{Timeout: client.timeout}
+	var nc net.Conn
+	var err error
+	if client.options.TLS != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", client.endpoint, client.options.TLS)
+	} else {
+		nc, err = dialer.Dial("tcp", client.endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(nc)
+	nc.SetDeadline(time.Now().Add(client.timeout))
+	if client.options.Password != "" {
+		args := []interface{}{"AUTH", client.options.Password}
+		if client.options.Username != "" {
+			args = []interface{}{"AUTH", client.options.Username, client.options.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if client.options.DB != 0 {
+		if _, err := c.do("SELECT", client.options.DB); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// setArgs returns the SET command writing item, and true if the item's expiration has already passed at now
+func setArgs(item *memcache.Item, now time.Time) ([]interface{}, bool) {
+	value := make([]byte, 4+len(item.Value))
+	binary.BigEndian.PutUint32(value, item.Flags)
+	copy(value[4:], item.Value)
+
+	args := []interface{}{"SET", item.Key, value}
+	ttl, absolute, expired := redisTTL(item.Expiration, now)
+	switch {
+	case ttl == 0:
+	case absolute:
+		args = append(args, "EXAT", ttl)
+	default:
+		args = append(args, "EX", ttl)
+	}
+	return args, expired
+}
+
+// redisTTL converts a memcache expiration, in seconds from now or a Unix time, to a number of seconds if absolute is
+// false or a Unix time if it is true. ttl is zero for no expiry. expired is true if the expiration has passed.
+func redisTTL(expiration int32, now time.Time) (ttl int64, absolute bool, expired bool) {
+	switch {
+	case expiration == 0:
+		return 0, false, false
+	case expiration < 0:
+		return 0, false, true
+	case expiration <= maxRelativeExpiration:
+		return int64(expiration), false, false
+	case int64(expiration) <= now.Unix():
+		return 0, false, true
+	}
+	return int64(expiration), true, false
+}
+
+// decodeReply returns the item stored under key from the reply of GET, or memcache.ErrCacheMiss for a null reply
+func decodeReply(key string, reply interface{}) (*memcache.Item, error) {
+	if reply == nil {
+		return nil, memcache.ErrCacheMiss
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, errProtocol
+	}
+	if len(value) < 4 {
+		return nil, ErrMalformedValue
+	}
+	hash := fnv.New64a()
+	hash.Write(value)
+	return &memcache.Item{
+		Key:   key,
+		Value: value[4:],
+		Flags: binary.BigEndian.Uint32(value),
+		CasID: hash.Sum64(),
+	}, nil
+}
+
+// multi runs commands in a MULTI/EXEC transaction, returning the replies of EXEC, or nil if a watched key changed
+func (c *conn) multi(commands ...[]interface{}) ([]interface{}, error) {
+	c.write("MULTI")
+	for _, command := range commands {
+		if err := c.write(command...); err != nil {
+			return nil, err
+		}
+	}
+	c.write("EXEC")
+	if err := c.rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	// MULTI replies OK and each command QUEUED, or an error that aborts the transaction, reported by EXEC
+	for i := 0; i <= len(commands); i++ {
+		if _, err := c.read(); err != nil {
+			if _, ok := err.(Error); !ok {
+				return nil, err
+			}
+		}
+	}
+	reply, err := c.read()
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, errProtocol
+	}
+	return replies, nil
+}
@@ -0,0 +1,576 @@
+package redisnode
+
+import (
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closeConn is the raw reply closing the connection instead of replying
+const closeConn = "close"
+
+// fakeServer is a Redis server holding strings in memory, speaking enough RESP for a NodeClient
+type fakeServer struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	values   map[string][]byte
+	expiring map[string]bool
+	versions map[string]int
+	commands [][]string
+	conns    []net.Conn
+	// beforeExec is called before EXEC runs a transaction, e.g. to change a watched key
+	beforeExec func()
+	// raw replies to commands by name with a raw reply instead, or closes the connection for closeConn
+	raw map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{
+		listener: listener,
+		values:   map[string][]byte{},
+		expiring: map[string]bool{},
+		versions: map[string]int{},
+		raw:      map[string]string{},
+	}
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, nc)
+			server.mutex.Unlock()
+			go server.serve(nc)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		for _, nc := range server.conns {
+			nc.Close()
+		}
+	})
+	return server
+}
+
+// newClient returns a NodeClient for the server with the given options
+func (server *fakeServer) newClient(options Options) *NodeClient {
+	return NewNodeClient(server.listener.Addr().String(), time.Second, options)
+}
+
+// dials returns the number of connections accepted
+func (server *fakeServer) dials() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.conns)
+}
+
+// setBeforeExec sets the function called before EXEC runs a transaction
+func (server *fakeServer) setBeforeExec(fn func()) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.beforeExec = fn
+}
+
+// setRaw replies to the command with a raw reply, or as usual if raw is empty
+func (server *fakeServer) setRaw(name string, raw string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if raw == "" {
+		delete(server.raw, name)
+	} else {
+		server.raw[name] = raw
+	}
+}
+
+// take returns and clears the commands received
+func (server *fakeServer) take() [][]string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	commands := server.commands
+	server.commands = nil
+	return commands
+}
+
+func (server *fakeServer) serve(nc net.Conn) {
+	defer nc.Close()
+	c := newConn(nc)
+	watched := map[string]int{}
+	var queued [][]string
+	multi := false
+	for {
+		request, err := c.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		name := strings.ToUpper(args[0])
+
+		server.mutex.Lock()
+		server.commands = append(server.commands, args)
+		raw, override := server.raw[name]
+		server.mutex.Unlock()
+
+		var reply string
+		switch {
+		case override && raw == closeConn:
+			return
+		case override:
+			reply = raw
+		case name == "MULTI":
+			multi, queued = true, nil
+			reply = "+OK\r\n"
+		case name == "EXEC":
+			server.mutex.Lock()
+			beforeExec := server.beforeExec
+			server.mutex.Unlock()
+			if beforeExec != nil {
+				beforeExec()
+			}
+			server.mutex.Lock()
+			changed := false
+			for key, version := range watched {
+				changed = changed || server.versions[key] != version
+			}
+			if changed {
+				reply = "*-1\r\n"
+			} else {
+				reply = fmt.Sprintf("*%d\r\n", len(queued))
+				for _, args := range queued {
+					reply += server.execute(args)
+				}
+			}
+			server.mutex.Unlock()
+			multi, queued, watched = false, nil, map[string]int{}
+		case name == "WATCH":
+			server.mutex.Lock()
+			for _, key := range args[1:] {
+				watched[key] = server.versions[key]
+			}
+			server.mutex.Unlock()
+			reply = "+OK\r\n"
+		case name == "UNWATCH":
+			watched = map[string]int{}
+			reply = "+OK\r\n"
+		case multi:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		default:
+			server.mutex.Lock()
+			reply = server.execute(args)
+			server.mutex.Unlock()
+		}
+		if _, err := nc.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command, returning its reply. The caller holds the mutex.
+func (server *fakeServer) execute(args []string) string {
+	exists := func(key string) bool {
+		_, found := server.values[key]
+		return found
+	}
+	integer := func(b bool) string {
+		if b {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	bulk := func(key string) string {
+		if value, found := server.values[key]; found {
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		}
+		return "$-1\r\n"
+	}
+
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		return bulk(key)
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			reply += bulk(key)
+		}
+		return reply
+	case "SET":
+		options := strings.ToUpper(strings.Join(args[3:], " "))
+		if strings.Contains(options, "NX") && exists(key) {
+			return "$-1\r\n"
+		}
+		server.values[key] = []byte(args[2])
+		server.expiring[key] = strings.Contains(options, "EX")
+		server.versions[key]++
+		return "+OK\r\n"
+	case "DEL":
+		found := exists(key)
+		delete(server.values, key)
+		delete(server.expiring, key)
+		server.versions[key]++
+		return integer(found)
+	case "EXISTS":
+		return integer(exists(key))
+	case "EXPIRE", "EXPIREAT":
+		found := exists(key)
+		server.expiring[key] = found
+		return integer(found)
+	case "PERSIST":
+		// As Redis, 0 for a key without an expiry as well as a missing key
+		persisted := exists(key) && server.expiring[key]
+		server.expiring[key] = false
+		return integer(persisted)
+	case "FLUSHDB":
+		for key := range server.values {
+			server.versions[key]++
+		}
+		server.values = map[string][]byte{}
+		server.expiring = map[string]bool{}
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestRedisTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, test := range []struct {
+		expiration int32
+		ttl        int64
+		absolute   bool
+		expired    bool
+	}{
+		{0, 0, false, false},
+		{-1, 0, false, true},
+		{60, 60, false, false},
+		{maxRelativeExpiration, maxRelativeExpiration, false, false},
+		// Longer than 30 days is a Unix time, in 1970 here
+		{maxRelativeExpiration + 1, 0, false, true},
+		{1700000000, 0, false, true},
+		{1700000060, 1700000060, true, false},
+	} {
+		ttl, absolute, expired := redisTTL(test.expiration, now)
+		if ttl != test.ttl || absolute != test.absolute || expired != test.expired {
+			t.Fatalf("redisTTL(%d) returned %d, %t, %t, expected %d, %t, %t", test.expiration, ttl, absolute, expired, test.ttl, test.absolute, test.expired)
+		}
+	}
+}
+
+func TestDecodeReply(t *testing.T) {
+	if _, err := decodeReply("key", nil); err != memcache.ErrCacheMiss {
+		t.Fatalf("decodeReply of a null reply returned %v, expected ErrCacheMiss", err)
+	}
+	if _, err := decodeReply("key", "OK"); err != errProtocol {
+		t.Fatalf("decodeReply of a status reply returned %v, expected errProtocol", err)
+	}
+	if _, err := decodeReply("key", []byte{0, 0, 0}); err != ErrMalformedValue {
+		t.Fatalf("decodeReply of a value without flags returned %v, expected ErrMalformedValue", err)
+	}
+
+	item, err := decodeReply("key", []byte("\x00\x00\x01\x02value"))
+	if err != nil || item.Key != "key" || string(item.Value) != "value" || item.Flags != 0x102 {
+		t.Fatalf("decodeReply returned %+v, %v", item, err)
+	}
+	// The CasID changes with the flags as well as the value
+	same, _ := decodeReply("key", []byte("\x00\x00\x01\x02value"))
+	flags, _ := decodeReply("key", []byte("\x00\x00\x00\x02value"))
+	value, _ := decodeReply("key", []byte("\x00\x00\x01\x02other"))
+	if same.CasID != item.CasID || flags.CasID == item.CasID || value.CasID == item.CasID {
+		t.Fatalf("CasIDs are %d, %d, %d, %d, expected the first two only to be equal", item.CasID, same.CasID, flags.CasID, value.CasID)
+	}
+}
+
+func TestSetArgs(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	value := []byte("\x00\x00\x00\x07value")
+	for _, test := range []struct {
+		expiration int32
+		args       []interface{}
+		expired    bool
+	}{
+		{0, []interface{}{"SET", "key", value}, false},
+		{60, []interface{}{"SET", "key", value, "EX", int64(60)}, false},
+		{1700000060, []interface{}{"SET", "key", value, "EXAT", int64(1700000060)}, false},
+		{1699999999, []interface{}{"SET", "key", value}, true},
+	} {
+		args, expired := setArgs(&memcache.Item{Key: "key", Value: []byte("value"), Flags: 7, Expiration: test.expiration}, now)
+		if !reflect.DeepEqual(args, test.args) || expired != test.expired {
+			t.Fatalf("setArgs with expiration %d returned %q, %t, expected %q, %t", test.expiration, args, expired, test.args, test.expired)
+		}
+	}
+}
+
+func TestNodeClient(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.newClient(Options{})
+
+	if err := client.Set(&memcache.Item{Key: "key", Value: []byte("value"), Flags: 3}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if item, err := client.Get("key"); err != nil || string(item.Value) != "value" || item.Flags != 3 {
+		t.Fatalf("Get returned %+v, %v", item, err)
+	}
+	if _, err := client.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if err := client.Add(&memcache.Item{Key: "key", Value: []byte("other")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+	if err := client.Add(&memcache.Item{Key: "added", Value: []byte("added")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	items, err := client.GetMulti([]string{"key", "missing", "added"})
+	if err != nil || len(items) != 2 || string(items["key"].Value) != "value" || string(items["added"].Value) != "added" {
+		t.Fatalf("GetMulti returned %v, %v", items, err)
+	}
+
+	if err := client.Delete("key"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if err := client.Delete("key"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Delete of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %s", err)
+	}
+	if err := client.FlushAll(); err != nil {
+		t.Fatalf("FlushAll failed: %s", err)
+	}
+	if _, err := client.Get("added"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get after FlushAll returned %v, expected ErrCacheMiss", err)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.newClient(Options{})
+
+	if err := client.CompareAndSwap(&memcache.Item{Key: "key", Value: []byte("value")}); err != memcache.ErrCacheMiss {
+		t.Fatalf("CompareAndSwap of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if err := client.Set(&memcache.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	item, err := client.Get("key")
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	server.take()
+
+	// An item changed since it was read is not swapped, and the key is unwatched
+	stale := &memcache.Item{Key: "key", Value: []byte("stale"), CasID: item.CasID + 1}
+	if err := client.CompareAndSwap(stale); err != memcache.ErrCASConflict {
+		t.Fatalf("CompareAndSwap with another CasID returned %v, expected ErrCASConflict", err)
+	}
+	expected := [][]string{{"WATCH", "key"}, {"GET", "key"}, {"UNWATCH"}}
+	if commands := server.take(); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("CompareAndSwap sent %q, expected %q", commands, expected)
+	}
+
+	// Nor is one changed between the read and the transaction
+	server.setBeforeExec(func() {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.execute([]string{"SET", "key", "\x00\x00\x00\x00concurrent"})
+	})
+	if err := client.CompareAndSwap(&memcache.Item{Key: "key", Value: []byte("swapped"), CasID: item.CasID}); err != memcache.ErrCASConflict {
+		t.Fatalf("CompareAndSwap of a key changed before EXEC returned %v, expected ErrCASConflict", err)
+	}
+	if current, err := client.Get("key"); err != nil || string(current.Value) != "concurrent" {
+		t.Fatalf("Get returned %+v, %v, expected the concurrent write", current, err)
+	}
+	server.setBeforeExec(nil)
+
+	current, _ := client.Get("key")
+	server.take()
+	if err := client.CompareAndSwap(&memcache.Item{Key: "key", Value: []byte("swapped"), CasID: current.CasID}); err != nil {
+		t.Fatalf("CompareAndSwap failed: %s", err)
+	}
+	expected = [][]string{{"WATCH", "key"}, {"GET", "key"}, {"MULTI"}, {"SET", "key", "\x00\x00\x00\x00swapped"}, {"EXEC"}}
+	if commands := server.take(); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("CompareAndSwap sent %q, expected %q", commands, expected)
+	}
+	if swapped, err := client.Get("key"); err != nil || string(swapped.Value) != "swapped" {
+		t.Fatalf("Get returned %+v, %v", swapped, err)
+	}
+}
+
+func TestExpirations(t *testing.T) {
+	// A clock behind the system clock, so Unix times already past on the system clock are not on the client's
+	clk := memcachehatest.NewClock(time.Unix(1600000000, 0))
+	server := newFakeServer(t)
+	client := server.newClient(Options{Clock: clk})
+
+	in := func(seconds int) string { return strconv.FormatInt(clk.Now().Unix()+int64(seconds), 10) }
+	at := func(seconds int) int32 { return int32(clk.Now().Unix() + int64(seconds)) }
+	for _, test := range []struct {
+		expiration int32
+		expected   []string
+	}{
+		{0, []string{"SET", "key", "\x00\x00\x00\x00value"}},
+		{60, []string{"SET", "key", "\x00\x00\x00\x00value", "EX", "60"}},
+		{at(60), []string{"SET", "key", "\x00\x00\x00\x00value", "EXAT", in(60)}},
+		{at(-1), []string{"DEL", "key"}},
+	} {
+		if err := client.Set(&memcache.Item{Key: "key", Value: []byte("value"), Expiration: test.expiration}); err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+		if commands := server.take(); !reflect.DeepEqual(commands, [][]string{test.expected}) {
+			t.Fatalf("Set with expiration %d sent %q, expected %q", test.expiration, commands, test.expected)
+		}
+	}
+
+	// An Add already expired only checks the key is absent
+	if err := client.Add(&memcache.Item{Key: "key", Value: []byte("value"), Expiration: at(-1)}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if commands := server.take(); !reflect.DeepEqual(commands, [][]string{{"EXISTS", "key"}}) {
+		t.Fatalf("Add of an expired item sent %q", commands)
+	}
+	if err := client.Add(&memcache.Item{Key: "key", Value: []byte("value"), Expiration: at(60)}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	expected := []string{"SET", "key", "\x00\x00\x00\x00value", "EXAT", in(60), "NX"}
+	if commands := server.take(); !reflect.DeepEqual(commands, [][]string{expected}) {
+		t.Fatalf("Add sent %q, expected %q", commands, expected)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Unix(1600000000, 0))
+	server := newFakeServer(t)
+	client := server.newClient(Options{Clock: clk})
+	if err := client.Set(&memcache.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	server.take()
+
+	unix := int32(clk.Now().Unix() + 60)
+	for _, test := range []struct {
+		seconds  int32
+		expected [][]string
+	}{
+		{60, [][]string{{"EXPIRE", "key", "60"}}},
+		{unix, [][]string{{"EXPIREAT", "key", strconv.Itoa(int(unix))}}},
+		{0, [][]string{{"MULTI"}, {"EXISTS", "key"}, {"PERSIST", "key"}, {"EXEC"}}},
+		// PERSIST of a key without an expiry replies 0, but the key is present
+		{0, [][]string{{"MULTI"}, {"EXISTS", "key"}, {"PERSIST", "key"}, {"EXEC"}}},
+	} {
+		if err := client.Touch("key", test.seconds); err != nil {
+			t.Fatalf("Touch with %d failed: %s", test.seconds, err)
+		}
+		if commands := server.take(); !reflect.DeepEqual(commands, test.expected) {
+			t.Fatalf("Touch with %d sent %q, expected %q", test.seconds, commands, test.expected)
+		}
+	}
+
+	for _, seconds := range []int32{60, unix, 0} {
+		if err := client.Touch("missing", seconds); err != memcache.ErrCacheMiss {
+			t.Fatalf("Touch of a missing key with %d returned %v, expected ErrCacheMiss", seconds, err)
+		}
+	}
+
+	// An expiration already passed deletes the key
+	if err := client.Touch("key", unix-120); err != nil {
+		t.Fatalf("Touch with a past expiration failed: %s", err)
+	}
+	if _, err := client.Get("key"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get after Touch with a past expiration returned %v, expected ErrCacheMiss", err)
+	}
+	if err := client.Touch("key", unix-120); err != memcache.ErrCacheMiss {
+		t.Fatalf("Touch of a missing key with a past expiration returned %v, expected ErrCacheMiss", err)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.newClient(Options{})
+
+	// Misses, unstored items, malformed values and error replies leave the connection usable
+	server.mutex.Lock()
+	server.values["malformed"] = []byte("ab")
+	server.mutex.Unlock()
+	if _, err := client.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, expected ErrCacheMiss", err)
+	}
+	if _, err := client.Get("malformed"); err != ErrMalformedValue {
+		t.Fatalf("Get of a malformed value returned %v, expected ErrMalformedValue", err)
+	}
+	if err := client.Add(&memcache.Item{Key: "malformed", Value: []byte("value")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+	if _, err := client.Stats(); err == nil {
+		t.Fatal("Stats of a server without INFO succeeded")
+	} else if _, ok := err.(Error); !ok {
+		t.Fatalf("Stats returned %v, expected an Error", err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %s", err)
+	}
+	if dials := server.dials(); dials != 1 {
+		t.Fatalf("%d connections were dialed, expected 1", dials)
+	}
+
+	// An invalid reply closes the connection, and the next request dials another
+	server.setRaw("PING", "?\r\n")
+	if err := client.Ping(); err != errProtocol {
+		t.Fatalf("Ping with an invalid reply returned %v, expected errProtocol", err)
+	}
+	server.setRaw("PING", "")
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %s", err)
+	}
+	if dials := server.dials(); dials != 2 {
+		t.Fatalf("%d connections were dialed, expected 2", dials)
+	}
+
+	// As does a connection closed by the server
+	server.setRaw("GET", closeConn)
+	if _, err := client.Get("key"); err == nil || err == memcache.ErrCacheMiss {
+		t.Fatalf("Get on a closed connection returned %v, expected a connection error", err)
+	}
+	server.setRaw("GET", "")
+	if _, err := client.Get("key"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get returned %v, expected ErrCacheMiss", err)
+	}
+	if dials := server.dials(); dials != 3 {
+		t.Fatalf("%d connections were dialed, expected 3", dials)
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.newClient(Options{Username: "user", Password: "secret", DB: 2})
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %s", err)
+	}
+	expected := [][]string{{"AUTH", "user", "secret"}, {"SELECT", "2"}, {"PING"}}
+	if commands := server.take(); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("Connecting sent %q, expected %q", commands, expected)
+	}
+}
@@ -0,0 +1,131 @@
+package redisnode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Error is an error reply from a Redis server, e.g. "WRONGTYPE Operation against a key holding the wrong kind of value"
+type Error string
+
+func (err Error) Error() string { return "redisnode: " + string(err) }
+
+// errProtocol is returned for replies that are not valid RESP
+var errProtocol = errors.New("redisnode: invalid reply")
+
+// conn is a connection to a Redis server
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}
+}
+
+// do sends a command and returns its reply: a string for a status reply, an int64, a []byte for a bulk string, a
+// []interface{} for an array, or nil for a null bulk string or array. Error replies are returned as an Error.
+func (c *conn) do(args ...interface{}) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	if err := c.rw.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// write buffers a command as an array of bulk strings
+func (c *conn) write(args ...interface{}) error {
+	fmt.Fprintf(c.rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		case int:
+			b = strconv.AppendInt(nil, int64(arg), 10)
+		case int64:
+			b = strconv.AppendInt(nil, arg, 10)
+		default:
+			return fmt.Errorf("redisnode: unsupported argument type %T", arg)
+		}
+		fmt.Fprintf(c.rw, "$%d\r\n", len(b))
+		c.rw.Write(b)
+		c.rw.WriteString("\r\n")
+	}
+	return nil
+}
+
+// read reads one reply
+func (c *conn) read() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rw, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			// An error reply within an array, e.g. from EXEC, is returned as the element
+			reply, err := c.read()
+			if _, ok := err.(Error); ok {
+				reply, err = err, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			replies[i] = reply
+		}
+		return replies, nil
+	}
+	return nil, errProtocol
+}
+
+// readLine reads a line without its CRLF
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.rw.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
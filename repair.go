@@ -0,0 +1,137 @@
+package memcacheha
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// RepairStats counts the writes made to synchronise nodes with missing data
+type RepairStats struct {
+	// Performed is the number of repair writes sent to nodes
+	Performed uint64 `json:"performed"`
+	// Dropped is the number of repair writes not sent because of MaxRepairsPerSecond or MaxConcurrentRepairs
+	Dropped uint64 `json:"dropped"`
+}
+
+// repairThrottle limits repair writes with a token bucket refilled at MaxRepairsPerSecond and a count of repairs in
+// progress
+type repairThrottle struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+	stats    RepairStats
+	mutex    sync.Mutex
+}
+
+// allow returns true and counts a repair in progress if it can be sent under the given limits, where zero means no
+// limit, and otherwise counts it as dropped
+func (throttle *repairThrottle) allow(perSecond float64, maxConcurrent int, now time.Time) bool {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	if perSecond > 0 {
+		// Refill the bucket, holding at most one second of repairs
+		if !throttle.last.IsZero() {
+			throttle.tokens += now.Sub(throttle.last).Seconds() * perSecond
+		} else {
+			throttle.tokens = perSecond
+		}
+		if throttle.tokens > perSecond {
+			throttle.tokens = perSecond
+		}
+		throttle.last = now
+	}
+
+	if (perSecond > 0 && throttle.tokens < 1) || (maxConcurrent > 0 && throttle.inFlight >= maxConcurrent) {
+		throttle.stats.Dropped++
+		return false
+	}
+	if perSecond > 0 {
+		throttle.tokens--
+	}
+	throttle.inFlight++
+	throttle.stats.Performed++
+	return true
+}
+
+// done marks a repair as complete
+func (throttle *repairThrottle) done() {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	throttle.inFlight--
+}
+
+// RepairStats returns the number of repair writes sent and dropped by this client
+func (client *Client) RepairStats() RepairStats {
+	client.repairs.mutex.Lock()
+	defer client.repairs.mutex.Unlock()
+	return client.repairs.stats
+}
+
+// repairNodes writes item to nodes that are missing it, dropping writes that exceed MaxRepairsPerSecond or
+// MaxConcurrentRepairs
+func (client *Client) repairNodes(opID string, nodes []*Node, item *Item) {
+	client.configMutex.RLock()
+	perSecond, maxConcurrent := client.MaxRepairsPerSecond, client.MaxConcurrentRepairs
+	client.configMutex.RUnlock()
+
+	dropped := 0
+	for _, node := range nodes {
+		if !client.repairs.allow(perSecond, maxConcurrent, client.Clock.Now()) {
+			dropped++
+			continue
+		}
+		node := node
+		node.run(nil, func() *NodeResponse {
+			defer client.repairs.done()
+			return node.doSet(opID, item)
+		})
+	}
+	if dropped > 0 {
+		client.levelLog.Debug("[%s] Repair: Dropped %d of %d repairs of %s", opID, dropped, len(nodes), client.LogKey(item.Key))
+	}
+}
+
+// alignCounters sets the counter key on nodes that are behind to value, see Node.alignCounter, under the same limits
+// as repairNodes
+func (client *Client) alignCounters(opID string, nodes []*Node, key string, value uint64, incr bool) {
+	client.configMutex.RLock()
+	perSecond, maxConcurrent := client.MaxRepairsPerSecond, client.MaxConcurrentRepairs
+	client.configMutex.RUnlock()
+
+	dropped := 0
+	for _, node := range nodes {
+		if !client.repairs.allow(perSecond, maxConcurrent, client.Clock.Now()) {
+			dropped++
+			continue
+		}
+		node := node
+		node.run(nil, func() *NodeResponse {
+			defer client.repairs.done()
+			return node.alignCounter(opID, key, value, incr)
+		})
+	}
+	if dropped > 0 {
+		client.levelLog.Debug("[%s] Repair: Dropped %d of %d counter repairs of %s", opID, dropped, len(nodes), client.LogKey(key))
+	}
+}
+
+// agreedItem returns the item returned by the most nodes, comparing values and flags, and the number of nodes that
+// returned it. Ties are won by the last item returned.
+func agreedItem(items []*Item) (*Item, int) {
+	var best *Item
+	bestCount := 0
+	for _, item := range items {
+		count := 0
+		for _, other := range items {
+			if other.Flags == item.Flags && bytes.Equal(other.Value, item.Value) {
+				count++
+			}
+		}
+		if count >= bestCount {
+			best, bestCount = item, count
+		}
+	}
+	return best, bestCount
+}
@@ -0,0 +1,101 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"testing"
+	"time"
+)
+
+func TestRepairLimits(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Now())
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	client := cluster.NewClient(t)
+	endpoints := cluster.Endpoints()
+	client.SetRepairLimits(2, 0)
+
+	// Each read finds a key held by one node and repairs the other two, as the rate allows
+	get := func(key string) {
+		t.Helper()
+		cluster.Put(&memcacheha.Item{Key: key, Value: []byte("value")}, endpoints[0])
+		if _, err := client.Get(key, memcacheha.WithReadAll()); err != nil {
+			t.Fatalf("Get failed: %s", err)
+		}
+	}
+	get("first")
+	get("dropped")
+	if stats := client.RepairStats(); stats != (memcacheha.RepairStats{Performed: 2, Dropped: 2}) {
+		t.Fatalf("RepairStats are %+v, expected 2 performed and 2 dropped", stats)
+	}
+	eventually(t, func() bool { return consistent(cluster, "first", []byte("value")) })
+	for _, endpoint := range endpoints[1:] {
+		if value := cluster.Value(endpoint, "dropped"); value != nil {
+			t.Fatalf("%s was repaired with a dropped repair", endpoint)
+		}
+	}
+
+	// The rate refills with the client's clock
+	clk.Advance(time.Second)
+	get("refilled")
+	if stats := client.RepairStats(); stats != (memcacheha.RepairStats{Performed: 4, Dropped: 2}) {
+		t.Fatalf("RepairStats are %+v, expected 4 performed and 2 dropped", stats)
+	}
+	eventually(t, func() bool { return consistent(cluster, "refilled", []byte("value")) })
+
+	// Repairs beyond the concurrent limit are dropped while others are in progress
+	client.SetRepairLimits(0, 1)
+	cluster.SetLatency(100*time.Millisecond, endpoints[1:]...)
+	get("concurrent")
+	if stats := client.RepairStats(); stats != (memcacheha.RepairStats{Performed: 5, Dropped: 3}) {
+		t.Fatalf("RepairStats are %+v, expected 5 performed and 3 dropped", stats)
+	}
+
+	// Without limits, nothing is dropped
+	cluster.SetLatency(0)
+	client.SetRepairLimits(0, 0)
+	get("unlimited")
+	eventually(t, func() bool { return consistent(cluster, "unlimited", []byte("value")) })
+	if stats := client.RepairStats(); stats.Dropped != 3 {
+		t.Fatalf("RepairStats are %+v, expected no more dropped", stats)
+	}
+}
+
+func TestRepairQuorum(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.DefaultReadOptions.RepairQuorum = 2 })
+	endpoints := cluster.Endpoints()
+
+	// A value held by a single node, or on which nodes disagree, is returned but not propagated
+	cluster.Put(&memcacheha.Item{Key: "single", Value: []byte("value")}, endpoints[0])
+	cluster.Put(&memcacheha.Item{Key: "conflict", Value: []byte("a")}, endpoints[0])
+	cluster.Put(&memcacheha.Item{Key: "conflict", Value: []byte("b")}, endpoints[1])
+	for _, key := range []string{"single", "conflict"} {
+		if _, err := client.Get(key, memcacheha.WithReadAll()); err != nil {
+			t.Fatalf("Get of %s failed: %s", key, err)
+		}
+		if value := cluster.Value(endpoints[2], key); value != nil {
+			t.Fatalf("%s was repaired with %q of %s without a quorum", endpoints[2], value, key)
+		}
+	}
+	if stats := client.RepairStats(); stats.Performed != 0 {
+		t.Fatalf("RepairStats are %+v, expected no repairs", stats)
+	}
+
+	// A value held by the quorum is propagated
+	cluster.Put(&memcacheha.Item{Key: "agreed", Value: []byte("value")}, endpoints[:2]...)
+	if _, err := client.Get("agreed", memcacheha.WithReadAll()); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	eventually(t, func() bool { return consistent(cluster, "agreed", []byte("value")) })
+
+	// The option overrides the client's default
+	if _, err := client.Get("single", memcacheha.WithReadAll(), memcacheha.WithRepairQuorum(1)); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	eventually(t, func() bool { return consistent(cluster, "single", []byte("value")) })
+	if stats := client.RepairStats(); stats.Performed != 3 {
+		t.Fatalf("RepairStats are %+v, expected 3 repairs", stats)
+	}
+}
@@ -0,0 +1,60 @@
+package memcacheha
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// RESOLVE_PERIOD is the default period between re-resolving node hostnames, see ResolvePeriod
+	RESOLVE_PERIOD time.Duration = time.Duration(30 * time.Second)
+	// RESOLVE_TIMEOUT is the timeout of resolving a node hostname
+	RESOLVE_TIMEOUT time.Duration = time.Duration(2 * time.Second)
+)
+
+// lookupHost resolves a hostname to its addresses
+var lookupHost = net.DefaultResolver.LookupHost
+
+// ResolveNodes re-resolves the hostname of each node, reconnecting nodes whose addresses have changed since they were
+// last resolved, e.g. after a DNS-based failover. Nodes with IP address endpoints are skipped.
+func (client *Client) ResolveNodes() {
+	for endpoint, node := range client.Nodes.GetNodes() {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil || net.ParseIP(host) != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), RESOLVE_TIMEOUT)
+		addrs, err := lookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			client.levelLog.Warn("ResolveNodes: Resolving %s failed: %s", host, err)
+			continue
+		}
+		sort.Strings(addrs)
+
+		previous := node.setAddrs(addrs)
+		if previous != nil && strings.Join(previous, ",") != strings.Join(addrs, ",") {
+			client.levelLog.Info("ResolveNodes: Node %s moved from %s to %s, reconnecting", endpoint, strings.Join(previous, ","), strings.Join(addrs, ","))
+			nodeClient, err := client.newNodeClientFor(endpoint, node.Spec)
+			if err != nil {
+				client.levelLog.Error("ResolveNodes: Node %s not reconnected: %s", endpoint, err)
+				continue
+			}
+			node.SetClient(nodeClient)
+		}
+	}
+}
+
+// setAddrs records the resolved addresses of this node, returning the previous addresses or nil if it had not been
+// resolved
+func (node *Node) setAddrs(addrs []string) []string {
+	node.clientMutex.Lock()
+	defer node.clientMutex.Unlock()
+	previous := node.addrs
+	node.addrs = addrs
+	return previous
+}
@@ -0,0 +1,96 @@
+package memcacheha
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves hostnames to the addresses set with set, counting lookups
+type fakeResolver struct {
+	mutex   sync.Mutex
+	addrs   map[string][]string
+	lookups map[string]int
+}
+
+// set makes host resolve to addrs, or fail to resolve if none are given
+func (resolver *fakeResolver) set(host string, addrs ...string) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	if len(addrs) == 0 {
+		delete(resolver.addrs, host)
+		return
+	}
+	resolver.addrs[host] = addrs
+}
+
+func (resolver *fakeResolver) lookupHost(ctx context.Context, host string) ([]string, error) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.lookups[host]++
+	addrs, found := resolver.addrs[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+	return append([]string(nil), addrs...), nil
+}
+
+func TestResolveNodes(t *testing.T) {
+	resolver := &fakeResolver{addrs: map[string][]string{}, lookups: map[string]int{}}
+	defer func(lookup func(context.Context, string) ([]string, error)) { lookupHost = lookup }(lookupHost)
+	lookupHost = resolver.lookupHost
+	resolver.set("cache1", "10.0.0.1", "10.0.0.2")
+
+	client := New(nil, NewStaticNodeSource("cache1:11211", "10.0.0.9:11211"))
+	connects := 0
+	client.NewNodeClient = func(endpoint string, timeout time.Duration) NodeClient {
+		connects++
+		return NewMemoryNodeClient()
+	}
+	client.GetNodes()
+	node := client.Nodes.GetNodes()["cache1:11211"]
+	nodeClient, created := node.getClient(), connects
+
+	// Nodes are not reconnected when first resolved, or when resolved to the same addresses in another order
+	client.ResolveNodes()
+	resolver.set("cache1", "10.0.0.2", "10.0.0.1")
+	client.ResolveNodes()
+	if node.getClient() != nodeClient || connects != created {
+		t.Fatal("A node resolved to the same addresses was reconnected")
+	}
+
+	// Nor when resolving fails
+	resolver.set("cache1")
+	client.ResolveNodes()
+	resolver.set("cache1", "10.0.0.1", "10.0.0.2")
+	client.ResolveNodes()
+	if node.getClient() != nodeClient || connects != created {
+		t.Fatal("A node was reconnected after resolving it failed")
+	}
+
+	// A node resolved to new addresses is reconnected
+	resolver.set("cache1", "10.0.0.3")
+	client.ResolveNodes()
+	if node.getClient() == nodeClient || connects != created+1 {
+		t.Fatal("A node resolved to new addresses was not reconnected")
+	}
+	if len(client.Nodes.GetNodes()) != 2 {
+		t.Fatalf("%d nodes after reconnecting, expected 2", len(client.Nodes.GetNodes()))
+	}
+
+	// If it can't be reconnected, its client is kept
+	nodeClient = node.getClient()
+	client.NewNodeClient = nil
+	resolver.set("cache1", "10.0.0.4")
+	client.ResolveNodes()
+	if node.getClient() != nodeClient {
+		t.Fatal("The client of a node that could not be reconnected was replaced")
+	}
+
+	// IP address endpoints are never resolved
+	if lookups := resolver.lookups["10.0.0.9"]; lookups != 0 {
+		t.Fatalf("An IP address endpoint was resolved %d times", lookups)
+	}
+}
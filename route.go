@@ -0,0 +1,279 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+
+	"context"
+	"errors"
+	"path"
+	"time"
+)
+
+// ErrNoRoute is an error meaning no route of a Router matches the key
+var ErrNoRoute = errors.New("memcacheha: no route for key")
+
+// RouteStrategy decides how the keys of a route are stored in its pool
+type RouteStrategy int
+
+const (
+	// ROUTE_REPLICATE stores keys on all nodes of the pool
+	ROUTE_REPLICATE RouteStrategy = iota
+	// ROUTE_PINNED stores keys on a single node of the pool, see PinnedKeys
+	ROUTE_PINNED
+	// ROUTE_READ_ALL stores keys on all nodes of the pool, and reads from all healthy nodes, see WithReadAll
+	ROUTE_READ_ALL
+)
+
+// ErrUnknownRouteStrategy is an error meaning a RouteStrategy name is not replicate, pinned or read_all
+var ErrUnknownRouteStrategy = errors.New("memcacheha: unknown route strategy")
+
+var routeStrategyNames = map[RouteStrategy]string{
+	ROUTE_REPLICATE: "replicate",
+	ROUTE_PINNED:    "pinned",
+	ROUTE_READ_ALL:  "read_all",
+}
+
+// UnmarshalText parses a strategy name: replicate, pinned or read_all
+func (strategy *RouteStrategy) UnmarshalText(text []byte) error {
+	for s, name := range routeStrategyNames {
+		if name == string(text) {
+			*strategy = s
+			return nil
+		}
+	}
+	return ErrUnknownRouteStrategy
+}
+
+// MarshalText returns the strategy name
+func (strategy RouteStrategy) MarshalText() ([]byte, error) {
+	name, found := routeStrategyNames[strategy]
+	if !found {
+		return nil, ErrUnknownRouteStrategy
+	}
+	return []byte(name), nil
+}
+
+// Route directs the keys matching Pattern to the pool named Pool
+type Route struct {
+	// Pattern is a pattern of keys (see path.Match), e.g. "sess:*". An empty pattern matches all keys.
+	Pattern  string
+	Pool     string
+	Strategy RouteStrategy
+	// TTL is the expiry of items written through this route without one. Zero means no expiry.
+	TTL time.Duration
+}
+
+// matches returns true if key matches the pattern of this route
+func (route *Route) matches(key string) bool {
+	if route.Pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(route.Pattern, key)
+	return matched
+}
+
+// RouteConfig is the configuration of a Route
+type RouteConfig struct {
+	Pattern  string        `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Pool     string        `json:"pool" yaml:"pool"`
+	Strategy RouteStrategy `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	TTL      Duration      `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// Router directs keys to several pools of nodes, each a Client, by the first of its routes matching the key, so one
+// Router can replace several single-purpose clients.
+type Router struct {
+	routes  []Route
+	pools   map[string]*Client
+	manager *Manager
+}
+
+// NewRouter returns a new Router over the given named pools. Each route must name one of the pools; ErrUnknownCluster
+// is returned otherwise. The pools are started and stopped by the Router, and should not be started individually.
+func NewRouter(log logger.Logger, pools map[string]*Client, routes ...Route) (*Router, error) {
+	router := &Router{
+		routes:  routes,
+		pools:   pools,
+		manager: NewManager(log),
+	}
+	for name, client := range pools {
+		if err := router.manager.Add(name, client); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range routes {
+		client, found := pools[route.Pool]
+		if !found {
+			return nil, ErrUnknownCluster
+		}
+		if route.Strategy == ROUTE_PINNED {
+			pattern := route.Pattern
+			if pattern == "" {
+				pattern = "*"
+			}
+			client.configMutex.RLock()
+			pinned := append(append([]string{}, client.PinnedKeys...), pattern)
+			client.configMutex.RUnlock()
+			client.SetPinnedKeys(pinned...)
+		}
+	}
+	return router, nil
+}
+
+// NewRouterFromConfig returns a new Router with a Client for each of the Pools of cfg, and its Routes
+func NewRouterFromConfig(log logger.Logger, cfg *Config) (*Router, error) {
+	pools := map[string]*Client{}
+	for name, poolCfg := range cfg.Pools {
+		client, err := NewFromConfig(logger.NewScopedLogger(name, log), poolCfg)
+		if err != nil {
+			return nil, err
+		}
+		pools[name] = client
+	}
+	routes := make([]Route, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		routes = append(routes, Route{
+			Pattern:  route.Pattern,
+			Pool:     route.Pool,
+			Strategy: route.Strategy,
+			TTL:      time.Duration(route.TTL),
+		})
+	}
+	return NewRouter(log, pools, routes...)
+}
+
+// Route returns the first route matching the given key and the Client of its pool, or ErrNoRoute
+func (router *Router) Route(key string) (*Route, *Client, error) {
+	for i := range router.routes {
+		if router.routes[i].matches(key) {
+			return &router.routes[i], router.pools[router.routes[i].Pool], nil
+		}
+	}
+	return nil, nil, ErrNoRoute
+}
+
+// Pools returns the Clients of all pools by name
+func (router *Router) Pools() map[string]*Client {
+	out := make(map[string]*Client, len(router.pools))
+	for name, client := range router.pools {
+		out[name] = client
+	}
+	return out
+}
+
+// Start discovery and healthchecks for all pools
+func (router *Router) Start() error {
+	return router.manager.Start()
+}
+
+// StartAndWait discovers and healthchecks the nodes of all pools, then starts them and waits for a healthy node in each,
+// see Client.StartAndWait
+func (router *Router) StartAndWait(ctx context.Context) error {
+	return router.manager.StartAndWait(ctx)
+}
+
+// Stop discovery and healthchecks for all pools
+func (router *Router) Stop() error {
+	return router.manager.Stop()
+}
+
+// WaitForNodes waits for at least one available node in every pool, timing out on the deadline with ErrNoHealthyNodes
+func (router *Router) WaitForNodes(deadline time.Time) error {
+	return router.manager.WaitForNodes(deadline)
+}
+
+// WaitForNodesContext waits for at least minNodes healthy nodes in every pool, see Client.WaitForNodesContext
+func (router *Router) WaitForNodesContext(ctx context.Context, minNodes int) error {
+	return router.manager.WaitForNodesContext(ctx, minNodes)
+}
+
+// withTTL returns item, or a copy of it expiring after the TTL of route from now if it has no expiry
+func withTTL(route *Route, item *Item, now time.Time) *Item {
+	if route.TTL <= 0 || item.Expiration != nil {
+		return item
+	}
+	routed := *item
+	expiration := now.Add(route.TTL)
+	routed.Expiration = &expiration
+	return &routed
+}
+
+// readOptions returns the options of a read through route, followed by opts
+func readOptions(route *Route, opts []ReadOption) []ReadOption {
+	if route.Strategy != ROUTE_READ_ALL {
+		return opts
+	}
+	return append([]ReadOption{WithReadAll()}, opts...)
+}
+
+// Add performs Client.Add on the pool of the item's key
+func (router *Router) Add(item *Item, opts ...WriteOption) error {
+	route, client, err := router.Route(item.Key)
+	if err != nil {
+		return err
+	}
+	return client.Add(withTTL(route, item, client.Clock.Now()), opts...)
+}
+
+// AddOrGet performs Client.AddOrGet on the pool of the item's key
+func (router *Router) AddOrGet(item *Item, opts ...WriteOption) (*Item, error) {
+	route, client, err := router.Route(item.Key)
+	if err != nil {
+		return nil, err
+	}
+	return client.AddOrGet(withTTL(route, item, client.Clock.Now()), opts...)
+}
+
+// Set performs Client.Set on the pool of the item's key
+func (router *Router) Set(item *Item, opts ...WriteOption) error {
+	route, client, err := router.Route(item.Key)
+	if err != nil {
+		return err
+	}
+	return client.Set(withTTL(route, item, client.Clock.Now()), opts...)
+}
+
+// Get performs Client.Get on the pool of the key
+func (router *Router) Get(key string, opts ...ReadOption) (*Item, error) {
+	route, client, err := router.Route(key)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(key, readOptions(route, opts)...)
+}
+
+// Delete performs Client.Delete on the pool of the key
+func (router *Router) Delete(key string, opts ...WriteOption) error {
+	_, client, err := router.Route(key)
+	if err != nil {
+		return err
+	}
+	return client.Delete(key, opts...)
+}
+
+// Touch performs Client.Touch on the pool of the key
+func (router *Router) Touch(key string, seconds int32, opts ...WriteOption) error {
+	_, client, err := router.Route(key)
+	if err != nil {
+		return err
+	}
+	return client.Touch(key, seconds, opts...)
+}
+
+// Increment performs Client.Increment on the pool of the key
+func (router *Router) Increment(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	_, client, err := router.Route(key)
+	if err != nil {
+		return 0, err
+	}
+	return client.Increment(key, delta, opts...)
+}
+
+// Decrement performs Client.Decrement on the pool of the key
+func (router *Router) Decrement(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	_, client, err := router.Route(key)
+	if err != nil {
+		return 0, err
+	}
+	return client.Decrement(key, delta, opts...)
+}
@@ -0,0 +1,136 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRouterRoutes(t *testing.T) {
+	pools := map[string]*memcacheha.Client{
+		"sessions": newManagedClient(memcachehatest.NewCluster(1)),
+		"shared":   newManagedClient(memcachehatest.NewCluster(1)),
+	}
+	if _, err := memcacheha.NewRouter(nil, pools, memcacheha.Route{Pool: "unknown"}); err != memcacheha.ErrUnknownCluster {
+		t.Fatalf("NewRouter with an unknown pool returned %v, expected ErrUnknownCluster", err)
+	}
+
+	router, err := memcacheha.NewRouter(nil, map[string]*memcacheha.Client{
+		"sessions": newManagedClient(memcachehatest.NewCluster(1)),
+		"shared":   newManagedClient(memcachehatest.NewCluster(1)),
+	}, memcacheha.Route{Pattern: "sess:*", Pool: "sessions"}, memcacheha.Route{Pattern: "sess:admin:*", Pool: "shared"}, memcacheha.Route{Pool: "shared"})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %s", err)
+	}
+
+	// The first matching route is used, and an empty pattern matches all keys
+	for key, pool := range map[string]string{"sess:1": "sessions", "sess:admin:1": "sessions", "other": "shared", "": "shared"} {
+		route, client, err := router.Route(key)
+		if err != nil || route.Pool != pool || client != router.Pools()[pool] {
+			t.Fatalf("Route of %q returned %+v, %v, expected the %s pool", key, route, err, pool)
+		}
+	}
+
+	router, err = memcacheha.NewRouter(nil, map[string]*memcacheha.Client{
+		"sessions": newManagedClient(memcachehatest.NewCluster(1)),
+	}, memcacheha.Route{Pattern: "sess:*", Pool: "sessions"})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %s", err)
+	}
+	if _, _, err := router.Route("other"); err != memcacheha.ErrNoRoute {
+		t.Fatalf("Route of a key matching no route returned %v, expected ErrNoRoute", err)
+	}
+	if err := router.Set(&memcacheha.Item{Key: "other", Value: []byte("value")}); err != memcacheha.ErrNoRoute {
+		t.Fatalf("Set of a key matching no route returned %v, expected ErrNoRoute", err)
+	}
+	if _, err := router.Get("other"); err != memcacheha.ErrNoRoute {
+		t.Fatalf("Get of a key matching no route returned %v, expected ErrNoRoute", err)
+	}
+}
+
+func TestRouterStrategies(t *testing.T) {
+	clk := memcachehatest.NewClock(time.Now().Truncate(time.Second))
+	sessions, shared := memcachehatest.NewCluster(3), memcachehatest.NewCluster(3)
+	sessions.SetClock(clk)
+	pools := map[string]*memcacheha.Client{"sessions": newManagedClient(sessions), "shared": newManagedClient(shared)}
+	pools["sessions"].Clock = clk
+	router, err := memcacheha.NewRouter(logger.NewConsoleLogger("error"), pools,
+		memcacheha.Route{Pattern: "sess:*", Pool: "sessions", TTL: 10 * time.Second},
+		memcacheha.Route{Pattern: "pin:*", Pool: "shared", Strategy: memcacheha.ROUTE_PINNED},
+		memcacheha.Route{Pattern: "all:*", Pool: "shared", Strategy: memcacheha.ROUTE_READ_ALL},
+		memcacheha.Route{Pool: "shared"},
+	)
+	if err != nil {
+		t.Fatalf("NewRouter failed: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := router.StartAndWait(ctx); err != nil {
+		t.Fatalf("StartAndWait failed: %s", err)
+	}
+	t.Cleanup(func() { router.Stop() })
+	if err := router.WaitForNodesContext(ctx, 3); err != nil {
+		t.Fatalf("WaitForNodesContext failed: %s", err)
+	}
+
+	// Keys are written to the pool of their route only
+	if err := router.Set(&memcacheha.Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	shared.AssertValue(t, "key", []byte("value"))
+	sessions.AssertValue(t, "key", nil)
+
+	// Items without an expiry get the TTL of their route, on the client's clock
+	if err := router.Set(&memcacheha.Item{Key: "sess:1", Value: []byte("session")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := router.Add(&memcacheha.Item{Key: "sess:2", Value: []byte("session")}); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	shared.AssertValue(t, "sess:1", nil)
+	clk.Advance(9 * time.Second)
+	if item, err := router.Get("sess:1"); err != nil || string(item.Value) != "session" {
+		t.Fatalf("Get before the TTL of the route returned %v, %v", item, err)
+	}
+	clk.Advance(2 * time.Second)
+	for _, key := range []string{"sess:1", "sess:2"} {
+		if _, err := router.Get(key); err != memcache.ErrCacheMiss {
+			t.Fatalf("Get of %s after the TTL of the route returned %v, expected ErrCacheMiss", key, err)
+		}
+	}
+
+	// Pinned keys are stored on a single node of the pool
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("pin:%d", i)
+		if err := router.Set(&memcacheha.Item{Key: key, Value: []byte("pinned")}); err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+		holders := 0
+		for _, endpoint := range shared.Endpoints() {
+			if shared.Value(endpoint, key) != nil {
+				holders++
+			}
+		}
+		if holders != 1 {
+			t.Fatalf("%s is held by %d nodes, expected 1", key, holders)
+		}
+		if item, err := router.Get(key); err != nil || string(item.Value) != "pinned" {
+			t.Fatalf("Get of a pinned key returned %v, %v", item, err)
+		}
+	}
+
+	// Keys of a read_all route are found on any node
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("all:%d", i)
+		shared.Put(&memcacheha.Item{Key: key, Value: []byte("value")}, shared.Endpoints()[i%3])
+		if item, err := router.Get(key); err != nil || string(item.Value) != "value" {
+			t.Fatalf("Get of %s held by one node returned %v, %v", key, item, err)
+		}
+	}
+}
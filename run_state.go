@@ -0,0 +1,62 @@
+package memcacheha
+
+import (
+	"sync"
+)
+
+// runState tracks whether a runloop is running. Start and Stop may be called from any goroutine: the running state is
+// changed under the mutex by Start and Stop themselves rather than by the runloop, and shutdown is broadcast by closing
+// the stop channel. The zero value is a stopped runState.
+type runState struct {
+	mutex sync.Mutex
+	// stop is non-nil while running, and closed by stop to shut the runloop down
+	stop chan struct{}
+	// done is closed when the runloop returns
+	done chan struct{}
+}
+
+// running returns true between a successful start and the following stop
+func (state *runState) running() bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.stop != nil
+}
+
+// start runs prepare, then runs loop in a new goroutine until the stop channel passed to it is closed. ErrAlreadyRunning
+// is returned if already running. If prepare returns an error, it is returned and loop is not run.
+func (state *runState) start(prepare func() error, loop func(stop <-chan struct{})) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.stop != nil {
+		return ErrAlreadyRunning
+	}
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			return err
+		}
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	state.stop, state.done = stop, done
+	go func() {
+		defer close(done)
+		loop(stop)
+	}()
+	return nil
+}
+
+// stopAndWait closes the stop channel, waits for the runloop to return, then runs cleanup, returning its error.
+// ErrNotRunning is returned if not running. Concurrent calls to start wait until the runloop has returned.
+func (state *runState) stopAndWait(cleanup func() error) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.stop == nil {
+		return ErrNotRunning
+	}
+	close(state.stop)
+	<-state.done
+	state.stop, state.done = nil, nil
+	if cleanup != nil {
+		return cleanup()
+	}
+	return nil
+}
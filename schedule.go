@@ -0,0 +1,388 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/memcacheha/clock"
+
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// MAINTENANCE_JITTER is the default fraction by which the periods of discovery, healthchecks and the other periodic
+	// tasks are randomly varied, e.g. 0.1 for up to 10% either way. Zero runs them on exact periods.
+	MAINTENANCE_JITTER float64 = 0
+	// MAINTENANCE_START_DELAY is the delay after Start before the first discovery, resolve and healthcheck
+	MAINTENANCE_START_DELAY time.Duration = time.Duration(1 * time.Second)
+	// WORKER_RESTART_DELAY is the delay before a maintenance worker that panicked is restarted
+	WORKER_RESTART_DELAY time.Duration = time.Duration(1 * time.Second)
+)
+
+// The maintenance workers each run their tasks in their own goroutine, so that a slow or panicking task only delays
+// or restarts its own worker
+const (
+	WORKER_DISCOVERY   = "discovery"
+	WORKER_HEALTHCHECK = "healthcheck"
+	WORKER_REPAIR      = "repair"
+	WORKER_HOTKEYS     = "hotkeys"
+	// WORKER_WATCH subscribes to WatchableSources
+	WORKER_WATCH = "watch"
+)
+
+// maintenanceWorkers are the workers started by the runloop
+var maintenanceWorkers = []string{WORKER_DISCOVERY, WORKER_HEALTHCHECK, WORKER_REPAIR, WORKER_HOTKEYS, WORKER_WATCH}
+
+// WorkerStatus counts the panics of a maintenance worker running the tasks of a client
+type WorkerStatus struct {
+	Worker        string    `json:"worker"`
+	Panics        int       `json:"panics"`
+	LastPanic     string    `json:"last_panic,omitempty"`
+	LastPanicTime time.Time `json:"last_panic_time,omitempty"`
+}
+
+// maintenanceTask is a periodic task run by a maintenance worker
+type maintenanceTask struct {
+	worker string
+	// period returns the period of the task, zero or negative if it is disabled. It is called with the configMutex
+	// read locked.
+	period func(client *Client) time.Duration
+	run    func(client *Client)
+	// atStart runs the task first MAINTENANCE_START_DELAY after Start, rather than a period after
+	atStart bool
+}
+
+// maintenanceTasks are run in order when several tasks of a worker are due together
+var maintenanceTasks = []maintenanceTask{
+	{
+		worker:  WORKER_DISCOVERY,
+		period:  discoveryPeriod,
+		run:     (*Client).GetNodes,
+		atStart: true,
+	},
+	{
+		worker:  WORKER_DISCOVERY,
+		period:  func(client *Client) time.Duration { return client.ResolvePeriod },
+		run:     (*Client).ResolveNodes,
+		atStart: true,
+	},
+	{
+		worker:  WORKER_HEALTHCHECK,
+		period:  func(client *Client) time.Duration { return client.HealthCheckPeriod },
+		run:     (*Client).runHealthCheck,
+		atStart: true,
+	},
+	{
+		worker: WORKER_HEALTHCHECK,
+		period: func(client *Client) time.Duration { return client.IdleCheckPeriod },
+		run:    (*Client).checkIdleConnections,
+	},
+	{
+		worker: WORKER_REPAIR,
+		period: func(client *Client) time.Duration { return client.HealthCheckPeriod },
+		run:    (*Client).retryDeletes,
+	},
+	{
+		worker: WORKER_HOTKEYS,
+		period: func(client *Client) time.Duration { return hotKeyPeriod(client, HOTKEY_LOG_PERIOD) },
+		run:    (*Client).logHotKeys,
+	},
+	{
+		worker: WORKER_HOTKEYS,
+		period: func(client *Client) time.Duration { return hotKeyPeriod(client, HOTKEY_DECAY_PERIOD) },
+		run:    func(client *Client) { client.hotKeys.Decay() },
+	},
+}
+
+// discoveryPeriod returns the GetNodesPeriod, or the shortest TTL of the NodeSpecs found by the last discovery if it
+// is shorter
+func discoveryPeriod(client *Client) time.Duration {
+	if client.sourceTTL > 0 && client.sourceTTL < client.GetNodesPeriod {
+		return client.sourceTTL
+	}
+	return client.GetNodesPeriod
+}
+
+// hotKeyPeriod returns period if TrackHotKeys is enabled, disabling the hot key tasks otherwise
+func hotKeyPeriod(client *Client, period time.Duration) time.Duration {
+	if !client.TrackHotKeys {
+		return 0
+	}
+	return period
+}
+
+// workerTasks returns the maintenanceTasks of worker
+func workerTasks(worker string) []maintenanceTask {
+	var tasks []maintenanceTask
+	for _, task := range maintenanceTasks {
+		if task.worker == worker {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// maintenanceState holds the schedule of the tasks of a worker for a client, indexed as tasks
+type maintenanceState struct {
+	tasks []maintenanceTask
+	start time.Time
+	// periods are the periods the tasks were scheduled with, zero for disabled tasks
+	periods []time.Duration
+	// next are the times the tasks are next due, zero for disabled tasks
+	next []time.Time
+	// last are the times the tasks last ran, zero if they haven't
+	last []time.Time
+}
+
+func newMaintenanceState(tasks []maintenanceTask, now time.Time) *maintenanceState {
+	return &maintenanceState{
+		tasks:   tasks,
+		start:   now,
+		periods: make([]time.Duration, len(tasks)),
+		next:    make([]time.Time, len(tasks)),
+		last:    make([]time.Time, len(tasks)),
+	}
+}
+
+// maintain runs the tasks of state due at now, returning the time the next task is due, or the zero time if all are
+// disabled. Tasks are rescheduled when their period changes, from their last run.
+func (client *Client) maintain(state *maintenanceState, now time.Time) time.Time {
+	periods := make([]time.Duration, len(state.tasks))
+	client.configMutex.RLock()
+	for i, task := range state.tasks {
+		periods[i] = task.period(client)
+	}
+	jitter := client.MaintenanceJitter
+	client.configMutex.RUnlock()
+
+	var earliest time.Time
+	for i, task := range state.tasks {
+		period := periods[i]
+		if period <= 0 {
+			state.periods[i], state.next[i] = 0, time.Time{}
+			continue
+		}
+		if period != state.periods[i] {
+			state.schedule(i, period, jitter)
+		}
+
+		if !now.Before(state.next[i]) {
+			task.run(client)
+			done := client.Clock.Now()
+			state.last[i] = done
+			// Keep to the cadence of the period unless the task overran it
+			state.next[i] = state.next[i].Add(jitterPeriod(period, jitter))
+			if !state.next[i].After(done) {
+				state.next[i] = done.Add(jitterPeriod(period, jitter))
+			}
+		}
+
+		if earliest.IsZero() || state.next[i].Before(earliest) {
+			earliest = state.next[i]
+		}
+	}
+	return earliest
+}
+
+// schedule sets the next run of task i with the given period, a period after its last run
+func (state *maintenanceState) schedule(i int, period time.Duration, jitter float64) {
+	state.periods[i] = period
+	switch {
+	case !state.last[i].IsZero():
+		state.next[i] = state.last[i].Add(jitterPeriod(period, jitter))
+	case state.tasks[i].atStart:
+		state.next[i] = state.start.Add(MAINTENANCE_START_DELAY)
+	default:
+		state.next[i] = state.start.Add(jitterPeriod(period, jitter))
+	}
+}
+
+// jitterPeriod returns period randomly varied by up to the fraction jitter either way
+func jitterPeriod(period time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return period
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return period + time.Duration((randFloat64()*2-1)*jitter*float64(period))
+}
+
+// runHealthCheck healthchecks the nodes, then updates the degraded nodes and partition status from the results, and
+// checks the latency objectives
+func (client *Client) runHealthCheck() {
+	err := client.HealthCheck()
+	if err != nil {
+		client.levelLog.Warn("HealthCheck returned an error: %s", err)
+	}
+	client.updateDegradedNodes()
+	client.updatePartition()
+	client.updateSingleNode()
+	client.checkSLOs()
+}
+
+// superviseWorker runs the tasks of worker for the clients returned by clients until stop is closed. A panic in a task
+// is logged and counted against the client it ran for, see WorkerStatuses, and the worker is restarted after
+// WORKER_RESTART_DELAY with a new schedule.
+func superviseWorker(worker string, clients func() []*Client, wake func() <-chan struct{}, stop <-chan struct{}) {
+	tasks := workerTasks(worker)
+	for {
+		var current *Client
+		panicked := func() (panicked bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					panicked = true
+					if current != nil {
+						current.workerPanicked(worker, r, debug.Stack())
+					}
+				}
+			}()
+			if worker == WORKER_WATCH {
+				runWatchWorker(clients, wake, stop, &current)
+			} else {
+				runWorker(tasks, clients, wake, stop, &current)
+			}
+			return false
+		}()
+		if !panicked {
+			return
+		}
+		select {
+		case <-time.After(WORKER_RESTART_DELAY):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runWorker runs tasks for the clients returned by clients until stop is closed, setting current to the client whose
+// tasks are running
+func runWorker(tasks []maintenanceTask, clients func() []*Client, wake func() <-chan struct{}, stop <-chan struct{}, current **Client) {
+	states := map[*Client]*maintenanceState{}
+	timer := &maintenanceTimer{}
+
+	for {
+		woken := wake()
+		running := clients()
+
+		// Clients may have their own Clocks, so the wait for the earliest task is measured on each, and waited for on
+		// the clock of the client if there is only one, or in real time
+		timer.clock = clock.Real
+		if len(running) == 1 {
+			timer.clock = running[0].Clock
+		}
+		var earliest time.Time
+		for _, client := range running {
+			state, found := states[client]
+			if !found {
+				state = newMaintenanceState(tasks, client.Clock.Now())
+				states[client] = state
+			}
+			*current = client
+			next := client.maintain(state, client.Clock.Now())
+			if next.IsZero() {
+				continue
+			}
+			if at := timer.clock.Now().Add(next.Sub(client.Clock.Now())); earliest.IsZero() || at.Before(earliest) {
+				earliest = at
+			}
+		}
+
+		select {
+		case <-timer.wait(earliest):
+			timer.fired()
+		case <-woken:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// workerPanicked logs and counts a panic of worker running the tasks of this client
+func (client *Client) workerPanicked(worker string, r interface{}, stack []byte) {
+	client.levelLog.Error("Maintenance: %s worker panicked, restarting in %s: %v\n%s", worker, WORKER_RESTART_DELAY, r, stack)
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	status, found := client.workers[worker]
+	if !found {
+		status = &WorkerStatus{Worker: worker}
+		client.workers[worker] = status
+	}
+	status.Panics++
+	status.LastPanic = fmt.Sprint(r)
+	status.LastPanicTime = time.Now()
+}
+
+// WorkerStatuses returns the panics counted for each maintenance worker that has panicked running the tasks of this
+// client, sorted by worker
+func (client *Client) WorkerStatuses() []WorkerStatus {
+	client.statusMutex.Lock()
+	defer client.statusMutex.Unlock()
+	statuses := []WorkerStatus{}
+	for _, status := range client.workers {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Worker < statuses[j].Worker })
+	return statuses
+}
+
+// wakeSignal wakes every worker waiting on it, by closing the channel returned by wait
+type wakeSignal struct {
+	mutex sync.Mutex
+	c     chan struct{}
+}
+
+func newWakeSignal() *wakeSignal {
+	return &wakeSignal{c: make(chan struct{})}
+}
+
+// wait returns a channel closed at the next notify
+func (signal *wakeSignal) wait() <-chan struct{} {
+	signal.mutex.Lock()
+	defer signal.mutex.Unlock()
+	return signal.c
+}
+
+// notify closes the channel returned by wait
+func (signal *wakeSignal) notify() {
+	signal.mutex.Lock()
+	defer signal.mutex.Unlock()
+	close(signal.c)
+	signal.c = make(chan struct{})
+}
+
+// wakeRunloop makes the maintenance workers of this client reschedule their tasks, after their periods or sources
+// have been changed. The configMutex must be held.
+func (client *Client) wakeRunloop() {
+	client.wake.notify()
+}
+
+// waitWake returns a channel closed when the periods of this client next change
+func (client *Client) waitWake() <-chan struct{} {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.wake.wait()
+}
+
+// maintenanceTimer waits for the next maintenance, starting a new timer only when the time waited for changes
+type maintenanceTimer struct {
+	clock clock.Clock
+	c     <-chan time.Time
+	at    time.Time
+}
+
+// wait returns a channel receiving when next has passed, or nil, blocking forever, if next is the zero time
+func (timer *maintenanceTimer) wait(next time.Time) <-chan time.Time {
+	if next.IsZero() {
+		timer.c, timer.at = nil, time.Time{}
+	} else if timer.c == nil || !next.Equal(timer.at) {
+		timer.c, timer.at = timer.clock.After(next.Sub(timer.clock.Now())), next
+	}
+	return timer.c
+}
+
+// fired records that the channel returned by wait has received
+func (timer *maintenanceTimer) fired() {
+	timer.c, timer.at = nil, time.Time{}
+}
@@ -0,0 +1,65 @@
+package sessions
+
+import (
+	"github.com/gorilla/sessions"
+
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const sessionContextKey contextKey = 0
+
+// Middleware returns http middleware that loads the session with the given name into the request context, and
+// saves it before the response headers are written. Handlers access the session with FromContext.
+func (store *Store) Middleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := store.Get(r, name)
+			if err != nil {
+				store.Client.Log.Warn("Session: Invalid session %s, starting a new one: %s", name, err)
+			}
+			writer := &sessionWriter{ResponseWriter: w, store: store, request: r, session: session}
+			next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), sessionContextKey, session)))
+			writer.save()
+		})
+	}
+}
+
+// FromContext returns the session loaded by Middleware, or nil
+func FromContext(ctx context.Context) *sessions.Session {
+	session, _ := ctx.Value(sessionContextKey).(*sessions.Session)
+	return session
+}
+
+// sessionWriter saves the session before the first write of the response
+type sessionWriter struct {
+	http.ResponseWriter
+	store   *Store
+	request *http.Request
+	session *sessions.Session
+	saved   bool
+}
+
+func (writer *sessionWriter) save() {
+	if writer.saved {
+		return
+	}
+	writer.saved = true
+	if err := writer.store.Save(writer.request, writer.ResponseWriter, writer.session); err != nil {
+		writer.store.Client.Log.Warn("Session: Save %s failed: %s", writer.session.Name(), err)
+	}
+}
+
+// WriteHeader saves the session, then writes the response header
+func (writer *sessionWriter) WriteHeader(statusCode int) {
+	writer.save()
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write saves the session, then writes to the response
+func (writer *sessionWriter) Write(data []byte) (int, error) {
+	writer.save()
+	return writer.ResponseWriter.Write(data)
+}
@@ -0,0 +1,154 @@
+// Package sessions implements a gorilla/sessions Store backed by a memcacheha Client. The session ID is kept in a
+// signed cookie, and the session values in the memcache cluster.
+package sessions
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// SESSION_KEY_PREFIX is the default prefix of the memcache keys of sessions
+	SESSION_KEY_PREFIX = "session_"
+	// SESSION_ID_LENGTH is the number of random bytes in a new session ID
+	SESSION_ID_LENGTH = 32
+	// SESSION_MAX_AGE is the default session cookie and item lifetime in seconds
+	SESSION_MAX_AGE = 86400 * 30
+)
+
+// Serializer encodes and decodes the values of a session
+type Serializer interface {
+	Serialize(session *sessions.Session) ([]byte, error)
+	Deserialize(data []byte, session *sessions.Session) error
+}
+
+// GobSerializer is a Serializer using encoding/gob. Custom value types must be registered with gob.Register.
+type GobSerializer struct{}
+
+// Serialize encodes the values of the given session
+func (serializer GobSerializer) Serialize(session *sessions.Session) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(session.Values)
+	return buf.Bytes(), err
+}
+
+// Deserialize decodes data into the values of the given session
+func (serializer GobSerializer) Deserialize(data []byte, session *sessions.Session) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values)
+}
+
+// Store is a gorilla/sessions Store keeping session values in a memcacheha cluster
+type Store struct {
+	Client     *memcacheha.Client
+	Codecs     []securecookie.Codec
+	Options    *sessions.Options
+	KeyPrefix  string
+	Serializer Serializer
+}
+
+// NewStore returns a new Store using the given client. The key pairs sign (and optionally encrypt) the session
+// ID cookie, as with securecookie.CodecsFromPairs.
+func NewStore(client *memcacheha.Client, keyPairs ...[]byte) *Store {
+	return &Store{
+		Client: client,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   SESSION_MAX_AGE,
+			HttpOnly: true,
+		},
+		KeyPrefix:  SESSION_KEY_PREFIX,
+		Serializer: GobSerializer{},
+	}
+}
+
+// Get returns the session with the given name, cached for the request in the gorilla/sessions registry
+func (store *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(store, name)
+}
+
+// New returns the session with the given name from the cluster, or a new session if the request has no valid
+// session cookie or the session has expired.
+func (store *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(store, name)
+	options := *store.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, store.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	found, err := store.load(session)
+	if err == nil && found {
+		session.IsNew = false
+	}
+	return session, err
+}
+
+// Save writes the session to the cluster and sets the session cookie. A session with a negative MaxAge is
+// deleted and its cookie cleared.
+func (store *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			err := store.Client.Delete(store.KeyPrefix + session.ID)
+			if err != nil && err != memcache.ErrCacheMiss {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(SESSION_ID_LENGTH)), "=")
+	}
+	if err := store.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, store.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// load reads the values of the session from the cluster, returning false if it does not exist
+func (store *Store) load(session *sessions.Session) (bool, error) {
+	item, err := store.Client.Get(store.KeyPrefix + session.ID)
+	if err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, store.Serializer.Deserialize(item.Value, session)
+}
+
+// save writes the values of the session to the cluster, expiring with the session cookie on the client's Clock
+func (store *Store) save(session *sessions.Session) error {
+	data, err := store.Serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
+	item := &memcacheha.Item{Key: store.KeyPrefix + session.ID, Value: data}
+	if session.Options.MaxAge > 0 {
+		expiration := store.Client.Clock.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+		item.Expiration = &expiration
+	}
+	return store.Client.Set(item)
+}
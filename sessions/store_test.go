@@ -0,0 +1,149 @@
+package sessions_test
+
+import (
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/apitalent/memcacheha/sessions"
+
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var hashKey = []byte("0123456789abcdef0123456789abcdef")
+
+// requestWith returns a request carrying the cookies set by response
+func requestWith(response *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range response.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	store := sessions.NewStore(cluster.NewClient(t), hashKey)
+
+	// Without a cookie, the session is new
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := store.Get(r, "session")
+	if err != nil || !session.IsNew || len(session.Values) != 0 {
+		t.Fatalf("Get returned %+v, %v, expected a new session", session, err)
+	}
+	if again, _ := store.Get(r, "session"); again != session {
+		t.Fatal("Get of the same request returned another session")
+	}
+
+	session.Values["user"] = "alice"
+	response := httptest.NewRecorder()
+	if err := store.Save(r, response, session); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+	if session.ID == "" {
+		t.Fatal("Save did not set a session ID")
+	}
+	for _, endpoint := range cluster.Endpoints() {
+		if item := cluster.Node(endpoint).Peek(sessions.SESSION_KEY_PREFIX + session.ID); item == nil {
+			t.Fatalf("Session not stored on %s", endpoint)
+		}
+	}
+
+	// The cookie loads the stored values
+	loaded, err := store.New(requestWith(response), "session")
+	if err != nil || loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+		t.Fatalf("New returned %+v, %v, expected the saved session", loaded, err)
+	}
+
+	// A cookie not signed by the store's keys starts a new session
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "forged"})
+	if session, err := store.New(r, "session"); err == nil || !session.IsNew {
+		t.Fatalf("New with an invalid cookie returned %+v, %v, expected a new session and an error", session, err)
+	}
+
+	// As does a cookie of a session no longer in the cluster
+	cluster.Flush()
+	if session, err := store.New(requestWith(response), "session"); err != nil || !session.IsNew || len(session.Values) != 0 {
+		t.Fatalf("New of a missing session returned %+v, %v, expected a new session", session, err)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	// A clock well ahead of the system clock, so expiry on the system clock is already past
+	clk := memcachehatest.NewClock(time.Now().Add(24 * time.Hour))
+	cluster := memcachehatest.NewCluster(3)
+	cluster.SetClock(clk)
+	store := sessions.NewStore(cluster.NewClient(t), hashKey)
+	store.Options.MaxAge = 60
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(r, "session")
+	session.Values["user"] = "alice"
+	response := httptest.NewRecorder()
+	if err := store.Save(r, response, session); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	// Sessions expire with their cookie, on the client's clock
+	clk.Advance(59 * time.Second)
+	if loaded, err := store.New(requestWith(response), "session"); err != nil || loaded.IsNew {
+		t.Fatalf("New before MaxAge returned %+v, %v, expected the saved session", loaded, err)
+	}
+	clk.Advance(2 * time.Second)
+	if loaded, err := store.New(requestWith(response), "session"); err != nil || !loaded.IsNew {
+		t.Fatalf("New after MaxAge returned %+v, %v, expected a new session", loaded, err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	cluster := memcachehatest.NewCluster(3)
+	store := sessions.NewStore(cluster.NewClient(t), hashKey)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(r, "session")
+	session.Values["user"] = "alice"
+	if err := store.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+	key := sessions.SESSION_KEY_PREFIX + session.ID
+
+	// A negative MaxAge deletes the session and clears its cookie
+	session.Options.MaxAge = -1
+	response := httptest.NewRecorder()
+	if err := store.Save(r, response, session); err != nil {
+		t.Fatalf("Save with a negative MaxAge failed: %s", err)
+	}
+	cluster.AssertValue(t, key, nil)
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "" || cookies[0].MaxAge >= 0 {
+		t.Fatalf("Save with a negative MaxAge set cookies %+v, expected a cleared cookie", cookies)
+	}
+
+	// Deleting a session that is already gone is not an error
+	if err := store.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Save of a deleted session failed: %s", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	store := sessions.NewStore(memcachehatest.NewCluster(3).NewClient(t), hashKey)
+	handler := store.Middleware("session")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.FromContext(r.Context())
+		count, _ := session.Values["count"].(int)
+		session.Values["count"] = count + 1
+		w.Write([]byte("ok"))
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	for i := 0; i < 2; i++ {
+		next := httptest.NewRecorder()
+		handler.ServeHTTP(next, requestWith(response))
+		response = next
+	}
+	session, err := store.New(requestWith(response), "session")
+	if err != nil || session.Values["count"] != 3 {
+		t.Fatalf("New returned %+v, %v, expected a count of 3", session, err)
+	}
+}
@@ -0,0 +1,172 @@
+package memcacheha
+
+import (
+	"github.com/apitalent/logger"
+
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNoShards is an error meaning a ShardedClient was created without any shards
+var ErrNoShards = errors.New("memcacheha: no shards")
+
+// ShardedClient shards the keyspace across several independent Clients ("shards"), each replicating its keys to
+// all of its own nodes. Total capacity grows with the number of shards, while each key remains highly available
+// within its shard. Each key belongs to the shard with the highest rendezvous hash of key and shard name, so only
+// the keys of an added or removed shard move. Keys do not move between shards when a shard fails.
+type ShardedClient struct {
+	shards  map[string]*Client
+	names   []string
+	manager *Manager
+}
+
+// NewShardedClient returns a new ShardedClient over the given named shards. The shards are started and stopped
+// by the ShardedClient, and should not be started individually.
+func NewShardedClient(log logger.Logger, shards map[string]*Client) (*ShardedClient, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+	sharded := &ShardedClient{
+		shards:  shards,
+		manager: NewManager(log),
+	}
+	for name, client := range shards {
+		sharded.names = append(sharded.names, name)
+		if err := sharded.manager.Add(name, client); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(sharded.names)
+	return sharded, nil
+}
+
+// NewShardedFromConfig returns a new ShardedClient with a Client for each of the Shards of cfg
+func NewShardedFromConfig(log logger.Logger, cfg *Config) (*ShardedClient, error) {
+	shards := map[string]*Client{}
+	for name, shardCfg := range cfg.Shards {
+		client, err := NewFromConfig(logger.NewScopedLogger(name, log), shardCfg)
+		if err != nil {
+			return nil, err
+		}
+		shards[name] = client
+	}
+	return NewShardedClient(log, shards)
+}
+
+// Shard returns the name and Client of the shard the given key belongs to
+func (sharded *ShardedClient) Shard(key string) (string, *Client) {
+	var shard string
+	var highest uint64
+	for _, name := range sharded.names {
+		weight := shardWeight(name, key)
+		if shard == "" || weight > highest {
+			shard, highest = name, weight
+		}
+	}
+	return shard, sharded.shards[shard]
+}
+
+// Parameters of the 64-bit FNV-1a hash used by shardWeight
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// shardWeight returns the rendezvous hash of shard name and key. FNV alone distributes poorly when only the short
+// shard names differ, so the hash is finished with the splitmix64 mixer.
+func shardWeight(name string, key string) uint64 {
+	// FNV-1a of name, a zero byte and key, computed without allocating
+	weight := uint64(fnvOffset64)
+	for i := 0; i < len(name); i++ {
+		weight = (weight ^ uint64(name[i])) * fnvPrime64
+	}
+	weight *= fnvPrime64
+	for i := 0; i < len(key); i++ {
+		weight = (weight ^ uint64(key[i])) * fnvPrime64
+	}
+	weight = (weight ^ (weight >> 30)) * 0xbf58476d1ce4e5b9
+	weight = (weight ^ (weight >> 27)) * 0x94d049bb133111eb
+	return weight ^ (weight >> 31)
+}
+
+// Shards returns the Clients of all shards by name
+func (sharded *ShardedClient) Shards() map[string]*Client {
+	out := make(map[string]*Client, len(sharded.shards))
+	for name, client := range sharded.shards {
+		out[name] = client
+	}
+	return out
+}
+
+func (sharded *ShardedClient) client(key string) *Client {
+	_, client := sharded.Shard(key)
+	return client
+}
+
+// Start discovery and healthchecks for all shards
+func (sharded *ShardedClient) Start() error {
+	return sharded.manager.Start()
+}
+
+// StartAndWait discovers and healthchecks the nodes of all shards, then starts them and waits for a healthy node in each,
+// see Client.StartAndWait
+func (sharded *ShardedClient) StartAndWait(ctx context.Context) error {
+	return sharded.manager.StartAndWait(ctx)
+}
+
+// Stop discovery and healthchecks for all shards
+func (sharded *ShardedClient) Stop() error {
+	return sharded.manager.Stop()
+}
+
+// WaitForNodes waits for at least one available node in every shard, timing out on the deadline with ErrNoHealthyNodes
+func (sharded *ShardedClient) WaitForNodes(deadline time.Time) error {
+	return sharded.manager.WaitForNodes(deadline)
+}
+
+// WaitForNodesContext waits for at least minNodes healthy nodes in every shard, see Client.WaitForNodesContext
+func (sharded *ShardedClient) WaitForNodesContext(ctx context.Context, minNodes int) error {
+	return sharded.manager.WaitForNodesContext(ctx, minNodes)
+}
+
+// Add performs Client.Add on the shard of the item's key
+func (sharded *ShardedClient) Add(item *Item, opts ...WriteOption) error {
+	return sharded.client(item.Key).Add(item, opts...)
+}
+
+// AddOrGet performs Client.AddOrGet on the shard of the item's key
+func (sharded *ShardedClient) AddOrGet(item *Item, opts ...WriteOption) (*Item, error) {
+	return sharded.client(item.Key).AddOrGet(item, opts...)
+}
+
+// Set performs Client.Set on the shard of the item's key
+func (sharded *ShardedClient) Set(item *Item, opts ...WriteOption) error {
+	return sharded.client(item.Key).Set(item, opts...)
+}
+
+// Get performs Client.Get on the shard of the key
+func (sharded *ShardedClient) Get(key string, opts ...ReadOption) (*Item, error) {
+	return sharded.client(key).Get(key, opts...)
+}
+
+// Delete performs Client.Delete on the shard of the key
+func (sharded *ShardedClient) Delete(key string, opts ...WriteOption) error {
+	return sharded.client(key).Delete(key, opts...)
+}
+
+// Touch performs Client.Touch on the shard of the key
+func (sharded *ShardedClient) Touch(key string, seconds int32, opts ...WriteOption) error {
+	return sharded.client(key).Touch(key, seconds, opts...)
+}
+
+// Increment performs Client.Increment on the shard of the key
+func (sharded *ShardedClient) Increment(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return sharded.client(key).Increment(key, delta, opts...)
+}
+
+// Decrement performs Client.Decrement on the shard of the key
+func (sharded *ShardedClient) Decrement(key string, delta uint64, opts ...WriteOption) (uint64, error) {
+	return sharded.client(key).Decrement(key, delta, opts...)
+}
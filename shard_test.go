@@ -0,0 +1,144 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/logger"
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newShardedClient returns a ShardedClient over a cluster of 3 nodes for each name, started if start is true
+func newShardedClient(t *testing.T, start bool, names ...string) (*memcacheha.ShardedClient, map[string]*memcachehatest.Cluster) {
+	clusters := map[string]*memcachehatest.Cluster{}
+	shards := map[string]*memcacheha.Client{}
+	for _, name := range names {
+		clusters[name] = memcachehatest.NewCluster(3)
+		shards[name] = newManagedClient(clusters[name])
+	}
+	sharded, err := memcacheha.NewShardedClient(logger.NewConsoleLogger("error"), shards)
+	if err != nil {
+		t.Fatalf("NewShardedClient failed: %s", err)
+	}
+	if !start {
+		return sharded, clusters
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sharded.StartAndWait(ctx); err != nil {
+		t.Fatalf("StartAndWait failed: %s", err)
+	}
+	t.Cleanup(func() { sharded.Stop() })
+	if err := sharded.WaitForNodesContext(ctx, 3); err != nil {
+		t.Fatalf("WaitForNodesContext failed: %s", err)
+	}
+	return sharded, clusters
+}
+
+// shardsOf returns the shard of each of 1000 keys
+func shardsOf(sharded *memcacheha.ShardedClient) map[string]string {
+	shards := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		shards[key], _ = sharded.Shard(key)
+	}
+	return shards
+}
+
+func TestShardedClientKeys(t *testing.T) {
+	if _, err := memcacheha.NewShardedClient(nil, nil); err != memcacheha.ErrNoShards {
+		t.Fatalf("NewShardedClient without shards returned %v, expected ErrNoShards", err)
+	}
+
+	sharded, _ := newShardedClient(t, false, "a", "b", "c")
+	shards := shardsOf(sharded)
+	counts := map[string]int{}
+	for _, shard := range shards {
+		counts[shard]++
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if counts[name] < 250 || counts[name] > 420 {
+			t.Fatalf("Keys are spread %v over 3 shards", counts)
+		}
+	}
+
+	// Keys only move to an added shard, and only those of a removed shard move
+	added, _ := newShardedClient(t, false, "a", "b", "c", "d")
+	for key, shard := range shardsOf(added) {
+		if shard != shards[key] && shard != "d" {
+			t.Fatalf("%s moved from shard %s to %s when d was added", key, shards[key], shard)
+		}
+	}
+	removed, _ := newShardedClient(t, false, "a", "b")
+	for key, shard := range shardsOf(removed) {
+		if shard != shards[key] && shards[key] != "c" {
+			t.Fatalf("%s moved from shard %s to %s when c was removed", key, shards[key], shard)
+		}
+	}
+}
+
+func TestShardedClientOperations(t *testing.T) {
+	sharded, clusters := newShardedClient(t, true, "a", "b", "c")
+
+	// Each key is written to and read from all nodes of its own shard only
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := sharded.Set(&memcacheha.Item{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+		shard, client := sharded.Shard(key)
+		if client != sharded.Shards()[shard] {
+			t.Fatalf("Shard returned another client than Shards for %s", shard)
+		}
+		for name, cluster := range clusters {
+			if name == shard {
+				cluster.AssertValue(t, key, []byte(key))
+			} else {
+				cluster.AssertValue(t, key, nil)
+			}
+		}
+		if item, err := sharded.Get(key); err != nil || string(item.Value) != key {
+			t.Fatalf("Get returned %v, %v", item, err)
+		}
+	}
+
+	if err := sharded.Add(&memcacheha.Item{Key: "key0", Value: []byte("other")}); err != memcache.ErrNotStored {
+		t.Fatalf("Add of an existing key returned %v, expected ErrNotStored", err)
+	}
+	if item, err := sharded.AddOrGet(&memcacheha.Item{Key: "key0", Value: []byte("other")}); err != memcache.ErrNotStored || string(item.Value) != "key0" {
+		t.Fatalf("AddOrGet of an existing key returned %v, %v, expected the existing item and ErrNotStored", item, err)
+	}
+	if err := sharded.Set(&memcacheha.Item{Key: "counter", Value: []byte("5")}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if value, err := sharded.Increment("counter", 2); err != nil || value != 7 {
+		t.Fatalf("Increment returned %d, %v, expected 7", value, err)
+	}
+	if value, err := sharded.Decrement("counter", 3); err != nil || value != 4 {
+		t.Fatalf("Decrement returned %d, %v, expected 4", value, err)
+	}
+	if err := sharded.Touch("counter", 60); err != nil {
+		t.Fatalf("Touch failed: %s", err)
+	}
+	if err := sharded.Delete("counter"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if _, err := sharded.Get("counter"); err != memcache.ErrCacheMiss {
+		t.Fatalf("Get of a deleted key returned %v, expected ErrCacheMiss", err)
+	}
+
+	// Keys do not move to another shard when theirs fails
+	shard, _ := sharded.Shard("key0")
+	clusters[shard].Fail(errors.New("node failure"))
+	if _, err := sharded.Get("key0"); err == nil {
+		t.Fatal("Get of a key of a failed shard succeeded")
+	}
+	if moved, _ := sharded.Shard("key0"); moved != shard {
+		t.Fatalf("key0 moved from failed shard %s to %s", shard, moved)
+	}
+}
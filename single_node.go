@@ -0,0 +1,123 @@
+package memcacheha
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// SINGLE_NODE_MIN_REQUESTS is the number of node requests between healthchecks below which the error rate is not
+	// measured, see SingleNodeErrorRate
+	SINGLE_NODE_MIN_REQUESTS uint64 = 100
+	// SINGLE_NODE_MIN_PERIOD is the minimum time spent in single-node mode before fanning out again
+	SINGLE_NODE_MIN_PERIOD time.Duration = time.Duration(30 * time.Second)
+)
+
+// singleNodeMode is the state of the error budget of a client, see SingleNodeErrorRate
+type singleNodeMode struct {
+	mutex sync.Mutex
+	// endpoint is the node all requests are sent to, empty unless in single-node mode
+	endpoint string
+	since    time.Time
+	// baselines are the request and error counts of each node at the last healthcheck
+	baselines map[string][2]uint64
+}
+
+// SingleNode returns the endpoint of the node all requests are sent to while the client is in single-node mode, see
+// SingleNodeErrorRate, or an empty string if it is not
+func (client *Client) SingleNode() string {
+	client.singleNode.mutex.Lock()
+	defer client.singleNode.mutex.Unlock()
+	return client.singleNode.endpoint
+}
+
+// RequestCount returns the number of requests to this node, including healthchecks
+func (node *Node) RequestCount() uint64 {
+	return atomic.LoadUint64(&node.requestCount)
+}
+
+// errorRate returns the fraction of node requests that failed since the last call, or zero if there were fewer than
+// SINGLE_NODE_MIN_REQUESTS
+func (mode *singleNodeMode) errorRate(nodes map[string]*Node) float64 {
+	baselines := make(map[string][2]uint64, len(nodes))
+	var requests, errors uint64
+	for endpoint, node := range nodes {
+		current := [2]uint64{node.RequestCount(), node.ErrorCount()}
+		baselines[endpoint] = current
+		last := mode.baselines[endpoint]
+		if current[0] >= last[0] && current[1] >= last[1] {
+			requests += current[0] - last[0]
+			errors += current[1] - last[1]
+		}
+	}
+	mode.baselines = baselines
+	if requests < SINGLE_NODE_MIN_REQUESTS {
+		return 0
+	}
+	return float64(errors) / float64(requests)
+}
+
+// updateSingleNode enters single-node mode if the error rate of node requests since the last healthcheck exceeds
+// SingleNodeErrorRate, and leaves it once the rate falls below half of it, no majority of nodes is unreachable and
+// SINGLE_NODE_MIN_PERIOD has passed
+func (client *Client) updateSingleNode() {
+	client.configMutex.RLock()
+	threshold := client.SingleNodeErrorRate
+	client.configMutex.RUnlock()
+
+	nodes := client.Nodes.GetNodes()
+	now := client.Clock.Now()
+	mode := &client.singleNode
+	mode.mutex.Lock()
+	defer mode.mutex.Unlock()
+
+	rate := mode.errorRate(nodes)
+	if mode.endpoint == "" {
+		if threshold <= 0 || rate <= threshold || len(nodes) < 2 {
+			return
+		}
+		node := lowestLatencyNode(client.Nodes.GetHealthyNodes())
+		if node == nil {
+			return
+		}
+		mode.endpoint, mode.since = node.Endpoint, now
+		client.levelLog.Error("SingleNode: %.1f%% of node requests failed, sending all requests to Node %s", rate*100, node.Endpoint)
+		return
+	}
+
+	if threshold > 0 && (rate > threshold/2 || now.Sub(mode.since) < SINGLE_NODE_MIN_PERIOD || client.PartitionStatus() != nil) {
+		return
+	}
+	mode.endpoint = ""
+	client.levelLog.Info("SingleNode: %.1f%% of node requests failed, sending requests to all nodes", rate*100)
+}
+
+// singleNodeOf returns the node of nodes that all requests are sent to in single-node mode, choosing another if the
+// last one is no longer healthy, or nil if not in single-node mode
+func (client *Client) singleNodeOf(nodes map[string]*Node) *Node {
+	mode := &client.singleNode
+	mode.mutex.Lock()
+	defer mode.mutex.Unlock()
+	if mode.endpoint == "" {
+		return nil
+	}
+	if node, found := nodes[mode.endpoint]; found {
+		return node
+	}
+	node := lowestLatencyNode(nodes)
+	if node != nil {
+		client.levelLog.Warn("SingleNode: Node %s is unhealthy, sending all requests to Node %s", mode.endpoint, node.Endpoint)
+		mode.endpoint = node.Endpoint
+	}
+	return node
+}
+
+// lowestLatencyNode returns the node of nodes with the lowest p99 latency, or nil if there are none
+func lowestLatencyNode(nodes map[string]*Node) *Node {
+	selected := selectReadNodes("", nodes, 1, READ_SELECT_LATENCY)
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected[0]
+}
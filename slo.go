@@ -0,0 +1,128 @@
+package memcacheha
+
+import (
+	"sync"
+	"time"
+)
+
+// Objectives of a NodeSLOReport
+const (
+	// SLO_HEALTHCHECK_LATENCY is the latency of the last healthcheck of a node, see Client.SLOHealthCheckLatency
+	SLO_HEALTHCHECK_LATENCY = "healthcheck_latency"
+	// SLO_NODE_LATENCY is the p99 latency of requests to a node, see Client.SLONodeLatency
+	SLO_NODE_LATENCY = "node_latency"
+	// SLO_CLUSTER_LATENCY is the p99 latency of requests to all healthy nodes, see Client.SLOClusterLatency
+	SLO_CLUSTER_LATENCY = "cluster_latency"
+)
+
+// NodeSLOReport is a latency objective of a node or the cluster that was breached, or recovered, delivered to
+// Client.SLOViolationHook
+type NodeSLOReport struct {
+	Time time.Time `json:"time"`
+	// Endpoint is the node, or "" for SLO_CLUSTER_LATENCY
+	Endpoint  string        `json:"endpoint,omitempty"`
+	Objective string        `json:"objective"`
+	Latency   time.Duration `json:"latency"`
+	Threshold time.Duration `json:"threshold"`
+	// Violated is true when the objective is breached, and false when it is met again
+	Violated bool `json:"violated"`
+}
+
+// sloState holds the objectives currently breached, by objective and endpoint
+type sloState struct {
+	violated map[sloKey]bool
+	mutex    sync.Mutex
+}
+
+type sloKey struct {
+	objective string
+	endpoint  string
+}
+
+// update records whether the objective is breached, returning true if that changed
+func (state *sloState) update(key sloKey, violated bool) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.violated == nil {
+		state.violated = map[sloKey]bool{}
+	}
+	if state.violated[key] == violated {
+		return false
+	}
+	if violated {
+		state.violated[key] = true
+	} else {
+		delete(state.violated, key)
+	}
+	return true
+}
+
+// forget drops the objectives of nodes no longer in nodes, without reporting them
+func (state *sloState) forget(nodes map[string]*Node) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	for key := range state.violated {
+		if _, found := nodes[key.endpoint]; key.endpoint != "" && !found {
+			delete(state.violated, key)
+		}
+	}
+}
+
+// checkSLOs compares the latencies of the nodes and the cluster with the latency objectives, reporting each breach
+// and recovery to SLOViolationHook
+func (client *Client) checkSLOs() {
+	client.configMutex.RLock()
+	hook := client.SLOViolationHook
+	healthCheck, nodeLatency, clusterLatency := client.SLOHealthCheckLatency, client.SLONodeLatency, client.SLOClusterLatency
+	client.configMutex.RUnlock()
+	if hook == nil {
+		return
+	}
+
+	nodes := client.Nodes.GetNodes()
+	client.slos.forget(nodes)
+	now := client.Clock.Now()
+	var all []time.Duration
+	for endpoint, node := range nodes {
+		if history := node.History(); len(history) > 0 {
+			client.checkSLO(hook, now, SLO_HEALTHCHECK_LATENCY, endpoint, history[len(history)-1].Latency, healthCheck, true)
+		}
+		latencies := node.latencySamples()
+		if len(latencies) >= NODE_LATENCY_MIN_SAMPLES {
+			client.checkSLO(hook, now, SLO_NODE_LATENCY, endpoint, p99(latencies), nodeLatency, true)
+		}
+		if node.IsHealthy() {
+			all = append(all, latencies...)
+		}
+	}
+	client.checkSLO(hook, now, SLO_CLUSTER_LATENCY, "", p99(all), clusterLatency, len(all) >= NODE_LATENCY_MIN_SAMPLES)
+}
+
+// checkSLO reports a breach of threshold by latency, or its recovery, if known is true. A zero threshold recovers
+// any breach.
+func (client *Client) checkSLO(hook func(NodeSLOReport), now time.Time, objective string, endpoint string, latency time.Duration, threshold time.Duration, known bool) {
+	if !known {
+		return
+	}
+	violated := threshold > 0 && latency > threshold
+	if !client.slos.update(sloKey{objective: objective, endpoint: endpoint}, violated) {
+		return
+	}
+	target := "cluster"
+	if endpoint != "" {
+		target = "Node " + endpoint
+	}
+	if violated {
+		client.levelLog.Warn("SLO: %s %s %s exceeds %s", target, objective, latency, threshold)
+	} else {
+		client.levelLog.Info("SLO: %s %s %s met", target, objective, latency)
+	}
+	hook(NodeSLOReport{
+		Time:      now,
+		Endpoint:  endpoint,
+		Objective: objective,
+		Latency:   latency,
+		Threshold: threshold,
+		Violated:  violated,
+	})
+}
@@ -0,0 +1,74 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"context"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Structured log record messages, see LogHandler
+const (
+	LOG_RECORD_OPERATION  = "memcacheha operation"
+	LOG_RECORD_NODE_ERROR = "memcacheha node error"
+)
+
+// logOperation sends a record of a completed operation to LogHandler, at debug level, or warn level if it failed
+func (client *Client) logOperation(handler slog.Handler, clientID string, op string, key string, start time.Time, err error) {
+	level := slog.LevelDebug
+	if err != nil && err != memcache.ErrCacheMiss && err != memcache.ErrNotStored {
+		level = slog.LevelWarn
+	}
+	if !handler.Enabled(context.Background(), level) {
+		return
+	}
+
+	record := slog.NewRecord(time.Now(), level, LOG_RECORD_OPERATION, 0)
+	record.AddAttrs(
+		slog.String("op", op),
+		slog.String("key_hash", keyHash(key)),
+		slog.Duration("duration", client.Clock.Now().Sub(start)),
+	)
+	if clientID != "" {
+		record.AddAttrs(slog.String("client_id", clientID))
+	}
+	if err != nil {
+		record.AddAttrs(slog.String("error", err.Error()))
+	}
+	handler.Handle(context.Background(), record)
+}
+
+// logNodeError sends a record of a failed request to a node to LogHandler, at warn level
+func (client *Client) logNodeError(opID string, err error) {
+	client.configMutex.RLock()
+	handler, clientID := client.LogHandler, client.ClientID
+	client.configMutex.RUnlock()
+	if handler == nil || !handler.Enabled(context.Background(), slog.LevelWarn) {
+		return
+	}
+
+	record := slog.NewRecord(time.Now(), slog.LevelWarn, LOG_RECORD_NODE_ERROR, 0)
+	var nodeErr *NodeError
+	if errors.As(err, &nodeErr) {
+		record.AddAttrs(slog.String("node", nodeErr.Endpoint), slog.String("class", nodeErr.Class.Error()))
+	}
+	if opID != "" {
+		record.AddAttrs(slog.String("op_id", opID))
+	}
+	if clientID != "" {
+		record.AddAttrs(slog.String("client_id", clientID))
+	}
+	record.AddAttrs(slog.String("error", err.Error()))
+	handler.Handle(context.Background(), record)
+}
+
+// keyHash returns a short hash of key, so records can be correlated by key without logging keys
+func keyHash(key string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
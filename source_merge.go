@@ -0,0 +1,92 @@
+package memcacheha
+
+import (
+	"errors"
+)
+
+// SourceMergePolicy decides how the endpoints of multiple NodeSources are combined
+type SourceMergePolicy int
+
+const (
+	// SOURCE_MERGE_UNION uses the endpoints of all sources
+	SOURCE_MERGE_UNION SourceMergePolicy = iota
+	// SOURCE_MERGE_PRIORITY uses the endpoints of the first source that returns any, in the order the sources were given
+	SOURCE_MERGE_PRIORITY
+	// SOURCE_MERGE_INTERSECTION uses the endpoints returned by every source
+	SOURCE_MERGE_INTERSECTION
+)
+
+// ErrUnknownSourceMergePolicy is an error meaning a SourceMergePolicy name is not union, priority or intersection
+var ErrUnknownSourceMergePolicy = errors.New("memcacheha: unknown source merge policy")
+
+var sourceMergePolicyNames = map[SourceMergePolicy]string{
+	SOURCE_MERGE_UNION:        "union",
+	SOURCE_MERGE_PRIORITY:     "priority",
+	SOURCE_MERGE_INTERSECTION: "intersection",
+}
+
+// UnmarshalText parses a policy name: union, priority or intersection
+func (policy *SourceMergePolicy) UnmarshalText(text []byte) error {
+	for p, name := range sourceMergePolicyNames {
+		if name == string(text) {
+			*policy = p
+			return nil
+		}
+	}
+	return ErrUnknownSourceMergePolicy
+}
+
+// MarshalText returns the policy name
+func (policy SourceMergePolicy) MarshalText() ([]byte, error) {
+	name, found := sourceMergePolicyNames[policy]
+	if !found {
+		return nil, ErrUnknownSourceMergePolicy
+	}
+	return []byte(name), nil
+}
+
+// mergeSources combines the endpoints returned by each source according to policy. Endpoints are compared in their
+// normalized form.
+func mergeSources(policy SourceMergePolicy, sourceEndpoints [][]string) []string {
+	switch policy {
+	case SOURCE_MERGE_PRIORITY:
+		for _, endpoints := range sourceEndpoints {
+			if len(endpoints) > 0 {
+				return endpoints
+			}
+		}
+		return nil
+
+	case SOURCE_MERGE_INTERSECTION:
+		if len(sourceEndpoints) == 0 {
+			return nil
+		}
+		counts := map[string]int{}
+		for _, endpoints := range sourceEndpoints {
+			seen := map[string]bool{}
+			for _, endpoint := range endpoints {
+				normalized, err := NormalizeEndpoint(endpoint)
+				if err != nil || seen[normalized] {
+					continue
+				}
+				seen[normalized] = true
+				counts[normalized]++
+			}
+		}
+		var out []string
+		for _, endpoint := range sourceEndpoints[0] {
+			normalized, err := NormalizeEndpoint(endpoint)
+			if err == nil && counts[normalized] == len(sourceEndpoints) {
+				out = append(out, normalized)
+				counts[normalized] = 0
+			}
+		}
+		return out
+	}
+
+	var out []string
+	for _, endpoints := range sourceEndpoints {
+		out = append(out, endpoints...)
+	}
+	return out
+}
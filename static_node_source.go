@@ -1,15 +1,64 @@
 package memcacheha
 
-// StaticNodeSource represents a static list of nodes
-type StaticNodeSource []string
+import (
+	"sync"
+)
+
+// StaticNodeSource represents a static list of nodes, which can be changed at runtime with AddNode and RemoveNode.
+// It is safe for concurrent use.
+type StaticNodeSource struct {
+	nodes []string
+	mutex sync.RWMutex
+}
 
 // NewStaticNodeSource returns a new StaticNodeSource with the given endpoints
 func NewStaticNodeSource(nodes ...string) *StaticNodeSource {
-	staticNodeSource := StaticNodeSource(nodes)
-	return &staticNodeSource
+	return &StaticNodeSource{
+		nodes: append([]string{}, nodes...),
+	}
 }
 
 // GetNodes implements NodeSource, return a slice of configured endpoints
 func (staticNodeSource *StaticNodeSource) GetNodes() ([]string, error) {
-	return *staticNodeSource, nil
+	staticNodeSource.mutex.RLock()
+	defer staticNodeSource.mutex.RUnlock()
+	return append([]string{}, staticNodeSource.nodes...), nil
+}
+
+// AddNode adds the given endpoint, if not already present. Clients add the node on their next discovery, or call
+// Client.GetNodes to add it immediately. ErrInvalidEndpoint is returned if the endpoint is not a valid host:port.
+func (staticNodeSource *StaticNodeSource) AddNode(endpoint string) error {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+
+	staticNodeSource.mutex.Lock()
+	defer staticNodeSource.mutex.Unlock()
+	for _, node := range staticNodeSource.nodes {
+		if n, _ := NormalizeEndpoint(node); n == normalized {
+			return nil
+		}
+	}
+	staticNodeSource.nodes = append(staticNodeSource.nodes, endpoint)
+	return nil
+}
+
+// RemoveNode removes the given endpoint, returning false if it was not present. Clients remove the node on their
+// next discovery.
+func (staticNodeSource *StaticNodeSource) RemoveNode(endpoint string) bool {
+	normalized, err := NormalizeEndpoint(endpoint)
+	if err != nil {
+		return false
+	}
+
+	staticNodeSource.mutex.Lock()
+	defer staticNodeSource.mutex.Unlock()
+	for i, node := range staticNodeSource.nodes {
+		if n, _ := NormalizeEndpoint(node); n == normalized {
+			staticNodeSource.nodes = append(staticNodeSource.nodes[:i:i], staticNodeSource.nodes[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,156 @@
+package memcacheha
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	// STREAM_CHUNK_SIZE is the size of the chunks written by SetReader, below the 1MB default item size of memcached
+	STREAM_CHUNK_SIZE = 512 * 1024
+	// STREAM_CHUNK_SUFFIX is appended to a stream key, with the stream generation and chunk number, to form the key
+	// of each chunk
+	STREAM_CHUNK_SUFFIX = "_chunk_"
+)
+
+// STREAM_HEADER starts the manifest of a stream written with SetReader
+var STREAM_HEADER []byte = []byte{0x6d, 0x68, 0x73, 0x01}
+
+// ErrNotStream is an error meaning a key was not written with SetReader
+var ErrNotStream = errors.New("memcacheha: not a stream")
+
+// SetReader writes size bytes read from r to the given key, in chunks of STREAM_CHUNK_SIZE written as separate keys,
+// so large values need not be held in memory. The chunks are followed by a manifest at key, so readers see either
+// the previous or the new value. The chunks of a previous value are left to expire. A ttl of zero means no expiry.
+// io.ErrUnexpectedEOF is returned if r ends before size bytes, and the error of ValidateKey, before anything is written,
+// if key is invalid.
+func (client *Client) SetReader(key string, r io.Reader, size int64, ttl time.Duration, opts ...WriteOption) error {
+	if _, err := client.mapKey(key); err != nil {
+		return err
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	generation := hex.EncodeToString(random)
+
+	var expiration *time.Time
+	if ttl > 0 {
+		x := client.Clock.Now().Add(ttl)
+		expiration = &x
+	}
+
+	chunks := 0
+	for written := int64(0); written < size; chunks++ {
+		n := int64(STREAM_CHUNK_SIZE)
+		if size-written < n {
+			n = size - written
+		}
+		// Each chunk has its own buffer, as nodes beyond the quorum may still be writing the last one
+		value := make([]byte, n)
+		if _, err := io.ReadFull(r, value); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		chunk := &Item{Key: streamChunkKey(key, generation, chunks), Value: value, Expiration: expiration}
+		if err := client.Set(chunk, opts...); err != nil {
+			return err
+		}
+		written += n
+	}
+
+	manifest := make([]byte, len(STREAM_HEADER)+24)
+	copy(manifest, STREAM_HEADER)
+	binary.BigEndian.PutUint64(manifest[len(STREAM_HEADER):], uint64(size))
+	binary.BigEndian.PutUint64(manifest[len(STREAM_HEADER)+8:], uint64(chunks))
+	copy(manifest[len(STREAM_HEADER)+16:], random)
+	return client.Set(&Item{Key: key, Value: manifest, Expiration: expiration}, opts...)
+}
+
+// GetReader returns a reader of the value written to the given key with SetReader, reading each chunk when needed.
+// memcache.ErrCacheMiss is returned if the key is not found, and ErrNotStream if it was not written with SetReader.
+// The reader returns io.ErrUnexpectedEOF if a chunk has been evicted.
+func (client *Client) GetReader(key string, opts ...ReadOption) (io.ReadCloser, error) {
+	item, err := client.Get(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	value := item.Value
+	if len(value) != len(STREAM_HEADER)+24 || !hasHeader(value, STREAM_HEADER) {
+		return nil, ErrNotStream
+	}
+	value = value[len(STREAM_HEADER):]
+	return &streamReader{
+		client:     client,
+		key:        key,
+		size:       int64(binary.BigEndian.Uint64(value[0:8])),
+		chunks:     int(binary.BigEndian.Uint64(value[8:16])),
+		generation: hex.EncodeToString(value[16:24]),
+		opts:       opts,
+	}, nil
+}
+
+// streamChunkKey returns the key of chunk n of a stream. The stream key is replaced by its HashKey if the chunk key
+// would otherwise exceed MAX_KEY_LENGTH.
+func streamChunkKey(key string, generation string, n int) string {
+	suffix := STREAM_CHUNK_SUFFIX + generation + "_" + strconv.Itoa(n)
+	if len(key)+len(suffix) > MAX_KEY_LENGTH {
+		key = HashKey(key)
+	}
+	return key + suffix
+}
+
+// streamReader reads the chunks of a stream in order
+type streamReader struct {
+	client     *Client
+	key        string
+	size       int64
+	chunks     int
+	generation string
+	opts       []ReadOption
+
+	next   int
+	read   int64
+	buffer []byte
+}
+
+func (reader *streamReader) Read(p []byte) (int, error) {
+	if len(reader.buffer) == 0 {
+		if reader.next >= reader.chunks {
+			if reader.read != reader.size {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		}
+		item, err := reader.client.Get(streamChunkKey(reader.key, reader.generation, reader.next), reader.opts...)
+		if err == memcache.ErrCacheMiss {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		reader.buffer = item.Value
+		reader.next++
+	}
+	n := copy(p, reader.buffer)
+	reader.buffer = reader.buffer[n:]
+	reader.read += int64(n)
+	return n, nil
+}
+
+// Close releases the chunk being read
+func (reader *streamReader) Close() error {
+	reader.buffer = nil
+	reader.next = reader.chunks
+	return nil
+}
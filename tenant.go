@@ -0,0 +1,125 @@
+package memcacheha
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TenantQuota limits the operations of a tenant of a shared client, see Client.TenantQuotas. Zero means no limit.
+type TenantQuota struct {
+	// OpsPerSecond limits the rate of operations
+	OpsPerSecond float64 `json:"ops_per_second,omitempty" yaml:"ops_per_second,omitempty" env:"OPS_PER_SECOND"`
+	// BytesPerSecond limits the rate of value bytes written. A value larger than BytesPerSecond is always rejected.
+	BytesPerSecond float64 `json:"bytes_per_second,omitempty" yaml:"bytes_per_second,omitempty" env:"BYTES_PER_SECOND"`
+	// MaxValueSize limits the size in bytes of each value written
+	MaxValueSize int `json:"max_value_size,omitempty" yaml:"max_value_size,omitempty" env:"MAX_VALUE_SIZE"`
+}
+
+// TenantCounts are the operations of a tenant allowed and rejected by its quota, and the value bytes it wrote
+type TenantCounts struct {
+	Allowed      uint64 `json:"allowed"`
+	Rejected     uint64 `json:"rejected"`
+	BytesWritten uint64 `json:"bytes_written"`
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the ID of the tenant, e.g. the caller or code path, whose operations
+// performed with it are subject to its TenantQuota, see WithContext
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant set on ctx with WithTenant, or ""
+func Tenant(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantBucket holds the token buckets of one tenant, refilled at the rates of its quota
+type tenantBucket struct {
+	ops   float64
+	bytes float64
+	last  time.Time
+}
+
+// tenantThrottle enforces TenantQuotas with a token bucket per tenant and per limit
+type tenantThrottle struct {
+	buckets map[string]*tenantBucket
+	mutex   sync.Mutex
+}
+
+func newTenantThrottle() *tenantThrottle {
+	return &tenantThrottle{buckets: map[string]*tenantBucket{}}
+}
+
+// allow returns true and takes an operation and size bytes from the buckets of tenant if they are within quota
+func (throttle *tenantThrottle) allow(tenant string, quota TenantQuota, size int, now time.Time) bool {
+	if quota.MaxValueSize > 0 && size > quota.MaxValueSize {
+		return false
+	}
+	if quota.OpsPerSecond <= 0 && quota.BytesPerSecond <= 0 {
+		return true
+	}
+
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	bucket, found := throttle.buckets[tenant]
+	if !found {
+		// Buckets start full, holding at most one second of operations and bytes
+		bucket = &tenantBucket{ops: quota.OpsPerSecond, bytes: quota.BytesPerSecond}
+		throttle.buckets[tenant] = bucket
+	} else {
+		elapsed := now.Sub(bucket.last).Seconds()
+		bucket.ops = refill(bucket.ops, elapsed, quota.OpsPerSecond)
+		bucket.bytes = refill(bucket.bytes, elapsed, quota.BytesPerSecond)
+	}
+	bucket.last = now
+
+	if (quota.OpsPerSecond > 0 && bucket.ops < 1) || (quota.BytesPerSecond > 0 && size > 0 && bucket.bytes < float64(size)) {
+		return false
+	}
+	if quota.OpsPerSecond > 0 {
+		bucket.ops--
+	}
+	if quota.BytesPerSecond > 0 {
+		bucket.bytes -= float64(size)
+	}
+	return true
+}
+
+// refill returns tokens refilled at perSecond for elapsed seconds, holding at most one second
+func refill(tokens float64, elapsed float64, perSecond float64) float64 {
+	tokens += elapsed * perSecond
+	if tokens > perSecond {
+		tokens = perSecond
+	}
+	return tokens
+}
+
+// checkQuota returns ErrQuotaExceeded if an operation of the tenant of ctx, writing size bytes, exceeds its
+// TenantQuota, counting it in Metrics. Operations without a tenant are not limited.
+func (client *Client) checkQuota(ctx context.Context, size int) error {
+	tenant := Tenant(ctx)
+	if tenant == "" {
+		return nil
+	}
+	client.configMutex.RLock()
+	quota, found := client.TenantQuotas[tenant]
+	if !found {
+		quota = client.DefaultTenantQuota
+	}
+	client.configMutex.RUnlock()
+
+	allowed := client.tenants.allow(tenant, quota, size, client.Clock.Now())
+	client.metrics.recordTenant(tenant, size, allowed)
+	if !allowed {
+		client.levelLog.Debug("Quota: Rejected operation of tenant %s", tenant)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
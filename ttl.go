@@ -0,0 +1,43 @@
+package memcacheha
+
+import (
+	"time"
+)
+
+// applyTTL returns item written at now with an expiry of DefaultTTL if it has none, limited to MaxTTL. The given
+// item is not modified.
+func (options *WriteOptions) applyTTL(item *Item, now time.Time) *Item {
+	expiration := options.clampExpiration(item.Expiration, now)
+	if expiration == item.Expiration {
+		return item
+	}
+	clamped := *item
+	clamped.Expiration = expiration
+	return &clamped
+}
+
+// clampExpiration returns the expiry of a write at now with the given expiry, nil for none, after DefaultTTL and
+// MaxTTL
+func (options *WriteOptions) clampExpiration(expiration *time.Time, now time.Time) *time.Time {
+	if expiration == nil && options.DefaultTTL > 0 {
+		x := now.Add(options.DefaultTTL)
+		expiration = &x
+	}
+	if options.MaxTTL > 0 {
+		if max := now.Add(options.MaxTTL); expiration == nil || expiration.After(max) {
+			expiration = &max
+		}
+	}
+	return expiration
+}
+
+// clampSeconds returns the memcached expiration value of a Touch at now with the given value, after DefaultTTL and
+// MaxTTL
+func (options *WriteOptions) clampSeconds(seconds int32, now time.Time) int32 {
+	expiration := secondsToExpiration(seconds, now)
+	clamped := options.clampExpiration(expiration, now)
+	if clamped == expiration {
+		return seconds
+	}
+	return expirationToSeconds(clamped, now)
+}
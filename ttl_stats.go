@@ -0,0 +1,156 @@
+package memcacheha
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// TTL_HISTOGRAM_BUCKETS are the upper bounds of the TTL histogram buckets of TTLStats. TTLs above the last bound
+	// are counted in a final bucket bounded by the maximum TTL.
+	TTL_HISTOGRAM_BUCKETS = []time.Duration{
+		time.Minute,
+		5 * time.Minute,
+		15 * time.Minute,
+		time.Hour,
+		6 * time.Hour,
+		24 * time.Hour,
+		7 * 24 * time.Hour,
+		30 * 24 * time.Hour,
+	}
+	// EXPIRY_FORECAST_BUCKET is the width of each bucket of the expiry forecast of TTLStats
+	EXPIRY_FORECAST_BUCKET time.Duration = time.Duration(time.Minute)
+	// EXPIRY_FORECAST_BUCKETS is the number of buckets of the expiry forecast, which covers EXPIRY_FORECAST_BUCKETS
+	// times EXPIRY_FORECAST_BUCKET from now
+	EXPIRY_FORECAST_BUCKETS = 60
+)
+
+// TTLBucket is the number of sampled writes with a TTL within UpperBound, and above the previous bucket
+type TTLBucket struct {
+	UpperBound time.Duration `json:"upper_bound"`
+	Count      uint64        `json:"count"`
+}
+
+// ExpiryForecast is the estimated number of items written by the client that expire between Start and End
+type ExpiryForecast struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Expiring uint64    `json:"expiring"`
+}
+
+// TTLStats are the TTLs of the writes sampled by a client since it was created, see TTLSampleRate
+type TTLStats struct {
+	ClientID string `json:"client_id,omitempty"`
+	// Sampled is the number of successful writes sampled, of which NoExpiry had no expiry
+	Sampled  uint64 `json:"sampled"`
+	NoExpiry uint64 `json:"no_expiry"`
+	// Buckets are the histogram of the TTLs of sampled writes with an expiry, and Max the highest TTL
+	Buckets []TTLBucket   `json:"buckets"`
+	Max     time.Duration `json:"max"`
+	// Forecast estimates the items expiring in each EXPIRY_FORECAST_BUCKET from now, scaled up from the samples by
+	// TTLSampleRate. Items overwritten, deleted or evicted since they were written are still counted.
+	Forecast []ExpiryForecast `json:"forecast"`
+}
+
+// ttlSampler holds the sampled TTLs of a client
+type ttlSampler struct {
+	mutex    sync.Mutex
+	sampled  uint64
+	noExpiry uint64
+	buckets  []uint64
+	max      time.Duration
+	// expiring are the scaled-up numbers of items expiring in each forecast bucket, keyed by the start of the
+	// bucket in Unix nanoseconds
+	expiring map[int64]float64
+}
+
+func newTTLSampler() *ttlSampler {
+	return &ttlSampler{
+		buckets:  make([]uint64, len(TTL_HISTOGRAM_BUCKETS)+1),
+		expiring: map[int64]float64{},
+	}
+}
+
+// record counts a write at now with the given expiry, nil for none, sampled at rate
+func (sampler *ttlSampler) record(expiration *time.Time, now time.Time, rate float64) {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	sampler.sampled++
+	if expiration == nil {
+		sampler.noExpiry++
+		return
+	}
+
+	ttl := expiration.Sub(now)
+	bucket := len(TTL_HISTOGRAM_BUCKETS)
+	for i, bound := range TTL_HISTOGRAM_BUCKETS {
+		if ttl <= bound {
+			bucket = i
+			break
+		}
+	}
+	sampler.buckets[bucket]++
+	if ttl > sampler.max {
+		sampler.max = ttl
+	}
+
+	if ttl < time.Duration(EXPIRY_FORECAST_BUCKETS)*EXPIRY_FORECAST_BUCKET {
+		sampler.expiring[expiration.Truncate(EXPIRY_FORECAST_BUCKET).UnixNano()] += 1 / rate
+	}
+}
+
+// stats returns the TTL histogram, and the expiry forecast from now, removing forecast buckets that have passed
+func (sampler *ttlSampler) stats(now time.Time) TTLStats {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+
+	stats := TTLStats{Sampled: sampler.sampled, NoExpiry: sampler.noExpiry, Max: sampler.max}
+	for i, count := range sampler.buckets {
+		bound := sampler.max
+		if i < len(TTL_HISTOGRAM_BUCKETS) {
+			bound = TTL_HISTOGRAM_BUCKETS[i]
+		}
+		stats.Buckets = append(stats.Buckets, TTLBucket{UpperBound: bound, Count: count})
+	}
+
+	current := now.Truncate(EXPIRY_FORECAST_BUCKET)
+	for start, expiring := range sampler.expiring {
+		if start < current.UnixNano() {
+			delete(sampler.expiring, start)
+			continue
+		}
+		bucketStart := time.Unix(0, start)
+		stats.Forecast = append(stats.Forecast, ExpiryForecast{
+			Start:    bucketStart,
+			End:      bucketStart.Add(EXPIRY_FORECAST_BUCKET),
+			Expiring: uint64(expiring + 0.5),
+		})
+	}
+	sort.Slice(stats.Forecast, func(i, j int) bool { return stats.Forecast[i].Start.Before(stats.Forecast[j].Start) })
+	return stats
+}
+
+// TTLStats returns the histogram of the TTLs of sampled writes and the forecast of their expiry, see TTLSampleRate
+func (client *Client) TTLStats() TTLStats {
+	client.configMutex.RLock()
+	clientID := client.ClientID
+	client.configMutex.RUnlock()
+
+	stats := client.ttls.stats(client.Clock.Now())
+	stats.ClientID = clientID
+	return stats
+}
+
+// sampleTTL records the expiry of a successful Set or Add of item, after DefaultTTL and MaxTTL, for rate of writes
+func (client *Client) sampleTTL(op string, key string, item *Item, err error, rate float64) {
+	if (op != OP_SET && op != OP_ADD) || item == nil || err != nil {
+		return
+	}
+	if rate < 1 && randFloat64() >= rate {
+		return
+	}
+	now := client.Clock.Now()
+	expiration := client.newWriteOptions(key, nil).clampExpiration(item.Expiration, now)
+	client.ttls.record(expiration, now, rate)
+}
@@ -0,0 +1,107 @@
+package memcacheha
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// NodeUsage is the number of items and memory of one node, see Client.Usage
+type NodeUsage struct {
+	Endpoint string `json:"endpoint"`
+	// Items is the number of items on the node, from the curr_items stat
+	Items uint64 `json:"items"`
+	// Bytes is the memory used by the items, including memcached overhead, from the bytes stat
+	Bytes uint64 `json:"bytes"`
+	// LimitBytes is the memory the node may use for items, from the limit_maxbytes stat, or zero if unlimited
+	LimitBytes uint64 `json:"limit_bytes,omitempty"`
+	// Error is the error reading the stats of the node, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ClusterUsage is the logical size of the cache, see Client.Usage
+type ClusterUsage struct {
+	// Items and Bytes estimate the unique items in the cache and their memory. As every item is written to every
+	// node, they are those of the node holding the most items; items on nodes that missed writes, or pinned to other
+	// nodes, are not counted.
+	Items uint64 `json:"items"`
+	Bytes uint64 `json:"bytes"`
+	// LimitBytes is the smallest memory limit of the nodes, which bounds the logical size of the cache, or zero if
+	// no node is limited
+	LimitBytes uint64 `json:"limit_bytes,omitempty"`
+	// RawItems and RawBytes are the totals of all nodes, counting every copy of an item
+	RawItems uint64 `json:"raw_items"`
+	RawBytes uint64 `json:"raw_bytes"`
+	// Nodes are the usage of each healthy node, sorted by endpoint
+	Nodes []NodeUsage `json:"nodes"`
+}
+
+// Usage reads the stats of the healthy nodes and estimates the number of unique items in the cache and their memory,
+// rather than the sum over nodes, which counts each item once per node. ErrNoHealthyNodes is returned if there are no
+// healthy nodes, and ErrAllNodesFailed with the usage if no node's stats could be read. Nodes whose NodeClient is not
+// a NodeStatter fail with ErrStatsUnsupported.
+func (client *Client) Usage() (ClusterUsage, error) {
+	nodes := client.Nodes.GetHealthyNodes()
+	if len(nodes) == 0 {
+		return ClusterUsage{}, ErrNoHealthyNodes
+	}
+
+	usage := ClusterUsage{}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	errs := map[string]error{}
+	for endpoint, node := range nodes {
+		wg.Add(1)
+		go func(endpoint string, node *Node) {
+			defer wg.Done()
+			nodeUsage, err := node.usage()
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs[endpoint] = err
+				nodeUsage.Error = err.Error()
+			}
+			usage.Nodes = append(usage.Nodes, nodeUsage)
+		}(endpoint, node)
+	}
+	wg.Wait()
+	sort.Slice(usage.Nodes, func(i, j int) bool { return usage.Nodes[i].Endpoint < usage.Nodes[j].Endpoint })
+
+	for _, nodeUsage := range usage.Nodes {
+		if nodeUsage.Error != "" {
+			continue
+		}
+		usage.RawItems += nodeUsage.Items
+		usage.RawBytes += nodeUsage.Bytes
+		if nodeUsage.Items > usage.Items || (nodeUsage.Items == usage.Items && nodeUsage.Bytes > usage.Bytes) {
+			usage.Items, usage.Bytes = nodeUsage.Items, nodeUsage.Bytes
+		}
+		if nodeUsage.LimitBytes > 0 && (usage.LimitBytes == 0 || nodeUsage.LimitBytes < usage.LimitBytes) {
+			usage.LimitBytes = nodeUsage.LimitBytes
+		}
+	}
+	if len(errs) == len(nodes) {
+		return usage, &ErrAllNodesFailed{Errors: errs}
+	}
+	if len(errs) > 0 {
+		client.levelLog.Debug("Usage: Reading stats of %d of %d nodes failed", len(errs), len(nodes))
+	}
+	return usage, nil
+}
+
+// usage reads the item count and memory of this node from the stats of its server
+func (node *Node) usage() (NodeUsage, error) {
+	usage := NodeUsage{Endpoint: node.Endpoint}
+	statter, ok := node.getClient().(NodeStatter)
+	if !ok {
+		return usage, ErrStatsUnsupported
+	}
+	stats, err := statter.Stats()
+	if err != nil {
+		return usage, err
+	}
+	usage.Items, _ = strconv.ParseUint(stats["curr_items"], 10, 64)
+	usage.Bytes, _ = strconv.ParseUint(stats["bytes"], 10, 64)
+	usage.LimitBytes, _ = strconv.ParseUint(stats["limit_maxbytes"], 10, 64)
+	return usage, nil
+}
@@ -0,0 +1,110 @@
+package memcacheha
+
+import (
+	"strings"
+)
+
+// SIZE_HISTOGRAM_BUCKETS are the upper bounds in bytes of the value size histogram buckets of Metrics. Sizes above
+// the last bound are counted in a final bucket bounded by the maximum size.
+var SIZE_HISTOGRAM_BUCKETS = []int{
+	64,
+	256,
+	1024,
+	4 * 1024,
+	16 * 1024,
+	64 * 1024,
+	256 * 1024,
+	1024 * 1024,
+}
+
+// SizeBucket is the number of values written with a size within UpperBound bytes, and above the previous bucket
+type SizeBucket struct {
+	UpperBound int    `json:"upper_bound"`
+	Count      uint64 `json:"count"`
+}
+
+// SizeHistogram is the distribution of the sizes in bytes of the values written by successful Sets and Adds.
+// Percentiles are the upper bound of the bucket they fall in.
+type SizeHistogram struct {
+	Count   uint64       `json:"count"`
+	Sum     uint64       `json:"sum"`
+	Max     int          `json:"max"`
+	P50     int          `json:"p50"`
+	P90     int          `json:"p90"`
+	P99     int          `json:"p99"`
+	Buckets []SizeBucket `json:"buckets"`
+}
+
+// sizeMetrics are the counts of value sizes in each bucket of SIZE_HISTOGRAM_BUCKETS
+type sizeMetrics struct {
+	buckets []uint64
+	count   uint64
+	sum     uint64
+	max     int
+}
+
+func newSizeMetrics() *sizeMetrics {
+	return &sizeMetrics{buckets: make([]uint64, len(SIZE_HISTOGRAM_BUCKETS)+1)}
+}
+
+// add counts a value of size bytes
+func (m *sizeMetrics) add(size int) {
+	bucket := len(SIZE_HISTOGRAM_BUCKETS)
+	for i, bound := range SIZE_HISTOGRAM_BUCKETS {
+		if size <= bound {
+			bucket = i
+			break
+		}
+	}
+	m.buckets[bucket]++
+	m.count++
+	m.sum += uint64(size)
+	if size > m.max {
+		m.max = size
+	}
+}
+
+// histogram returns the size histogram of m
+func (m *sizeMetrics) histogram() SizeHistogram {
+	histogram := SizeHistogram{Count: m.count, Sum: m.sum, Max: m.max}
+	for i, count := range m.buckets {
+		histogram.Buckets = append(histogram.Buckets, SizeBucket{UpperBound: m.bound(i), Count: count})
+	}
+	histogram.P50, histogram.P90, histogram.P99 = m.percentile(50), m.percentile(90), m.percentile(99)
+	return histogram
+}
+
+// bound returns the upper bound of bucket i, the maximum size for the last
+func (m *sizeMetrics) bound(i int) int {
+	if i < len(SIZE_HISTOGRAM_BUCKETS) {
+		return SIZE_HISTOGRAM_BUCKETS[i]
+	}
+	return m.max
+}
+
+// percentile returns the upper bound of the bucket holding the size at percentile p, at most the maximum size
+func (m *sizeMetrics) percentile(p uint64) int {
+	rank := (m.count*p + 99) / 100
+	var cumulative uint64
+	for i, count := range m.buckets {
+		cumulative += count
+		if cumulative >= rank && cumulative > 0 {
+			if bound := m.bound(i); bound < m.max {
+				return bound
+			}
+			break
+		}
+	}
+	return m.max
+}
+
+// sizePrefix returns the longest of prefixes that key starts with, and false if there is none
+func sizePrefix(prefixes []string, key string) (string, bool) {
+	longest, found := "", false
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) && (!found || len(prefix) > len(longest)) {
+			longest, found = prefix, true
+		}
+	}
+	return longest, found
+}
@@ -0,0 +1,142 @@
+package memcacheha
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WARM_PROGRESS_PERIOD is the period between progress callbacks during Warm
+var WARM_PROGRESS_PERIOD time.Duration = time.Duration(1 * time.Second)
+
+// WarmStats reports the progress of a Warm
+type WarmStats struct {
+	// Items is the number of items written to at least one node
+	Items int64
+	// Errors is the number of items that could not be written to any node
+	Errors int64
+	// NodeErrors is the number of failed writes per node endpoint
+	NodeErrors map[string]int64
+	// Elapsed is the time since Warm was called
+	Elapsed time.Duration
+}
+
+// Warm writes all items received from the items channel to all healthy nodes, with at most concurrency items in
+// flight, until the channel is closed or ctx is done. If progress is not nil, it is called every WARM_PROGRESS_PERIOD
+// and once on completion. The final stats are returned, with ctx.Err() if ctx was done before the channel was closed.
+func (client *Client) Warm(ctx context.Context, items <-chan *Item, concurrency int, progress func(WarmStats)) (*WarmStats, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	start := time.Now()
+	stats := &WarmStats{NodeErrors: map[string]int64{}}
+	var mutex sync.Mutex
+	snapshot := func() WarmStats {
+		mutex.Lock()
+		defer mutex.Unlock()
+		out := *stats
+		out.NodeErrors = map[string]int64{}
+		for endpoint, count := range stats.NodeErrors {
+			out.NodeErrors[endpoint] = count
+		}
+		out.Elapsed = time.Since(start)
+		return out
+	}
+
+	// Report progress periodically
+	progressDone := make(chan struct{})
+	if progress != nil {
+		go func() {
+			ticker := time.NewTicker(WARM_PROGRESS_PERIOD)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					progress(snapshot())
+				case <-progressDone:
+					return
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var item *Item
+				var ok bool
+				select {
+				case item, ok = <-items:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+				nodeErrors, err := client.warmItem(item)
+				mutex.Lock()
+				if err != nil {
+					stats.Errors++
+				} else {
+					stats.Items++
+				}
+				for _, endpoint := range nodeErrors {
+					stats.NodeErrors[endpoint]++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(progressDone)
+
+	final := snapshot()
+	if progress != nil {
+		progress(final)
+	}
+	return &final, ctx.Err()
+}
+
+// warmItem writes item to all healthy nodes, returning the endpoints of nodes that failed and an error if all failed
+func (client *Client) warmItem(item *Item) ([]string, error) {
+	if client.Disabled() {
+		return nil, nil
+	}
+	nodes := client.getHealthyNodes(item.Key)
+	item, err := client.mapItem(client.newWriteOptions(item.Key, nil).applyTTL(item, client.Clock.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	nodeCount := len(nodes)
+	if nodeCount == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	opID := newOperationID()
+	statusChan := make(chan (*NodeResponse), nodeCount)
+	for _, node := range nodes {
+		node.set(opID, item, statusChan)
+	}
+
+	var failed []string
+	var lastErr error
+	for ; nodeCount > 0; nodeCount-- {
+		response := <-statusChan
+		if response.Error != nil {
+			failed = append(failed, response.Node.Endpoint)
+			lastErr = response.Error
+		}
+		releaseNodeResponse(response)
+	}
+
+	if len(failed) == len(nodes) {
+		return failed, lastErr
+	}
+	return failed, nil
+}
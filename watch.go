@@ -0,0 +1,195 @@
+package memcacheha
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	// WATCH_RETRY_DELAY is the delay before resubscribing to a WatchableSource whose subscription failed or ended.
+	// The source is polled as other sources are until then.
+	WATCH_RETRY_DELAY time.Duration = time.Duration(5 * time.Second)
+)
+
+// WatchableSource is a NodeSource that pushes changes of its nodes, e.g. from Consul, etcd or Kubernetes. Clients
+// subscribe to it rather than polling it, and discover nodes as soon as an update is received. The channel should
+// send the complete list of nodes on each change, starting with the current list, and be closed when ctx is done.
+type WatchableSource interface {
+	NodeSource
+	Subscribe(ctx context.Context) (<-chan []NodeSpec, error)
+}
+
+// sourceWatch is a subscription to a WatchableSource
+type sourceWatch struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// specs are the nodes of the last update, valid if received is true
+	specs    []NodeSpec
+	received bool
+}
+
+// sourceWatches are the subscriptions of a client to its WatchableSources, keyed by source
+type sourceWatches struct {
+	mutex   sync.Mutex
+	watches map[NodeSource]*sourceWatch
+}
+
+func newSourceWatches() *sourceWatches {
+	return &sourceWatches{watches: map[NodeSource]*sourceWatch{}}
+}
+
+// specs returns the nodes of the last update received from source, and false if it isn't subscribed to or no update
+// has been received, in which case it is polled
+func (watches *sourceWatches) specs(source NodeSource) ([]NodeSpec, bool) {
+	if !reflect.TypeOf(source).Comparable() {
+		return nil, false
+	}
+	watches.mutex.Lock()
+	defer watches.mutex.Unlock()
+	watch, found := watches.watches[source]
+	if !found || !watch.received {
+		return nil, false
+	}
+	return watch.specs, true
+}
+
+// update records the nodes of an update from source, returning false if it is no longer subscribed to
+func (watches *sourceWatches) update(source NodeSource, watch *sourceWatch, specs []NodeSpec, received bool) bool {
+	watches.mutex.Lock()
+	defer watches.mutex.Unlock()
+	if watches.watches[source] != watch {
+		return false
+	}
+	watch.specs, watch.received = specs, received
+	return true
+}
+
+// syncWatches subscribes to the WatchableSources of this client not yet subscribed to, and cancels the subscriptions
+// of sources that have been removed, waiting for them to end
+func (client *Client) syncWatches() {
+	client.configMutex.RLock()
+	sources := client.Sources
+	client.configMutex.RUnlock()
+
+	current := map[NodeSource]bool{}
+	var removed []*sourceWatch
+	client.watches.mutex.Lock()
+	for _, source := range sources {
+		watchable, ok := source.(WatchableSource)
+		if !ok || !reflect.TypeOf(source).Comparable() {
+			continue
+		}
+		current[source] = true
+		if _, found := client.watches.watches[source]; found {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		watch := &sourceWatch{cancel: cancel, done: make(chan struct{})}
+		client.watches.watches[source] = watch
+		go client.watchSource(ctx, watchable, watch)
+	}
+	for source, watch := range client.watches.watches {
+		if !current[source] {
+			watch.cancel()
+			delete(client.watches.watches, source)
+			removed = append(removed, watch)
+		}
+	}
+	client.watches.mutex.Unlock()
+
+	for _, watch := range removed {
+		<-watch.done
+	}
+}
+
+// stopWatches cancels all subscriptions, waiting for them to end
+func (client *Client) stopWatches() {
+	client.watches.mutex.Lock()
+	watches := client.watches.watches
+	client.watches.watches = map[NodeSource]*sourceWatch{}
+	client.watches.mutex.Unlock()
+
+	for _, watch := range watches {
+		watch.cancel()
+		<-watch.done
+	}
+}
+
+// watchSource receives the updates of source until ctx is done, discovering nodes on each. A failed or ended
+// subscription is retried after WATCH_RETRY_DELAY.
+func (client *Client) watchSource(ctx context.Context, source WatchableSource, watch *sourceWatch) {
+	defer close(watch.done)
+	name := fmt.Sprintf("%T", source)
+	for {
+		client.receiveUpdates(ctx, source, watch, name)
+		// Poll the source until resubscribed
+		client.watches.update(source, watch, nil, false)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(WATCH_RETRY_DELAY):
+		}
+	}
+}
+
+// receiveUpdates subscribes to source and discovers nodes on each update, returning when the subscription ends
+func (client *Client) receiveUpdates(ctx context.Context, source WatchableSource, watch *sourceWatch, name string) {
+	defer func() {
+		if r := recover(); r != nil {
+			client.workerPanicked(WORKER_WATCH, r, debug.Stack())
+		}
+	}()
+
+	updates, err := source.Subscribe(ctx)
+	if err != nil {
+		client.levelLog.Warn("Watch: Subscribing to %s failed, polling it: %s", name, err)
+		return
+	}
+	client.levelLog.Debug("Watch: Subscribed to %s", name)
+	for {
+		select {
+		case specs, ok := <-updates:
+			if !ok {
+				if ctx.Err() == nil {
+					client.levelLog.Warn("Watch: Subscription to %s ended, polling it", name)
+				}
+				return
+			}
+			if !client.watches.update(source, watch, specs, true) {
+				return
+			}
+			client.GetNodesContext(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runWatchWorker keeps the subscriptions of the clients returned by clients in step with their sources, until stop
+// is closed
+func runWatchWorker(clients func() []*Client, wake func() <-chan struct{}, stop <-chan struct{}, current **Client) {
+	running := map[*Client]bool{}
+	defer func() {
+		for client := range running {
+			client.stopWatches()
+		}
+	}()
+
+	for {
+		woken := wake()
+		for _, client := range clients() {
+			*current = client
+			running[client] = true
+			client.syncWatches()
+		}
+		select {
+		case <-woken:
+		case <-stop:
+			return
+		}
+	}
+}
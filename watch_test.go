@@ -0,0 +1,185 @@
+package memcacheha_test
+
+import (
+	"github.com/apitalent/memcacheha"
+	"github.com/apitalent/memcacheha/memcachehatest"
+
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushSource is a WatchableSource pushing the nodes given to push, and returning polled when polled
+type pushSource struct {
+	mutex         sync.Mutex
+	polled        []string
+	updates       chan []memcacheha.NodeSpec
+	subscriptions int
+	active        int
+	unsubscribed  int
+}
+
+func newPushSource(polled ...string) *pushSource {
+	return &pushSource{polled: polled}
+}
+
+func (source *pushSource) GetNodes() ([]string, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return source.polled, nil
+}
+
+// Subscribe forwards the nodes given to push until ctx is done or end is called
+func (source *pushSource) Subscribe(ctx context.Context) (<-chan []memcacheha.NodeSpec, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	source.subscriptions++
+	source.active++
+	updates, out := make(chan []memcacheha.NodeSpec), make(chan []memcacheha.NodeSpec)
+	source.updates = updates
+	go func() {
+		defer func() {
+			source.mutex.Lock()
+			source.active--
+			source.mutex.Unlock()
+			close(out)
+		}()
+		for {
+			select {
+			case specs, ok := <-updates:
+				if !ok {
+					return
+				}
+				select {
+				case out <- specs:
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+				source.mutex.Lock()
+				source.unsubscribed++
+				source.mutex.Unlock()
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// push sends the given nodes to the current subscription
+func (source *pushSource) push(endpoints ...string) {
+	specs := []memcacheha.NodeSpec{}
+	for _, endpoint := range endpoints {
+		specs = append(specs, memcacheha.NodeSpec{Address: endpoint})
+	}
+	source.mutex.Lock()
+	updates := source.updates
+	source.mutex.Unlock()
+	updates <- specs
+}
+
+// end ends the current subscription, as a source losing its connection would
+func (source *pushSource) end() {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	close(source.updates)
+	source.updates = nil
+}
+
+// counts returns the number of subscriptions made and cancelled
+func (source *pushSource) counts() (int, int) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return source.subscriptions, source.unsubscribed
+}
+
+// subscribed returns true if the source has a subscription that hasn't ended
+func (source *pushSource) subscribed() bool {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return source.active > 0
+}
+
+// nodeEndpoints returns the sorted endpoints of the nodes of client
+func nodeEndpoints(client *memcacheha.Client) []string {
+	out := []string{}
+	for endpoint := range client.Nodes.GetNodes() {
+		out = append(out, endpoint)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestWatchableSource(t *testing.T) {
+	// Subscribe soon after Start, without waiting on slow lookups of the unresolvable test hostnames
+	startDelay, resolveTimeout, retryDelay := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT, memcacheha.WATCH_RETRY_DELAY
+	t.Cleanup(func() {
+		memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT, memcacheha.WATCH_RETRY_DELAY = startDelay, resolveTimeout, retryDelay
+	})
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+	memcacheha.WATCH_RETRY_DELAY = 10 * time.Millisecond
+
+	cluster := memcachehatest.NewCluster(3)
+	source := newPushSource("node3:11211")
+	client := memcacheha.New(nil, source)
+	client.NewNodeClient = cluster.NewNodeClient
+	client.GetNodesPeriod = time.Hour
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	t.Cleanup(func() { client.Stop() })
+
+	// Pushed nodes are discovered without waiting for the GetNodesPeriod, and the source is no longer polled
+	eventually(t, source.subscribed)
+	source.push("node1:11211")
+	eventually(t, func() bool { return reflect.DeepEqual(nodeEndpoints(client), []string{"node1:11211"}) })
+	source.push("node1:11211", "node2:11211")
+	eventually(t, func() bool { return reflect.DeepEqual(nodeEndpoints(client), []string{"node1:11211", "node2:11211"}) })
+	client.GetNodes()
+	if nodes := nodeEndpoints(client); !reflect.DeepEqual(nodes, []string{"node1:11211", "node2:11211"}) {
+		t.Fatalf("Discovery of a watched source found %v, expected the pushed nodes", nodes)
+	}
+	if sources := client.DebugState().Sources; len(sources) != 1 || !sources[0].Watched {
+		t.Fatalf("Sources are %+v, expected the watched source", sources)
+	}
+
+	// A source whose subscription ends is polled until resubscribed
+	source.end()
+	eventually(t, func() bool {
+		client.GetNodes()
+		return reflect.DeepEqual(nodeEndpoints(client), []string{"node3:11211"})
+	})
+	eventually(t, source.subscribed)
+	if subscriptions, _ := source.counts(); subscriptions != 2 {
+		t.Fatalf("%d subscriptions, expected 2", subscriptions)
+	}
+
+	// Stop cancels the subscription
+	if err := client.Stop(); err != nil {
+		t.Fatalf("Stop failed: %s", err)
+	}
+	eventually(t, func() bool { return !source.subscribed() })
+	if subscriptions, unsubscribed := source.counts(); subscriptions != 2 || unsubscribed != 1 {
+		t.Fatalf("%d subscriptions and %d cancelled after Stop, expected 2 and 1", subscriptions, unsubscribed)
+	}
+}
+
+func TestWatchableSourceRemoved(t *testing.T) {
+	startDelay, resolveTimeout := memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT
+	t.Cleanup(func() { memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = startDelay, resolveTimeout })
+	memcacheha.MAINTENANCE_START_DELAY, memcacheha.RESOLVE_TIMEOUT = 10*time.Millisecond, 10*time.Millisecond
+
+	cluster := memcachehatest.NewCluster(1)
+	source := newPushSource()
+	client := cluster.NewClient(t, func(client *memcacheha.Client) { client.AddSource(source) })
+	eventually(t, source.subscribed)
+
+	// Removing the source cancels its subscription
+	client.SetSources(cluster)
+	eventually(t, func() bool { return !source.subscribed() })
+	if subscriptions, unsubscribed := source.counts(); subscriptions != 1 || unsubscribed != 1 {
+		t.Fatalf("%d subscriptions and %d cancelled, expected 1 and 1", subscriptions, unsubscribed)
+	}
+}
@@ -0,0 +1,57 @@
+package memcacheha
+
+import (
+	"sync"
+)
+
+var (
+	// WORKER_POOL_SIZE is the number of goroutines running node operations. It is read when the first node operation is run.
+	WORKER_POOL_SIZE = 128
+	// WORKER_POOL_QUEUE is the number of node operations that can be queued for the worker pool before
+	// operations are run on new goroutines
+	WORKER_POOL_QUEUE = 1024
+
+	defaultWorkerPool     *workerPool
+	defaultWorkerPoolOnce sync.Once
+)
+
+// workerPool runs functions on a fixed set of goroutines, avoiding a goroutine per node operation
+type workerPool struct {
+	jobs    chan func()
+	workers int
+}
+
+func newWorkerPool(workers int, queue int) *workerPool {
+	pool := &workerPool{
+		jobs:    make(chan func(), queue),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// getWorkerPool returns the worker pool shared by all nodes
+func getWorkerPool() *workerPool {
+	defaultWorkerPoolOnce.Do(func() {
+		defaultWorkerPool = newWorkerPool(WORKER_POOL_SIZE, WORKER_POOL_QUEUE)
+	})
+	return defaultWorkerPool
+}
+
+// Submit runs job on the pool. If the queue is full, job is run on a new goroutine rather than blocking.
+// Jobs must not wait on other jobs.
+func (pool *workerPool) Submit(job func()) {
+	select {
+	case pool.jobs <- job:
+	default:
+		go job()
+	}
+}
+
+func (pool *workerPool) work() {
+	for job := range pool.jobs {
+		job()
+	}
+}